@@ -0,0 +1,120 @@
+package annotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// AddOnePageAnnot holds the annotations to add to one page.
+type AddOnePageAnnot struct {
+	PageNumber int          `json:"page"` // page num, start from 0
+	Annots     []Annotation `json:"annots"`
+}
+
+// AddAnnotError is the failure of a single annotation in a batch add.
+type AddAnnotError struct {
+	PageNumber int    // page num, -1 when the page is not given by index
+	Index      int    // index of the annotation in the given slice
+	NM         string // annotation unique name
+	Err        error
+}
+
+func (e *AddAnnotError) Error() string {
+	return fmt.Sprintf("add annot %d (nm: %s) to page %d failed: %v", e.Index, e.NM, e.PageNumber, e.Err)
+}
+
+func (e *AddAnnotError) Unwrap() error {
+	return e.Err
+}
+
+// BatchAddError collects every failed annotation of a batch add.
+type BatchAddError struct {
+	Failures []*AddAnnotError
+}
+
+func (e *BatchAddError) Error() string {
+	if len(e.Failures) == 1 {
+		return e.Failures[0].Error()
+	}
+	return fmt.Sprintf("%d annots failed to add, first: %v", len(e.Failures), e.Failures[0])
+}
+
+// Unwrap makes errors.Is/As look into every failure.
+func (e *BatchAddError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		errs = append(errs, failure)
+	}
+	return errs
+}
+
+// AddAnnotationsToPage adds annots to a page in one call.
+// A failed annotation does not stop the others, every failure is reported in a *BatchAddError.
+// Annotations added with an *AnnotationWarning count as added, the warning is not reported.
+// ps: appearance should be generated before, same as AddAnnotationToPage
+func AddAnnotationsToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page, annots []Annotation) (added int, err error) {
+	pageNumber := -1
+	if page.ByIndex != nil {
+		pageNumber = page.ByIndex.Index
+	}
+
+	added, failures := addAnnotationsToPage(ctx, instance, page, pageNumber, annots)
+	if len(failures) > 0 {
+		return added, &BatchAddError{Failures: failures}
+	}
+	return added, nil
+}
+
+// AddAnnotationsToPDF adds annots to several pages of a pdf in one call.
+// A failed annotation does not stop the others, every failure is reported in a *BatchAddError.
+// Annotations added with an *AnnotationWarning count as added, see AddAnnotationsToPage.
+func AddAnnotationsToPDF(ctx context.Context, instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageAnnots []AddOnePageAnnot) (added int, err error) {
+	var failures []*AddAnnotError
+	for _, item := range pageAnnots {
+		page := requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: pdfDoc,
+				Index:    item.PageNumber,
+			},
+		}
+		num, pageFailures := addAnnotationsToPage(ctx, instance, page, item.PageNumber, item.Annots)
+		added += num
+		failures = append(failures, pageFailures...)
+	}
+
+	if len(failures) > 0 {
+		return added, &BatchAddError{Failures: failures}
+	}
+	return added, nil
+}
+
+func addAnnotationsToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page, pageNumber int, annots []Annotation) (added int, failures []*AddAnnotError) {
+	for i, annot := range annots {
+		if annot == nil {
+			failures = append(failures, &AddAnnotError{PageNumber: pageNumber, Index: i, Err: errors.New("annot is nil")})
+			continue
+		}
+
+		// stop adding once the caller gives up, the rest are reported as failed
+		err := ctx.Err()
+		if err == nil {
+			err = annot.AddAnnotationToPage(ctx, instance, page)
+		}
+		if err != nil && !IsWarning(err) {
+			failures = append(failures, &AddAnnotError{
+				PageNumber: pageNumber,
+				Index:      i,
+				NM:         annot.GetNM(),
+				Err:        err,
+			})
+			continue
+		}
+		added++
+	}
+	return added, failures
+}
@@ -1,14 +1,32 @@
 package annotation
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
 	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
 	"github.com/klippa-app/go-pdfium/single_threaded"
 )
 
@@ -63,8 +81,8 @@ func TestAddLineAnnotation(t *testing.T) {
 		lineAnnot.GenerateAppearance()
 
 		err = lineAnnot.AddAnnotationToPage(context.Background(), instance, page)
-		if err != nil {
-			t.Fatal(err)
+		if !errors.Is(err, ErrSavePDFRequired) || !IsWarning(err) {
+			t.Fatalf("want the SavePDF warning, got %v", err)
 		}
 
 		_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
@@ -117,7 +135,7 @@ func TestAddLineAnnotation(t *testing.T) {
 430.457 684.889 l
 S`)
 		err = lineAnnot.AddAnnotationToPage(context.Background(), instance, page)
-		if err != nil {
+		if err != nil && !IsWarning(err) {
 			t.Fatal(err)
 		}
 
@@ -129,6 +147,89 @@ S`)
 			t.Fatalf("save square document failed: %v", err)
 		}
 	})
+
+	t.Run("line endings, leader lines and caption", func(t *testing.T) {
+		// output file
+		outputFile := "data/simple_dimension_line.pdf"
+		os.Remove(outputFile)
+
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+
+		page := requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: docRes.Document,
+				Index:    0,
+			},
+		}
+
+		var lineAnnot = NewLineAnnotation()
+		lineAnnot.SetWidth(1)
+		lineAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+		lineAnnot.SetFillColor(Color{R: 255, G: 0, B: 0})
+		lineAnnot.SetLineTo(300, 500, 100, 500)
+		lineAnnot.SetLineEndings(LineEndingClosedArrow, LineEndingClosedArrow)
+		lineAnnot.SetLeaderLine(-20, 5)
+		lineAnnot.SetContents("200 pt")
+		lineAnnot.SetCaption(nil, 10)
+
+		err = lineAnnot.AddAnnotationToPage(context.Background(), instance, page)
+		if err != nil && !IsWarning(err) {
+			t.Fatal(err)
+		}
+
+		rect := lineAnnot.GetRect()
+		if rect.Left >= 100 || rect.Right <= 300 || rect.Top <= 525 || rect.Bottom >= 500 {
+			t.Fatalf("unexpected rect: %+v", rect)
+		}
+
+		savedPage := savePDFAndReopen(t, docRes.Document, outputFile)
+		annots, err := LoadAnnotationsInPage(instance, savedPage.ByIndex.Document, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, ok := annots[len(annots)-1].(*LineAnnotation)
+		if !ok {
+			t.Fatalf("expect a line, got %s", annots[len(annots)-1].GetSubtypeName())
+		}
+		if loaded.GetLineTo() != lineAnnot.GetLineTo() || loaded.LineEndings != lineAnnot.LineEndings ||
+			loaded.LeaderLine != -20 || loaded.LeaderLineExtension != 5 || !loaded.Caption {
+			t.Fatalf("unexpected line keys: %+v", loaded)
+		}
+		// the caption is text, drawn upright above the line moved up by the leader lines
+		if !strings.Contains(loaded.GetAppearance(), "Tj") {
+			t.Fatalf("expect the caption as text: %s", loaded.GetAppearance())
+		}
+		annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+			Page:  savedPage,
+			Index: len(annots) - 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subtypeRes.Subtype != enums.FPDF_ANNOT_SUBTYPE_LINE {
+			t.Fatalf("expect the saved subtype to be line, got %d", subtypeRes.Subtype)
+		}
+		lineRes, err := instance.FPDFAnnot_GetLine(&requests.FPDFAnnot_GetLine{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lineRes.Start.X != 300 || lineRes.End.X != 100 {
+			t.Fatalf("unexpected saved line: %+v", lineRes)
+		}
+	})
 }
 
 func TestAddSquareAnnotation(t *testing.T) {
@@ -175,6 +276,311 @@ func TestAddSquareAnnotation(t *testing.T) {
 	}
 }
 
+func TestBorderStyles(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_border_styles.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	dashedSquare := NewSquareAnnotation()
+	dashedSquare.SetRect(Rect{Left: 100, Bottom: 600, Right: 200, Top: 700})
+	dashedSquare.SetWidth(2)
+	dashedSquare.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	dashedSquare.SetBorderStyle(BorderStyleDashed, 6, 3)
+	dashedSquare.GenerateAppearance()
+	if !strings.Contains(dashedSquare.GetAppearance(), "[6 3] 0 d") {
+		t.Fatalf("dash pattern not set: %s", dashedSquare.GetAppearance())
+	}
+
+	beveledSquare := NewSquareAnnotation()
+	beveledSquare.SetRect(Rect{Left: 250, Bottom: 600, Right: 350, Top: 700})
+	beveledSquare.SetWidth(4)
+	beveledSquare.SetStrikeColor(Color{R: 0, G: 0, B: 0})
+	beveledSquare.SetFillColor(Color{R: 0, G: 128, B: 255})
+	beveledSquare.SetBorderStyle(BorderStyleBeveled)
+	beveledSquare.GenerateAppearance()
+
+	cloudySquare := NewSquareAnnotation()
+	cloudyRect := Rect{Left: 100, Bottom: 400, Right: 250, Top: 500}
+	cloudySquare.SetRect(cloudyRect)
+	cloudySquare.SetWidth(1)
+	cloudySquare.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	cloudySquare.SetCloudy(1)
+	cloudySquare.GenerateAppearance()
+
+	// every curl of the cloud stays inside the rect
+	checkCurls := func(ap string, rect Rect) {
+		for _, line := range strings.Split(ap, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 7 || fields[6] != "c" {
+				continue
+			}
+			x, _ := strconv.ParseFloat(fields[4], 32)
+			y, _ := strconv.ParseFloat(fields[5], 32)
+			if float32(x) < rect.Left-0.01 || float32(x) > rect.Right+0.01 ||
+				float32(y) < rect.Bottom-0.01 || float32(y) > rect.Top+0.01 {
+				t.Fatalf("curl outside of the rect %+v: %s", rect, line)
+			}
+		}
+	}
+	checkCurls(cloudySquare.GetAppearance(), cloudyRect)
+
+	cloudyCircle := NewCircleAnnotation()
+	cloudyCircle.SetRect(Rect{Left: 300, Bottom: 400, Right: 450, Top: 500})
+	cloudyCircle.SetWidth(1)
+	cloudyCircle.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+	cloudyCircle.SetCloudy(2)
+	cloudyCircle.GenerateAppearance()
+
+	cloudyPolygon := NewPolygonAnnotation()
+	cloudyPolygon.SetWidth(1)
+	cloudyPolygon.SetStrikeColor(Color{R: 0, G: 128, B: 0})
+	cloudyPolygon.SetVertices([]Point{{X: 100, Y: 200}, {X: 250, Y: 200}, {X: 175, Y: 320}})
+	cloudyPolygon.SetCloudy(1)
+	cloudyPolygon.GenerateAppearance()
+	checkCurls(cloudyPolygon.GetAppearance(), cloudyPolygon.GetRect())
+
+	cloudyFreeText := NewFreeTextAnnotation()
+	cloudyFreeText.SetRect(Rect{Left: 300, Bottom: 200, Right: 500, Top: 300})
+	cloudyFreeText.SetWidth(1)
+	cloudyFreeText.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	cloudyFreeText.SetContents("Please check this paragraph")
+	cloudyFreeText.SetCloudy(1)
+
+	for _, annot := range []Annotation{dashedSquare, beveledSquare, cloudySquare, cloudyCircle, cloudyPolygon, cloudyFreeText} {
+		err = annot.AddAnnotationToPage(context.Background(), instance, page)
+		if err != nil && !IsWarning(err) {
+			t.Fatal(err)
+		}
+	}
+
+	// the styles are kept in /BS and /BE
+	savedPage := savePDFAndReopen(t, docRes.Document, outputFile)
+	annots, err := LoadAnnotationsInPage(instance, savedPage.ByIndex.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annots) < 6 {
+		t.Fatalf("expect 6 annotations after saving, got %d", len(annots))
+	}
+	annots = annots[len(annots)-6:]
+	loadedDashed, ok := annots[0].(*SquareAnnotation)
+	if !ok || loadedDashed.Style != BorderStyleDashed || !reflect.DeepEqual(loadedDashed.DashArray, []int{6, 3}) {
+		t.Fatalf("expect a dashed square, got %+v", annots[0])
+	}
+	if loadedBeveled, ok := annots[1].(*SquareAnnotation); !ok || loadedBeveled.Style != BorderStyleBeveled {
+		t.Fatalf("expect a beveled square, got %+v", annots[1])
+	}
+	for i, annot := range annots[2:] {
+		style, ok := annot.(interface{ border() *BorderStyle })
+		if !ok || !style.border().isCloudy() {
+			t.Fatalf("expect annotation %d to be cloudy, got %+v", i+2, annot)
+		}
+	}
+	if loadedCircle := annots[3].(*CircleAnnotation); loadedCircle.EffectInt != 2 {
+		t.Fatalf("expect the intensity 2, got %d", loadedCircle.EffectInt)
+	}
+}
+
+func TestAddPolygonAnnotation(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_polygon.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	var polygonAnnot = NewPolygonAnnotation()
+	polygonAnnot.SetWidth(2)
+	polygonAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	polygonAnnot.SetFillColor(Color{R: 255, G: 255, B: 0})
+	polygonAnnot.SetVertices([]Point{
+		{X: 100, Y: 100},
+		{X: 200, Y: 100},
+		{X: 250, Y: 180},
+		{X: 150, Y: 250},
+	})
+	polygonAnnot.AddVertex(Point{X: 80, Y: 180})
+	polygonAnnot.GenerateAppearance()
+
+	// the rect is the bounding box of the vertices padded by the border width
+	if polygonAnnot.GetRect() != (Rect{Left: 78, Bottom: 98, Right: 252, Top: 252}) {
+		t.Fatalf("unexpected rect: %+v", polygonAnnot.GetRect())
+	}
+
+	err = polygonAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil && !IsWarning(err) {
+		t.Fatal(err)
+	}
+
+	// a rect set by the caller is kept
+	keptRect := Rect{Left: 300, Bottom: 300, Right: 500, Top: 500}
+	framedPolygon := NewPolygonAnnotation()
+	framedPolygon.SetRect(keptRect)
+	framedPolygon.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+	framedPolygon.SetVertices([]Point{{X: 350, Y: 350}, {X: 450, Y: 350}, {X: 400, Y: 450}})
+	framedPolygon.GenerateAppearance()
+	if framedPolygon.GetRect() != keptRect {
+		t.Fatalf("rect set by the caller changed: %+v", framedPolygon.GetRect())
+	}
+	err = framedPolygon.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil && !IsWarning(err) {
+		t.Fatal(err)
+	}
+
+	// the stashed vertices are read before saving
+	annots, err := LoadAnnotationsInPage(instance, docRes.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok := annots[len(annots)-2].(*PolygonAnnotation)
+	if !ok || len(loaded.Vertices) != 5 {
+		t.Fatalf("expect the polygon with 5 vertices, got %#v", annots[len(annots)-2])
+	}
+
+	savedPage := savePDFAndReopen(t, docRes.Document, outputFile)
+	annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+		Page:  savedPage,
+		Index: len(annots) - 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+		Annotation: annotRes.Annotation,
+	})
+	if err != nil || subtypeRes.Subtype != enums.FPDF_ANNOT_SUBTYPE_POLYGON {
+		t.Fatalf("expect a polygon after saving, got %v %v", subtypeRes, err)
+	}
+	verticesRes, err := instance.FPDFAnnot_GetVertices(&requests.FPDFAnnot_GetVertices{
+		Annotation: annotRes.Annotation,
+	})
+	if err != nil || len(verticesRes.Vertices) != 5 {
+		t.Fatalf("expect 5 vertices after saving, got %v %v", verticesRes, err)
+	}
+}
+
+// savePDFAndReopen saves the document with SavePDFFile and returns the first page of the saved file.
+func savePDFAndReopen(t *testing.T, document references.FPDF_DOCUMENT, outputFile string) requests.Page {
+	err := SavePDFFile(instance, document, outputFile)
+	if err != nil {
+		t.Fatalf("save document failed: %v", err)
+	}
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &outputFile,
+	})
+	if err != nil {
+		t.Fatalf("open saved document failed: %v", err)
+	}
+	return requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+}
+
+func TestAddPolylineAnnotation(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_polyline.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	var polylineAnnot = NewPolylineAnnotation()
+	polylineAnnot.SetWidth(2)
+	polylineAnnot.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+	polylineAnnot.SetFillColor(Color{R: 0, G: 0, B: 255})
+	polylineAnnot.SetVertices([]Point{
+		{X: 100, Y: 300},
+		{X: 200, Y: 350},
+		{X: 300, Y: 300},
+		{X: 400, Y: 350},
+	})
+	polylineAnnot.MoveVertex(3, Point{X: 400, Y: 400})
+	polylineAnnot.SetLineEndings(LineEndingCircle, LineEndingClosedArrow)
+	polylineAnnot.GenerateAppearance()
+
+	// the rect leaves room for the line endings
+	rect := polylineAnnot.GetRect()
+	if rect.Left >= 100-2 || rect.Top <= 400+2 {
+		t.Fatalf("unexpected rect: %+v", rect)
+	}
+
+	err = polylineAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil && !IsWarning(err) {
+		t.Fatal(err)
+	}
+
+	savedPage := savePDFAndReopen(t, docRes.Document, outputFile)
+	countRes, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+		Page: savedPage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+		Page:  savedPage,
+		Index: countRes.Count - 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+		Annotation: annotRes.Annotation,
+	})
+	if err != nil || subtypeRes.Subtype != enums.FPDF_ANNOT_SUBTYPE_POLYLINE {
+		t.Fatalf("expect a polyline after saving, got %v %v", subtypeRes, err)
+	}
+	hasLE, err := hasAnnotKey(instance, annotRes.Annotation, "LE")
+	if err != nil || !hasLE {
+		t.Fatalf("expect /LE after saving, got %v %v", hasLE, err)
+	}
+
+	// the saved polyline loads with its vertices and line endings
+	annots, err := LoadAnnotationsInPage(instance, savedPage.ByIndex.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok := annots[len(annots)-1].(*PolylineAnnotation)
+	if !ok || len(loaded.Vertices) != 4 || loaded.Vertices[3] != (Point{X: 400, Y: 400}) {
+		t.Fatalf("unexpected loaded polyline: %#v", annots[len(annots)-1])
+	}
+}
+
 func TestAddInkAnnotation(t *testing.T) {
 	inputFile := "simple.pdf"
 	outputFile := "data/simple_ink.pdf"
@@ -374,9 +780,65 @@ func TestAddFreeTextAnnotation(t *testing.T) {
 	freeTextAnnot.FontColor = Color{R: 255, G: 0, B: 0}
 	freeTextAnnot.GenerateAppearance()
 	err = freeTextAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil && !IsWarning(err) {
+		t.Fatal(err)
+	}
+
+	// a loaded free text added again draws its text once
+	annots, err := LoadAnnotationsInPage(instance, docRes.Document, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
+	loaded, ok := annots[len(annots)-1].(*FreeTextAnnotation)
+	if !ok {
+		t.Fatalf("expect a free text, got %T", annots[len(annots)-1])
+	}
+	loaded.SetNM(GenerateUUID())
+	err = loaded.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil && !IsWarning(err) {
+		t.Fatal(err)
+	}
+	textObjects := func(index int) int {
+		annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+			Page:  page,
+			Index: index,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+			Annotation: annotRes.Annotation,
+		})
+		countRes, err := instance.FPDFAnnot_GetObjectCount(&requests.FPDFAnnot_GetObjectCount{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		for i := 0; i < countRes.Count; i++ {
+			objRes, err := instance.FPDFAnnot_GetObject(&requests.FPDFAnnot_GetObject{
+				Annotation: annotRes.Annotation,
+				Index:      i,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			typeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
+				PageObject: objRes.PageObject,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typeRes.Type == enums.FPDF_PAGEOBJ_TEXT {
+				count++
+			}
+		}
+		return count
+	}
+	if added, readded := textObjects(len(annots)-1), textObjects(len(annots)); added == 0 || readded != added {
+		t.Fatalf("expect the loaded free text to draw its %d text objects once, got %d", added, readded)
+	}
 
 	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
 		Document: docRes.Document,
@@ -387,9 +849,9 @@ func TestAddFreeTextAnnotation(t *testing.T) {
 	}
 }
 
-func TestAddCircleAnnotation(t *testing.T) {
+func TestFreeTextAppearance(t *testing.T) {
 	inputFile := "simple.pdf"
-	outputFile := "data/simple_circle.pdf"
+	outputFile := "data/simple_freetext_appearance.pdf"
 	os.Remove(outputFile)
 	docRes, err := instance.OpenDocument(&requests.OpenDocument{
 		FilePath: &inputFile,
@@ -405,54 +867,227 @@ func TestAddCircleAnnotation(t *testing.T) {
 		},
 	}
 
-	var circleAnnot = NewCircleAnnotation()
-	circleAnnot.SetRect(Rect{
-		Left:   100,
-		Top:    200,
-		Right:  200,
-		Bottom: 100,
-	})
-	circleAnnot.SetWidth(2)
-	circleAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
-	circleAnnot.SetFillColor(Color{R: 0, G: 255, B: 0})
-	circleAnnot.SetOpacity(120)
-	circleAnnot.GenerateAppearance()
-	err = circleAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	font, err := LoadStandardFont(instance, docRes.Document, "Times-Bold")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer font.Close()
 
-	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
-		Document: docRes.Document,
-		FilePath: &outputFile,
-	})
-	if err != nil {
-		t.Fatalf("save circle document failed: %v", err)
+	alignments := []TextAlignment{TextAlignLeft, TextAlignCenter, TextAlignRight}
+	for i, alignment := range alignments {
+		var freeTextAnnot = NewFreeTextAnnotation()
+		freeTextAnnot.SetRect(Rect{
+			Left:   float32(50 + i*160),
+			Top:    400,
+			Right:  float32(200 + i*160),
+			Bottom: 300,
+		})
+		freeTextAnnot.SetWidth(1)
+		freeTextAnnot.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+		freeTextAnnot.SetFillColor(Color{R: 255, G: 255, B: 200})
+		freeTextAnnot.SetFont(font)
+		freeTextAnnot.SetFontSize(14)
+		freeTextAnnot.SetAlignment(alignment)
+		freeTextAnnot.SetPadding(4)
+		freeTextAnnot.Contents = "The quick brown fox jumps over the lazy dog"
+		err = freeTextAnnot.GenerateAppearance()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if freeTextAnnot.GetAppearance() == "" {
+			t.Fatal("appearance not generated")
+		}
+		if freeTextAnnot.GetDefaultAppearance() != "/TiBo 14 Tf 0.000 0.000 0.000 rg" {
+			t.Fatalf("unexpected da: %s", freeTextAnnot.GetDefaultAppearance())
+		}
+		err = freeTextAnnot.AddAnnotationToPage(context.Background(), instance, page)
+		if err != nil && !IsWarning(err) {
+			t.Fatal(err)
+		}
 	}
-}
 
-func TestAddHighlightAnnotation(t *testing.T) {
-	inputFile := "simple_text.pdf"
-	outputFile := "data/simple_highlight.pdf"
-	os.Remove(outputFile)
-	docRes, err := instance.OpenDocument(&requests.OpenDocument{
-		FilePath: &inputFile,
-	})
+	// the text wraps to several lines at the rect width
+	lines, err := wrapText(font, "The quick brown fox jumps over the lazy dog", 14, 142)
 	if err != nil {
-		t.Fatalf("open document failed: %v", err)
+		t.Fatal(err)
 	}
-
-	page := requests.Page{
-		ByIndex: &requests.PageByIndex{
-			Document: docRes.Document,
-			Index:    0,
-		},
+	if len(lines) < 2 {
+		t.Fatalf("expected wrapped lines, got %q", lines)
 	}
 
-	var highlightAnnot = NewHighlightAnnotation()
-	highlightAnnot.SetRect(Rect{
-		Left:   239.084,
-		Top:    446.565,
+	// the text is drawn with text operators, the font of /DA is in /DR
+	savedPage := savePDFAndReopen(t, docRes.Document, outputFile)
+	annots, err := LoadAnnotationsInPage(instance, savedPage.ByIndex.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annots = annots[len(annots)-len(alignments):]
+	for i, annot := range annots {
+		loaded, ok := annot.(*FreeTextAnnotation)
+		if !ok {
+			t.Fatalf("expect a free text, got %s", annot.GetSubtypeName())
+		}
+		if loaded.Alignment != alignments[i] || loaded.FontSize != 14 || loaded.Contents != "The quick brown fox jumps over the lazy dog" {
+			t.Fatalf("unexpected free text: %+v", loaded)
+		}
+		if !strings.Contains(loaded.GetAppearance(), "Tf") || strings.Count(loaded.GetAppearance(), "Tj") < 2 {
+			t.Fatalf("expect the wrapped lines as text: %s", loaded.GetAppearance())
+		}
+	}
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`/DR\s*<<\s*/Font\s*<<\s*/TiBo\s+\d+ 0 R`).Match(data) {
+		t.Fatal("expect the font of /DA in /DR")
+	}
+
+	// pdfium can not append text to a saved free text, its update draws the glyph outlines
+	contents := "Updated"
+	updated, err := UpdateAnnotByNM(instance, savedPage.ByIndex.Document, 0, annots[0].GetNM(), UpdateAnnot{
+		Contents: &contents,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ap := updated.(*FreeTextAnnotation).GetAppearance(); !strings.Contains(ap, " c\n") && !strings.Contains(ap, " l\n") {
+		t.Fatalf("expect the glyph outlines of the text: %s", ap)
+	}
+}
+
+func TestFreeTextEmbeddedFont(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_freetext_cjk.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	// text objects reference the font, so it is loaded into the annotated document
+	font, err := LoadFontFile(instance, docRes.Document, "NotoSansSC-Regular.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer font.Close()
+
+	var freeTextAnnot = NewFreeTextAnnotation()
+	freeTextAnnot.SetRect(Rect{
+		Left:   100,
+		Top:    400,
+		Right:  300,
+		Bottom: 300,
+	})
+	freeTextAnnot.SetFont(font)
+	freeTextAnnot.SetFontSize(16)
+	freeTextAnnot.Contents = "你好，世界！这是一个自由文本注释。"
+	err = freeTextAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil && !IsWarning(err) {
+		t.Fatal(err)
+	}
+
+	var stampAnnot = NewStampAnnotation()
+	stampAnnot.SetRect(Rect{
+		Left:   100,
+		Top:    280,
+		Right:  300,
+		Bottom: 200,
+	})
+	stampAnnot.SetTextObject("你好", font, 24, Color{R: 255, G: 0, B: 0}, 0)
+	err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the font is registered in /DR under its name of /DA
+	err = SavePDFFile(instance, docRes.Document, outputFile)
+	if err != nil {
+		t.Fatalf("save freetext document failed: %v", err)
+	}
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`/DR\s*<<\s*/Font\s*<<\s*/` + regexp.QuoteMeta(font.ResourceName()) + `\s+\d+ 0 R`).Match(data) {
+		t.Fatalf("expect %s in /DR", font.ResourceName())
+	}
+}
+
+func TestAddCircleAnnotation(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_circle.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	var circleAnnot = NewCircleAnnotation()
+	circleAnnot.SetRect(Rect{
+		Left:   100,
+		Top:    200,
+		Right:  200,
+		Bottom: 100,
+	})
+	circleAnnot.SetWidth(2)
+	circleAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	circleAnnot.SetFillColor(Color{R: 0, G: 255, B: 0})
+	circleAnnot.SetOpacity(120)
+	circleAnnot.GenerateAppearance()
+	err = circleAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+		Document: docRes.Document,
+		FilePath: &outputFile,
+	})
+	if err != nil {
+		t.Fatalf("save circle document failed: %v", err)
+	}
+}
+
+func TestAddHighlightAnnotation(t *testing.T) {
+	inputFile := "simple_text.pdf"
+	outputFile := "data/simple_highlight.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	var highlightAnnot = NewHighlightAnnotation()
+	highlightAnnot.SetRect(Rect{
+		Left:   239.084,
+		Top:    446.565,
 		Right:  932.784,
 		Bottom: 387.359,
 	})
@@ -486,12 +1121,46 @@ func TestAddHighlightAnnotation(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
-		Document: docRes.Document,
-		FilePath: &outputFile,
-	})
+	// an opaque highlight multiplied with the text below
+	multiplyAnnot := NewHighlightAnnotation()
+	multiplyAnnot.SetRect(Rect{Left: 239.084, Top: 380, Right: 600, Bottom: 350})
+	multiplyAnnot.SetStrikeColor(Color{R: 255, G: 128, B: 0})
+	multiplyAnnot.QuadPoints = []QuadPoint{rectQuadPoint(multiplyAnnot.GetRect())}
+	multiplyAnnot.SetBlendMode(enums.PDF_BLEND_MODE_MULTIPLY)
+	multiplyAnnot.GenerateAppearance()
+	err = multiplyAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedPage := savePDFAndReopen(t, docRes.Document, outputFile)
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(regexp.MustCompile(`/GS\s*<<[^>]*/BM\s*/Multiply`).FindAll(data, -1)); n != 1 {
+		t.Fatalf("expect the blend mode in the ExtGState of one highlight, got %d", n)
+	}
+	annots, err := LoadAnnotationsInPage(instance, savedPage.ByIndex.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, ok := annots[len(annots)-1].(*HighlightAnnotation); !ok || loaded.blendMode != enums.PDF_BLEND_MODE_MULTIPLY {
+		t.Fatalf("expect a multiplied highlight, got %+v", annots[len(annots)-1])
+	}
+
+	// saving again keeps the blend mode without a second update
+	resavedFile := "data/simple_highlight_resaved.pdf"
+	err = SavePDFFile(instance, savedPage.ByIndex.Document, resavedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(resavedFile)
 	if err != nil {
-		t.Fatalf("save highlight document failed: %v", err)
+		t.Fatal(err)
+	}
+	if bytes.Count(data, []byte("startxref")) != 1 || !regexp.MustCompile(`/BM\s*/Multiply`).Match(data) {
+		t.Fatal("expect the blend mode kept in one revision")
 	}
 }
 
@@ -624,78 +1293,567 @@ func TestAddStrikeoutAnnotation(t *testing.T) {
 	}
 }
 
-func TestAddStampAnnotation(t *testing.T) {
-	inputFile := "simple.pdf"
+func TestAddSquigglyAnnotation(t *testing.T) {
+	inputFile := "simple_text.pdf"
+	outputFile := "data/simple_squiggly.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
 
-	t.Run("add path to stamp annot", func(t *testing.T) {
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
 
-		docRes, err := instance.OpenDocument(&requests.OpenDocument{
-			FilePath: &inputFile,
+	var squigglyAnnot = NewSquigglyAnnotation()
+	squigglyAnnot.SetRect(Rect{
+		Left:   239.084,
+		Top:    446.565,
+		Right:  932.784,
+		Bottom: 387.359,
+	})
+	squigglyAnnot.SetWidth(4)
+	squigglyAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	squigglyAnnot.SetOpacity(255)
+	squigglyAnnot.QuadPoints = []QuadPoint{
+		{
+			LeftTopX:     239.85,
+			LeftTopY:     445.799,
+			RightTopX:    932.018,
+			RightTopY:    445.799,
+			LeftBottomX:  239.85,
+			LeftBottomY:  421.276,
+			RightBottomX: 932.018,
+			RightBottomY: 421.276,
+		},
+		{
+			LeftTopX:     239.85,
+			LeftTopY:     412.649,
+			RightTopX:    854.006,
+			RightTopY:    412.649,
+			LeftBottomX:  239.85,
+			LeftBottomY:  388.126,
+			RightBottomX: 854.006,
+			RightBottomY: 388.126,
+		},
+	}
+	squigglyAnnot.GenerateAppearance()
+	if !strings.Contains(squigglyAnnot.GetAppearance(), " l ") {
+		t.Fatal("wave not drawn")
+	}
+	err = squigglyAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+		Document: docRes.Document,
+		FilePath: &outputFile,
+	})
+	if err != nil {
+		t.Fatalf("save squiggly document failed: %v", err)
+	}
+}
+
+func TestRedactAnnotation(t *testing.T) {
+	inputFile := "simple_text.pdf"
+	outputFile := "data/simple_redact.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	matches, err := SearchTextInPage(instance, docRes.Document, 0, "quick brown fox", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expect 1 match, got %d", len(matches))
+	}
+	pageText := func(page requests.Page) string {
+		textPageRes, err := instance.FPDFText_LoadPage(&requests.FPDFText_LoadPage{
+			Page: page,
 		})
 		if err != nil {
-			t.Fatalf("open document failed: %v", err)
+			t.Fatal(err)
 		}
-
-		page := requests.Page{
-			ByIndex: &requests.PageByIndex{
-				Document: docRes.Document,
-				Index:    0,
-			},
+		defer instance.FPDFText_ClosePage(&requests.FPDFText_ClosePage{
+			TextPage: textPageRes.TextPage,
+		})
+		countRes, err := instance.FPDFText_CountChars(&requests.FPDFText_CountChars{
+			TextPage: textPageRes.TextPage,
+		})
+		if err != nil {
+			t.Fatal(err)
 		}
-
-		var stampAnnot = NewStampAnnotation()
-		stampAnnot.SetRect(Rect{
-			Left:   0,
-			Bottom: 0,
-			Top:    200,
-			Right:  200,
+		textRes, err := instance.FPDFText_GetText(&requests.FPDFText_GetText{
+			TextPage:   textPageRes.TextPage,
+			StartIndex: 0,
+			Count:      countRes.Count,
 		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return textRes.Text
+	}
 
-		outputFile := "data/simple_stamp_path.pdf"
-		os.Remove(outputFile)
-		stampAnnot.SetPathObject([][]Point{
-			{
-				{X: 100, Y: 100},
-				{X: 102, Y: 98},
-				{X: 104, Y: 102},
-				{X: 106, Y: 100},
-				{X: 108, Y: 98},
-				{X: 110, Y: 100},
-				{X: 112, Y: 102},
-				{X: 114, Y: 100},
-				{X: 116, Y: 98},
-				{X: 118, Y: 100},
-				{X: 120, Y: 102},
-			},
-			{
-				{X: 50, Y: 100},
-				{X: 52, Y: 98},
-				{X: 54, Y: 102},
-				{X: 56, Y: 100},
-				{X: 58, Y: 98},
-				{X: 60, Y: 100},
-				{X: 62, Y: 102},
-				{X: 64, Y: 100},
-				{X: 66, Y: 98},
-				{X: 68, Y: 100},
-				{X: 70, Y: 102},
+	var redactAnnot = NewRedactAnnotation()
+	redactAnnot.QuadPoints = matches[0].QuadPoints
+	redactAnnot.SetOverlayText("REDACTED", 0, Color{R: 255, G: 255, B: 255})
+	redactAnnot.GenerateAppearance()
+	err = redactAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil && !IsWarning(err) {
+		t.Fatal(err)
+	}
+
+	annots, err := LoadAnnotationsInPage(instance, docRes.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok := annots[len(annots)-1].(*RedactAnnotation)
+	if !ok || loaded.OverlayText != "REDACTED" || len(loaded.QuadPoints) != 1 {
+		t.Fatalf("unexpected loaded redact annot: %#v", annots[len(annots)-1])
+	}
+
+	// the saved file has a real redaction with its quads
+	savedFile := "data/simple_redact_marked.pdf"
+	savedPage := savePDFAndReopen(t, docRes.Document, savedFile)
+	annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+		Page:  savedPage,
+		Index: len(annots) - 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+		Annotation: annotRes.Annotation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subtypeRes.Subtype != enums.FPDF_ANNOT_SUBTYPE_REDACT {
+		t.Fatalf("expect the saved subtype to be redact, got %d", subtypeRes.Subtype)
+	}
+	saved, err := os.ReadFile(savedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, _, err := (&pdfParser{data: saved}).parseBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedQuads := false
+	for _, obj := range objects {
+		if dict, ok := obj.(pdfDict); ok && dict["Subtype"] == pdfName("Redact") {
+			quadPoints, err := pdfQuadPoints(dict["QuadPoints"])
+			savedQuads = err == nil && len(quadPoints) == 1
+		}
+	}
+	if !savedQuads {
+		t.Fatalf("expect the saved redaction to have its quads")
+	}
+
+	applied, err := ApplyRedactions(instance, docRes.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 1 {
+		t.Fatalf("expect 1 applied redaction, got %d", applied)
+	}
+
+	// only the text under the quads is gone, the rest of the line is kept
+	text := pageText(page)
+	if strings.Contains(text, "quick") || strings.Contains(text, "fox") || !strings.Contains(text, "REDACTED") ||
+		!strings.Contains(text, "The") || !strings.Contains(text, "jumps over the lazy dog") {
+		t.Fatalf("unexpected text after the redaction: %q", text)
+	}
+	annots, err = LoadAnnotationsInPage(instance, docRes.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, annot := range annots {
+		if _, ok := annot.(*RedactAnnotation); ok {
+			t.Fatalf("redact annot not removed")
+		}
+	}
+
+	// the words are gone from the saved streams, not only from the text page
+	err = SavePDFFile(instance, docRes.Document, outputFile)
+	if err != nil {
+		t.Fatalf("save redact document failed: %v", err)
+	}
+	if word := savedStreamsContain(t, outputFile, "quick", "fox"); word != "" {
+		t.Fatalf("expect %q to be removed from the saved file", word)
+	}
+
+	t.Run("form xobject", func(t *testing.T) {
+		// a form with a secret word and an image, a fake bold text and a path of two subpaths
+		form := "BT /F1 24 Tf 10 100 Td (SECRET word) Tj ET\nBT /F1 24 Tf 10 300 Td (KEEP form) Tj ET\nq 100 0 0 100 250 400 cm /Im1 Do Q\n"
+		content := "q 1 0 0 1 50 50 cm /Fm1 Do Q\nBT /F1 24 Tf 100 700 Td (BOLD text) Tj ET\nBT /F1 24 Tf 100 700 Td (BOLD text) Tj ET\n" +
+			"1 0 0 RG 4 w 50 300 m 250 300 l 50 350 m 250 350 l S\n"
+		data := buildTestPDF([]string{
+			"<</Type /Catalog /Pages 2 0 R>>",
+			"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+			"<</Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources <</Font <</F1 5 0 R>> /XObject <</Fm1 6 0 R>>>>>>",
+			testPDFStream("", content),
+			"<</Type /Font /Subtype /Type1 /BaseFont /Helvetica>>",
+			testPDFStream("/Type /XObject /Subtype /Form /BBox [0 0 500 500] /Resources <</Font <</F1 5 0 R>> /XObject <</Im1 7 0 R>>>>", form),
+			testPDFStream("/Type /XObject /Subtype /Image /Width 4 /Height 4 /ColorSpace /DeviceRGB /BitsPerComponent 8", strings.Repeat("\xff\x00\x00", 16)),
+		})
+		formDocRes, err := instance.OpenDocument(&requests.OpenDocument{
+			File: &data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		formPage := requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: formDocRes.Document,
+				Index:    0,
 			},
-		}, 2, Color{R: 0, G: 255, B: 0}, 120)
-		err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+		}
+
+		var quads []QuadPoint
+		for _, word := range []string{"SECRET", "BOLD"} {
+			matches, err := SearchTextInPage(instance, formDocRes.Document, 0, word, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != 1 {
+				t.Fatalf("expect 1 match of %s, got %d", word, len(matches))
+			}
+			quads = append(quads, matches[0].QuadPoints...)
+		}
+		// the upper left quarter of the image and the middle of the lower subpath
+		quads = append(quads,
+			rectQuadPoint(Rect{Left: 300, Bottom: 500, Right: 350, Top: 550}),
+			rectQuadPoint(Rect{Left: 100, Bottom: 290, Right: 150, Top: 310}),
+		)
+		redactAnnot := NewRedactAnnotation()
+		redactAnnot.QuadPoints = quads
+		redactAnnot.GenerateAppearance()
+		err = redactAnnot.AddAnnotationToPage(context.Background(), instance, formPage)
+		if err != nil && !IsWarning(err) {
+			t.Fatal(err)
+		}
+		_, err = ApplyRedactions(instance, formDocRes.Document, 0)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+		formFile := "data/simple_redact_form.pdf"
+		savedPage := savePDFAndReopen(t, formDocRes.Document, formFile)
+		if word := savedStreamsContain(t, formFile, "SECRET", "BOLD"); word != "" {
+			t.Fatalf("expect %q to be removed from the saved file", word)
+		}
+		text := pageText(savedPage)
+		if strings.Contains(text, "SECRET") || strings.Contains(text, "BOLD") || !strings.Contains(text, "KEEP form") {
+			t.Fatalf("unexpected text after the redaction: %q", text)
+		}
+
+		countRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+			Page: savedPage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var images, keptPaths int
+		for i := 0; i < countRes.Count; i++ {
+			objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+				Page:  savedPage,
+				Index: i,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			typeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
+				PageObject: objRes.PageObject,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds, err := getPageObjectBounds(instance, objRes.PageObject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch typeRes.Type {
+			case enums.FPDF_PAGEOBJ_FORM:
+				t.Fatalf("expect the form to be replaced by its objects")
+			case enums.FPDF_PAGEOBJ_IMAGE:
+				images++
+				dataRes, err := instance.FPDFImageObj_GetImageDataDecoded(&requests.FPDFImageObj_GetImageDataDecoded{
+					ImageObject: objRes.PageObject,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				pixels := dataRes.Data
+				if len(pixels) != 48 || !bytes.Equal(pixels[:3], []byte{0, 0, 0}) || !bytes.Equal(pixels[45:], []byte{255, 0, 0}) {
+					t.Fatalf("expect the upper left pixels black and the others red, got %v", pixels)
+				}
+			case enums.FPDF_PAGEOBJ_PATH:
+				// the painted boxes start right of the subpaths
+				if bounds.Left > 60 {
+					continue
+				}
+				if bounds.Bottom < 300 && bounds.Top > 300 {
+					t.Fatalf("expect the lower subpath to be removed, got a path in %v", bounds)
+				}
+				if bounds.Bottom < 350 && bounds.Top > 350 {
+					keptPaths++
+				}
+			}
+		}
+		if images != 1 || keptPaths != 1 {
+			t.Fatalf("expect the image and the upper subpath to be kept, got %d images and %d paths", images, keptPaths)
+		}
+	})
+}
+
+func TestSavePDFObjectStreams(t *testing.T) {
+	data := buildObjectStreamTestPDF([]string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+		"<</Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R>>",
+		testPDFStream("", "0 0 1 rg 100 100 50 50 re f"),
+	})
+	objects, trailer, err := (&pdfParser{data: data}).parseBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, _ := resolvePDFObject(objects, trailer["Root"]).(pdfDict)
+	pages, _ := resolvePDFObject(objects, catalog["Pages"]).(pdfDict)
+	if pages["Type"] != pdfName("Pages") || !reflect.DeepEqual(pages["Kids"], []any{pdfRef{num: 3}}) {
+		t.Fatalf("expect the pages read from the object stream, got %v", pages)
+	}
+
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		File: &data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
 			Document: docRes.Document,
-			FilePath: &outputFile,
+			Index:    0,
+		},
+	}
+
+	lineAnnot := NewLineAnnotation()
+	lineAnnot.SetWidth(2)
+	lineAnnot.SetStrikeColor(Color{R: 255})
+	lineAnnot.SetLineTo(100, 200, 300, 400)
+	lineAnnot.GenerateAppearance()
+	err = lineAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil && !IsWarning(err) {
+		t.Fatal(err)
+	}
+
+	// the saved file is saved again, the line is written once
+	for i, outputFile := range []string{"data/objstm_line.pdf", "data/objstm_line_resaved.pdf"} {
+		os.Remove(outputFile)
+		page = savePDFAndReopen(t, page.ByIndex.Document, outputFile)
+		annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+			Page:  page,
+			Index: 0,
 		})
 		if err != nil {
-			t.Fatalf("save stamp document failed: %v", err)
+			t.Fatal(err)
+		}
+		subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil || subtypeRes.Subtype != enums.FPDF_ANNOT_SUBTYPE_LINE {
+			t.Fatalf("expect a line in %s, got %v %v", outputFile, subtypeRes, err)
+		}
+		annots, err := LoadAnnotationsInPage(instance, page.ByIndex.Document, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, ok := annots[0].(*LineAnnotation)
+		if len(annots) != 1 || !ok || loaded.GetLineTo() != lineAnnot.GetLineTo() {
+			t.Fatalf("expect the line in %s, got %+v", outputFile, annots)
+		}
+		saved, err := os.ReadFile(outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(saved, []byte("startxref")); n > i+2 {
+			t.Fatalf("expect at most %d revisions in %s, got %d", i+2, outputFile, n)
+		}
+	}
+}
+
+// buildTestPDF returns a pdf of the objects, numbered from 1, the first one is the catalog.
+func buildTestPDF(objects []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f\r\n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n\r\n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<</Size %d /Root 1 0 R>>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func testPDFStream(dict, data string) string {
+	return fmt.Sprintf("<<%s /Length %d>>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// buildObjectStreamTestPDF returns a pdf like buildTestPDF, with the objects that are no streams
+// in an object stream and a cross-reference stream instead of the table.
+func buildObjectStreamTestPDF(objects []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	objStmNum, xrefNum := len(objects)+1, len(objects)+2
+	// the entries of the cross-reference stream: type, offset or object stream, generation or index
+	entries := make([][3]int, xrefNum+1)
+	entries[0] = [3]int{0, 0, 65535}
+	var header, body bytes.Buffer
+	var count int
+	for i, object := range objects {
+		if strings.Contains(object, "\nstream\n") {
+			entries[i+1] = [3]int{1, buf.Len(), 0}
+			fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+			continue
+		}
+		entries[i+1] = [3]int{2, objStmNum, count}
+		fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+		body.WriteString(object + "\n")
+		count++
+	}
+	entries[objStmNum] = [3]int{1, buf.Len(), 0}
+	fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", objStmNum,
+		testPDFStream(fmt.Sprintf("/Type /ObjStm /N %d /First %d", count, header.Len()), header.String()+body.String()))
+
+	xref := buf.Len()
+	entries[xrefNum] = [3]int{1, xref, 0}
+	var xrefData []byte
+	for _, entry := range entries {
+		xrefData = append(xrefData, byte(entry[0]), byte(entry[1]>>24), byte(entry[1]>>16), byte(entry[1]>>8), byte(entry[1]), byte(entry[2]>>8), byte(entry[2]))
+	}
+	fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", xrefNum,
+		testPDFStream(fmt.Sprintf("/Type /XRef /Size %d /W [1 4 2] /Root 1 0 R", xrefNum+1), string(xrefData)))
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xref)
+	return buf.Bytes()
+}
+
+// savedStreamsContain returns the first word found in the decoded streams of the first page of the file,
+// its contents and resources, as text or as the hex string pdfium writes.
+func savedStreamsContain(t *testing.T, file string, words ...string) string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, trailer, err := (&pdfParser{data: data}).parseBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, _ := resolvePDFObject(objects, trailer["Root"]).(pdfDict)
+	pages, _ := resolvePDFObject(objects, catalog["Pages"]).(pdfDict)
+	kids, _ := resolvePDFObject(objects, pages["Kids"]).([]any)
+	if len(kids) == 0 {
+		t.Fatalf("no page in %s", file)
+	}
+
+	var streams bytes.Buffer
+	visited := map[int]bool{}
+	var walk func(obj any)
+	walk = func(obj any) {
+		if ref, ok := obj.(pdfRef); ok {
+			if visited[ref.num] {
+				return
+			}
+			visited[ref.num] = true
+			obj = objects[ref.num]
+		}
+		switch obj := obj.(type) {
+		case pdfDict:
+			for key, value := range obj {
+				if key != "Parent" && key != "Annots" {
+					walk(value)
+				}
+			}
+		case []any:
+			for _, value := range obj {
+				walk(value)
+			}
+		case *pdfStream:
+			decoded, err := decodePDFStream(obj)
+			if err != nil {
+				// images may use filters the parser can not decode
+				decoded = obj.data
+			}
+			streams.Write(decoded)
+			walk(obj.dict)
+		}
+	}
+	walk(kids[0])
+
+	for _, word := range words {
+		if bytes.Contains(streams.Bytes(), []byte(word)) || bytes.Contains(streams.Bytes(), []byte(strings.ToUpper(hex.EncodeToString([]byte(word))))) {
+			return word
+		}
+	}
+	return ""
+}
+
+func TestSearchText(t *testing.T) {
+	t.Run("quad points of lines", func(t *testing.T) {
+		// two horizontal lines, then text going up the page
+		chars := []textChar{
+			{box: Rect{Left: 100, Bottom: 500, Right: 108, Top: 512}, origin: Point{X: 100, Y: 502}},
+			{box: Rect{Left: 108, Bottom: 500, Right: 116, Top: 510}, origin: Point{X: 108, Y: 502}},
+			{box: Rect{Left: 100, Bottom: 480, Right: 108, Top: 492}, origin: Point{X: 100, Y: 482}},
+			{box: Rect{Left: 300, Bottom: 100, Right: 312, Top: 108}, origin: Point{X: 310, Y: 100}, angle: math.Pi / 2},
+			{box: Rect{Left: 300, Bottom: 108, Right: 312, Top: 116}, origin: Point{X: 310, Y: 108}, angle: math.Pi / 2},
+		}
+		quads := charLinesQuadPoints(chars)
+		if len(quads) != 3 {
+			t.Fatalf("expect 3 lines, got %d", len(quads))
+		}
+		first := QuadPoint{
+			LeftTopX: 100, LeftTopY: 512, RightTopX: 116, RightTopY: 512,
+			LeftBottomX: 100, LeftBottomY: 500, RightBottomX: 116, RightBottomY: 500,
+		}
+		if quads[0] != first {
+			t.Fatalf("unexpected first line: %+v", quads[0])
+		}
+		// the bottom of the rotated line is its baseline side, on the right
+		rotated := quads[2]
+		if abs32(rotated.LeftBottomX-312) > 0.001 || abs32(rotated.LeftBottomY-100) > 0.001 ||
+			abs32(rotated.RightTopX-300) > 0.001 || abs32(rotated.RightTopY-116) > 0.001 {
+			t.Fatalf("unexpected rotated line: %+v", rotated)
 		}
 	})
 
-	t.Run("add text to stamp annot", func(t *testing.T) {
+	t.Run("search and highlight", func(t *testing.T) {
+		inputFile := "simple_text.pdf"
+		outputFile := "data/simple_search.pdf"
+		os.Remove(outputFile)
 		docRes, err := instance.OpenDocument(&requests.OpenDocument{
 			FilePath: &inputFile,
 		})
@@ -703,19 +1861,78 @@ func TestAddStampAnnotation(t *testing.T) {
 			t.Fatalf("open document failed: %v", err)
 		}
 
-		page := requests.Page{
-			ByIndex: &requests.PageByIndex{
-				Document: docRes.Document,
-				Index:    0,
-			},
+		words, err := SearchRegexpInPage(instance, docRes.Document, 0, regexp.MustCompile(`\pL{3,}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(words) == 0 {
+			t.Fatalf("expect words on the page")
+		}
+		if len(words[0].QuadPoints) != 1 {
+			t.Fatalf("expect one line for a word, got %d", len(words[0].QuadPoints))
+		}
+
+		// the plain search finds the word again
+		matches, err := SearchTextInPDF(instance, docRes.Document, words[0].Text, &SearchOptions{MatchCase: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) == 0 || matches[0].CharIndex != words[0].CharIndex || matches[0].QuadPoints[0] != words[0].QuadPoints[0] {
+			t.Fatalf("unexpected matches of %q: %+v", words[0].Text, matches)
+		}
+
+		annots, err := AddTextMarkupsToPDF(context.Background(), instance, docRes.Document, enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT, matches, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the word is on every page
+		var loaded []Annotation
+		loadedPages := map[int]bool{}
+		for _, match := range matches {
+			if loadedPages[match.PageNumber] {
+				continue
+			}
+			loadedPages[match.PageNumber] = true
+			pageAnnots, err := LoadAnnotationsInPage(instance, docRes.Document, match.PageNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded = append(loaded, pageAnnots...)
+		}
+		if len(annots) != len(matches) || len(loaded) < len(annots) {
+			t.Fatalf("expect %d highlights, got %d added and %d loaded", len(matches), len(annots), len(loaded))
+		}
+
+		_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+			Document: docRes.Document,
+			FilePath: &outputFile,
+		})
+		if err != nil {
+			t.Fatalf("save search document failed: %v", err)
 		}
+	})
+}
 
-		_ = page
-		// outputFile := "data/simple_text_stamp.pdf"
-		// os.Remove(outputFile)
+func TestSelectCharRange(t *testing.T) {
+	t.Run("merge line rects", func(t *testing.T) {
+		rects := []Rect{
+			{Left: 100, Bottom: 500, Right: 150, Top: 512},
+			{Left: 152, Bottom: 499, Right: 200, Top: 514}, // bigger font on the same line
+			{Left: 100, Bottom: 480, Right: 180, Top: 492},
+		}
+		lines := mergeLineRects(rects)
+		if len(lines) != 2 {
+			t.Fatalf("expect 2 lines, got %d", len(lines))
+		}
+		if lines[0] != (Rect{Left: 100, Bottom: 499, Right: 200, Top: 514}) || lines[1] != rects[2] {
+			t.Fatalf("unexpected lines: %+v", lines)
+		}
 	})
 
-	t.Run("add img to stamp annot", func(t *testing.T) {
+	t.Run("select chars", func(t *testing.T) {
+		inputFile := "simple_text.pdf"
+		outputFile := "data/simple_select.pdf"
+		os.Remove(outputFile)
 		docRes, err := instance.OpenDocument(&requests.OpenDocument{
 			FilePath: &inputFile,
 		})
@@ -723,29 +1940,48 @@ func TestAddStampAnnotation(t *testing.T) {
 			t.Fatalf("open document failed: %v", err)
 		}
 
+		words, err := SearchRegexpInPage(instance, docRes.Document, 0, regexp.MustCompile(`\pL{3,}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(words) == 0 {
+			t.Fatalf("expect words on the page")
+		}
+
+		// a selection of the same characters lines up with the search hit
+		selection, err := SelectCharRange(instance, docRes.Document, 0, words[0].CharIndex, words[0].CharCount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selection.Text != words[0].Text || len(selection.QuadPoints) != 1 || selection.QuadPoints[0] != words[0].QuadPoints[0] {
+			t.Fatalf("unexpected selection: %+v, word: %+v", selection, words[0])
+		}
+
+		_, err = SelectCharRange(instance, docRes.Document, 0, -1, 3)
+		if err == nil {
+			t.Fatalf("expect out of range error")
+		}
+
+		// the whole page
+		quads, err := CharRangeQuadPoints(instance, docRes.Document, 0, 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(quads) == 0 {
+			t.Fatalf("expect quads for the page text")
+		}
+
+		underlineAnnot, err := NewTextMarkupAnnotation(enums.FPDF_ANNOT_SUBTYPE_UNDERLINE, selection, &Color{R: 0, G: 0, B: 255})
+		if err != nil {
+			t.Fatal(err)
+		}
 		page := requests.Page{
 			ByIndex: &requests.PageByIndex{
 				Document: docRes.Document,
 				Index:    0,
 			},
 		}
-
-		var stampAnnot = NewStampAnnotation()
-		stampAnnot.SetRect(Rect{
-			Left:   100,
-			Bottom: 100,
-			Top:    146,
-			Right:  168,
-		})
-
-		outputFile := "data/simple_stamp_img.pdf"
-		os.Remove(outputFile)
-
-		// set image object
-		stampAnnot.SetImgObject("jpeg", docRes.Document, "simple.jpeg")
-
-		// add annotation to page
-		err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+		err = underlineAnnot.AddAnnotationToPage(context.Background(), instance, page)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -755,7 +1991,2112 @@ func TestAddStampAnnotation(t *testing.T) {
 			FilePath: &outputFile,
 		})
 		if err != nil {
-			t.Fatalf("save img stamp document failed: %v", err)
+			t.Fatalf("save select document failed: %v", err)
+		}
+	})
+}
+
+func TestMarkedText(t *testing.T) {
+	t.Run("char overlap", func(t *testing.T) {
+		// corners in the order of another tool, the polygon is ordered again
+		quad := QuadPoint{
+			LeftTopX: 100, LeftTopY: 100, RightTopX: 200, RightTopY: 100,
+			LeftBottomX: 200, LeftBottomY: 110, RightBottomX: 100, RightBottomY: 110,
+		}
+		polygon := convexPolygon(quad.points())
+		if overlap := charOverlap(Rect{Left: 110, Bottom: 105, Right: 120, Top: 115}, polygon); abs32(overlap-0.5) > 0.001 {
+			t.Fatalf("expect half the box marked, got %v", overlap)
+		}
+		if overlap := charOverlap(Rect{Left: 120, Bottom: 101, Right: 130, Top: 109}, polygon); abs32(overlap-1) > 0.001 {
+			t.Fatalf("expect the whole box marked, got %v", overlap)
+		}
+		if overlap := charOverlap(Rect{Left: 210, Bottom: 101, Right: 220, Top: 109}, polygon); overlap != 0 {
+			t.Fatalf("expect the box outside, got %v", overlap)
 		}
 	})
+
+	t.Run("text under highlights", func(t *testing.T) {
+		inputFile := "simple_text.pdf"
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+
+		words, err := SearchRegexpInPage(instance, docRes.Document, 0, regexp.MustCompile(`\pL{3,}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(words) == 0 {
+			t.Fatalf("expect words on the page")
+		}
+		annots, err := AddTextMarkupsToPDF(context.Background(), instance, docRes.Document, enums.FPDF_ANNOT_SUBTYPE_SQUIGGLY, words[:1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, minOverlap := range []float32{0, DefaultMarkedTextOverlap} {
+			marked, err := GetMarkedTextsInPage(instance, docRes.Document, 0, minOverlap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var found bool
+			for _, m := range marked {
+				if m.Annotation.GetNM() == annots[0].GetNM() {
+					found = true
+					if m.Text != words[0].Text {
+						t.Fatalf("expect %q under the squiggly with overlap %v, got %q", words[0].Text, minOverlap, m.Text)
+					}
+				}
+			}
+			if !found {
+				t.Fatalf("squiggly annot not found")
+			}
+		}
+
+		text, err := GetMarkedText(instance, docRes.Document, 0, annots[0], 0.9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != words[0].Text {
+			t.Fatalf("expect %q with a high overlap, got %q", words[0].Text, text)
+		}
+	})
+
+	t.Run("text of another line in between", func(t *testing.T) {
+		// the lower line is drawn between the two words of the upper line
+		content := "BT /F1 24 Tf 100 700 Td (LEFT) Tj ET\nBT /F1 24 Tf 100 600 Td (OTHER) Tj ET\nBT /F1 24 Tf 300 700 Td (RIGHT) Tj ET\n"
+		data := buildTestPDF([]string{
+			"<</Type /Catalog /Pages 2 0 R>>",
+			"<</Type /Pages /Kids [3 0 R] /Count 1>>",
+			"<</Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources <</Font <</F1 5 0 R>>>>>>",
+			testPDFStream("", content),
+			"<</Type /Font /Subtype /Type1 /BaseFont /Helvetica>>",
+		})
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			File: &data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		highlightAnnot := NewHighlightAnnotation()
+		highlightAnnot.QuadPoints = []QuadPoint{rectQuadPoint(Rect{Left: 90, Bottom: 690, Right: 450, Top: 730})}
+		text, err := GetMarkedText(instance, docRes.Document, 0, highlightAnnot, DefaultMarkedTextOverlap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != "LEFT RIGHT" {
+			t.Fatalf("expect the words of the marked line only, got %q", text)
+		}
+	})
+}
+
+func TestXFDF(t *testing.T) {
+	newAnnots := func() []Annotation {
+		highlightAnnot := NewHighlightAnnotation()
+		highlightAnnot.SetRect(Rect{Left: 100, Bottom: 500, Right: 200, Top: 520})
+		highlightAnnot.QuadPoints = []QuadPoint{rectQuadPoint(highlightAnnot.GetRect())}
+		highlightAnnot.SetStrikeColor(Color{R: 255, G: 255, B: 0})
+		highlightAnnot.SetTitle("reviewer")
+		highlightAnnot.SetContents("check this")
+		highlightAnnot.SetOpacity(128)
+		highlightAnnot.SetCreationDate(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC))
+		highlightAnnot.GenerateAppearance()
+
+		inkAnnot := NewInkAnnotation()
+		inkAnnot.SetRect(Rect{Left: 100, Bottom: 300, Right: 300, Top: 400})
+		inkAnnot.Points = [][]Point{{{X: 110, Y: 310}, {X: 150.5, Y: 350}}, {{X: 200, Y: 390}, {X: 290, Y: 320}}}
+		inkAnnot.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+		inkAnnot.SetWidth(2)
+		inkAnnot.GenerateAppearance()
+
+		lineAnnot := NewLineAnnotation()
+		lineAnnot.SetRect(Rect{Left: 0, Bottom: 0, Right: 600, Top: 700})
+		lineAnnot.SetLineTo(100, 200, 300, 250)
+		lineAnnot.SetLineEndings(LineEndingNone, LineEndingClosedArrow)
+		lineAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+		lineAnnot.GenerateAppearance()
+
+		squareAnnot := NewSquareAnnotation()
+		squareAnnot.SetRect(Rect{Left: 350, Bottom: 100, Right: 450, Top: 200})
+		squareAnnot.SetStrikeColor(Color{R: 0, G: 128, B: 0})
+		squareAnnot.SetBorderStyle(BorderStyleDashed, 4, 2)
+		squareAnnot.GenerateAppearance()
+
+		polygonAnnot := NewPolygonAnnotation()
+		polygonAnnot.SetRect(Rect{Left: 0, Bottom: 0, Right: 300, Top: 300})
+		polygonAnnot.Vertices = []Point{{X: 10, Y: 10}, {X: 100, Y: 200}, {X: 250, Y: 20}}
+		polygonAnnot.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+		polygonAnnot.GenerateAppearance()
+
+		redactAnnot := NewRedactAnnotation()
+		redactAnnot.SetRect(Rect{Left: 100, Bottom: 100, Right: 200, Top: 120})
+		redactAnnot.QuadPoints = []QuadPoint{rectQuadPoint(redactAnnot.GetRect())}
+		redactAnnot.SetFillColor(Color{R: 0, G: 0, B: 0})
+		redactAnnot.GenerateAppearance()
+		return []Annotation{highlightAnnot, inkAnnot, lineAnnot, squareAnnot, polygonAnnot, redactAnnot}
+	}
+
+	t.Run("marshal and unmarshal", func(t *testing.T) {
+		annots := newAnnots()
+		data, err := MarshalXFDF([]AddOnePageAnnot{{PageNumber: 1, Annots: annots}}, "simple.pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageAnnots, err := UnmarshalXFDF(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pageAnnots) != 1 || pageAnnots[0].PageNumber != 1 || len(pageAnnots[0].Annots) != len(annots) {
+			t.Fatalf("unexpected annots in xfdf: %s", data)
+		}
+
+		for i, annot := range pageAnnots[0].Annots {
+			want := annots[i]
+			if annot.GetSubtype() != want.GetSubtype() || annot.GetNM() != want.GetNM() || annot.GetRect() != want.GetRect() {
+				t.Fatalf("annot %d: expect %s %s, got %s %s", i, want.GetSubtypeName(), want.GetNM(), annot.GetSubtypeName(), annot.GetNM())
+			}
+			b, wantBase := annot.(annotationBase).base(), want.(annotationBase).base()
+			if b.title != wantBase.title || b.contents != wantBase.contents || b.opacity != wantBase.opacity || b.ap != wantBase.ap {
+				t.Fatalf("annot %d: base keys differ", i)
+			}
+			if !b.creationDate.Equal(wantBase.creationDate) {
+				t.Fatalf("annot %d: expect creation date %v, got %v", i, wantBase.creationDate, b.creationDate)
+			}
+		}
+
+		highlightAnnot := pageAnnots[0].Annots[0].(*HighlightAnnotation)
+		if *highlightAnnot.GetFillColor() != (Color{R: 255, G: 255, B: 0}) || len(highlightAnnot.QuadPoints) != 1 ||
+			highlightAnnot.QuadPoints[0] != annots[0].(*HighlightAnnotation).QuadPoints[0] {
+			t.Fatalf("unexpected highlight: %+v", highlightAnnot)
+		}
+		inkAnnot := pageAnnots[0].Annots[1].(*InkAnnotation)
+		if !reflect.DeepEqual(inkAnnot.Points, annots[1].(*InkAnnotation).Points) || inkAnnot.GetWidth() != 2 {
+			t.Fatalf("unexpected ink: %+v", inkAnnot)
+		}
+		lineAnnot := pageAnnots[0].Annots[2].(*LineAnnotation)
+		if lineAnnot.GetLineTo() != annots[2].(*LineAnnotation).GetLineTo() || lineAnnot.LineEndings[1] != LineEndingClosedArrow {
+			t.Fatalf("unexpected line: %+v", lineAnnot)
+		}
+		squareAnnot := pageAnnots[0].Annots[3].(*SquareAnnotation)
+		if squareAnnot.Style != BorderStyleDashed || !reflect.DeepEqual(squareAnnot.DashArray, []int{4, 2}) {
+			t.Fatalf("unexpected square border: %+v", squareAnnot.BorderStyle)
+		}
+	})
+
+	t.Run("export and import", func(t *testing.T) {
+		inputFile := "simple.pdf"
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+		annots := newAnnots()
+		_, err = AddAnnotationsToPDF(context.Background(), instance, docRes.Document, []AddOnePageAnnot{{PageNumber: 0, Annots: annots}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		err = ExportXFDF(instance, docRes.Document, nil, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// apply the markup to a fresh copy
+		freshRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+		added, err := ImportXFDF(context.Background(), instance, freshRes.Document, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != len(annots) {
+			t.Fatalf("expect %d annots imported, got %d", len(annots), added)
+		}
+		loaded, err := LoadAnnotationsInPage(instance, freshRes.Document, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, annot := range annots {
+			var found bool
+			for _, l := range loaded {
+				if l.GetNM() == annot.GetNM() && l.GetSubtype() == annot.GetSubtype() {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("%s annot %s not imported", annot.GetSubtypeName(), annot.GetNM())
+			}
+		}
+
+		// stamps of the subtypes pdfium can not create get their keys written by SavePDF
+		savedPage := savePDFAndReopen(t, freshRes.Document, "data/simple_xfdf_imported.pdf")
+		checkImportedGeometry(t, savedPage, annots)
+	})
+
+	t.Run("free text and image stamp on a fresh document", func(t *testing.T) {
+		doc := newBlankDocument(t)
+		_, err := AddAnnotationsToPDF(context.Background(), instance, doc, []AddOnePageAnnot{{PageNumber: 0, Annots: newRoundTripAnnots()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = ExportXFDF(instance, doc, nil, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "<imagedata>data:image/png;base64,") {
+			t.Fatalf("expect the image of the stamp in the xfdf: %s", buf.String())
+		}
+
+		freshDoc := newBlankDocument(t)
+		added, err := ImportXFDF(context.Background(), instance, freshDoc, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != 2 {
+			t.Fatalf("expect 2 annots imported, got %d", added)
+		}
+		checkRoundTripRender(t, savePDFAndReopen(t, freshDoc, "data/blank_xfdf_imported.pdf"))
+	})
+}
+
+// newBlankDocument creates a document with one empty letter page.
+func newBlankDocument(t *testing.T) references.FPDF_DOCUMENT {
+	docRes, err := instance.FPDF_CreateNewDocument(&requests.FPDF_CreateNewDocument{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = instance.FPDFPage_New(&requests.FPDFPage_New{
+		Document:  docRes.Document,
+		PageIndex: 0,
+		Width:     612,
+		Height:    792,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return docRes.Document
+}
+
+// newRoundTripAnnots returns a free text and an image stamp, their appearances need resources.
+func newRoundTripAnnots() []Annotation {
+	freeTextAnnot := NewFreeTextAnnotation()
+	freeTextAnnot.SetRect(Rect{Left: 100, Bottom: 500, Right: 300, Top: 600})
+	freeTextAnnot.SetFillColor(Color{R: 255, G: 255, B: 0})
+	freeTextAnnot.Contents = "HELLO"
+	freeTextAnnot.FontSize = 40
+	freeTextAnnot.FontColor = Color{R: 255, G: 0, B: 0}
+	freeTextAnnot.GenerateAppearance()
+
+	// the left half is opaque blue, the right half transparent
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 10; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{B: 255, A: 255})
+		}
+	}
+	stampAnnot := NewStampAnnotation()
+	stampAnnot.SetRect(Rect{Left: 300, Bottom: 300, Right: 400, Top: 400})
+	stampAnnot.SetImgObjectImage("", img)
+	return []Annotation{freeTextAnnot, stampAnnot}
+}
+
+// checkRoundTripRender renders the page and checks the annotations of newRoundTripAnnots are drawn.
+func checkRoundTripRender(t *testing.T, page requests.Page) {
+	renderRes, err := instance.RenderPageInDPI(&requests.RenderPageInDPI{
+		Page:        page,
+		DPI:         72,
+		RenderFlags: enums.FPDF_RENDER_FLAG_ANNOT,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer renderRes.Cleanup()
+	img := renderRes.Result.Image
+	pixel := func(x, y int) color.RGBA {
+		return img.RGBAAt(x, 792-y)
+	}
+
+	if c := pixel(325, 350); c.R > 50 || c.G > 50 || c.B < 200 {
+		t.Fatalf("expect the opaque half of the stamp blue, got %v", c)
+	}
+	if c := pixel(375, 350); c.R < 200 || c.G < 200 || c.B < 200 {
+		t.Fatalf("expect the transparent half of the stamp white, got %v", c)
+	}
+	if c := pixel(110, 505); c.R < 200 || c.G < 200 || c.B > 50 {
+		t.Fatalf("expect the free text filled yellow, got %v", c)
+	}
+	var red int
+	for y := 500; y < 600; y++ {
+		for x := 100; x < 300; x++ {
+			if c := pixel(x, y); c.R > 200 && c.G < 80 && c.B < 80 {
+				red++
+			}
+		}
+	}
+	if red == 0 {
+		t.Fatal("expect the red text of the free text")
+	}
+}
+
+// checkImportedGeometry checks that the saved page holds the annotations with their subtype and geometry,
+// read by this package and by pdfium.
+func checkImportedGeometry(t *testing.T, page requests.Page, annots []Annotation) {
+	loaded, err := LoadAnnotationsInPage(instance, page.ByIndex.Document, page.ByIndex.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byNM := map[string]Annotation{}
+	for _, annot := range loaded {
+		byNM[annot.GetNM()] = annot
+	}
+	for _, want := range annots {
+		got := byNM[want.GetNM()]
+		if got == nil || got.GetSubtype() != want.GetSubtype() {
+			t.Fatalf("%s annot %s not saved", want.GetSubtypeName(), want.GetNM())
+		}
+		var equal bool
+		switch w := want.(type) {
+		case *LineAnnotation:
+			equal = got.(*LineAnnotation).GetLineTo() == w.GetLineTo() && got.(*LineAnnotation).LineEndings == w.LineEndings
+		case *PolygonAnnotation:
+			equal = reflect.DeepEqual(got.(*PolygonAnnotation).Vertices, w.Vertices)
+		case *PolylineAnnotation:
+			equal = reflect.DeepEqual(got.(*PolylineAnnotation).Vertices, w.Vertices) && got.(*PolylineAnnotation).LineEndings == w.LineEndings
+		case *RedactAnnotation:
+			equal = reflect.DeepEqual(got.(*RedactAnnotation).QuadPoints, w.QuadPoints)
+		case *InkAnnotation:
+			equal = reflect.DeepEqual(got.(*InkAnnotation).Points, w.Points)
+		default:
+			equal = true
+		}
+		if !equal {
+			t.Fatalf("%s annot %s differs after import: %+v", want.GetSubtypeName(), want.GetNM(), got)
+		}
+	}
+
+	// pdfium reads the written keys, not the stash
+	countRes, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+		Page: page,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < countRes.Count; i++ {
+		annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+			Page:  page,
+			Index: i,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch subtypeRes.Subtype {
+		case enums.FPDF_ANNOT_SUBTYPE_STAMP:
+			t.Fatalf("annot %d is still a stamp", i)
+		case enums.FPDF_ANNOT_SUBTYPE_LINE:
+			_, err = instance.FPDFAnnot_GetLine(&requests.FPDFAnnot_GetLine{
+				Annotation: annotRes.Annotation,
+			})
+		case enums.FPDF_ANNOT_SUBTYPE_POLYGON, enums.FPDF_ANNOT_SUBTYPE_POLYLINE:
+			verticesRes, verticesErr := instance.FPDFAnnot_GetVertices(&requests.FPDFAnnot_GetVertices{
+				Annotation: annotRes.Annotation,
+			})
+			if verticesErr == nil && len(verticesRes.Vertices) == 0 {
+				t.Fatalf("annot %d has no /Vertices", i)
+			}
+			err = verticesErr
+		}
+		if err != nil {
+			t.Fatalf("annot %d: %v", i, err)
+		}
+		instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+			Annotation: annotRes.Annotation,
+		})
+	}
+}
+
+func TestFDF(t *testing.T) {
+	t.Run("marshal and unmarshal", func(t *testing.T) {
+		inkAnnot := NewInkAnnotation()
+		inkAnnot.SetRect(Rect{Left: 100, Bottom: 300, Right: 300, Top: 400})
+		inkAnnot.Points = [][]Point{{{X: 110, Y: 310}, {X: 150.5, Y: 350}}}
+		inkAnnot.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+		inkAnnot.SetTitle("réviseur")
+		inkAnnot.SetContents("a (note)\nwith two lines")
+		inkAnnot.SetModDate(time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("", 8*3600)))
+		inkAnnot.GenerateAppearance()
+
+		textAnnot := NewTextAnnotation()
+		textAnnot.SetPosition(50, 700)
+		textAnnot.SetIcon(TextIconNote)
+		textAnnot.SetPopupRect(Rect{Left: 80, Bottom: 600, Right: 280, Top: 700})
+		textAnnot.GenerateAppearance()
+
+		caretAnnot := NewUnsupportedAnnotation(enums.FPDF_ANNOT_SUBTYPE_CARET)
+		caretAnnot.SetRect(Rect{Left: 10, Bottom: 10, Right: 20, Top: 20})
+		caretAnnot.Keys = map[string]string{"Subj": "(Inserted Text)", "Sy": "/P", "RD": "[1 1 1 1]"}
+
+		annots := []Annotation{inkAnnot, textAnnot, caretAnnot}
+		data, err := MarshalFDF([]AddOnePageAnnot{{PageNumber: 2, Annots: annots}}, "simple.pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageAnnots, err := UnmarshalFDF(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pageAnnots) != 1 || pageAnnots[0].PageNumber != 2 || len(pageAnnots[0].Annots) != len(annots) {
+			t.Fatalf("unexpected annots in fdf: %s", data)
+		}
+
+		for i, annot := range pageAnnots[0].Annots {
+			want := annots[i]
+			b, wantBase := annot.(annotationBase).base(), want.(annotationBase).base()
+			if annot.GetSubtype() != want.GetSubtype() || b.nm != wantBase.nm || b.rect != wantBase.rect {
+				t.Fatalf("annot %d: expect %s %s, got %s %s", i, want.GetSubtypeName(), want.GetNM(), annot.GetSubtypeName(), annot.GetNM())
+			}
+			if b.title != wantBase.title || b.contents != wantBase.contents || b.ap != wantBase.ap || !b.modDate.Equal(wantBase.modDate) {
+				t.Fatalf("annot %d: base keys differ", i)
+			}
+		}
+
+		inkGot := pageAnnots[0].Annots[0].(*InkAnnotation)
+		if !reflect.DeepEqual(inkGot.Points, inkAnnot.Points) || *inkGot.GetStrikeColor() != *inkAnnot.GetStrikeColor() {
+			t.Fatalf("unexpected ink: %+v", inkGot)
+		}
+		textGot := pageAnnots[0].Annots[1].(*TextAnnotation)
+		if textGot.Icon != TextIconNote || textGot.PopupRect == nil || *textGot.PopupRect != *textAnnot.PopupRect {
+			t.Fatalf("unexpected text: %+v", textGot)
+		}
+		caretGot := pageAnnots[0].Annots[2].(*UnsupportedAnnotation)
+		if !reflect.DeepEqual(caretGot.Keys, caretAnnot.Keys) {
+			t.Fatalf("expect raw keys %v, got %v", caretAnnot.Keys, caretGot.Keys)
+		}
+	})
+
+	t.Run("export and import twice", func(t *testing.T) {
+		inputFile := "simple.pdf"
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+		squareAnnot := NewSquareAnnotation()
+		squareAnnot.SetRect(Rect{Left: 100, Bottom: 100, Right: 200, Top: 200})
+		squareAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+		squareAnnot.SetWidth(2)
+		squareAnnot.GenerateAppearance()
+		highlightAnnot := NewHighlightAnnotation()
+		highlightAnnot.SetRect(Rect{Left: 100, Bottom: 500, Right: 200, Top: 520})
+		highlightAnnot.QuadPoints = []QuadPoint{rectQuadPoint(highlightAnnot.GetRect())}
+		highlightAnnot.SetStrikeColor(Color{R: 255, G: 255, B: 0})
+		highlightAnnot.GenerateAppearance()
+		polylineAnnot := NewPolylineAnnotation()
+		polylineAnnot.SetRect(Rect{Left: 0, Bottom: 300, Right: 300, Top: 600})
+		polylineAnnot.Vertices = []Point{{X: 10, Y: 310}, {X: 100, Y: 500}, {X: 250, Y: 320}}
+		polylineAnnot.LineEndings = [2]LineEnding{LineEndingCircle, LineEndingOpenArrow}
+		polylineAnnot.SetStrikeColor(Color{R: 0, G: 255, B: 0})
+		polylineAnnot.GenerateAppearance()
+		annots := []Annotation{squareAnnot, highlightAnnot, polylineAnnot}
+		_, err = AddAnnotationsToPDF(context.Background(), instance, docRes.Document, []AddOnePageAnnot{{PageNumber: 0, Annots: annots}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		err = ExportFDF(instance, docRes.Document, []int{0}, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+
+		freshRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+		added, err := ImportFDF(context.Background(), instance, freshRes.Document, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != len(annots) {
+			t.Fatalf("expect %d annots imported, got %d", len(annots), added)
+		}
+		// the nms are already on the page
+		added, err = ImportFDF(context.Background(), instance, freshRes.Document, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != 0 {
+			t.Fatalf("expect no annots imported twice, got %d", added)
+		}
+
+		loaded, err := LoadAnnotationsInPage(instance, freshRes.Document, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found int
+		for _, annot := range loaded {
+			for _, want := range annots {
+				if annot.GetNM() == want.GetNM() {
+					found++
+				}
+			}
+		}
+		if found != len(annots) {
+			t.Fatalf("expect the %d annots on the page once, got %d", len(annots), found)
+		}
+
+		savedPage := savePDFAndReopen(t, freshRes.Document, "data/simple_fdf_imported.pdf")
+		checkImportedGeometry(t, savedPage, annots)
+	})
+
+	t.Run("free text and image stamp on a fresh document", func(t *testing.T) {
+		doc := newBlankDocument(t)
+		_, err := AddAnnotationsToPDF(context.Background(), instance, doc, []AddOnePageAnnot{{PageNumber: 0, Annots: newRoundTripAnnots()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = ExportFDF(instance, doc, nil, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte("/Resources <</XObject <</Im0")) || !bytes.Contains(buf.Bytes(), []byte("/SMask")) {
+			t.Fatalf("expect the image of the stamp in the resources of its appearance: %q", buf.String())
+		}
+
+		freshDoc := newBlankDocument(t)
+		added, err := ImportFDF(context.Background(), instance, freshDoc, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != 2 {
+			t.Fatalf("expect 2 annots imported, got %d", added)
+		}
+		checkRoundTripRender(t, savePDFAndReopen(t, freshDoc, "data/blank_fdf_imported.pdf"))
+	})
+}
+
+func TestAnnotationJSON(t *testing.T) {
+	t.Run("marshal and unmarshal", func(t *testing.T) {
+		freeTextAnnot := NewFreeTextAnnotation()
+		freeTextAnnot.SetRect(Rect{Left: 100, Bottom: 600, Right: 300, Top: 650})
+		freeTextAnnot.Contents = "hello"
+		freeTextAnnot.SetAlignment(TextAlignCenter)
+		freeTextAnnot.SetCloudy(1)
+		freeTextAnnot.SetCreationDate(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC))
+
+		polylineAnnot := NewPolylineAnnotation()
+		polylineAnnot.SetRect(Rect{Left: 0, Bottom: 0, Right: 300, Top: 300})
+		polylineAnnot.Vertices = []Point{{X: 10, Y: 10}, {X: 100, Y: 200}, {X: 250.5, Y: 20}}
+		polylineAnnot.LineEndings = [2]LineEnding{LineEndingCircle, LineEndingOpenArrow}
+		polylineAnnot.SetStrikeColor(Color{R: 0, G: 128, B: 255})
+		polylineAnnot.SetOpacity(128)
+		polylineAnnot.SetBorderStyle(BorderStyleDashed, 3, 1)
+		polylineAnnot.GenerateAppearance()
+
+		redactAnnot := NewRedactAnnotation()
+		redactAnnot.SetRect(Rect{Left: 100, Bottom: 100, Right: 200, Top: 120})
+		redactAnnot.QuadPoints = []QuadPoint{rectQuadPoint(redactAnnot.GetRect())}
+		redactAnnot.OverlayText = "REDACTED"
+		redactAnnot.SetFillColor(Color{R: 0, G: 0, B: 0})
+
+		textAnnot := NewTextAnnotation()
+		textAnnot.SetPosition(50, 700)
+		textAnnot.SetOpen(true)
+		textAnnot.SetPopupRect(Rect{Left: 80, Bottom: 600, Right: 280, Top: 700})
+
+		caretAnnot := NewUnsupportedAnnotation(enums.FPDF_ANNOT_SUBTYPE_CARET)
+		caretAnnot.SetRect(Rect{Left: 10, Bottom: 10, Right: 20, Top: 20})
+		caretAnnot.Keys = map[string]string{"Sy": "/P"}
+
+		pageAnnots := []AddOnePageAnnot{
+			{PageNumber: 0, Annots: []Annotation{freeTextAnnot, polylineAnnot}},
+			{PageNumber: 3, Annots: []Annotation{redactAnnot, textAnnot, caretAnnot}},
+		}
+		data, err := MarshalAnnotationsJSON(pageAnnots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := UnmarshalAnnotationsJSON(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, pageAnnots) {
+			t.Fatalf("annots differ after a round trip: %s", data)
+		}
+
+		_, err = UnmarshalAnnotationsJSON([]byte(`{"version": 99, "pages": []}`))
+		if err == nil {
+			t.Fatalf("expect newer schema versions refused")
+		}
+		_, err = UnmarshalAnnotationJSON([]byte(`{"subtype": "Nope", "rect": [0, 0, 1, 1]}`))
+		if err == nil {
+			t.Fatalf("expect unknown subtypes refused")
+		}
+	})
+
+	t.Run("apply json", func(t *testing.T) {
+		inputFile := "simple.pdf"
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+
+		// what a browser sends, the appearances are generated
+		data := `{"version": 1, "pages": [{"page": 0, "annots": [
+			{"subtype": "Square", "nm": "square-1", "rect": [100, 100, 200, 200], "color": "#FF0000", "width": 2},
+			{"subtype": "Highlight", "nm": "highlight-1", "rect": [100, 500, 200, 520], "color": "#FFFF00",
+				"quadPoints": [[100, 520, 200, 520, 100, 500, 200, 500]]},
+			{"subtype": "FreeText", "nm": "freetext-1", "rect": [300, 600, 500, 650], "contents": "hello", "fontSize": 14}
+		]}]}`
+		added, err := ImportJSON(context.Background(), instance, docRes.Document, strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != 3 {
+			t.Fatalf("expect 3 annots added, got %d", added)
+		}
+
+		var buf bytes.Buffer
+		err = ExportJSON(instance, docRes.Document, []int{0}, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageAnnots, err := UnmarshalAnnotationsJSON(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found int
+		for _, annot := range pageAnnots[0].Annots {
+			switch annot.GetNM() {
+			case "square-1", "highlight-1":
+				found++
+				if annot.(annotationBase).base().ap == "" {
+					t.Fatalf("expect an appearance on %s", annot.GetNM())
+				}
+			case "freetext-1":
+				// generated again on import, its fonts are not exported
+				found++
+				if annot.(annotationBase).base().ap != "" {
+					t.Fatal("expect the free text exported without appearance")
+				}
+			}
+		}
+		if found != 3 {
+			t.Fatalf("expect the 3 annots exported, got %d", found)
+		}
+	})
+
+	t.Run("free text and image stamp on a fresh document", func(t *testing.T) {
+		doc := newBlankDocument(t)
+		_, err := AddAnnotationsToPDF(context.Background(), instance, doc, []AddOnePageAnnot{{PageNumber: 0, Annots: newRoundTripAnnots()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = ExportJSON(instance, doc, nil, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), `"image":"data:image/png;base64,`) {
+			t.Fatalf("expect the image of the stamp in the json: %s", buf.String())
+		}
+
+		freshDoc := newBlankDocument(t)
+		added, err := ImportJSON(context.Background(), instance, freshDoc, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != 2 {
+			t.Fatalf("expect 2 annots imported, got %d", added)
+		}
+		checkRoundTripRender(t, savePDFAndReopen(t, freshDoc, "data/blank_json_imported.pdf"))
+	})
+}
+
+func TestWebAnnotation(t *testing.T) {
+	t.Run("selectors and ids", func(t *testing.T) {
+		squareAnnot := NewSquareAnnotation()
+		squareAnnot.SetRect(Rect{Left: 100, Bottom: 600, Right: 250, Top: 700})
+		squareAnnot.SetTitle("reviewer")
+		squareAnnot.SetContents("look here")
+		squareAnnot.SetCreationDate(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC))
+
+		webAnnot := newWebAnnotation("https://example.com/a.pdf", 1, 842, squareAnnot, "")
+		if webAnnot.ID != "urn:uuid:"+squareAnnot.GetNM() || webAnnotationNM(webAnnot.ID) != squareAnnot.GetNM() {
+			t.Fatalf("unexpected id %s", webAnnot.ID)
+		}
+		if webAnnot.Target.Selector[0].Value != "page=2&viewrect=100,142,150,100" {
+			t.Fatalf("unexpected fragment %s", webAnnot.Target.Selector[0].Value)
+		}
+		if webAnnot.Creator.Name != "reviewer" || webAnnot.Body[0].Value != "look here" || webAnnot.Created != "2024-05-06T07:08:09Z" {
+			t.Fatalf("unexpected web annot: %+v", webAnnot)
+		}
+		pageNum, viewRect, ok := parsePDFFragment(webAnnot.Target.Selector[0].Value)
+		if !ok || pageNum != 1 || !reflect.DeepEqual(viewRect, []float32{100, 142, 150, 100}) {
+			t.Fatalf("unexpected fragment parsed: %d %v", pageNum, viewRect)
+		}
+
+		if nm := webAnnotationNM(webAnnotationID("https://example.com/a.pdf", "note #1")); nm != "note #1" {
+			t.Fatalf("expect the nm back from the id, got %q", nm)
+		}
+
+		// single values instead of arrays, and a creator IRI
+		var got WebAnnotation
+		err := json.Unmarshal([]byte(`{"id": "x", "type": "Annotation", "creator": "https://example.com/user/1",
+			"body": {"type": "TextualBody", "value": "hi"},
+			"target": {"source": "a.pdf", "selector": {"type": "FragmentSelector", "value": "page=1"}}}`), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Creator.ID != "https://example.com/user/1" || len(got.Body) != 1 || len(got.Target.Selector) != 1 {
+			t.Fatalf("unexpected web annot: %+v", got)
+		}
+	})
+
+	t.Run("export and import", func(t *testing.T) {
+		inputFile := "simple_text.pdf"
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+		words, err := SearchRegexpInPage(instance, docRes.Document, 0, regexp.MustCompile(`\pL{4,}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(words) == 0 {
+			t.Fatalf("expect words on the page")
+		}
+		annots, err := AddTextMarkupsToPDF(context.Background(), instance, docRes.Document, enums.FPDF_ANNOT_SUBTYPE_UNDERLINE, words[:1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		webAnnots, err := ExportWebAnnotations(instance, docRes.Document, "simple_text.pdf", []int{0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var exported *WebAnnotation
+		for i := range webAnnots {
+			if webAnnotationNM(webAnnots[i].ID) == annots[0].GetNM() {
+				exported = &webAnnots[i]
+			}
+		}
+		if exported == nil || len(exported.Target.Selector) != 2 || exported.Target.Selector[1].Exact != words[0].Text {
+			t.Fatalf("expect a quote of %q, got %+v", words[0].Text, exported)
+		}
+
+		freshRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+		added, err := ImportWebAnnotations(context.Background(), instance, freshRes.Document, []WebAnnotation{*exported})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != 1 {
+			t.Fatalf("expect 1 annot imported, got %d", added)
+		}
+		loaded, err := LoadAnnotationsInPage(instance, freshRes.Document, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, annot := range loaded {
+			if annot.GetNM() != annots[0].GetNM() {
+				continue
+			}
+			if annot.GetSubtype() != enums.FPDF_ANNOT_SUBTYPE_UNDERLINE {
+				t.Fatalf("expect an underline, got %s", annot.GetSubtypeName())
+			}
+			text, err := GetMarkedText(instance, freshRes.Document, 0, annot, DefaultMarkedTextOverlap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != words[0].Text {
+				t.Fatalf("expect %q under the imported underline, got %q", words[0].Text, text)
+			}
+			return
+		}
+		t.Fatalf("imported annot not found")
+	})
+}
+
+func TestFlattenAnnotations(t *testing.T) {
+	inputFile := "simple.pdf"
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: docRes.Document,
+	})
+
+	squareAnnot := NewSquareAnnotation()
+	squareAnnot.SetRect(Rect{Left: 100, Bottom: 100, Right: 200, Top: 200})
+	squareAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	squareAnnot.SetContents("too wide")
+	squareAnnot.GenerateAppearance()
+	highlightAnnot := NewHighlightAnnotation()
+	highlightAnnot.SetRect(Rect{Left: 100, Bottom: 500, Right: 200, Top: 520})
+	highlightAnnot.QuadPoints = []QuadPoint{rectQuadPoint(highlightAnnot.GetRect())}
+	highlightAnnot.SetStrikeColor(Color{R: 255, G: 255, B: 0})
+	highlightAnnot.GenerateAppearance()
+	textAnnot := NewTextAnnotation()
+	textAnnot.SetPosition(50, 700)
+	textAnnot.SetContents("a note")
+	textAnnot.GenerateAppearance()
+	_, err = AddAnnotationsToPDF(context.Background(), instance, docRes.Document, []AddOnePageAnnot{{PageNumber: 0, Annots: []Annotation{squareAnnot, highlightAnnot, textAnnot}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+	countObjects := func() int {
+		countRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+			Page: page,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return countRes.Count
+	}
+	objectCount := countObjects()
+
+	t.Run("by nm", func(t *testing.T) {
+		res, err := FlattenAnnotInPDF(instance, docRes.Document, FlattenAnnot{
+			FlattenType:         FlattenByNM,
+			FlattenOnePageAnnot: []FlattenOnePageAnnot{{PageNumber: 0, AnnotNMs: []string{squareAnnot.GetNM()}}},
+			KeepComments:        true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Flattened != 1 || len(res.Comments) != 1 || len(res.Comments[0].Annots) != 1 {
+			t.Fatalf("expect the square flattened and kept, got %+v", res)
+		}
+		if kept := res.Comments[0].Annots[0]; kept.GetNM() != squareAnnot.GetNM() || kept.(*SquareAnnotation).GetContents() != "too wide" {
+			t.Fatalf("unexpected kept comment: %+v", kept)
+		}
+
+		// the appearance is a form object of the page
+		if countObjects() != objectCount+1 {
+			t.Fatalf("expect a form object added to the %d page objects, got %d", objectCount, countObjects())
+		}
+		objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+			Page:  page,
+			Index: objectCount,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		typeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
+			PageObject: objRes.PageObject,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typeRes.Type != enums.FPDF_PAGEOBJ_FORM {
+			t.Fatalf("expect a form object, got %d", typeRes.Type)
+		}
+
+		loaded, err := LoadAnnotationsInPage(instance, docRes.Document, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, annot := range loaded {
+			if annot.GetNM() == squareAnnot.GetNM() {
+				t.Fatal("the flattened square is still an annotation")
+			}
+		}
+	})
+
+	t.Run("by subtype", func(t *testing.T) {
+		res, err := FlattenAnnotInPDF(instance, docRes.Document, FlattenAnnot{
+			FlattenType: FlattenBySubtype,
+			Subtypes:    []enums.FPDF_ANNOTATION_SUBTYPE{enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Flattened != 1 || res.Comments != nil {
+			t.Fatalf("expect the highlight flattened, got %+v", res)
+		}
+	})
+
+	t.Run("all", func(t *testing.T) {
+		res, err := FlattenAnnotInPDF(instance, docRes.Document, FlattenAnnot{
+			FlattenType: FlattenAll,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Flattened != 1 {
+			t.Fatalf("expect the text flattened, got %+v", res)
+		}
+		annotCount, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+			Page: page,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if annotCount.Count != 0 {
+			t.Fatalf("expect no annotation left, got %d", annotCount.Count)
+		}
+		if countObjects() != objectCount+3 {
+			t.Fatalf("expect a form object per flatten, got %d page objects", countObjects())
+		}
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		_, err := FlattenAnnotInPDF(instance, docRes.Document, FlattenAnnot{})
+		if err == nil {
+			t.Fatal("expect an error for a missing flatten type")
+		}
+	})
+}
+
+func TestAddStampAnnotation(t *testing.T) {
+	inputFile := "simple.pdf"
+
+	t.Run("add path to stamp annot", func(t *testing.T) {
+
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+
+		page := requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: docRes.Document,
+				Index:    0,
+			},
+		}
+
+		var stampAnnot = NewStampAnnotation()
+		stampAnnot.SetRect(Rect{
+			Left:   0,
+			Bottom: 0,
+			Top:    200,
+			Right:  200,
+		})
+
+		outputFile := "data/simple_stamp_path.pdf"
+		os.Remove(outputFile)
+		stampAnnot.SetPathObject([][]Point{
+			{
+				{X: 100, Y: 100},
+				{X: 102, Y: 98},
+				{X: 104, Y: 102},
+				{X: 106, Y: 100},
+				{X: 108, Y: 98},
+				{X: 110, Y: 100},
+				{X: 112, Y: 102},
+				{X: 114, Y: 100},
+				{X: 116, Y: 98},
+				{X: 118, Y: 100},
+				{X: 120, Y: 102},
+			},
+			{
+				{X: 50, Y: 100},
+				{X: 52, Y: 98},
+				{X: 54, Y: 102},
+				{X: 56, Y: 100},
+				{X: 58, Y: 98},
+				{X: 60, Y: 100},
+				{X: 62, Y: 102},
+				{X: 64, Y: 100},
+				{X: 66, Y: 98},
+				{X: 68, Y: 100},
+				{X: 70, Y: 102},
+			},
+		}, 2, Color{R: 0, G: 255, B: 0}, 120)
+		err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+			Document: docRes.Document,
+			FilePath: &outputFile,
+		})
+		if err != nil {
+			t.Fatalf("save stamp document failed: %v", err)
+		}
+	})
+
+	t.Run("add text to stamp annot", func(t *testing.T) {
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+
+		page := requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: docRes.Document,
+				Index:    0,
+			},
+		}
+
+		outputFile := "data/simple_text_stamp.pdf"
+		os.Remove(outputFile)
+
+		// fitted to the rect, rotated, with a rounded frame
+		var stampAnnot = NewStampAnnotation()
+		stampAnnot.SetRect(Rect{
+			Left:   100,
+			Bottom: 500,
+			Top:    600,
+			Right:  300,
+		})
+		stampAnnot.SetTextObject("APPROVED", nil, 0, Color{R: 0, G: 128, B: 0}, 15)
+		stampAnnot.SetTextBorder(3, 6)
+		err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// fixed font size, square frame
+		stampAnnot = NewStampAnnotation()
+		stampAnnot.SetRect(Rect{
+			Left:   100,
+			Bottom: 400,
+			Top:    460,
+			Right:  300,
+		})
+		stampAnnot.SetTextObject("DRAFT", nil, 24, Color{R: 255, G: 0, B: 0}, 0)
+		stampAnnot.SetTextBorder(2, 0)
+		err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a single text object starts at the origin
+		textRef, err := CreateTextObject(instance, &TextObjectParam{
+			Document: docRes.Document,
+			Text:     "DRAFT",
+			FontSize: 24,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		boundsRes, err := instance.FPDFPageObj_GetBounds(&requests.FPDFPageObj_GetBounds{
+			PageObject: textRef,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(float64(boundsRes.Left)) > 2 || boundsRes.Bottom > 0 || boundsRes.Top < 12 {
+			t.Fatalf("unexpected text bounds: %+v", boundsRes)
+		}
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: textRef,
+		})
+		_, err = CreateTextObject(instance, &TextObjectParam{
+			Document: docRes.Document,
+			Text:     "DRAFT",
+		})
+		if err == nil {
+			t.Fatalf("expect an error without font size")
+		}
+
+		_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+			Document: docRes.Document,
+			FilePath: &outputFile,
+		})
+		if err != nil {
+			t.Fatalf("save text stamp document failed: %v", err)
+		}
+	})
+
+	t.Run("add img to stamp annot", func(t *testing.T) {
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+
+		page := requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: docRes.Document,
+				Index:    0,
+			},
+		}
+
+		var stampAnnot = NewStampAnnotation()
+		stampAnnot.SetRect(Rect{
+			Left:   100,
+			Bottom: 100,
+			Top:    146,
+			Right:  168,
+		})
+
+		outputFile := "data/simple_stamp_img.pdf"
+		os.Remove(outputFile)
+
+		// set image object
+		stampAnnot.SetImgObject("jpeg", docRes.Document, "simple.jpeg")
+
+		// add annotation to page
+		err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+			Document: docRes.Document,
+			FilePath: &outputFile,
+		})
+		if err != nil {
+			t.Fatalf("save img stamp document failed: %v", err)
+		}
+	})
+
+	t.Run("add png, gif and image.Image to stamp annot", func(t *testing.T) {
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+
+		page := requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: docRes.Document,
+				Index:    0,
+			},
+		}
+
+		outputFile := "data/simple_stamp_img_memory.pdf"
+		os.Remove(outputFile)
+
+		// a blue stroke on a transparent background, like a signature drawn on a canvas
+		signature := image.NewNRGBA(image.Rect(0, 0, 120, 40))
+		for x := 10; x < 110; x++ {
+			y := 20 + int(10*math.Sin(float64(x)/10))
+			for dy := -2; dy <= 2; dy++ {
+				signature.Set(x, y+dy, color.NRGBA{R: 0, G: 0, B: 200, A: 255})
+			}
+		}
+		var pngData, gifData bytes.Buffer
+		err = png.Encode(&pngData, signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = gif.Encode(&gifData, signature, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the size is read from the header of the file
+		pngFile := filepath.Join(t.TempDir(), "signature.png")
+		err = os.WriteFile(pngFile, pngData.Bytes(), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		width, height, err := GetImageDimensions(pngFile)
+		if err != nil || width != 120 || height != 40 {
+			t.Fatalf("unexpected image dimensions: %d x %d, %v", width, height, err)
+		}
+		_, _, err = GetImageDimensions("simple.pdf")
+		if err == nil {
+			t.Fatalf("expect an error for a file that is no image")
+		}
+
+		for i, set := range []func(s *StampAnnotation){
+			func(s *StampAnnotation) { s.SetImgObjectImage(docRes.Document, signature) },
+			func(s *StampAnnotation) { s.SetImgObjectData(docRes.Document, pngData.Bytes()) },
+			func(s *StampAnnotation) { s.SetImgObjectData(docRes.Document, gifData.Bytes()) },
+		} {
+			var stampAnnot = NewStampAnnotation()
+			stampAnnot.SetRect(Rect{
+				Left:   100,
+				Bottom: float32(300 + i*50),
+				Top:    float32(340 + i*50),
+				Right:  220,
+			})
+			set(stampAnnot)
+			err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the pixels are written into the bitmap of the image object
+		imgRef, err := createBitmapImgObject(instance, docRes.Document, signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bitmapRes, err := instance.FPDFImageObj_GetBitmap(&requests.FPDFImageObj_GetBitmap{
+			ImageObject: imgRef,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		strideRes, err := instance.FPDFBitmap_GetStride(&requests.FPDFBitmap_GetStride{
+			Bitmap: bitmapRes.Bitmap,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bufferRes, err := instance.FPDFBitmap_GetBuffer(&requests.FPDFBitmap_GetBuffer{
+			Bitmap: bitmapRes.Bitmap,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the image is stored as BGR, the alpha goes to its soft mask
+		for x := 10; x < 110; x += 10 {
+			y := 20 + int(10*math.Sin(float64(x)/10))
+			pixel := bufferRes.Buffer[y*strideRes.Stride+x*3:]
+			if pixel[0] != 200 || pixel[1] != 0 || pixel[2] != 0 {
+				t.Fatalf("pixel (%d,%d): expect the blue stroke, got %v", x, y, pixel[:3])
+			}
+		}
+		instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+			Bitmap: bitmapRes.Bitmap,
+		})
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: imgRef,
+		})
+
+		_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+			Document: docRes.Document,
+			FilePath: &outputFile,
+		})
+		if err != nil {
+			t.Fatalf("save img stamp document failed: %v", err)
+		}
+	})
+
+	t.Run("image stamp through a wrapped instance", func(t *testing.T) {
+		// the buffer of a wrapped instance is not known to be the bitmap, the pixels are filled by runs
+		wrapped := struct{ pdfium.Pdfium }{instance}
+		if !hasLiveBitmapBuffer(instance) || hasLiveBitmapBuffer(wrapped) {
+			t.Fatal("expect the bitmap buffer of the webassembly runtime only")
+		}
+		doc := newBlankDocument(t)
+		_, err := AddAnnotationsToPDF(context.Background(), wrapped, doc, []AddOnePageAnnot{{PageNumber: 0, Annots: newRoundTripAnnots()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRoundTripRender(t, requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: doc,
+				Index:    0,
+			},
+		})
+	})
+
+	t.Run("place img in stamp annot", func(t *testing.T) {
+		docRes, err := instance.OpenDocument(&requests.OpenDocument{
+			FilePath: &inputFile,
+		})
+		if err != nil {
+			t.Fatalf("open document failed: %v", err)
+		}
+
+		page := requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: docRes.Document,
+				Index:    0,
+			},
+		}
+
+		outputFile := "data/simple_stamp_img_placement.pdf"
+		os.Remove(outputFile)
+
+		logo := image.NewNRGBA(image.Rect(0, 0, 120, 40))
+		for x := 0; x < 120; x++ {
+			for y := 0; y < 40; y++ {
+				logo.Set(x, y, color.NRGBA{R: uint8(x * 2), G: 100, B: uint8(y * 6), A: 255})
+			}
+		}
+
+		// a 120x40 image fitted into a 100x100 rect keeps its aspect ratio and is centered
+		matrix, err := placeImg(Rect{Left: 0, Bottom: 0, Right: 100, Top: 100}, &ImageObjectParam{
+			Image:     logo,
+			Placement: ImgPlacementFit,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(float64(matrix.A-100)) > 0.01 || math.Abs(float64(matrix.D-100.0/3)) > 0.01 || math.Abs(float64(matrix.F-100.0/3)) > 0.01 {
+			t.Fatalf("unexpected fit matrix: %+v", matrix)
+		}
+
+		placements := []struct {
+			placement ImgPlacement
+			anchor    ImgAnchor
+			rotation  float32
+		}{
+			{ImgPlacementFill, ImgAnchorBottomLeft, 0},
+			{ImgPlacementFit, ImgAnchorBottomLeft, 0},
+			{ImgPlacementCover, ImgAnchorBottomLeft, 0},
+			{ImgPlacementOriginal, ImgAnchorTopRight, 0},
+			{ImgPlacementFit, ImgAnchorBottomLeft, 30},
+		}
+		for i, p := range placements {
+			var stampAnnot = NewStampAnnotation()
+			stampAnnot.SetRect(Rect{
+				Left:   float32(50 + i*110),
+				Bottom: 400,
+				Top:    500,
+				Right:  float32(150 + i*110),
+			})
+			stampAnnot.SetImgObjectImage(docRes.Document, logo)
+			stampAnnot.SetImgPlacement(p.placement, p.anchor, p.rotation)
+			err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+			Document: docRes.Document,
+			FilePath: &outputFile,
+		})
+		if err != nil {
+			t.Fatalf("save img stamp document failed: %v", err)
+		}
+	})
+}
+
+func TestAddTextAnnotation(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_text_note.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	icons := []TextIcon{TextIconComment, TextIconNote, TextIconHelp, TextIconKey, TextIconInsert, TextIconParagraph, TextIconNewParagraph}
+	for i, icon := range icons {
+		var textAnnot = NewTextAnnotation()
+		textAnnot.SetPosition(float32(50+i*40), 700)
+		textAnnot.SetIcon(icon)
+		textAnnot.SetContents("sticky note " + string(icon))
+		textAnnot.SetPopupRect(Rect{Left: 300, Top: 700, Right: 500, Bottom: 600})
+		textAnnot.GenerateAppearance()
+		err = textAnnot.AddAnnotationToPage(context.Background(), instance, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an open note with its popup in the default place
+	var openAnnot = NewTextAnnotation()
+	openAnnot.SetPosition(50, 500)
+	openAnnot.SetOpen(true)
+	openAnnot.SetContents("sticky note open")
+	openAnnot.GenerateAppearance()
+	err = openAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultPopup := Rect{Left: 70, Top: 500, Right: 70 + DefaultPopupWidth, Bottom: 500 - DefaultPopupHeight}
+
+	checkNotes := func(document references.FPDF_DOCUMENT) {
+		annots, err := LoadAnnotationsInPage(instance, document, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var notes, popups int
+		for _, annot := range annots {
+			switch a := annot.(type) {
+			case *TextAnnotation:
+				if !strings.HasPrefix(a.GetContents(), "sticky note") {
+					t.Fatalf("unexpected contents: %s", a.GetContents())
+				}
+				wantIcon, wantPopup := TextIconNote, defaultPopup
+				if notes < len(icons) {
+					wantIcon, wantPopup = icons[notes], Rect{Left: 300, Top: 700, Right: 500, Bottom: 600}
+				}
+				if a.Icon != wantIcon || a.Open != (notes == len(icons)) || a.PopupRect == nil || *a.PopupRect != wantPopup {
+					t.Fatalf("unexpected note %d: icon %s, open %t, popup %v", notes, a.Icon, a.Open, a.PopupRect)
+				}
+				notes++
+			default:
+				if annot.GetSubtype() == enums.FPDF_ANNOT_SUBTYPE_POPUP {
+					popups++
+				}
+			}
+		}
+		if notes != len(icons)+1 || popups != notes {
+			t.Fatalf("expected %d notes and popups, got %d notes and %d popups", len(icons)+1, notes, popups)
+		}
+	}
+	checkNotes(docRes.Document)
+
+	// the saved notes link their popups
+	savedPage := savePDFAndReopen(t, docRes.Document, outputFile)
+	checkNotes(savedPage.ByIndex.Document)
+	annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+		Page:  savedPage,
+		Index: 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	popupRes, err := instance.FPDFAnnot_GetLinkedAnnot(&requests.FPDFAnnot_GetLinkedAnnot{
+		Annotation: annotRes.Annotation,
+		Key:        "Popup",
+	})
+	if err != nil {
+		t.Fatalf("expect the note linked to its popup: %v", err)
+	}
+	parentRes, err := instance.FPDFAnnot_GetLinkedAnnot(&requests.FPDFAnnot_GetLinkedAnnot{
+		Annotation: popupRes.LinkedAnnotation,
+		Key:        "Parent",
+	})
+	if err != nil {
+		t.Fatalf("expect the popup linked to its note: %v", err)
+	}
+	nm, err := getAnnotString(instance, parentRes.LinkedAnnotation, "NM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	icon, err := getAnnotString(instance, annotRes.Annotation, "Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := getAnnotString(instance, annotRes.Annotation, "NM"); nm != first || icon != string(icons[0]) {
+		t.Fatalf("unexpected saved note: parent %s, icon %s", nm, icon)
+	}
+}
+
+func TestAddAnnotationsToPage(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_batch.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	var squareAnnot = NewSquareAnnotation()
+	squareAnnot.SetRect(Rect{
+		Left:   100,
+		Top:    200,
+		Right:  200,
+		Bottom: 100,
+	})
+	squareAnnot.SetWidth(4)
+	squareAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	squareAnnot.GenerateAppearance()
+
+	// rect is not set, should be reported and skipped
+	var badAnnot = NewCircleAnnotation()
+	badAnnot.GenerateAppearance()
+
+	var inkAnnot = NewInkAnnotation()
+	inkAnnot.Points = [][]Point{
+		{{X: 100, Y: 300}, {X: 200, Y: 380}, {X: 300, Y: 400}},
+	}
+	inkAnnot.SetRect(Rect{
+		Left:   90,
+		Top:    410,
+		Right:  310,
+		Bottom: 290,
+	})
+	inkAnnot.SetWidth(2)
+	inkAnnot.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+	inkAnnot.GenerateAppearance()
+
+	added, err := AddAnnotationsToPage(context.Background(), instance, page, []Annotation{squareAnnot, badAnnot, inkAnnot})
+	if added != 2 {
+		t.Fatalf("expect 2 annots added, got %d", added)
+	}
+	var batchErr *BatchAddError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expect BatchAddError, got %v", err)
+	}
+	if len(batchErr.Failures) != 1 || batchErr.Failures[0].Index != 1 || batchErr.Failures[0].NM != badAnnot.GetNM() {
+		t.Fatalf("unexpected failures: %v", err)
+	}
+
+	// the failed annot is skipped, the others are on the page with their own subtype
+	annots, err := LoadAnnotationsInPage(instance, docRes.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := map[string]Annotation{}
+	for _, annot := range annots {
+		loaded[annot.GetNM()] = annot
+	}
+	if _, ok := loaded[squareAnnot.GetNM()].(*SquareAnnotation); !ok {
+		t.Fatalf("square annot not added")
+	}
+	if _, ok := loaded[inkAnnot.GetNM()].(*InkAnnotation); !ok {
+		t.Fatalf("ink annot not added")
+	}
+	if _, ok := loaded[badAnnot.GetNM()]; ok {
+		t.Fatalf("failed annot left on page")
+	}
+
+	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+		Document: docRes.Document,
+		FilePath: &outputFile,
+	})
+	if err != nil {
+		t.Fatalf("save batch document failed: %v", err)
+	}
+}
+
+func TestLoadAnnotationsInPage(t *testing.T) {
+	inputFile := "simple.pdf"
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	var squareAnnot = NewSquareAnnotation()
+	squareAnnot.SetRect(Rect{
+		Left:   100,
+		Top:    200,
+		Right:  200,
+		Bottom: 100,
+	})
+	squareAnnot.SetTitle("reviewer")
+	squareAnnot.SetContents("check this")
+	squareAnnot.SetWidth(4)
+	squareAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	squareAnnot.SetFillColor(Color{R: 0, G: 255, B: 0})
+	squareAnnot.SetOpacity(120)
+	squareAnnot.GenerateAppearance()
+
+	var inkAnnot = NewInkAnnotation()
+	inkAnnot.Points = [][]Point{
+		{{X: 10, Y: 10}, {X: 20, Y: 30}, {X: 40, Y: 20}},
+	}
+	inkAnnot.SetRect(Rect{
+		Left:   0,
+		Bottom: 0,
+		Top:    50,
+		Right:  50,
+	})
+	inkAnnot.SetWidth(2)
+	inkAnnot.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+	inkAnnot.GenerateAppearance()
+
+	_, err = AddAnnotationsToPage(context.Background(), instance, page, []Annotation{squareAnnot, inkAnnot})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annots, err := LoadAnnotationsInPage(instance, docRes.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loadedSquare *SquareAnnotation
+	var loadedInk *InkAnnotation
+	for _, annot := range annots {
+		switch annot.GetNM() {
+		case squareAnnot.GetNM():
+			loadedSquare, _ = annot.(*SquareAnnotation)
+		case inkAnnot.GetNM():
+			loadedInk, _ = annot.(*InkAnnotation)
+		}
+	}
+	if loadedSquare == nil || loadedInk == nil {
+		t.Fatalf("added annots not loaded: %v", annots)
+	}
+
+	if loadedSquare.GetTitle() != "reviewer" || loadedSquare.GetContents() != "check this" {
+		t.Fatalf("unexpected title/contents: %s %s", loadedSquare.GetTitle(), loadedSquare.GetContents())
+	}
+	if loadedSquare.GetRect() != squareAnnot.GetRect() {
+		t.Fatalf("unexpected rect: %v", loadedSquare.GetRect())
+	}
+	if loadedSquare.GetWidth() != 4 || loadedSquare.GetOpacity() != 120 {
+		t.Fatalf("unexpected width/opacity: %v %v", loadedSquare.GetWidth(), loadedSquare.GetOpacity())
+	}
+	if c := loadedSquare.GetStrikeColor(); c == nil || *c != (Color{R: 255, G: 0, B: 0}) {
+		t.Fatalf("unexpected strike color: %v", c)
+	}
+	if c := loadedSquare.GetFillColor(); c == nil || *c != (Color{R: 0, G: 255, B: 0}) {
+		t.Fatalf("unexpected fill color: %v", c)
+	}
+	if len(loadedInk.Points) != 1 || len(loadedInk.Points[0]) != 3 {
+		t.Fatalf("unexpected ink strokes: %v", loadedInk.Points)
+	}
+}
+
+func TestUpdateAnnotByNM(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_update.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	var squareAnnot = NewSquareAnnotation()
+	squareAnnot.SetRect(Rect{
+		Left:   100,
+		Top:    200,
+		Right:  200,
+		Bottom: 100,
+	})
+	squareAnnot.SetWidth(4)
+	squareAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	squareAnnot.GenerateAppearance()
+
+	var circleAnnot = NewCircleAnnotation()
+	circleAnnot.SetRect(Rect{
+		Left:   300,
+		Top:    400,
+		Right:  400,
+		Bottom: 300,
+	})
+	circleAnnot.SetWidth(2)
+	circleAnnot.SetStrikeColor(Color{R: 0, G: 0, B: 255})
+	circleAnnot.GenerateAppearance()
+
+	_, err = AddAnnotationsToPage(context.Background(), instance, page, []Annotation{squareAnnot, circleAnnot})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newColor := Color{R: 0, G: 128, B: 0}
+	newContents := "updated"
+	_, err = UpdateAnnotByNM(instance, docRes.Document, 0, squareAnnot.GetNM(), UpdateAnnot{
+		StrikeColor: &newColor,
+		Contents:    &newContents,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = UpdateAnnotByNM(instance, docRes.Document, 0, "not-exist", UpdateAnnot{})
+	if !errors.Is(err, ErrAnnotNotFound) {
+		t.Fatalf("expect ErrAnnotNotFound, got %v", err)
+	}
+
+	annots, err := LoadAnnotationsInPage(instance, docRes.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, annot := range annots {
+		if annot.GetNM() != squareAnnot.GetNM() {
+			continue
+		}
+		// the order is kept
+		if i+1 >= len(annots) || annots[i+1].GetNM() != circleAnnot.GetNM() {
+			t.Fatalf("annot order changed")
+		}
+		square := annot.(*SquareAnnotation)
+		if c := square.GetStrikeColor(); c == nil || *c != newColor {
+			t.Fatalf("unexpected strike color: %v", c)
+		}
+		if square.GetContents() != newContents || square.GetWidth() != 4 {
+			t.Fatalf("unexpected contents/width: %s %v", square.GetContents(), square.GetWidth())
+		}
+		if time.Since(square.GetModDate()) > time.Minute {
+			t.Fatalf("expect /M set to now, got %v", square.GetModDate())
+		}
+	}
+
+	// a failed color keeps the previous appearance
+	failing := &failingSetColorInstance{Pdfium: instance}
+	_, err = UpdateAnnotByNM(failing, docRes.Document, 0, circleAnnot.GetNM(), UpdateAnnot{
+		StrikeColor: &newColor,
+	})
+	if err == nil {
+		t.Fatal("expect the failed color returned")
+	}
+	annots, err = LoadAnnotationsInPage(instance, docRes.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, annot := range annots {
+		if annot.GetNM() == circleAnnot.GetNM() && annot.(annotationBase).base().ap != circleAnnot.ap {
+			t.Fatalf("expect the appearance of the circle kept, got %q", annot.(annotationBase).base().ap)
+		}
+	}
+
+	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+		Document: docRes.Document,
+		FilePath: &outputFile,
+	})
+	if err != nil {
+		t.Fatalf("save update document failed: %v", err)
+	}
+}
+
+func TestUpdateAnnotStashedKeys(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_update_stashed.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	var lineAnnot = NewLineAnnotation()
+	lineAnnot.SetWidth(2)
+	lineAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	lineAnnot.SetLineTo(100, 200, 200, 100)
+	lineAnnot.SetLineEndings(LineEndingNone, LineEndingOpenArrow)
+	lineAnnot.GenerateAppearance()
+
+	var highlightAnnot = NewHighlightAnnotation()
+	highlightAnnot.SetStrikeColor(Color{R: 255, G: 255, B: 0})
+	highlightAnnot.QuadPoints = []QuadPoint{
+		rectQuadPoint(Rect{Left: 100, Bottom: 400, Right: 300, Top: 420}),
+		rectQuadPoint(Rect{Left: 100, Bottom: 370, Right: 250, Top: 390}),
+	}
+	highlightAnnot.SetRect(Rect{Left: 100, Bottom: 370, Right: 300, Top: 420})
+	highlightAnnot.GenerateAppearance()
+
+	_, err = AddAnnotationsToPage(context.Background(), instance, page, []Annotation{lineAnnot, highlightAnnot})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lineTo := [2]Point{{X: 50, Y: 60}, {X: 250, Y: 120}}
+	_, err = UpdateAnnotByNM(instance, docRes.Document, 0, lineAnnot.GetNM(), UpdateAnnot{
+		LineTo: &lineTo,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pdfium can not remove quads, the remaining one is written by SavePDF
+	quadPoints := highlightAnnot.QuadPoints[:1]
+	_, err = UpdateAnnotByNM(instance, docRes.Document, 0, highlightAnnot.GetNM(), UpdateAnnot{
+		QuadPoints: quadPoints,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkAnnots := func(document references.FPDF_DOCUMENT) {
+		annots, err := LoadAnnotationsInPage(instance, document, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := 0
+		for _, annot := range annots {
+			switch a := annot.(type) {
+			case *LineAnnotation:
+				if a.GetNM() != lineAnnot.GetNM() {
+					continue
+				}
+				found++
+				if a.GetLineTo() != lineTo || a.LineEndings != lineAnnot.LineEndings {
+					t.Fatalf("unexpected line: %v %v", a.GetLineTo(), a.LineEndings)
+				}
+			case *HighlightAnnotation:
+				if a.GetNM() != highlightAnnot.GetNM() {
+					continue
+				}
+				found++
+				if len(a.QuadPoints) != 1 || a.QuadPoints[0] != quadPoints[0] {
+					t.Fatalf("unexpected quad points: %v", a.QuadPoints)
+				}
+			}
+		}
+		if found != 2 {
+			t.Fatalf("expect the line and the highlight, found %d", found)
+		}
+	}
+	checkAnnots(docRes.Document)
+
+	savedPage := savePDFAndReopen(t, docRes.Document, outputFile)
+	checkAnnots(savedPage.ByIndex.Document)
+
+	// pdfium reads the written keys from the saved dictionaries
+	countRes, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+		Page: savedPage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < countRes.Count; i++ {
+		annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+			Page:  savedPage,
+			Index: i,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch subtypeRes.Subtype {
+		case enums.FPDF_ANNOT_SUBTYPE_LINE:
+			lineRes, err := instance.FPDFAnnot_GetLine(&requests.FPDFAnnot_GetLine{
+				Annotation: annotRes.Annotation,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lineRes.Start.X != lineTo[0].X || lineRes.Start.Y != lineTo[0].Y ||
+				lineRes.End.X != lineTo[1].X || lineRes.End.Y != lineTo[1].Y {
+				t.Fatalf("unexpected /L: %v %v", lineRes.Start, lineRes.End)
+			}
+		case enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT:
+			pointsRes, err := instance.FPDFAnnot_CountAttachmentPoints(&requests.FPDFAnnot_CountAttachmentPoints{
+				Annotation: annotRes.Annotation,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pointsRes.Count != 1 {
+				t.Fatalf("expect 1 quad in /QuadPoints, got %d", pointsRes.Count)
+			}
+		}
+		instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+			Annotation: annotRes.Annotation,
+		})
+	}
+}
+
+func TestAddAnnotationError(t *testing.T) {
+	inputFile := "simple.pdf"
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	countRes, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+		Page: page,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rect is not set
+	var squareAnnot = NewSquareAnnotation()
+	squareAnnot.SetStrikeColor(Color{R: 255, G: 0, B: 0})
+	squareAnnot.GenerateAppearance()
+	err = squareAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if !errors.Is(err, ErrRectNotSet) {
+		t.Fatalf("expect ErrRectNotSet, got %v", err)
+	}
+	var annotErr *AnnotationError
+	if !errors.As(err, &annotErr) || annotErr.Step != StepPreCheck || annotErr.NM != squareAnnot.GetNM() {
+		t.Fatalf("unexpected annotation error: %v", err)
+	}
+
+	// stamp without object is removed from the page again
+	var stampAnnot = NewStampAnnotation()
+	stampAnnot.SetRect(Rect{
+		Left:   0,
+		Bottom: 0,
+		Top:    200,
+		Right:  200,
+	})
+	err = stampAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if !errors.As(err, &annotErr) || annotErr.Step != StepCreateObject {
+		t.Fatalf("unexpected annotation error: %v", err)
+	}
+
+	afterRes, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+		Page: page,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if afterRes.Count != countRes.Count {
+		t.Fatalf("half-created annot left on page: %d -> %d", countRes.Count, afterRes.Count)
+	}
+
+	// the objects not appended when the blend mode fails are destroyed
+	failing := &failingBlendModeInstance{Pdfium: instance, failAt: 2}
+	stampAnnot = NewStampAnnotation()
+	stampAnnot.SetRect(Rect{Left: 0, Bottom: 0, Top: 200, Right: 200})
+	stampAnnot.SetTextObject("stamp", nil, 20, Color{R: 255}, 0)
+	stampAnnot.SetTextBorder(2, 0)
+	stampAnnot.SetBlendMode(enums.PDF_BLEND_MODE_MULTIPLY)
+	err = stampAnnot.AddAnnotationToPage(context.Background(), failing, page)
+	if !errors.As(err, &annotErr) || annotErr.Step != StepCreateObject {
+		t.Fatalf("unexpected annotation error: %v", err)
+	}
+	if failing.destroyed != 1 {
+		t.Fatalf("expect the text object not appended destroyed, got %d destroyed", failing.destroyed)
+	}
+}
+
+// failingBlendModeInstance fails the call failAt of FPDFPageObj_SetBlendMode and counts the destroyed page objects.
+type failingBlendModeInstance struct {
+	pdfium.Pdfium
+	failAt    int
+	calls     int
+	destroyed int
+}
+
+func (i *failingBlendModeInstance) FPDFPageObj_SetBlendMode(request *requests.FPDFPageObj_SetBlendMode) (*responses.FPDFPageObj_SetBlendMode, error) {
+	i.calls++
+	if i.calls == i.failAt {
+		return nil, errors.New("set blend mode failed")
+	}
+	return i.Pdfium.FPDFPageObj_SetBlendMode(request)
+}
+
+func (i *failingBlendModeInstance) FPDFPageObj_Destroy(request *requests.FPDFPageObj_Destroy) (*responses.FPDFPageObj_Destroy, error) {
+	i.destroyed++
+	return i.Pdfium.FPDFPageObj_Destroy(request)
+}
+
+// failingSetColorInstance fails FPDFAnnot_SetColor.
+type failingSetColorInstance struct {
+	pdfium.Pdfium
+}
+
+func (i *failingSetColorInstance) FPDFAnnot_SetColor(request *requests.FPDFAnnot_SetColor) (*responses.FPDFAnnot_SetColor, error) {
+	return nil, errors.New("set color failed")
+}
+
+func TestAnnotationOpacity(t *testing.T) {
+	inputFile := "simple.pdf"
+	outputFile := "data/simple_opacity.pdf"
+	os.Remove(outputFile)
+	docRes, err := instance.OpenDocument(&requests.OpenDocument{
+		FilePath: &inputFile,
+	})
+	if err != nil {
+		t.Fatalf("open document failed: %v", err)
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: docRes.Document,
+			Index:    0,
+		},
+	}
+
+	// no color is set, the opacity still has to be written as /CA
+	var inkAnnot = NewInkAnnotation()
+	inkAnnot.Points = [][]Point{
+		{{X: 10, Y: 10}, {X: 20, Y: 30}, {X: 40, Y: 20}},
+	}
+	inkAnnot.SetRect(Rect{
+		Left:   0,
+		Bottom: 0,
+		Top:    50,
+		Right:  50,
+	})
+	inkAnnot.SetWidth(4)
+	inkAnnot.SetOpacity(120)
+	inkAnnot.GenerateAppearance()
+	err = inkAnnot.AddAnnotationToPage(context.Background(), instance, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annots, err := LoadAnnotationsInPage(instance, docRes.Document, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, annot := range annots {
+		if annot.GetNM() != inkAnnot.GetNM() {
+			continue
+		}
+		if opacity := annot.(*InkAnnotation).GetOpacity(); opacity != 120 {
+			t.Fatalf("expect opacity 120, got %d", opacity)
+		}
+	}
+
+	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+		Document: docRes.Document,
+		FilePath: &outputFile,
+	})
+	if err != nil {
+		t.Fatalf("save opacity document failed: %v", err)
+	}
 }
@@ -37,6 +37,9 @@ func (b *BaseAnnotation) GetColorAP() string {
 	}, "\n")
 }
 
+// GetPDFOpacityAP returns the operator applying the annotation opacity.
+// /GS is the ExtGState which FPDFAnnot_SetAP adds to the appearance resources
+// when the annotation has a /CA below 1, with both CA and ca set to that value.
 func (b *BaseAnnotation) GetPDFOpacityAP() string {
 	if b.opacity == DefaultOpacity {
 		return ""
@@ -0,0 +1,285 @@
+// 边框样式
+package annotation
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// border styles of /BS /S
+const (
+	BorderStyleSolid     = "S"
+	BorderStyleDashed    = "D"
+	BorderStyleBeveled   = "B"
+	BorderStyleInset     = "I"
+	BorderStyleUnderline = "U"
+)
+
+// border effects of /BE /S
+const (
+	BorderEffectNone   = "S"
+	BorderEffectCloudy = "C"
+)
+
+const (
+	// cloudyArcSweep is the angle swept by each curl of a cloudy border, above 180° the curls meet in cusps.
+	cloudyArcSweep = 4 * math.Pi / 3
+)
+
+// defaultDashArray is the dash array of /BS /D when none is given.
+var defaultDashArray = []int{3}
+
+// SetBorderStyle sets the style of the border, the dash array is only used by dashed borders.
+func (b *BorderStyle) SetBorderStyle(style string, dashArray ...int) {
+	b.Style = style
+	b.DashArray = dashArray
+}
+
+// SetCloudy draws the border as a cloud, the intensity goes from 0 to 2 and sets the size of the curls.
+// Only squares, circles, polygons and free texts draw cloudy borders.
+func (b *BorderStyle) SetCloudy(intensity int) {
+	b.Effect = BorderEffectCloudy
+	b.EffectInt = min(max(intensity, 0), 2)
+}
+
+// GetDashAP returns the operator setting the dash pattern of a dashed border.
+func (b *BorderStyle) GetDashAP() string {
+	if b.Style != BorderStyleDashed {
+		return ""
+	}
+	dashArray := b.DashArray
+	if len(dashArray) == 0 {
+		dashArray = defaultDashArray
+	}
+	dashes := make([]string, 0, len(dashArray))
+	for _, dash := range dashArray {
+		dashes = append(dashes, fmt.Sprint(dash))
+	}
+	return fmt.Sprintf("[%s] 0 d", strings.Join(dashes, " "))
+}
+
+// resetDashAP returns the operator going back to solid strokes after a dashed border, so line endings are drawn solid.
+func (b *BorderStyle) resetDashAP() string {
+	if b.Style != BorderStyleDashed {
+		return ""
+	}
+	return "[] 0 d\n"
+}
+
+// border gives access to the BorderStyle embedded in an annotation type.
+func (b *BorderStyle) border() *BorderStyle {
+	return b
+}
+
+func (b *BorderStyle) isCloudy() bool {
+	return b.Effect == BorderEffectCloudy
+}
+
+// styled reports whether the border has a style or an effect, written in /BS and /BE.
+func (b *BorderStyle) styled() bool {
+	return b.Style != "" || b.isCloudy()
+}
+
+// borderStyleEntries returns /BS with the width, and /BE of a cloudy border, in pdf syntax. style may be nil.
+func borderStyleEntries(width float32, style *BorderStyle) []string {
+	bs := []string{"/W " + formatNumbers(width)}
+	var entries []string
+	if style != nil {
+		if style.Style != "" {
+			bs = append(bs, "/S "+formatPDFName(style.Style))
+		}
+		if style.Style == BorderStyleDashed && len(style.DashArray) > 0 {
+			bs = append(bs, "/D ["+formatInts(style.DashArray)+"]")
+		}
+		if style.isCloudy() {
+			entries = append(entries, fmt.Sprintf("/BE <</S /C /I %d>>", style.EffectInt))
+		}
+	}
+	return append([]string{"/BS <<" + strings.Join(bs, " ") + ">>"}, entries...)
+}
+
+// cloudyRadius returns the radius of the curls of a cloudy border.
+func (b *BorderStyle) cloudyRadius(width float32) float32 {
+	return max(3+2.5*float32(b.EffectInt), width*2)
+}
+
+// cloudyExtent returns how far the curls of a cloudy border reach outside the shape it follows.
+func (b *BorderStyle) cloudyExtent(width float32) float32 {
+	radius := b.cloudyRadius(width)
+	return radius - radius*float32(math.Cos(cloudyArcSweep/2)) + width/2
+}
+
+// borderInset returns how far the content of a rectangular annotation is moved in by the border, beyond its width.
+func (b *BorderStyle) borderInset(width float32) float32 {
+	switch {
+	case b.isCloudy():
+		return b.cloudyExtent(width)
+	case b.Style == BorderStyleBeveled || b.Style == BorderStyleInset:
+		return width
+	default:
+		return 0
+	}
+}
+
+// cloudyPathAP returns the path of a cloud around the closed polygon, without painting it.
+// Every edge is split into chords, each drawn as a curl bulging outside the polygon.
+func cloudyPathAP(polygon []Point, radius float32) string {
+	if len(polygon) < 3 {
+		return ""
+	}
+
+	// walk the polygon counterclockwise, so the outside is on the right
+	var area float32
+	for i, p := range polygon {
+		q := polygon[(i+1)%len(polygon)]
+		area += p.X*q.Y - q.X*p.Y
+	}
+	if area < 0 {
+		reversed := make([]Point, 0, len(polygon))
+		for i := len(polygon) - 1; i >= 0; i-- {
+			reversed = append(reversed, polygon[i])
+		}
+		polygon = reversed
+	}
+
+	// points where the curls meet
+	chord := cloudyChord(radius)
+	var points []Point
+	for i, p := range polygon {
+		q := polygon[(i+1)%len(polygon)]
+		length := float32(math.Hypot(float64(q.X-p.X), float64(q.Y-p.Y)))
+		if IsZeroEpsilon(length) {
+			continue
+		}
+		n := max(int(math.Ceil(float64(length/chord))), 1)
+		for j := 0; j < n; j++ {
+			t := float32(j) / float32(n)
+			points = append(points, Point{X: p.X + (q.X-p.X)*t, Y: p.Y + (q.Y-p.Y)*t})
+		}
+	}
+	if len(points) < 2 {
+		return ""
+	}
+
+	var ap strings.Builder
+	fmt.Fprintf(&ap, "%.3f %.3f m\n", points[0].X, points[0].Y)
+	for i, p := range points {
+		ap.WriteString(curlAP(p, points[(i+1)%len(points)]))
+	}
+	ap.WriteString("h\n")
+	return ap.String()
+}
+
+// cloudyChord returns the distance between the ends of a curl of the radius.
+func cloudyChord(radius float32) float32 {
+	return 2 * radius * float32(math.Sin(cloudyArcSweep/2))
+}
+
+// curlAP returns the bezier curves of a circular arc from p to q sweeping cloudyArcSweep, bulging on the right of p->q.
+func curlAP(p, q Point) string {
+	dx, dy := q.X-p.X, q.Y-p.Y
+	chord := math.Hypot(float64(dx), float64(dy))
+	radius := chord / 2 / math.Sin(cloudyArcSweep/2)
+
+	// the center lies outside of the chord, on its right
+	offset := -radius * math.Cos(cloudyArcSweep/2)
+	cx := float64(p.X+q.X)/2 + float64(dy)/chord*offset
+	cy := float64(p.Y+q.Y)/2 - float64(dx)/chord*offset
+
+	// sweep counterclockwise in four curves
+	const segments = 4
+	step := cloudyArcSweep / segments
+	k := 4.0 / 3.0 * math.Tan(step/4) * radius
+	angle := math.Atan2(float64(p.Y)-cy, float64(p.X)-cx)
+	var ap string
+	for i := 0; i < segments; i++ {
+		a0, a1 := angle+float64(i)*step, angle+float64(i+1)*step
+		sin0, cos0 := math.Sincos(a0)
+		sin1, cos1 := math.Sincos(a1)
+		ap += fmt.Sprintf("%.3f %.3f %.3f %.3f %.3f %.3f c\n",
+			cx+radius*cos0-k*sin0, cy+radius*sin0+k*cos0,
+			cx+radius*cos1+k*sin1, cy+radius*sin1-k*cos1,
+			cx+radius*cos1, cy+radius*sin1)
+	}
+	return ap
+}
+
+// ellipsePolygon returns points on the ellipse in the rect, at most a curl of the radius apart, to draw a cloud around it.
+func ellipsePolygon(rect Rect, radius float32) []Point {
+	chord := cloudyChord(radius)
+	rx, ry := float64(rect.Right-rect.Left)/2, float64(rect.Top-rect.Bottom)/2
+	cx, cy := float64(rect.Left)+rx, float64(rect.Bottom)+ry
+	// Ramanujan's approximation of the perimeter
+	perimeter := math.Pi * (3*(rx+ry) - math.Sqrt((3*rx+ry)*(rx+3*ry)))
+	n := max(int(math.Ceil(perimeter/float64(chord))), 8)
+	points := make([]Point, 0, n)
+	for i := 0; i < n; i++ {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / float64(n))
+		points = append(points, Point{X: float32(cx + rx*cos), Y: float32(cy + ry*sin)})
+	}
+	return points
+}
+
+// rectBorderPathAP returns the path of the border of rect, following the middle of a stroke of the width
+// inside it, without painting it.
+func (b *BorderStyle) rectBorderPathAP(rect Rect, width float32) string {
+	if b.isCloudy() {
+		return cloudyPathAP(rectPolygon(insetRect(rect, b.cloudyExtent(width))), b.cloudyRadius(width))
+	}
+	rect = insetRect(rect, width/2)
+	if b.Style == BorderStyleUnderline {
+		return fmt.Sprintf("%.3f %.3f m %.3f %.3f l\n", rect.Left, rect.Bottom, rect.Right, rect.Bottom)
+	}
+	return fmt.Sprintf("%.3f %.3f %.3f %.3f re\n", rect.Left, rect.Bottom, rect.Right-rect.Left, rect.Top-rect.Bottom)
+}
+
+// insetRect returns the rect moved in by inset on each side.
+func insetRect(rect Rect, inset float32) Rect {
+	return Rect{
+		Left:   rect.Left + inset,
+		Bottom: rect.Bottom + inset,
+		Right:  rect.Right - inset,
+		Top:    rect.Top - inset,
+	}
+}
+
+// rectPolygon returns the corners of the rect counterclockwise.
+func rectPolygon(rect Rect) []Point {
+	return []Point{
+		{X: rect.Left, Y: rect.Bottom},
+		{X: rect.Right, Y: rect.Bottom},
+		{X: rect.Right, Y: rect.Top},
+		{X: rect.Left, Y: rect.Top},
+	}
+}
+
+// bevelAP returns the filled bands of a beveled or inset border, lining the inside of rect with the width of the border.
+// Beveled borders are lit from the top left, inset borders look pressed in.
+func (b *BaseAnnotation) bevelAP(style string, rect Rect) string {
+	w := b.width
+	if IsZeroEpsilon(w) || rect.Right-rect.Left <= 2*w || rect.Top-rect.Bottom <= 2*w {
+		return ""
+	}
+
+	var light, dark string
+	switch style {
+	case BorderStyleBeveled:
+		light = "1.000 g"
+		dark = "0.750 g"
+		if b.fillColor != nil {
+			dark = b.getColorAP(&Color{R: b.fillColor.R / 2, G: b.fillColor.G / 2, B: b.fillColor.B / 2}, true)
+		}
+	case BorderStyleInset:
+		light = "0.500 g"
+		dark = "0.750 g"
+	default:
+		return ""
+	}
+
+	l, bo, r, t := rect.Left, rect.Bottom, rect.Right, rect.Top
+	return fmt.Sprintf("%s\n%.3f %.3f m %.3f %.3f l %.3f %.3f l %.3f %.3f l %.3f %.3f l %.3f %.3f l h f\n"+
+		"%s\n%.3f %.3f m %.3f %.3f l %.3f %.3f l %.3f %.3f l %.3f %.3f l %.3f %.3f l h f\n",
+		light, l, bo, l, t, r, t, r-w, t-w, l+w, t-w, l+w, bo+w,
+		dark, r, t, r, bo, l, bo, l+w, bo+w, r-w, bo+w, r-w, t-w)
+}
@@ -21,6 +21,7 @@ var (
 
 type CircleAnnotation struct {
 	BaseAnnotation
+	BorderStyle
 }
 
 func NewCircleAnnotation() *CircleAnnotation {
@@ -43,14 +44,36 @@ func (c *CircleAnnotation) GenerateAppearance() error {
 		c.GetWidthAP(),
 		c.GetColorAP(),
 		c.GetPDFOpacityAP(),
+		c.GetDashAP(),
 		c.pointsCallback(),
 	}, "\n")
 
 	return nil
 }
 
+// pointsCallback draws the ellipse in the rect, or a cloud around a smaller one.
+// ps: beveled and inset styles are drawn solid on circles
 func (c *CircleAnnotation) pointsCallback() string {
 	var ap string
+	if c.isCloudy() {
+		radius := c.cloudyRadius(c.width)
+		ellipse := insetRect(c.rect, c.cloudyExtent(c.width))
+		ap = cloudyPathAP(ellipsePolygon(ellipse, radius), radius)
+	} else {
+		ap = c.ellipsePathAP()
+	}
+	if c.fillColor != nil {
+		ap += "B\n"
+	}
+	if c.strikeColor != nil {
+		ap += "S\n"
+	}
+
+	return ap
+}
+
+// ellipsePathAP returns the path of the ellipse inside the rect, without painting it.
+func (c *CircleAnnotation) ellipsePathAP() string {
 	x0 := c.rect.Left + float32(c.width)/2
 	y0 := c.rect.Top - float32(c.width)/2
 	x1 := c.rect.Right - float32(c.width)/2
@@ -60,7 +83,7 @@ func (c *CircleAnnotation) pointsCallback() string {
 	yMid := y0 + (y1-y0)/2
 	xOffset := ((x1 - x0) / 2) * controlPointsDistance
 	yOffset := ((y1 - y0) / 2) * controlPointsDistance
-	ap = strings.Join([]string{
+	return strings.Join([]string{
 		fmt.Sprintf("%.3f %.3f m", xMid, y1),
 		fmt.Sprintf("%.3f %.3f %.3f %.3f %.3f %.3f c", xMid+xOffset, y1, x1, yMid+yOffset, x1, yMid),
 		fmt.Sprintf("%.3f %.3f %.3f %.3f %.3f %.3f c", x1, yMid-yOffset, xMid+xOffset, y0, xMid, y0),
@@ -68,18 +91,11 @@ func (c *CircleAnnotation) pointsCallback() string {
 		fmt.Sprintf("%.3f %.3f %.3f %.3f %.3f %.3f c", x0, yMid+yOffset, xMid-xOffset, y1, xMid, y1),
 		"h\n",
 	}, "\n")
-	if c.fillColor != nil {
-		ap += "B\n"
-	}
-	if c.strikeColor != nil {
-		ap += "S\n"
-	}
-
-	return ap
 }
 
 func (c *CircleAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
 	// create annotation
+	c.stash = extraKeyEntries(c)
 	err := c.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
 	if err != nil {
 		return err
@@ -90,7 +106,7 @@ func (c *CircleAnnotation) AddAnnotationToPage(ctx context.Context, instance pdf
 		Annotation: c.annot,
 	})
 	if err != nil {
-		return err
+		return c.newError(StepClose, err)
 	}
 	return nil
 }
@@ -2,9 +2,10 @@ package annotation
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/klippa-app/go-pdfium"
 	"github.com/klippa-app/go-pdfium/enums"
@@ -40,6 +41,14 @@ func convertPointToPdfiumFormat(points []Point) []structs.FPDF_FS_POINTF {
 	return pdfiumPoints
 }
 
+func convertPointFromPdfiumFormat(pdfiumPoints []structs.FPDF_FS_POINTF) []Point {
+	points := make([]Point, 0, len(pdfiumPoints))
+	for _, point := range pdfiumPoints {
+		points = append(points, Point{X: point.X, Y: point.Y})
+	}
+	return points
+}
+
 // QuadPoint represents a quadrilateral point with left top, right top, right bottom, and left bottom coordinates.
 // Defining the area of the text-markup(highlight/underline/strikeout) annotation on the page.
 type QuadPoint struct {
@@ -66,6 +75,32 @@ func convertQuadPointToPdfiumFormat(quadPoints []QuadPoint) []structs.FPDF_FS_QU
 	return pdfiumQuadPoints
 }
 
+func convertQuadPointFromPdfiumFormat(quadPoint structs.FPDF_FS_QUADPOINTSF) QuadPoint {
+	return QuadPoint{
+		LeftTopX:     quadPoint.X1,
+		LeftTopY:     quadPoint.Y1,
+		RightTopX:    quadPoint.X2,
+		RightTopY:    quadPoint.Y2,
+		LeftBottomX:  quadPoint.X3,
+		LeftBottomY:  quadPoint.Y3,
+		RightBottomX: quadPoint.X4,
+		RightBottomY: quadPoint.Y4,
+	}
+}
+
+// points returns the corners of the quad, counterclockwise from the left bottom.
+func (q QuadPoint) points() []Point {
+	return []Point{
+		{X: q.LeftBottomX, Y: q.LeftBottomY},
+		{X: q.RightBottomX, Y: q.RightBottomY},
+		{X: q.RightTopX, Y: q.RightTopY},
+		{X: q.LeftTopX, Y: q.LeftTopY},
+	}
+}
+
+// BorderStyle is the /BS style and /BE effect of the border, drawn in the generated appearance.
+// The drawn width is the width of the annotation, Width is only kept for callers.
+// ps: pdfium can not write dictionaries, /Border gets the width and SavePDF writes /BS and /BE
 type BorderStyle struct {
 	Width     float32
 	Style     string
@@ -93,6 +128,10 @@ func (l *LineStyle) SetStrikeLineJoin(lineJoin enums.FPDF_LINEJOIN) {
 	l.StrikeLineJoin = lineJoin
 }
 
+func (l *LineStyle) lineStyle() *LineStyle {
+	return l
+}
+
 func (l *LineStyle) GetLineStyleAP() string {
 	return fmt.Sprintf("%d j %d J", l.StrikeLineCap, l.StrikeLineJoin)
 }
@@ -100,6 +139,7 @@ func (l *LineStyle) GetLineStyleAP() string {
 type BaseAnnotation struct {
 	nm          string // annotation unique name
 	title       string
+	contents    string
 	annot       references.FPDF_ANNOTATION
 	subtype     enums.FPDF_ANNOTATION_SUBTYPE
 	rect        Rect
@@ -108,6 +148,63 @@ type BaseAnnotation struct {
 	strikeColor *Color
 	fillColor   *Color
 	ap          string
+	// written as /CreationDate and /M when set
+	creationDate time.Time
+	modDate      time.Time
+	// keys pdfium has no setter for in pdf syntax, set by the annotation types, see SavePDF
+	stash []string
+	// created as a stamp, pdfium only appends page objects to stamps and inks
+	asStamp bool
+}
+
+// Annotation is implemented by every annotation type of this package,
+// so callers can hold different annotations in one slice and add them
+// without a type switch.
+type Annotation interface {
+	GetSubtype() enums.FPDF_ANNOTATION_SUBTYPE
+	GetSubtypeName() string
+	GetNM() string
+	GetRect() Rect
+	GenerateAppearance() error
+	// AddAnnotationToPage returns an *AnnotationWarning when the annotation is added as a stamp, see IsWarning
+	AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error
+}
+
+var (
+	_ Annotation = (*LineAnnotation)(nil)
+	_ Annotation = (*SquareAnnotation)(nil)
+	_ Annotation = (*CircleAnnotation)(nil)
+	_ Annotation = (*InkAnnotation)(nil)
+	_ Annotation = (*FreeTextAnnotation)(nil)
+	_ Annotation = (*HighlightAnnotation)(nil)
+	_ Annotation = (*UnderlineAnnotation)(nil)
+	_ Annotation = (*StrikeoutAnnotation)(nil)
+	_ Annotation = (*SquigglyAnnotation)(nil)
+	_ Annotation = (*PolygonAnnotation)(nil)
+	_ Annotation = (*PolylineAnnotation)(nil)
+	_ Annotation = (*TextAnnotation)(nil)
+	_ Annotation = (*StampAnnotation)(nil)
+	_ Annotation = (*RedactAnnotation)(nil)
+)
+
+// GetSubtype returns the subtype of the annotation.
+func (b *BaseAnnotation) GetSubtype() enums.FPDF_ANNOTATION_SUBTYPE {
+	return b.subtype
+}
+
+// GetNM returns the unique name of the annotation.
+func (b *BaseAnnotation) GetNM() string {
+	return b.nm
+}
+
+// SetNM overrides the unique name generated by the constructor.
+func (b *BaseAnnotation) SetNM(nm string) {
+	b.nm = nm
+}
+
+// GetRect returns the rect of the annotation.
+func (b *BaseAnnotation) GetRect() Rect {
+	return b.rect
 }
 
 // SetTitle sets the title of the annotation.
@@ -115,6 +212,21 @@ func (b *BaseAnnotation) SetTitle(title string) {
 	b.title = title
 }
 
+// GetTitle returns the title of the annotation.
+func (b *BaseAnnotation) GetTitle() string {
+	return b.title
+}
+
+// SetContents sets the text displayed for the annotation.
+func (b *BaseAnnotation) SetContents(contents string) {
+	b.contents = contents
+}
+
+// GetContents returns the text displayed for the annotation.
+func (b *BaseAnnotation) GetContents() string {
+	return b.contents
+}
+
 // SetRect sets the rect of the annotation.
 func (b *BaseAnnotation) SetRect(rect Rect) {
 	b.rect = rect
@@ -124,25 +236,85 @@ func (b *BaseAnnotation) SetWidth(width float32) {
 	b.width = width
 }
 
+// GetWidth returns the border width of the annotation.
+func (b *BaseAnnotation) GetWidth() float32 {
+	return b.width
+}
+
 // SetOpacity sets the opacity of the annotation.
+// It is written as /CA of the annotation and applied to the generated appearance through /GS.
 func (b *BaseAnnotation) SetOpacity(opacity uint8) {
 	b.opacity = opacity
 }
 
+// strikeColorWithOpacity returns the color to write as /C.
+// pdfium only writes /CA together with a color, so an annotation with opacity but
+// without any color gets black, which its appearance stream draws with by default.
+func (b *BaseAnnotation) strikeColorWithOpacity() *Color {
+	if b.strikeColor == nil && b.fillColor == nil && b.opacity != DefaultOpacity {
+		return &Color{R: 0, G: 0, B: 0}
+	}
+	return b.strikeColor
+}
+
+// GetOpacity returns the opacity of the annotation.
+func (b *BaseAnnotation) GetOpacity() uint8 {
+	return b.opacity
+}
+
 // SetStrikeColor sets the strike color of the annotation.
 func (b *BaseAnnotation) SetStrikeColor(c Color) {
 	b.strikeColor = &c
 }
 
+// GetStrikeColor returns the strike color of the annotation, nil if not set.
+func (b *BaseAnnotation) GetStrikeColor() *Color {
+	return b.strikeColor
+}
+
+// GetFillColor returns the fill color of the annotation, nil if not set.
+func (b *BaseAnnotation) GetFillColor() *Color {
+	return b.fillColor
+}
+
+// SetCreationDate sets the date the annotation was created.
+func (b *BaseAnnotation) SetCreationDate(t time.Time) {
+	b.creationDate = t
+}
+
+// GetCreationDate returns the date the annotation was created, zero if not set.
+func (b *BaseAnnotation) GetCreationDate() time.Time {
+	return b.creationDate
+}
+
+// SetModDate sets the date the annotation was last modified.
+func (b *BaseAnnotation) SetModDate(t time.Time) {
+	b.modDate = t
+}
+
+// GetModDate returns the date the annotation was last modified, zero if not set.
+func (b *BaseAnnotation) GetModDate() time.Time {
+	return b.modDate
+}
+
 func (b *BaseAnnotation) SetCustomAppearance(ap string) {
 	b.ap = ap
 }
 
+// GetAppearance returns the normal appearance stream of the annotation.
+func (b *BaseAnnotation) GetAppearance() string {
+	return b.ap
+}
+
+func (b *BaseAnnotation) base() *BaseAnnotation {
+	return b
+}
+
 func (b *BaseAnnotation) PreCheck() error {
 	// rect
 	if IsZeroEpsilon(b.rect.Left) && IsZeroEpsilon(b.rect.Bottom) &&
 		IsZeroEpsilon(b.rect.Right) && IsZeroEpsilon(b.rect.Top) {
-		return errors.New("rect must be set")
+		return ErrRectNotSet
 	}
 	return nil
 }
@@ -151,17 +323,24 @@ func (b *BaseAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfiu
 	// pre base check
 	err := b.PreCheck()
 	if err != nil {
-		return err
+		return b.newError(StepPreCheck, err)
+	}
+
+	// subtypes pdfium can not create are created as stamps, their subtype is stashed
+	subtype := b.subtype
+	stash := b.stash
+	if !creatableSubtypes[subtype] || b.asStamp {
+		subtype = enums.FPDF_ANNOT_SUBTYPE_STAMP
+		stash = append([]string{"/Subtype " + formatPDFName(pdfSubtypeName(b.subtype))}, stash...)
 	}
 
 	// create annot
 	annotRes, err := instance.FPDFPage_CreateAnnot(&requests.FPDFPage_CreateAnnot{
 		Page:    page,
-		Subtype: b.subtype,
+		Subtype: subtype,
 	})
 	if err != nil {
-		log.Fatalf("create annot failed: %v", err)
-		return err
+		return b.newError(StepCreate, err)
 	}
 	b.annot = annotRes.Annotation
 
@@ -173,8 +352,7 @@ func (b *BaseAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfiu
 			Value:      b.title,
 		})
 		if err != nil {
-			log.Fatalf("set annot title failed: %v", err)
-			return err
+			return b.abort(instance, page, StepSetTitle, err)
 		}
 	}
 
@@ -189,8 +367,7 @@ func (b *BaseAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfiu
 		},
 	})
 	if err != nil {
-		log.Fatalf("set annot rect failed: %v", err)
-		return err
+		return b.abort(instance, page, StepSetRect, err)
 	}
 
 	// set border
@@ -202,24 +379,22 @@ func (b *BaseAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfiu
 			BorderWidth:      float32(b.width),
 		})
 		if err != nil {
-			log.Fatalf("set annot border failed: %v", err)
-			return err
+			return b.abort(instance, page, StepSetBorder, err)
 		}
 	}
 
-	// set strike color
-	if b.strikeColor != nil {
+	// set strike color, the opacity is written as /CA along with it
+	if strikeColor := b.strikeColorWithOpacity(); strikeColor != nil {
 		_, err = instance.FPDFAnnot_SetColor(&requests.FPDFAnnot_SetColor{
 			Annotation: b.annot,
 			ColorType:  enums.FPDFANNOT_COLORTYPE_Color,
-			R:          uint(b.strikeColor.R),
-			G:          uint(b.strikeColor.G),
-			B:          uint(b.strikeColor.B),
+			R:          uint(strikeColor.R),
+			G:          uint(strikeColor.G),
+			B:          uint(strikeColor.B),
 			A:          uint(b.opacity),
 		})
 		if err != nil {
-			log.Fatalf("set annot strike color failed: %v", err)
-			return err
+			return b.abort(instance, page, StepSetColor, err)
 		}
 	}
 
@@ -234,8 +409,19 @@ func (b *BaseAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfiu
 			A:          uint(b.opacity),
 		})
 		if err != nil {
-			log.Fatalf("set annot fill color failed: %v", err)
-			return err
+			return b.abort(instance, page, StepSetFillColor, err)
+		}
+	}
+
+	// set contents
+	if b.contents != "" {
+		_, err = instance.FPDFAnnot_SetStringValue(&requests.FPDFAnnot_SetStringValue{
+			Annotation: b.annot,
+			Key:        "Contents",
+			Value:      b.contents,
+		})
+		if err != nil {
+			return b.abort(instance, page, StepSetContents, err)
 		}
 	}
 
@@ -247,8 +433,33 @@ func (b *BaseAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfiu
 			Value:      b.nm,
 		})
 		if err != nil {
-			log.Fatalf("set annot nm failed: %v", err)
-			return err
+			return b.abort(instance, page, StepSetNM, err)
+		}
+	}
+
+	// set dates
+	for _, date := range []struct {
+		key string
+		t   time.Time
+	}{{"CreationDate", b.creationDate}, {"M", b.modDate}} {
+		if date.t.IsZero() {
+			continue
+		}
+		_, err = instance.FPDFAnnot_SetStringValue(&requests.FPDFAnnot_SetStringValue{
+			Annotation: b.annot,
+			Key:        date.key,
+			Value:      FormatPDFDate(date.t),
+		})
+		if err != nil {
+			return b.abort(instance, page, StepSetDate, err)
+		}
+	}
+
+	// set stash
+	if len(stash) > 0 {
+		err = writeStash(instance, b.annot, stash)
+		if err != nil {
+			return b.abort(instance, page, StepSetRawKey, err)
 		}
 	}
 
@@ -262,8 +473,7 @@ func (b *BaseAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfiu
 			Value:          &b.ap,
 		})
 		if err != nil {
-			log.Fatalf("set annot ap failed: %v", err)
-			return err
+			return b.abort(instance, page, StepSetAP, err)
 		}
 	}
 
@@ -334,3 +544,13 @@ func (b *BaseAnnotation) GetSubtypeName() string {
 		return "Unknown"
 	}
 }
+
+// subtypeByName returns the subtype named name, as returned by GetSubtypeName, in any case.
+func subtypeByName(name string) (enums.FPDF_ANNOTATION_SUBTYPE, bool) {
+	for subtype := enums.FPDF_ANNOT_SUBTYPE_TEXT; subtype <= enums.FPDF_ANNOT_SUBTYPE_REDACT; subtype++ {
+		if strings.EqualFold((&BaseAnnotation{subtype: subtype}).GetSubtypeName(), name) {
+			return subtype, true
+		}
+	}
+	return enums.FPDF_ANNOT_SUBTYPE_UNKNOWN, false
+}
@@ -0,0 +1,133 @@
+package annotation
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+var (
+	ErrRectNotSet   = errors.New("rect must be set")
+	ErrRedactObject = errors.New("pdfium can not redact part of the page object")
+	// ErrSavePDFRequired is the reason of the *AnnotationWarning of the subtypes pdfium adds as stamps
+	ErrSavePDFRequired = errors.New("the annot is a stamp until the document is saved with SavePDF")
+)
+
+// AnnotStep is the step of adding an annotation to a page.
+type AnnotStep string
+
+const (
+	StepPreCheck       AnnotStep = "pre check"
+	StepCreate         AnnotStep = "create"
+	StepSetTitle       AnnotStep = "set title"
+	StepSetRect        AnnotStep = "set rect"
+	StepSetBorder      AnnotStep = "set border"
+	StepSetColor       AnnotStep = "set strike color"
+	StepSetFillColor   AnnotStep = "set fill color"
+	StepSetContents    AnnotStep = "set contents"
+	StepSetNM          AnnotStep = "set nm"
+	StepSetDate        AnnotStep = "set date"
+	StepSetAP          AnnotStep = "set ap"
+	StepSetDA          AnnotStep = "set da"
+	StepSetFlags       AnnotStep = "set flags"
+	StepSetQuadPoints  AnnotStep = "set quad points"
+	StepSetInkList     AnnotStep = "set ink list"
+	StepSetOverlayText AnnotStep = "set overlay text"
+	StepSetRawKey      AnnotStep = "set raw key"
+	StepCreateObject   AnnotStep = "create object"
+	StepAppendObject   AnnotStep = "append object"
+	StepClose          AnnotStep = "close"
+)
+
+// AnnotationError is returned by AddAnnotationToPage, it wraps the pdfium error of the failed step.
+type AnnotationError struct {
+	Step    AnnotStep
+	Subtype string
+	NM      string
+	Err     error
+}
+
+func (e *AnnotationError) Error() string {
+	return fmt.Sprintf("%s %s annot (nm: %s) failed: %v", e.Step, e.Subtype, e.NM, e.Err)
+}
+
+func (e *AnnotationError) Unwrap() error {
+	return e.Err
+}
+
+func (b *BaseAnnotation) newError(step AnnotStep, err error) error {
+	return &AnnotationError{
+		Step:    step,
+		Subtype: b.GetSubtypeName(),
+		NM:      b.nm,
+		Err:     err,
+	}
+}
+
+// AnnotationWarning is returned by AddAnnotationToPage when the annotation was added but is not complete,
+// e.g. lines, polygons, polylines, redactions and free texts are stamps until the document is saved with SavePDF.
+// The annotation stays on the page, check it with IsWarning.
+type AnnotationWarning struct {
+	Subtype string
+	NM      string
+	Err     error
+}
+
+func (w *AnnotationWarning) Error() string {
+	return fmt.Sprintf("%s annot (nm: %s) added: %v", w.Subtype, w.NM, w.Err)
+}
+
+func (w *AnnotationWarning) Unwrap() error {
+	return w.Err
+}
+
+// IsWarning reports whether err is an *AnnotationWarning, the annotation was added.
+func IsWarning(err error) bool {
+	var warning *AnnotationWarning
+	return errors.As(err, &warning)
+}
+
+// savePDFWarning returns the warning of an annotation added as a stamp, nil for the other annotations.
+func (b *BaseAnnotation) savePDFWarning() error {
+	if creatableSubtypes[b.subtype] && !b.asStamp {
+		return nil
+	}
+	return &AnnotationWarning{
+		Subtype: b.GetSubtypeName(),
+		NM:      b.nm,
+		Err:     ErrSavePDFRequired,
+	}
+}
+
+// abort removes the half-created annotation from the page and returns the wrapped error.
+func (b *BaseAnnotation) abort(instance pdfium.Pdfium, page requests.Page, step AnnotStep, err error) error {
+	b.removeFromPage(instance, page)
+	return b.newError(step, err)
+}
+
+// removeFromPage closes the annotation and removes it from the page, errors are ignored
+// since it only runs after another failure.
+func (b *BaseAnnotation) removeFromPage(instance pdfium.Pdfium, page requests.Page) {
+	if b.annot == "" {
+		return
+	}
+
+	indexRes, err := instance.FPDFPage_GetAnnotIndex(&requests.FPDFPage_GetAnnotIndex{
+		Page:       page,
+		Annotation: b.annot,
+	})
+	instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: b.annot,
+	})
+	b.annot = ""
+	if err != nil {
+		return
+	}
+
+	instance.FPDFPage_RemoveAnnot(&requests.FPDFPage_RemoveAnnot{
+		Page:  page,
+		Index: indexRes.Index,
+	})
+}
@@ -0,0 +1,570 @@
+// FDF 导入导出
+package annotation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+// fdfModeledKeys are the annotation keys read into the typed structs, the other keys of unsupported subtypes are kept raw.
+var fdfModeledKeys = map[string]bool{
+	"Type": true, "Subtype": true, "Page": true, "Rect": true, "NM": true, "T": true, "Contents": true,
+	"CreationDate": true, "M": true, "C": true, "IC": true, "CA": true, "Border": true, "BS": true,
+	"AP": true, "Popup": true, "Parent": true, "P": true,
+}
+
+// fdfWriter collects the indirect objects of an fdf file, object n is objects[n-1].
+type fdfWriter struct {
+	objects []string
+}
+
+// reserve returns the number of a new object, its body is set later.
+func (w *fdfWriter) reserve() int {
+	w.objects = append(w.objects, "")
+	return len(w.objects)
+}
+
+func (w *fdfWriter) set(num int, body string) {
+	w.objects[num-1] = body
+}
+
+func (w *fdfWriter) add(body string) int {
+	num := w.reserve()
+	w.set(num, body)
+	return num
+}
+
+func (w *fdfWriter) bytes(root int) []byte {
+	var buf bytes.Buffer
+	// the binary comment marks the file as binary for transfer tools
+	buf.WriteString("%FDF-1.2\n%\xE2\xE3\xCF\xD3\n")
+	for i, body := range w.objects {
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	fmt.Fprintf(&buf, "trailer\n<</Root %d 0 R>>\n%%%%EOF\n", root)
+	return buf.Bytes()
+}
+
+// MarshalFDF encodes the annotations of each page as an FDF file, file is the /F of the pdf, "" leaves it out.
+// Unsupported subtypes are written with their common keys, their raw keys and their appearance.
+// Popups of sticky notes are written as their own annotations, other popups and widgets are skipped.
+// Free texts are written without appearance, it is generated again on import. The appearance of an image stamp
+// is a form with the image in its resources, other appearances are written without resources.
+func MarshalFDF(pageAnnots []AddOnePageAnnot, file string) ([]byte, error) {
+	w := &fdfWriter{}
+	root := w.reserve()
+
+	var annotRefs []string
+	for _, item := range pageAnnots {
+		for _, annot := range item.Annots {
+			if !isExportable(annot) {
+				continue
+			}
+			nums, err := writeFDFAnnot(w, item.PageNumber, annot)
+			if err != nil {
+				return nil, err
+			}
+			for _, num := range nums {
+				annotRefs = append(annotRefs, fmt.Sprintf("%d 0 R", num))
+			}
+		}
+	}
+
+	fdf := "/Annots [" + strings.Join(annotRefs, " ") + "]"
+	if file != "" {
+		fdf += " /F " + formatPDFString(file)
+	}
+	w.set(root, "<</FDF <<"+fdf+">>>>")
+	return w.bytes(root), nil
+}
+
+// UnmarshalFDF decodes the annotations of an FDF file, grouped by page in the order of /Annots.
+// Subtypes without a type in this package become *UnsupportedAnnotation, with their other keys in Keys.
+// Appearances with a filter other than /FlateDecode are dropped, so are the appearances of free texts.
+// A stamp whose appearance draws one image becomes an image stamp.
+func UnmarshalFDF(data []byte) ([]AddOnePageAnnot, error) {
+	if !bytes.HasPrefix(data, []byte("%FDF-")) {
+		return nil, errors.New("not an fdf file")
+	}
+	p := &pdfParser{data: data}
+	objects, trailer, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+
+	root, _ := resolvePDFObject(objects, trailer["Root"]).(pdfDict)
+	fdf, _ := resolvePDFObject(objects, root["FDF"]).(pdfDict)
+	if fdf == nil {
+		return nil, errors.New("fdf dictionary not found")
+	}
+	annotRefs, _ := resolvePDFObject(objects, fdf["Annots"]).([]any)
+
+	var pageAnnots []AddOnePageAnnot
+	// popups are read with their parent
+	for _, ref := range annotRefs {
+		dict, ok := resolvePDFObject(objects, ref).(pdfDict)
+		if !ok {
+			continue
+		}
+		subtypeName, _ := dict["Subtype"].(pdfName)
+		subtype, ok := pdfSubtypeByName(string(subtypeName))
+		if !ok || subtype == enums.FPDF_ANNOT_SUBTYPE_POPUP || subtype == enums.FPDF_ANNOT_SUBTYPE_WIDGET {
+			continue
+		}
+		pageNum, _ := dict["Page"].(float64)
+		annot, err := fdfAnnotation(objects, dict, subtype)
+		if err != nil {
+			return nil, fmt.Errorf("fdf %s annot: %w", subtypeName, err)
+		}
+		pageAnnots = appendPageAnnot(pageAnnots, int(pageNum), annot)
+	}
+	return pageAnnots, nil
+}
+
+// ExportFDF writes the annotations of the given pages as an FDF file, every page when pageNums is nil.
+func ExportFDF(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNums []int, w io.Writer) error {
+	pageAnnots, err := loadPageAnnots(instance, pdfDoc, pageNums)
+	if err != nil {
+		return err
+	}
+	data, err := MarshalFDF(pageAnnots, "")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ImportFDF adds the annotations of an FDF file to the pdf, pages are matched by index.
+// Annotations whose NM is already on the page are skipped, so importing a file twice adds nothing.
+// ps: lines, polygons, polylines and redactions are added as stamps, save the document with SavePDF to write them.
+func ImportFDF(ctx context.Context, instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	pageAnnots, err := UnmarshalFDF(data)
+	if err != nil {
+		return 0, err
+	}
+	return addImportedAnnotations(ctx, instance, pdfDoc, pageAnnots)
+}
+
+// pdfSubtypeName returns the /Subtype name of the subtype, GetSubtypeName differs from it for a few subtypes.
+func pdfSubtypeName(subtype enums.FPDF_ANNOTATION_SUBTYPE) string {
+	switch subtype {
+	case enums.FPDF_ANNOT_SUBTYPE_POLYLINE:
+		return "PolyLine"
+	case enums.FPDF_ANNOT_SUBTYPE_STRIKEOUT:
+		return "StrikeOut"
+	case enums.FPDF_ANNOT_SUBTYPE_THREED:
+		return "3D"
+	}
+	return (&BaseAnnotation{subtype: subtype}).GetSubtypeName()
+}
+
+func pdfSubtypeByName(name string) (enums.FPDF_ANNOTATION_SUBTYPE, bool) {
+	if name == "3D" {
+		return enums.FPDF_ANNOT_SUBTYPE_THREED, true
+	}
+	return subtypeByName(name)
+}
+
+// writeFDFAnnot writes the annotation, its appearance stream and the popup of a sticky note,
+// it returns the numbers of the written annotations.
+func writeFDFAnnot(w *fdfWriter, pageNum int, annot Annotation) ([]int, error) {
+	b := annot.(annotationBase).base()
+	num := w.reserve()
+	nums := []int{num}
+
+	entries := []string{
+		"/Type /Annot",
+		"/Subtype " + formatPDFName(pdfSubtypeName(annot.GetSubtype())),
+		fmt.Sprintf("/Page %d", pageNum),
+		"/Rect " + formatPDFRect(b.rect),
+	}
+	addString := func(key, value string) {
+		if value != "" {
+			entries = append(entries, "/"+key+" "+formatPDFString(value))
+		}
+	}
+	addString("NM", b.nm)
+	addString("T", b.title)
+	addString("Contents", b.contents)
+	if !b.creationDate.IsZero() {
+		addString("CreationDate", FormatPDFDate(b.creationDate))
+	}
+	if !b.modDate.IsZero() {
+		addString("M", FormatPDFDate(b.modDate))
+	}
+
+	// highlight draws with the fill color, see HighlightAnnotation.SetStrikeColor
+	strikeColor, fillColor := b.strikeColor, b.fillColor
+	if b.subtype == enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT {
+		if fillColor != nil {
+			strikeColor = fillColor
+		}
+		fillColor = nil
+	}
+	if strikeColor != nil {
+		entries = append(entries, "/C "+formatPDFColor(strikeColor))
+	}
+	if fillColor != nil {
+		entries = append(entries, "/IC "+formatPDFColor(fillColor))
+	}
+	if b.opacity != DefaultOpacity {
+		entries = append(entries, "/CA "+formatNumbers(float32(b.opacity)/255))
+	}
+
+	// styled borders are written with the extra keys
+	if s, ok := annot.(interface{ border() *BorderStyle }); !ok || !s.border().styled() {
+		entries = append(entries, borderStyleEntries(b.width, nil)...)
+	}
+
+	if quadPoints, ok := markupQuadPoints(annot); ok {
+		entries = append(entries, "/QuadPoints ["+formatFDFQuadPoints(quadPoints)+"]")
+	}
+
+	entries = append(entries, extraKeyEntries(annot)...)
+
+	switch a := annot.(type) {
+	case *InkAnnotation:
+		strokes := make([]string, 0, len(a.Points))
+		for _, stroke := range a.Points {
+			strokes = append(strokes, "["+formatFDFPoints(stroke)+"]")
+		}
+		entries = append(entries, "/InkList ["+strings.Join(strokes, " ")+"]")
+	case *FreeTextAnnotation:
+		if b.contents == "" {
+			addString("Contents", a.Contents)
+		}
+		addString("DA", a.GetDefaultAppearance())
+	case *TextAnnotation:
+		if a.PopupRect != nil {
+			popup := w.add(fmt.Sprintf("<</Type /Annot /Subtype /Popup /Page %d /Rect %s /Open %t /Parent %d 0 R>>",
+				pageNum, formatPDFRect(*a.PopupRect), a.Open, num))
+			entries = append(entries, fmt.Sprintf("/Popup %d 0 R", popup))
+			nums = append(nums, popup)
+		}
+	case *RedactAnnotation:
+		if a.OverlayText != "" {
+			addString("OverlayText", a.OverlayText)
+			addString("DA", a.GetDefaultAppearance())
+		}
+	case *UnsupportedAnnotation:
+		keys := make([]string, 0, len(a.Keys))
+		for key := range a.Keys {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !fdfModeledKeys[key] {
+				entries = append(entries, formatPDFName(key)+" "+a.Keys[key])
+			}
+		}
+	}
+
+	// the appearance of an image stamp needs its image in the resources
+	var img image.Image
+	var matrix *structs.FPDF_FS_MATRIX
+	var isImg bool
+	if s, ok := annot.(*StampAnnotation); ok {
+		var err error
+		img, matrix, isImg, err = s.appearanceImage()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if isImg {
+		ap, err := writeFDFImageAppearance(w, b.rect, img, matrix)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fmt.Sprintf("/AP <</N %d 0 R>>", ap))
+	} else if b.ap != "" && b.subtype != enums.FPDF_ANNOT_SUBTYPE_FREETEXT {
+		ap := w.add(fmt.Sprintf("<</Type /XObject /Subtype /Form /BBox %s /Length %d>>\nstream\n%s\nendstream",
+			formatPDFRect(b.rect), len(b.ap), b.ap))
+		entries = append(entries, fmt.Sprintf("/AP <</N %d 0 R>>", ap))
+	}
+
+	w.set(num, "<<"+strings.Join(entries, " ")+">>")
+	return nums, nil
+}
+
+// fdfAnnotation returns the typed annotation of an fdf annotation dictionary.
+func fdfAnnotation(objects map[int]any, dict pdfDict, subtype enums.FPDF_ANNOTATION_SUBTYPE) (Annotation, error) {
+	annot := newAnnotationBySubtype(subtype)
+	b := annot.(annotationBase).base()
+	get := func(key string) any {
+		return resolvePDFObject(objects, dict[key])
+	}
+	text := func(key string) string {
+		s, _ := get(key).(string)
+		return s
+	}
+
+	rect := pdfNumbers(get("Rect"))
+	if len(rect) != 4 {
+		return nil, errors.New("rect must be set")
+	}
+	b.rect = Rect{
+		Left:   min(rect[0], rect[2]),
+		Bottom: min(rect[1], rect[3]),
+		Right:  max(rect[0], rect[2]),
+		Top:    max(rect[1], rect[3]),
+	}
+	if nm := text("NM"); nm != "" {
+		b.nm = nm
+	}
+	b.title = text("T")
+	b.contents = text("Contents")
+	b.creationDate, _ = ParsePDFDate(text("CreationDate"))
+	b.modDate, _ = ParsePDFDate(text("M"))
+
+	b.strikeColor = pdfColor(get("C"))
+	b.fillColor = pdfColor(get("IC"))
+	if subtype == enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT && b.fillColor == nil {
+		b.fillColor = b.strikeColor
+	}
+	if opacity, ok := get("CA").(float64); ok {
+		b.opacity = uint8(math.Min(math.Max(opacity, 0), 1)*255 + 0.5)
+	}
+
+	bs, _ := get("BS").(pdfDict)
+	if width, ok := resolvePDFObject(objects, bs["W"]).(float64); ok {
+		b.width = float32(width)
+	} else if border := pdfNumbers(get("Border")); len(border) >= 3 {
+		b.width = border[2]
+	}
+
+	var apStream *pdfStream
+	if ap, ok := get("AP").(pdfDict); ok && subtype != enums.FPDF_ANNOT_SUBTYPE_FREETEXT {
+		if stream, ok := resolvePDFObject(objects, ap["N"]).(*pdfStream); ok {
+			if data, err := decodePDFStream(stream); err == nil {
+				b.ap = string(data)
+				apStream = stream
+			}
+		}
+	}
+
+	quadPoints, err := pdfQuadPoints(get("QuadPoints"))
+	if err != nil {
+		return nil, err
+	}
+	readExtraKeys(objects, dict, annot)
+
+	switch a := annot.(type) {
+	case *HighlightAnnotation:
+		a.QuadPoints = quadPoints
+	case *UnderlineAnnotation:
+		a.QuadPoints = quadPoints
+	case *StrikeoutAnnotation:
+		a.QuadPoints = quadPoints
+	case *SquigglyAnnotation:
+		a.QuadPoints = quadPoints
+	case *RedactAnnotation:
+		a.OverlayText = text("OverlayText")
+		fontSize, fontColor := parseDefaultAppearance(text("DA"))
+		a.OverlayFontSize = fontSize
+		if fontColor != nil {
+			a.FontColor = *fontColor
+		}
+	case *InkAnnotation:
+		strokes, _ := get("InkList").([]any)
+		for _, stroke := range strokes {
+			a.Points = append(a.Points, pdfPoints(resolvePDFObject(objects, stroke)))
+		}
+	case *LineAnnotation:
+		if len(pdfNumbers(get("L"))) != 4 {
+			return nil, errors.New("line must have 4 coordinates")
+		}
+	case *FreeTextAnnotation:
+		a.Contents = b.contents
+		b.contents = ""
+		fontSize, fontColor := parseDefaultAppearance(text("DA"))
+		if fontSize > 0 {
+			a.FontSize = int(fontSize + 0.5)
+		}
+		if fontColor != nil {
+			a.FontColor = *fontColor
+		}
+	case *StampAnnotation:
+		if apStream != nil {
+			img, matrix, ok, err := fdfAppearanceImage(objects, apStream, b.rect)
+			if err != nil {
+				return nil, fmt.Errorf("appearance image: %w", err)
+			}
+			if ok {
+				a.setAppearanceImage(img, matrix)
+			}
+		}
+	case *TextAnnotation:
+		if popup, ok := get("Popup").(pdfDict); ok {
+			if popupRect := pdfNumbers(resolvePDFObject(objects, popup["Rect"])); len(popupRect) == 4 {
+				a.PopupRect = &Rect{Left: popupRect[0], Bottom: popupRect[1], Right: popupRect[2], Top: popupRect[3]}
+			}
+		}
+	case *UnsupportedAnnotation:
+		a.Keys = map[string]string{}
+		for key, value := range dict {
+			if _, ok := resolvePDFObject(objects, value).(*pdfStream); ok || fdfModeledKeys[key] {
+				continue
+			}
+			a.Keys[key] = formatPDFObject(objects, value)
+		}
+	}
+	return annot, nil
+}
+
+func formatPDFRect(rect Rect) string {
+	return "[" + formatFDFNumbers(rect.Left, rect.Bottom, rect.Right, rect.Top) + "]"
+}
+
+// formatPDFColor writes the color as an rgb array of /C or /IC.
+func formatPDFColor(c *Color) string {
+	return "[" + formatFDFNumbers(float32(c.R)/255, float32(c.G)/255, float32(c.B)/255) + "]"
+}
+
+// formatFDFNumbers joins the numbers with spaces.
+func formatFDFNumbers(numbers ...float32) string {
+	return strings.ReplaceAll(formatNumbers(numbers...), ",", " ")
+}
+
+func formatFDFPoints(points []Point) string {
+	numbers := make([]float32, 0, len(points)*2)
+	for _, p := range points {
+		numbers = append(numbers, p.X, p.Y)
+	}
+	return formatFDFNumbers(numbers...)
+}
+
+func formatFDFQuadPoints(quadPoints []QuadPoint) string {
+	return strings.ReplaceAll(formatQuadPoints(quadPoints), ",", " ")
+}
+
+func formatFDFLineEndings(endings [2]LineEnding) string {
+	names := make([]string, 0, 2)
+	for _, ending := range endings {
+		if ending == "" {
+			ending = LineEndingNone
+		}
+		names = append(names, formatPDFName(string(ending)))
+	}
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+func formatInts(numbers []int) string {
+	s := make([]string, 0, len(numbers))
+	for _, n := range numbers {
+		s = append(s, fmt.Sprint(n))
+	}
+	return strings.Join(s, " ")
+}
+
+// pdfNumbers returns the numbers of an array, other items are skipped.
+func pdfNumbers(obj any) []float32 {
+	array, _ := obj.([]any)
+	numbers := make([]float32, 0, len(array))
+	for _, item := range array {
+		if n, ok := item.(float64); ok {
+			numbers = append(numbers, float32(n))
+		}
+	}
+	return numbers
+}
+
+func pdfPoints(obj any) []Point {
+	numbers := pdfNumbers(obj)
+	points := make([]Point, 0, len(numbers)/2)
+	for i := 0; i+1 < len(numbers); i += 2 {
+		points = append(points, Point{X: numbers[i], Y: numbers[i+1]})
+	}
+	return points
+}
+
+func pdfQuadPoints(obj any) ([]QuadPoint, error) {
+	numbers := pdfNumbers(obj)
+	if len(numbers)%8 != 0 {
+		return nil, fmt.Errorf("quad points need 8 numbers each, got %d", len(numbers))
+	}
+	return parseQuadPoints(formatNumbers(numbers...))
+}
+
+// pdfColor reads a gray, rgb or cmyk color array, nil for other arrays.
+func pdfColor(obj any) *Color {
+	c := pdfNumbers(obj)
+	toByte := func(v float32) uint8 {
+		return uint8(min(max(v, 0), 1)*255 + 0.5)
+	}
+	switch len(c) {
+	case 1:
+		return &Color{R: toByte(c[0]), G: toByte(c[0]), B: toByte(c[0])}
+	case 3:
+		return &Color{R: toByte(c[0]), G: toByte(c[1]), B: toByte(c[2])}
+	case 4:
+		return &Color{R: toByte((1 - c[0]) * (1 - c[3])), G: toByte((1 - c[1]) * (1 - c[3])), B: toByte((1 - c[2]) * (1 - c[3]))}
+	}
+	return nil
+}
+
+func pdfLineEndings(obj any) [2]LineEnding {
+	endings := [2]LineEnding{LineEndingNone, LineEndingNone}
+	names, _ := obj.([]any)
+	for i := 0; i < len(names) && i < 2; i++ {
+		if name, ok := names[i].(pdfName); ok {
+			endings[i] = LineEnding(name)
+		}
+	}
+	return endings
+}
+
+// pageAnnotNMs returns the nms of the annotations of the page.
+func pageAnnotNMs(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int) (map[string]bool, error) {
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: pdfDoc,
+			Index:    pageNum,
+		},
+	}
+	annotCount, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+		Page: page,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nms := make(map[string]bool, annotCount.Count)
+	for i := 0; i < annotCount.Count; i++ {
+		annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+			Page:  page,
+			Index: i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		nm, err := getAnnotString(instance, annotRes.Annotation, "NM")
+		instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if nm != "" {
+			nms[nm] = true
+		}
+	}
+	return nms, nil
+}
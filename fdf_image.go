@@ -0,0 +1,223 @@
+// FDF 图章图片外观
+package annotation
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"regexp"
+	"strconv"
+
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+// fdfImageContent matches the content stream of an appearance drawing one image, see writeFDFImageAppearance.
+var fdfImageContent = regexp.MustCompile(`^\s*q\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+cm\s*/([^\s/\[\]<>()]+)\s+Do\s+Q\s*$`)
+
+// writeFDFImageAppearance writes the appearance of an image stamp as a form drawing the image by the matrix,
+// with the image in its resources, and returns its object number. A nil matrix fills the rect.
+func writeFDFImageAppearance(w *fdfWriter, rect Rect, img image.Image, matrix *structs.FPDF_FS_MATRIX) (int, error) {
+	imgNum, err := writeFDFImage(w, img)
+	if err != nil {
+		return 0, err
+	}
+	m := structs.FPDF_FS_MATRIX{A: rect.Right - rect.Left, D: rect.Top - rect.Bottom, E: rect.Left, F: rect.Bottom}
+	if matrix != nil {
+		m = *matrix
+	}
+	content := fmt.Sprintf("q %s cm /Im0 Do Q", formatFDFNumbers(m.A, m.B, m.C, m.D, m.E, m.F))
+	return w.add(fmt.Sprintf("<</Type /XObject /Subtype /Form /BBox %s /Resources <</XObject <</Im0 %d 0 R>>>> /Length %d>>\nstream\n%s\nendstream",
+		formatPDFRect(rect), imgNum, len(content), content)), nil
+}
+
+// writeFDFImage writes the image as an 8 bit rgb image, with a soft mask when it has transparent pixels.
+func writeFDFImage(w *fdfWriter, img image.Image) (int, error) {
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	alpha := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			opaque = opaque && c.A == 255
+		}
+	}
+
+	var smask string
+	if !opaque {
+		data, err := flateData(alpha)
+		if err != nil {
+			return 0, err
+		}
+		num := w.add(fmt.Sprintf("<</Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /Length %d>>\nstream\n%s\nendstream",
+			bounds.Dx(), bounds.Dy(), len(data), data))
+		smask = fmt.Sprintf(" /SMask %d 0 R", num)
+	}
+	data, err := flateData(rgb)
+	if err != nil {
+		return 0, err
+	}
+	return w.add(fmt.Sprintf("<</Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8%s /Filter /FlateDecode /Length %d>>\nstream\n%s\nendstream",
+		bounds.Dx(), bounds.Dy(), smask, len(data), data)), nil
+}
+
+func flateData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fdfAppearanceImage reads the image of an appearance drawing one image, and the matrix placing it on the page.
+// ok is false for other appearances.
+func fdfAppearanceImage(objects map[int]any, ap *pdfStream, rect Rect) (img image.Image, matrix *structs.FPDF_FS_MATRIX, ok bool, err error) {
+	content, err := decodePDFStream(ap)
+	if err != nil {
+		return nil, nil, false, nil
+	}
+	match := fdfImageContent.FindSubmatch(content)
+	if match == nil {
+		return nil, nil, false, nil
+	}
+	var numbers [6]float32
+	for i := range numbers {
+		n, err := strconv.ParseFloat(string(match[i+1]), 32)
+		if err != nil {
+			return nil, nil, false, nil
+		}
+		numbers[i] = float32(n)
+	}
+	resources, _ := resolvePDFObject(objects, ap.dict["Resources"]).(pdfDict)
+	xobjects, _ := resolvePDFObject(objects, resources["XObject"]).(pdfDict)
+	imgStream, _ := resolvePDFObject(objects, xobjects[string(match[7])]).(*pdfStream)
+	if imgStream == nil || imgStream.dict["Subtype"] != pdfName("Image") {
+		return nil, nil, false, nil
+	}
+	img, err = decodePDFImage(objects, imgStream)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	// the form space is mapped to the rect by /Matrix and the box around the transformed /BBox
+	m := structs.FPDF_FS_MATRIX{A: numbers[0], B: numbers[1], C: numbers[2], D: numbers[3], E: numbers[4], F: numbers[5]}
+	formMatrix := structs.FPDF_FS_MATRIX{A: 1, D: 1}
+	if n := pdfNumbers(resolvePDFObject(objects, ap.dict["Matrix"])); len(n) == 6 {
+		formMatrix = structs.FPDF_FS_MATRIX{A: n[0], B: n[1], C: n[2], D: n[3], E: n[4], F: n[5]}
+	}
+	bbox := rect
+	if n := pdfNumbers(resolvePDFObject(objects, ap.dict["BBox"])); len(n) == 4 {
+		bbox = Rect{Left: n[0], Bottom: n[1], Right: n[2], Top: n[3]}
+	}
+	corners := rectQuadPoint(bbox).points()
+	for i, p := range corners {
+		corners[i] = transformPoint(formMatrix, p)
+	}
+	bounds := verticesRect(corners, 0)
+	if IsZeroEpsilon(bounds.Right-bounds.Left) || IsZeroEpsilon(bounds.Top-bounds.Bottom) {
+		return nil, nil, false, nil
+	}
+	sx := (rect.Right - rect.Left) / (bounds.Right - bounds.Left)
+	sy := (rect.Top - rect.Bottom) / (bounds.Top - bounds.Bottom)
+	toRect := structs.FPDF_FS_MATRIX{A: sx, D: sy, E: rect.Left - bounds.Left*sx, F: rect.Bottom - bounds.Bottom*sy}
+	m = concatMatrix(concatMatrix(m, formMatrix), toRect)
+	return img, &m, true, nil
+}
+
+// concatMatrix returns the matrix applying m, then n.
+func concatMatrix(m, n structs.FPDF_FS_MATRIX) structs.FPDF_FS_MATRIX {
+	return structs.FPDF_FS_MATRIX{
+		A: m.A*n.A + m.B*n.C,
+		B: m.A*n.B + m.B*n.D,
+		C: m.C*n.A + m.D*n.C,
+		D: m.C*n.B + m.D*n.D,
+		E: m.E*n.A + m.F*n.C + n.E,
+		F: m.E*n.B + m.F*n.D + n.F,
+	}
+}
+
+// decodePDFImage decodes an 8 bit gray or rgb image, flate encoded or not, or a jpeg, with its soft mask.
+func decodePDFImage(objects map[int]any, stream *pdfStream) (image.Image, error) {
+	var img image.Image
+	filter := resolvePDFObject(objects, stream.dict["Filter"])
+	if filters, ok := filter.([]any); ok && len(filters) == 1 {
+		filter = filters[0]
+	}
+	if filter == pdfName("DCTDecode") {
+		var err error
+		img, err = jpeg.Decode(bytes.NewReader(stream.data))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := decodePDFStream(stream)
+		if err != nil {
+			return nil, err
+		}
+		width, _ := resolvePDFObject(objects, stream.dict["Width"]).(float64)
+		height, _ := resolvePDFObject(objects, stream.dict["Height"]).(float64)
+		bpc, _ := resolvePDFObject(objects, stream.dict["BitsPerComponent"]).(float64)
+		if width < 1 || height < 1 || bpc != 8 {
+			return nil, fmt.Errorf("image of %vx%v with %v bits per component not supported", width, height, bpc)
+		}
+		var components int
+		switch resolvePDFObject(objects, stream.dict["ColorSpace"]) {
+		case pdfName("DeviceGray"):
+			components = 1
+		case pdfName("DeviceRGB"):
+			components = 3
+		default:
+			return nil, fmt.Errorf("image color space %v not supported", stream.dict["ColorSpace"])
+		}
+		w, h := int(width), int(height)
+		if len(data) < w*h*components {
+			return nil, errors.New("image data is too short")
+		}
+		if components == 1 {
+			gray := image.NewGray(image.Rect(0, 0, w, h))
+			copy(gray.Pix, data)
+			img = gray
+		} else {
+			rgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+			for i := 0; i < w*h; i++ {
+				copy(rgba.Pix[i*4:], data[i*3:i*3+3])
+				rgba.Pix[i*4+3] = 255
+			}
+			img = rgba
+		}
+	}
+
+	smask, ok := resolvePDFObject(objects, stream.dict["SMask"]).(*pdfStream)
+	if !ok {
+		return img, nil
+	}
+	mask, err := decodePDFImage(objects, smask)
+	if err != nil {
+		return nil, fmt.Errorf("smask: %w", err)
+	}
+	bounds := img.Bounds()
+	if mask.Bounds().Size() != bounds.Size() {
+		return nil, errors.New("smask size differs from the image")
+	}
+	masked := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			c.A = color.GrayModel.Convert(mask.At(mask.Bounds().Min.X+x, mask.Bounds().Min.Y+y)).(color.Gray).Y
+			masked.SetNRGBA(x, y, c)
+		}
+	}
+	return masked, nil
+}
@@ -0,0 +1,410 @@
+// 标注拍平
+package annotation
+
+import (
+	"errors"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
+)
+
+type FlattenType int
+
+const (
+	FlattenByNM      FlattenType = 1 // flatten by unique name
+	FlattenBySubtype FlattenType = 2 // flatten Annot of given subtypes (every page)
+	FlattenByPage    FlattenType = 3 // flatten all Annot in given pages
+	FlattenAll       FlattenType = 4 // flatten all Annot (every page)
+)
+
+type FlattenOnePageAnnot struct {
+	PageNumber int      // page num, start from 0
+	AnnotNMs   []string // required when FlattenType is FlattenByNM
+}
+
+type FlattenAnnot struct {
+	FlattenType         FlattenType
+	FlattenOnePageAnnot []FlattenOnePageAnnot           // required when FlattenType is FlattenByNM or FlattenByPage
+	Subtypes            []enums.FPDF_ANNOTATION_SUBTYPE // required when FlattenType is FlattenBySubtype
+	// ForPrint draws the annotations with the print flag, as a printer would,
+	// instead of the annotations shown on screen.
+	ForPrint bool
+	// KeepComments returns the flattened annotations and their replies, to export them
+	// with MarshalAnnotationsJSON or MarshalXFDF before they are gone from the pdf.
+	KeepComments bool
+}
+
+type FlattenResult struct {
+	Flattened int               // number of annotations burnt into the pages
+	Comments  []AddOnePageAnnot // only when FlattenAnnot.KeepComments is set
+}
+
+// annotLinks are the indices of the annotations an annotation points to, -1 when absent.
+type annotLinks struct {
+	popup  int // /Popup of a markup annotation
+	irt    int // /IRT of a reply
+	parent int // /Parent of a popup
+}
+
+// FlattenAnnotInPDF draws the normal appearance of the selected annotations into the page content,
+// then removes them with their popups and replies.
+// Popups and form widgets are never flattened, annotations without a normal appearance are left on the page.
+// ps: pdfium flattens a whole page, the selected annotations are flattened on a copy of the page
+// and placed back as a form object.
+func FlattenAnnotInPDF(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, flatten FlattenAnnot) (FlattenResult, error) {
+	var res FlattenResult
+
+	pageCount, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+		Document: pdfDoc,
+	})
+	if err != nil {
+		return res, err
+	}
+
+	// page num -> selector of the annotations to flatten
+	selectors := map[int]func(Annotation) bool{}
+	var pageNums []int
+	all := func(Annotation) bool { return true }
+	switch flatten.FlattenType {
+	case FlattenByNM:
+		for _, item := range flatten.FlattenOnePageAnnot {
+			nms := make(map[string]bool, len(item.AnnotNMs))
+			for _, nm := range item.AnnotNMs {
+				nms[nm] = true
+			}
+			selectors[item.PageNumber] = func(annot Annotation) bool { return nms[annot.GetNM()] }
+			pageNums = append(pageNums, item.PageNumber)
+		}
+	case FlattenBySubtype:
+		subtypes := make(map[enums.FPDF_ANNOTATION_SUBTYPE]bool, len(flatten.Subtypes))
+		for _, subtype := range flatten.Subtypes {
+			subtypes[subtype] = true
+		}
+		for i := 0; i < pageCount.PageCount; i++ {
+			selectors[i] = func(annot Annotation) bool { return subtypes[annot.GetSubtype()] }
+			pageNums = append(pageNums, i)
+		}
+	case FlattenByPage:
+		for _, item := range flatten.FlattenOnePageAnnot {
+			selectors[item.PageNumber] = all
+			pageNums = append(pageNums, item.PageNumber)
+		}
+	case FlattenAll:
+		for i := 0; i < pageCount.PageCount; i++ {
+			selectors[i] = all
+			pageNums = append(pageNums, i)
+		}
+	default:
+		return res, errors.New("invalid flatten type")
+	}
+
+	usage := requests.FPDFPage_FlattenUsageNormalDisplay
+	if flatten.ForPrint {
+		usage = requests.FPDFPage_FlattenUsagePrint
+	}
+	for _, pageNum := range pageNums {
+		if pageNum < 0 || pageNum >= pageCount.PageCount {
+			return res, errors.New("page number out of range")
+		}
+		flattened, comments, err := flattenPage(instance, pdfDoc, pageNum, selectors[pageNum], usage)
+		if err != nil {
+			return res, err
+		}
+		res.Flattened += flattened
+		if flatten.KeepComments {
+			for _, annot := range comments {
+				res.Comments = appendPageAnnot(res.Comments, pageNum, annot)
+			}
+		}
+	}
+	return res, nil
+}
+
+// flattenPage flattens the selected annotations of the page.
+// res: the number of flattened annotations, the flattened annotations and their replies
+func flattenPage(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int, selector func(Annotation) bool, usage requests.FPDFPage_FlattenUsage) (int, []Annotation, error) {
+	// step1. select the annotations, the loaded annotations are in page order
+	annots, err := LoadAnnotationsInPage(instance, pdfDoc, pageNum)
+	if err != nil {
+		return 0, nil, err
+	}
+	selected := map[int]bool{}
+	for i, annot := range annots {
+		if isExportable(annot) && annot.(annotationBase).base().ap != "" && selector(annot) {
+			selected[i] = true
+		}
+	}
+	if len(selected) == 0 {
+		return 0, nil, nil
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: pdfDoc,
+			Index:    pageNum,
+		},
+	}
+
+	// step2. the replies of the selected annotations and the popups of both are removed with them
+	links, err := loadAnnotLinks(instance, page, len(annots))
+	if err != nil {
+		return 0, nil, err
+	}
+	removed := make(map[int]bool, len(selected))
+	for i := range selected {
+		removed[i] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, l := range links {
+			if !removed[i] && l.irt >= 0 && removed[l.irt] {
+				removed[i] = true
+				changed = true
+			}
+		}
+	}
+	for i, l := range links {
+		if removed[i] && l.popup >= 0 {
+			removed[l.popup] = true
+		}
+		if l.parent >= 0 && removed[l.parent] {
+			removed[i] = true
+		}
+	}
+
+	// step3. burn the appearances into the page content
+	err = flattenIntoPage(instance, pdfDoc, page, pageNum, selected, usage)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// step4. remove the annotations, from the last one so the indices stay valid
+	var comments []Annotation
+	for i := len(annots) - 1; i >= 0; i-- {
+		if !removed[i] {
+			continue
+		}
+		_, err = instance.FPDFPage_RemoveAnnot(&requests.FPDFPage_RemoveAnnot{
+			Page:  page,
+			Index: i,
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+		if isExportable(annots[i]) {
+			comments = append([]Annotation{annots[i]}, comments...)
+		}
+	}
+
+	return len(selected), comments, nil
+}
+
+// loadAnnotLinks reads the links between the annotations of the page, and the ones stashed until SavePDF writes them.
+func loadAnnotLinks(instance pdfium.Pdfium, page requests.Page, count int) ([]annotLinks, error) {
+	links := make([]annotLinks, count)
+	nms := make(map[string]int, count)
+	stashRefs := make([]pdfDict, count)
+	for i := range links {
+		annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+			Page:  page,
+			Index: i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		l := annotLinks{popup: -1, irt: -1, parent: -1}
+		for key, index := range map[string]*int{"Popup": &l.popup, "IRT": &l.irt, "Parent": &l.parent} {
+			*index, err = linkedAnnotIndex(instance, page, annotRes.Annotation, key)
+			if err != nil {
+				break
+			}
+		}
+		var nm string
+		var stash pdfDict
+		if err == nil {
+			nm, err = getAnnotString(instance, annotRes.Annotation, "NM")
+		}
+		if err == nil {
+			stash, err = loadStash(instance, annotRes.Annotation)
+		}
+		instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			return nil, err
+		}
+		links[i] = l
+		if nm != "" {
+			nms[nm] = i
+		}
+		stashRefs[i], _ = stash[stashRefsKey].(pdfDict)
+	}
+
+	for i, refs := range stashRefs {
+		l := &links[i]
+		for key, index := range map[string]*int{"Popup": &l.popup, "IRT": &l.irt, "Parent": &l.parent} {
+			nm, ok := refs[key].(string)
+			if j, found := nms[nm]; ok && found && *index == -1 {
+				*index = j
+			}
+		}
+	}
+	return links, nil
+}
+
+// linkedAnnotIndex returns the index in the page of the annotation the key points to, -1 when absent.
+func linkedAnnotIndex(instance pdfium.Pdfium, page requests.Page, annotRef references.FPDF_ANNOTATION, key string) (int, error) {
+	has, err := hasAnnotKey(instance, annotRef, key)
+	if err != nil || !has {
+		return -1, err
+	}
+	linkedRes, err := instance.FPDFAnnot_GetLinkedAnnot(&requests.FPDFAnnot_GetLinkedAnnot{
+		Annotation: annotRef,
+		Key:        key,
+	})
+	if err != nil {
+		// not an annotation dictionary
+		return -1, nil
+	}
+	defer instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: linkedRes.LinkedAnnotation,
+	})
+	indexRes, err := instance.FPDFPage_GetAnnotIndex(&requests.FPDFPage_GetAnnotIndex{
+		Page:       page,
+		Annotation: linkedRes.LinkedAnnotation,
+	})
+	if err != nil {
+		// on another page
+		return -1, nil
+	}
+	return indexRes.Index, nil
+}
+
+// flattenIntoPage flattens the selected annotations on a copy of the page without its content,
+// then adds the copy to the page as a form object.
+func flattenIntoPage(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, page requests.Page, pageNum int, selected map[int]bool, usage requests.FPDFPage_FlattenUsage) error {
+	scratch, err := instance.FPDF_CreateNewDocument(&requests.FPDF_CreateNewDocument{})
+	if err != nil {
+		return err
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: scratch.Document,
+	})
+
+	_, err = instance.FPDF_ImportPagesByIndex(&requests.FPDF_ImportPagesByIndex{
+		Source:      pdfDoc,
+		Destination: scratch.Document,
+		PageIndices: []int{pageNum},
+		Index:       0,
+	})
+	if err != nil {
+		return err
+	}
+	scratchPage := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: scratch.Document,
+			Index:    0,
+		},
+	}
+
+	// keep only the selected annotations
+	annotCount, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+		Page: scratchPage,
+	})
+	if err != nil {
+		return err
+	}
+	for i := annotCount.Count - 1; i >= 0; i-- {
+		if selected[i] {
+			continue
+		}
+		_, err = instance.FPDFPage_RemoveAnnot(&requests.FPDFPage_RemoveAnnot{
+			Page:  scratchPage,
+			Index: i,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// drop the content, the page already has it
+	countRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+		Page: scratchPage,
+	})
+	if err != nil {
+		return err
+	}
+	for i := countRes.Count - 1; i >= 0; i-- {
+		objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+			Page:  scratchPage,
+			Index: i,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = instance.FPDFPage_RemoveObject(&requests.FPDFPage_RemoveObject{
+			Page:       scratchPage,
+			PageObject: objRes.PageObject,
+		})
+		if err != nil {
+			return err
+		}
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: objRes.PageObject,
+		})
+	}
+	_, err = instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
+		Page: scratchPage,
+	})
+	if err != nil {
+		return err
+	}
+
+	flattenRes, err := instance.FPDFPage_Flatten(&requests.FPDFPage_Flatten{
+		Page:  scratchPage,
+		Usage: usage,
+	})
+	if err != nil {
+		return err
+	}
+	switch flattenRes.Result {
+	case responses.FPDFPage_FlattenResultFail:
+		return errors.New("flatten page failed")
+	case responses.FPDFPage_FlattenResultNothingToDo:
+		// hidden annotations, or not printed ones when flattening for print
+		return nil
+	}
+
+	// the form object has the coordinates of the page, it is placed as is
+	xobjectRes, err := instance.FPDF_NewXObjectFromPage(&requests.FPDF_NewXObjectFromPage{
+		Source:          scratch.Document,
+		Destination:     pdfDoc,
+		SourcePageIndex: 0,
+	})
+	if err != nil {
+		return err
+	}
+	defer instance.FPDF_CloseXObject(&requests.FPDF_CloseXObject{
+		XObject: xobjectRes.XObject,
+	})
+	formRes, err := instance.FPDF_NewFormObjectFromXObject(&requests.FPDF_NewFormObjectFromXObject{
+		XObject: xobjectRes.XObject,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = instance.FPDFPage_InsertObject(&requests.FPDFPage_InsertObject{
+		Page:       page,
+		PageObject: formRes.PageObject,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
+		Page: page,
+	})
+	return err
+}
@@ -0,0 +1,307 @@
+package annotation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+const (
+	DefaultFontName = "Helvetica"
+)
+
+// standardFontResourceNames are the resource names Acrobat uses for the standard 14 fonts in /DA.
+var standardFontResourceNames = map[string]string{
+	"Helvetica":             "Helv",
+	"Helvetica-Bold":        "HeBo",
+	"Helvetica-Oblique":     "HeOb",
+	"Helvetica-BoldOblique": "HeBO",
+	"Times-Roman":           "TiRo",
+	"Times-Bold":            "TiBo",
+	"Times-Italic":          "TiIt",
+	"Times-BoldItalic":      "TiBI",
+	"Courier":               "Cour",
+	"Courier-Bold":          "CoBo",
+	"Courier-Oblique":       "CoOb",
+	"Courier-BoldOblique":   "CoBO",
+	"Symbol":                "Symb",
+	"ZapfDingbats":          "ZaDb",
+}
+
+// Font is a font loaded into a document by pdfium, used to measure and draw text of annotations.
+//
+// FPDFAnnot_SetAP can not add font resources to an appearance stream, so free texts and
+// line captions append text objects in the font, pdfium puts the font in the page resources
+// and SavePDF copies them to the appearance streams. Free texts pdfium can not append text
+// objects to draw the glyph outlines of the font, they need no font in the /Resources.
+type Font struct {
+	instance pdfium.Pdfium
+	document references.FPDF_DOCUMENT
+	font     references.FPDF_FONT
+	name     string // resource name used in /DA
+
+	glyphs map[rune]*glyph
+}
+
+// glyph is the outline of a glyph, in a 1 unit em.
+type glyph struct {
+	ops []glyphOp
+}
+
+type glyphOp struct {
+	op     string // m, l, c, h
+	points []Point
+}
+
+// LoadStandardFont loads one of the standard 14 fonts, e.g. "Helvetica" or "Times-Bold", into the document.
+func LoadStandardFont(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, name string) (*Font, error) {
+	resourceName, ok := standardFontResourceNames[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a standard font", name)
+	}
+
+	fontRes, err := instance.FPDFText_LoadStandardFont(&requests.FPDFText_LoadStandardFont{
+		Document: document,
+		Font:     name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Font{
+		instance: instance,
+		document: document,
+		font:     fontRes.Font,
+		name:     resourceName,
+		glyphs:   make(map[rune]*glyph),
+	}, nil
+}
+
+// LoadFontFile loads a TrueType or OpenType font file into the document, see LoadFontData.
+func LoadFontFile(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, filePath string) (*Font, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return LoadFontData(instance, document, data)
+}
+
+// LoadFontData embeds a TrueType, OpenType or Type1 font into the document.
+// TrueType and OpenType fonts are loaded as CID fonts, so they show any unicode text their glyphs cover, e.g. CJK text.
+// ps: pdfium embeds the whole font program, it can not subset it. A CJK font adds its whole size to the saved
+// file, often more than 10 MB, so load it once and share it between the annotations of the document. Text objects
+// reference the font, it must be loaded into the annotated document. SavePDF registers the font of free texts in /DR.
+func LoadFontData(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, data []byte) (*Font, error) {
+	fontType := enums.FPDF_FONT_TRUETYPE
+	cid := true
+	if bytes.HasPrefix(data, []byte("%!")) || bytes.HasPrefix(data, []byte{0x80, 0x01}) {
+		fontType = enums.FPDF_FONT_TYPE1
+		cid = false
+	}
+
+	fontRes, err := instance.FPDFText_LoadFont(&requests.FPDFText_LoadFont{
+		Document: document,
+		Data:     data,
+		FontType: fontType,
+		CID:      cid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	font := &Font{
+		instance: instance,
+		document: document,
+		font:     fontRes.Font,
+		glyphs:   make(map[rune]*glyph),
+	}
+
+	// the base font name is used as the resource name in /DA
+	nameRes, err := instance.FPDFFont_GetBaseFontName(&requests.FPDFFont_GetBaseFontName{
+		Font: fontRes.Font,
+	})
+	if err == nil {
+		font.name = strings.Map(func(r rune) rune {
+			if r > unicode.MaxASCII || strings.ContainsRune(" ()<>[]{}/%#", r) {
+				return -1
+			}
+			return r
+		}, nameRes.BaseFontName)
+	}
+	if font.name == "" {
+		font.name = "F0"
+	}
+
+	return font, nil
+}
+
+// Close releases the font handle, the font stays in the document.
+func (f *Font) Close() error {
+	_, err := f.instance.FPDFFont_Close(&requests.FPDFFont_Close{
+		Font: f.font,
+	})
+	return err
+}
+
+// ResourceName returns the name of the font used in /DA strings.
+func (f *Font) ResourceName() string {
+	return f.name
+}
+
+// Ascent returns the distance above the baseline reached by the glyphs at the font size.
+func (f *Font) Ascent(fontSize float32) (float32, error) {
+	res, err := f.instance.FPDFFont_GetAscent(&requests.FPDFFont_GetAscent{
+		Font:     f.font,
+		FontSize: fontSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.Ascent, nil
+}
+
+// Descent returns the distance below the baseline reached by the glyphs at the font size, it is negative.
+func (f *Font) Descent(fontSize float32) (float32, error) {
+	res, err := f.instance.FPDFFont_GetDescent(&requests.FPDFFont_GetDescent{
+		Font:     f.font,
+		FontSize: fontSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.Descent, nil
+}
+
+// TextWidth returns the width of text at the font size.
+func (f *Font) TextWidth(text string, fontSize float32) (float32, error) {
+	var width float32
+	for _, r := range text {
+		res, err := f.instance.FPDFFont_GetGlyphWidth(&requests.FPDFFont_GetGlyphWidth{
+			Font:     f.font,
+			Glyph:    uint32(r),
+			FontSize: fontSize,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("get width of %q failed: %w", r, err)
+		}
+		width += res.GlyphWidth
+	}
+	return width, nil
+}
+
+// TextAP returns the appearance stream drawing text with its baseline starting at x, y.
+// Only the path construction is returned, the caller sets the color and fills it with "f".
+func (f *Font) TextAP(text string, fontSize, x, y float32) (string, error) {
+	var ap strings.Builder
+	for _, r := range text {
+		g, err := f.loadGlyph(r)
+		if err != nil {
+			return "", err
+		}
+		for _, op := range g.ops {
+			for _, p := range op.points {
+				fmt.Fprintf(&ap, "%.3f %.3f ", x+p.X*fontSize, y+p.Y*fontSize)
+			}
+			ap.WriteString(op.op)
+			ap.WriteString("\n")
+		}
+
+		width, err := f.TextWidth(string(r), fontSize)
+		if err != nil {
+			return "", err
+		}
+		x += width
+	}
+	return ap.String(), nil
+}
+
+// loadGlyph returns the outline of r, blank glyphs like spaces have no ops.
+func (f *Font) loadGlyph(r rune) (*glyph, error) {
+	if g, ok := f.glyphs[r]; ok {
+		return g, nil
+	}
+
+	g := &glyph{}
+	f.glyphs[r] = g
+	if r == ' ' || r == '\t' || r == 0x3000 {
+		return g, nil
+	}
+
+	pathRes, err := f.instance.FPDFFont_GetGlyphPath(&requests.FPDFFont_GetGlyphPath{
+		Font:     f.font,
+		Glyph:    uint32(r),
+		FontSize: 1,
+	})
+	if err != nil {
+		// the font has no glyph for r, draw nothing like a viewer would
+		return g, nil
+	}
+
+	countRes, err := f.instance.FPDFGlyphPath_CountGlyphSegments(&requests.FPDFGlyphPath_CountGlyphSegments{
+		GlyphPath: pathRes.GlyphPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// bezier curves come as three segments: two control points and the end point
+	var bezier []Point
+	for i := 0; i < countRes.Count; i++ {
+		segmentRes, err := f.instance.FPDFGlyphPath_GetGlyphPathSegment(&requests.FPDFGlyphPath_GetGlyphPathSegment{
+			GlyphPath: pathRes.GlyphPath,
+			Index:     i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		segment := segmentRes.GlyphPathSegment
+
+		typeRes, err := f.instance.FPDFPathSegment_GetType(&requests.FPDFPathSegment_GetType{
+			PathSegment: segment,
+		})
+		if err != nil {
+			return nil, err
+		}
+		pointRes, err := f.instance.FPDFPathSegment_GetPoint(&requests.FPDFPathSegment_GetPoint{
+			PathSegment: segment,
+		})
+		if err != nil {
+			return nil, err
+		}
+		closeRes, err := f.instance.FPDFPathSegment_GetClose(&requests.FPDFPathSegment_GetClose{
+			PathSegment: segment,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		point := Point{X: pointRes.X, Y: pointRes.Y}
+		switch typeRes.Type {
+		case enums.FPDF_SEGMENT_MOVETO:
+			g.ops = append(g.ops, glyphOp{op: "m", points: []Point{point}})
+		case enums.FPDF_SEGMENT_LINETO:
+			g.ops = append(g.ops, glyphOp{op: "l", points: []Point{point}})
+		case enums.FPDF_SEGMENT_BEZIERTO:
+			bezier = append(bezier, point)
+			if len(bezier) == 3 {
+				g.ops = append(g.ops, glyphOp{op: "c", points: bezier})
+				bezier = nil
+			}
+		default:
+			return nil, errors.New("unknown glyph path segment")
+		}
+		if closeRes.IsClose {
+			g.ops = append(g.ops, glyphOp{op: "h"})
+		}
+	}
+
+	return g, nil
+}
@@ -3,23 +3,50 @@ package annotation
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"unicode"
 
 	"github.com/klippa-app/go-pdfium"
 	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
 	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
 )
 
 var (
 	DefaultFontSize  = 12
 	DefaultFontColor = Color{R: 0, G: 0, B: 0}
+	DefaultPadding   = float32(2)
 )
 
+// TextAlignment is the horizontal alignment of the text, the values are the ones of /Q.
+type TextAlignment int
+
+const (
+	TextAlignLeft   TextAlignment = 0
+	TextAlignCenter TextAlignment = 1
+	TextAlignRight  TextAlignment = 2
+)
+
+// FreeTextAnnotation draws its text inside the rect, wrapped by words (or by characters
+// for CJK text). The lines below the rect are not drawn.
+// The background is the fill color and the border is drawn with the strike color and width.
+// AddAnnotationToPage draws the background from the fields and appends the text as text objects,
+// a loaded or custom appearance is replaced.
+// ps: pdfium can only append text objects to stamps, free texts are added as stamps and SavePDF writes
+// their /Subtype, /Q and the font of /DA in /DR. Free texts of a saved document are no stamps anymore,
+// UpdateAnnotByNM draws the glyph outlines of their text.
 type FreeTextAnnotation struct {
 	BaseAnnotation
+	BorderStyle
 	Contents  string
 	FontColor Color
 	FontSize  int
+	Font      *Font // nil means Helvetica, loaded when the annotation is added
+	Alignment TextAlignment
+	Padding   float32 // space between the border and the text
 }
 
 func NewFreeTextAnnotation() *FreeTextAnnotation {
@@ -31,9 +58,20 @@ func NewFreeTextAnnotation() *FreeTextAnnotation {
 		},
 		FontSize:  DefaultFontSize,
 		FontColor: DefaultFontColor,
+		Padding:   DefaultPadding,
 	}
 }
 
+// SetContents sets the text of the free text annotation.
+func (f *FreeTextAnnotation) SetContents(contents string) {
+	f.Contents = contents
+}
+
+// GetContents returns the text of the free text annotation.
+func (f *FreeTextAnnotation) GetContents() string {
+	return f.Contents
+}
+
 func (f *FreeTextAnnotation) SetFontColor(color Color) {
 	f.FontColor = color
 }
@@ -42,20 +80,365 @@ func (f *FreeTextAnnotation) SetFontSize(size int) {
 	f.FontSize = size
 }
 
+// SetFont sets the font of the text, the font must stay open until the annotation is added.
+func (f *FreeTextAnnotation) SetFont(font *Font) {
+	f.Font = font
+}
+
+func (f *FreeTextAnnotation) SetAlignment(alignment TextAlignment) {
+	f.Alignment = alignment
+}
+
+func (f *FreeTextAnnotation) SetPadding(padding float32) {
+	f.Padding = padding
+}
+
+// SetFillColor sets the background color.
+func (f *FreeTextAnnotation) SetFillColor(c Color) {
+	f.fillColor = &c
+}
+
+// GetDefaultAppearance returns the /DA string of the annotation.
+func (f *FreeTextAnnotation) GetDefaultAppearance() string {
+	return fmt.Sprintf("/%s %d Tf %.3f %.3f %.3f rg", f.fontResourceName(), f.FontSize,
+		float32(f.FontColor.R)/255, float32(f.FontColor.G)/255, float32(f.FontColor.B)/255)
+}
+
+// fontResourceName returns the name of the font in /DA and /DR.
+func (f *FreeTextAnnotation) fontResourceName() string {
+	if f.Font != nil {
+		return f.Font.ResourceName()
+	}
+	return standardFontResourceNames[DefaultFontName]
+}
+
+// GenerateAppearance generates the background and the border, the text is appended as text objects by AddAnnotationToPage.
 func (f *FreeTextAnnotation) GenerateAppearance() error {
-	// TODO generate freetext appearance
-	f.ap = ""
+	f.ap = strings.Join([]string{
+		f.GetPDFOpacityAP(),
+		f.backgroundCallback(),
+	}, "\n")
+	return nil
+}
+
+// generateOutlineAppearance generates the appearance with the glyph outlines of the text, for free texts pdfium
+// can not append text objects to. Helvetica is loaded into the document when no font is set.
+func (f *FreeTextAnnotation) generateOutlineAppearance(instance pdfium.Pdfium, document references.FPDF_DOCUMENT) error {
+	font := f.Font
+	if font == nil {
+		var err error
+		font, err = LoadStandardFont(instance, document, DefaultFontName)
+		if err != nil {
+			return err
+		}
+		defer font.Close()
+	}
+
+	textAP, err := f.textCallback(font)
+	if err != nil {
+		return err
+	}
+	f.ap = strings.Join([]string{
+		f.GetPDFOpacityAP(),
+		f.backgroundCallback(),
+		textAP,
+	}, "\n")
+	return nil
+}
+
+// backgroundCallback fills the rect, or the cloud of a cloudy border, with the fill color and strokes the border inside it.
+func (f *FreeTextAnnotation) backgroundCallback() string {
+	var ap string
+	if f.fillColor != nil {
+		background := fmt.Sprintf("%.3f %.3f %.3f %.3f re\n", f.rect.Left, f.rect.Bottom, f.rect.Right-f.rect.Left, f.rect.Top-f.rect.Bottom)
+		if f.isCloudy() {
+			background = f.rectBorderPathAP(f.rect, f.width)
+		}
+		ap += fmt.Sprintf("%s\n%sf\n", f.getColorAP(f.fillColor, true), background)
+	}
+	if f.strikeColor != nil && !IsZeroEpsilon(f.width) {
+		ap += fmt.Sprintf("%s\n%s\n%s\n%sS\n", f.GetWidthAP(), f.getColorAP(f.strikeColor, false),
+			f.GetDashAP(), f.rectBorderPathAP(f.rect, f.width))
+		ap += f.resetDashAP()
+		if !f.isCloudy() {
+			ap += f.bevelAP(f.Style, insetRect(f.rect, f.width))
+		}
+	}
+	return ap
+}
+
+// wrappedLine is a line of the wrapped text, its baseline starts at x, y.
+type wrappedLine struct {
+	text  string
+	x, y  float32
+	width float32
+}
+
+// fontSize returns the font size of the text, the default one when it is not set.
+func (f *FreeTextAnnotation) fontSize() float32 {
+	if f.FontSize <= 0 {
+		return float32(DefaultFontSize)
+	}
+	return float32(f.FontSize)
+}
+
+// layoutText wraps the contents in the rect inside the border and padding, and returns the lines fitting in it.
+func (f *FreeTextAnnotation) layoutText(font *Font) ([]wrappedLine, error) {
+	fontSize := f.fontSize()
+	inset := f.width + f.borderInset(f.width) + f.Padding
+	left := f.rect.Left + inset
+	right := f.rect.Right - inset
+	top := f.rect.Top - inset
+	bottom := f.rect.Bottom + inset
+	if right <= left || top <= bottom {
+		return nil, nil
+	}
+
+	wrapped, err := wrapText(font, f.Contents, fontSize, right-left)
+	if err != nil {
+		return nil, err
+	}
+	ascent, err := font.Ascent(fontSize)
+	if err != nil {
+		return nil, err
+	}
+	descent, err := font.Descent(fontSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []wrappedLine
+	y := top - ascent
+	for _, text := range wrapped {
+		// the remaining lines are below the rect
+		if y+descent < bottom {
+			break
+		}
+
+		width, err := font.TextWidth(text, fontSize)
+		if err != nil {
+			return nil, err
+		}
+		x := left
+		switch f.Alignment {
+		case TextAlignCenter:
+			x = left + (right-left-width)/2
+		case TextAlignRight:
+			x = right - width
+		}
+		lines = append(lines, wrappedLine{text: text, x: x, y: y, width: width})
+		y -= ascent - descent
+	}
+	return lines, nil
+}
+
+// textCallback draws the glyph outlines of the wrapped lines.
+func (f *FreeTextAnnotation) textCallback(font *Font) (string, error) {
+	lines, err := f.layoutText(font)
+	if err != nil {
+		return "", err
+	}
+
+	var ap strings.Builder
+	ap.WriteString(f.getColorAP(&f.FontColor, true))
+	ap.WriteString("\n")
+	for _, line := range lines {
+		lineAP, err := font.TextAP(line.text, f.fontSize(), line.x, line.y)
+		if err != nil {
+			return "", err
+		}
+		if lineAP != "" {
+			ap.WriteString(lineAP)
+			ap.WriteString("f\n")
+		}
+	}
+	return ap.String(), nil
+}
+
+// appendAppearanceObjects appends a text object for each wrapped line, in the font of the annotation
+// or in Helvetica loaded into the document.
+func (f *FreeTextAnnotation) appendAppearanceObjects(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, annotRef references.FPDF_ANNOTATION) error {
+	if f.Contents == "" {
+		return nil
+	}
+
+	// load font, helvetica when no font is given
+	font := f.Font
+	if font == nil {
+		var err error
+		font, err = LoadStandardFont(instance, document, DefaultFontName)
+		if err != nil {
+			return err
+		}
+		defer font.Close()
+	}
+
+	lines, err := f.layoutText(font)
+	if err != nil {
+		return err
+	}
+	fontSize := f.fontSize()
+	ascent, err := font.Ascent(fontSize)
+	if err != nil {
+		return err
+	}
+	descent, err := font.Descent(fontSize)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if line.text == "" {
+			continue
+		}
+		// the text object is centered on the origin, move it onto the baseline of the line
+		layout := &textLayout{
+			fontSize: fontSize,
+			width:    line.width,
+			ascent:   ascent,
+			descent:  descent,
+			opacity:  f.opacity,
+			matrix: structs.FPDF_FS_MATRIX{
+				A: 1,
+				D: 1,
+				E: line.x + line.width/2,
+				F: line.y + (ascent+descent)/2,
+			},
+		}
+		textRef, err := createTextObject(instance, font, layout, &TextObjectParam{
+			Text:  line.text,
+			Color: f.FontColor,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = instance.FPDFAnnot_AppendObject(&requests.FPDFAnnot_AppendObject{
+			Annotation: annotRef,
+			PageObject: textRef,
+		})
+		if err != nil {
+			instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+				PageObject: textRef,
+			})
+			return err
+		}
+	}
 	return nil
 }
 
+// wrapText breaks text into lines no wider than maxWidth. Lines break at spaces,
+// before and after CJK characters, and inside words longer than a line.
+// Every word is measured once, the width of a line is the sum of the widths of its words.
+func wrapText(font *Font, text string, fontSize, maxWidth float32) ([]string, error) {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line, lineWidth := "", float32(0)
+		for _, word := range splitWords(paragraph) {
+			width, err := font.TextWidth(word, fontSize)
+			if err != nil {
+				return nil, err
+			}
+			if lineWidth+width <= maxWidth {
+				line, lineWidth = line+word, lineWidth+width
+				continue
+			}
+
+			// start a new line, spaces at the break are dropped
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, strings.TrimRight(line, " "))
+			}
+			line, lineWidth = "", 0
+			if isSpace(word) {
+				continue
+			}
+			if width <= maxWidth {
+				line, lineWidth = word, width
+				continue
+			}
+
+			// break a word longer than a line by characters
+			for _, r := range word {
+				width, err = font.TextWidth(string(r), fontSize)
+				if err != nil {
+					return nil, err
+				}
+				if lineWidth+width > maxWidth && line != "" {
+					lines = append(lines, line)
+					line, lineWidth = "", 0
+				}
+				line, lineWidth = line+string(r), lineWidth+width
+			}
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	return lines, nil
+}
+
+// splitWords splits text into words, runs of spaces and single CJK characters.
+func splitWords(text string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flush()
+			words = append(words, string(r))
+		case len(word) > 0 && unicode.IsSpace(r) != unicode.IsSpace(word[len(word)-1]):
+			flush()
+			word = append(word, r)
+		default:
+			word = append(word, r)
+		}
+	}
+	flush()
+	return words
+}
+
+func isCJK(r rune) bool {
+	// cjk symbols and punctuation, halfwidth and fullwidth forms
+	if r >= 0x3000 && r <= 0x303f || r >= 0xff00 && r <= 0xffef {
+		return true
+	}
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func isSpace(word string) bool {
+	return strings.TrimSpace(word) == ""
+}
+
 func (f *FreeTextAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
 	// set default font size and color
 	if f.FontSize == 0 {
 		f.FontSize = DefaultFontSize
 	}
 
-	// create annotation
-	err := f.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
+	// the text objects are created in the document of the font
+	var document references.FPDF_DOCUMENT
+	switch {
+	case f.Font != nil:
+		document = f.Font.document
+	case page.ByIndex != nil:
+		document = page.ByIndex.Document
+	default:
+		return f.newError(StepPreCheck, errors.New("font must be set when the page is not given by index"))
+	}
+	// the text is appended as objects below, an appearance loaded from a pdf or an import already has it
+	err := f.GenerateAppearance()
+	if err != nil {
+		return f.newError(StepPreCheck, err)
+	}
+
+	// create annotation, as a stamp to append the text objects
+	f.asStamp = true
+	f.stash = append(extraKeyEntries(f), "/"+stashDRFontKey+" "+formatPDFName(f.fontResourceName()))
+	err = f.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
 	if err != nil {
 		return err
 	}
@@ -67,18 +450,31 @@ func (f *FreeTextAnnotation) AddAnnotationToPage(ctx context.Context, instance p
 		Value:      f.Contents,
 	})
 	if err != nil {
-		return err
+		return f.abort(instance, page, StepSetContents, err)
 	}
 
-	// set font color
-	da := fmt.Sprintf("%d Tf %.3f %.3f %.3f rg ", 12, float32(f.FontColor.R)/255, float32(f.FontColor.G)/255, float32(f.FontColor.B)/255)
+	// set font and font color
 	_, err = instance.FPDFAnnot_SetStringValue(&requests.FPDFAnnot_SetStringValue{
 		Annotation: f.annot,
 		Key:        "DA",
-		Value:      da,
+		Value:      f.GetDefaultAppearance(),
 	})
 	if err != nil {
-		return err
+		return f.abort(instance, page, StepSetDA, err)
 	}
-	return nil
+
+	// append text
+	err = f.appendAppearanceObjects(instance, document, f.annot)
+	if err != nil {
+		return f.abort(instance, page, StepAppendObject, err)
+	}
+
+	// close annotation
+	_, err = instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: f.annot,
+	})
+	if err != nil {
+		return f.newError(StepClose, err)
+	}
+	return f.savePDFWarning()
 }
@@ -15,9 +15,12 @@ var (
 	DefaultHighlightColor = Color{R: 255, G: 255, B: 0} // Default color is yellow in Acrobat Reader
 )
 
+// HighlightAnnotation fills the quads with the color, set the blend mode to multiply to keep the text below dark.
+// ps: pdfium writes the ExtGState of the appearance with /BM /Normal, SavePDF writes the blend mode into it
 type HighlightAnnotation struct {
 	BaseAnnotation
 	QuadPoints []QuadPoint
+	blendMode  enums.PDF_BLEND_MODE
 }
 
 func NewHighlightAnnotation() *HighlightAnnotation {
@@ -30,10 +33,21 @@ func NewHighlightAnnotation() *HighlightAnnotation {
 	}
 }
 
+// SetBlendMode sets the blend mode of the highlight, e.g. enums.PDF_BLEND_MODE_MULTIPLY.
+func (h *HighlightAnnotation) SetBlendMode(mode enums.PDF_BLEND_MODE) {
+	h.blendMode = mode
+}
+
 func (h *HighlightAnnotation) GenerateAppearance() error {
+	// the blend mode is in /GS, applied even when the highlight is opaque
+	opacityAP := h.GetPDFOpacityAP()
+	if h.blendMode != "" {
+		opacityAP = "/GS gs"
+	}
+
 	// generate highlight appearance
 	h.ap = strings.Join([]string{
-		h.GetPDFOpacityAP(),
+		opacityAP,
 		h.GetColorAP(),
 		h.pointsCallback(),
 	}, "\n")
@@ -44,7 +58,7 @@ func (h *HighlightAnnotation) GenerateAppearance() error {
 // SetStrikeColor sets the color of the highlight annotation.
 // highlight is different from underline&strikeout
 // it should set fill color instead of stroke color
-func (h *HighlightAnnotation) SetStrikeColor(c Color)  {
+func (h *HighlightAnnotation) SetStrikeColor(c Color) {
 	h.fillColor = &c
 }
 
@@ -65,6 +79,9 @@ func (h *HighlightAnnotation) AddAnnotationToPage(ctx context.Context, instance
 		h.strikeColor = &DefaultHighlightColor
 	}
 	// create annotation
+	if h.blendMode != "" {
+		h.stash = []string{"/" + stashBlendKey + " " + formatPDFName(string(h.blendMode))}
+	}
 	err := h.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
 	if err != nil {
 		return err
@@ -78,7 +95,7 @@ func (h *HighlightAnnotation) AddAnnotationToPage(ctx context.Context, instance
 			AttachmentPoints: points,
 		})
 		if err != nil {
-			return err
+			return h.abort(instance, page, StepSetQuadPoints, err)
 		}
 	}
 
@@ -87,7 +104,7 @@ func (h *HighlightAnnotation) AddAnnotationToPage(ctx context.Context, instance
 		Annotation: h.annot,
 	})
 	if err != nil {
-		return err
+		return h.newError(StepClose, err)
 	}
 
 	return nil
@@ -13,6 +13,7 @@ import (
 type InkAnnotation struct {
 	BaseAnnotation
 	LineStyle
+	BorderStyle
 	Points [][]Point
 }
 
@@ -37,6 +38,7 @@ func (i *InkAnnotation) GenerateAppearance() error {
 		i.GetWidthAP(),
 		i.GetPDFOpacityAP(),
 		i.GetLineStyleAP(),
+		i.GetDashAP(),
 		i.pointsCallback(),
 	}, "\n")
 
@@ -59,6 +61,7 @@ func (i *InkAnnotation) pointsCallback() string {
 }
 
 func (i *InkAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
+	i.stash = extraKeyEntries(i)
 	err := i.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
 	if err != nil {
 		return err
@@ -72,7 +75,7 @@ func (i *InkAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium
 			Points:     p,
 		})
 		if err != nil {
-			return err
+			return i.abort(instance, page, StepSetInkList, err)
 		}
 	}
 
@@ -81,7 +84,7 @@ func (i *InkAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium
 		Annotation: i.annot,
 	})
 	if err != nil {
-		return err
+		return i.newError(StepClose, err)
 	}
 	return nil
 }
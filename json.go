@@ -0,0 +1,626 @@
+// JSON 格式
+package annotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+// JSONSchemaVersion is the version of the JSON schema written by this package, see the README for the schema.
+// Documents of a newer version are refused.
+const JSONSchemaVersion = 1
+
+// JSONDocument is the envelope of the annotations of a document, grouped by page.
+type JSONDocument struct {
+	Version int               `json:"version"`
+	Pages   []AddOnePageAnnot `json:"pages"`
+}
+
+// jsonAnnotation is the JSON schema of an annotation, every type uses the keys it has.
+// Coordinates are in pdf user space, colors are #RRGGBB and quads list the corners in the order of /QuadPoints.
+type jsonAnnotation struct {
+	Subtype      string     `json:"subtype"`
+	NM           string     `json:"nm,omitempty"`
+	Rect         [4]float32 `json:"rect"` // left, bottom, right, top
+	Title        string     `json:"title,omitempty"`
+	Contents     string     `json:"contents,omitempty"`
+	CreationDate *time.Time `json:"creationDate,omitempty"`
+	ModDate      *time.Time `json:"modDate,omitempty"`
+	Color        string     `json:"color,omitempty"`
+	FillColor    string     `json:"fillColor,omitempty"`
+	Opacity      *float32   `json:"opacity,omitempty"` // 0 to 1, left out when opaque
+	Width        float32    `json:"width,omitempty"`
+	Appearance   string     `json:"appearance,omitempty"` // content stream of the normal appearance, without resources
+
+	// line styles and borders
+	LineCap  *enums.FPDF_LINECAP  `json:"lineCap,omitempty"`
+	LineJoin *enums.FPDF_LINEJOIN `json:"lineJoin,omitempty"`
+	Border   *jsonBorder          `json:"border,omitempty"`
+
+	// geometry
+	QuadPoints  [][8]float32   `json:"quadPoints,omitempty"`
+	InkList     [][][2]float32 `json:"inkList,omitempty"`
+	Vertices    [][2]float32   `json:"vertices,omitempty"`
+	Line        *[2][2]float32 `json:"line,omitempty"`
+	LineEndings *[2]LineEnding `json:"lineEndings,omitempty"`
+
+	// line
+	LeaderLine          float32 `json:"leaderLine,omitempty"`
+	LeaderLineExtension float32 `json:"leaderLineExtension,omitempty"`
+	Caption             bool    `json:"caption,omitempty"`
+	CaptionFontSize     float32 `json:"captionFontSize,omitempty"`
+
+	// free text and redact
+	FontSize    float32 `json:"fontSize,omitempty"`
+	FontColor   string  `json:"fontColor,omitempty"`
+	Alignment   string  `json:"alignment,omitempty"`
+	Padding     float32 `json:"padding,omitempty"`
+	OverlayText string  `json:"overlayText,omitempty"`
+
+	// text
+	Icon      TextIcon    `json:"icon,omitempty"`
+	Open      bool        `json:"open,omitempty"`
+	PopupRect *[4]float32 `json:"popupRect,omitempty"`
+
+	// image stamp
+	Image       string      `json:"image,omitempty"`       // png data url
+	ImageMatrix *[6]float32 `json:"imageMatrix,omitempty"` // left out when the image fills the rect
+
+	// unsupported subtypes
+	Keys map[string]string `json:"keys,omitempty"`
+}
+
+type jsonBorder struct {
+	Style  string `json:"style,omitempty"`
+	Dashes []int  `json:"dashes,omitempty"`
+	Cloudy *int   `json:"cloudy,omitempty"` // intensity of a cloudy border
+}
+
+// alignments of the JSON alignment key
+var jsonAlignments = map[TextAlignment]string{
+	TextAlignLeft:   "left",
+	TextAlignCenter: "center",
+	TextAlignRight:  "right",
+}
+
+// MarshalAnnotationsJSON encodes the annotations of each page as a JSONDocument.
+func MarshalAnnotationsJSON(pageAnnots []AddOnePageAnnot) ([]byte, error) {
+	return json.Marshal(JSONDocument{
+		Version: JSONSchemaVersion,
+		Pages:   pageAnnots,
+	})
+}
+
+// UnmarshalAnnotationsJSON decodes a JSONDocument, annotations without an appearance have none, see ImportJSON.
+func UnmarshalAnnotationsJSON(data []byte) ([]AddOnePageAnnot, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Version < 1 || header.Version > JSONSchemaVersion {
+		return nil, fmt.Errorf("json schema version %d not supported", header.Version)
+	}
+
+	var doc JSONDocument
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Pages, nil
+}
+
+// UnmarshalAnnotationJSON decodes one annotation into the type of its subtype.
+func UnmarshalAnnotationJSON(data []byte) (Annotation, error) {
+	var header struct {
+		Subtype string `json:"subtype"`
+	}
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, err
+	}
+	subtype, ok := subtypeByName(header.Subtype)
+	if !ok {
+		return nil, fmt.Errorf("unknown subtype %q", header.Subtype)
+	}
+
+	annot := newAnnotationBySubtype(subtype)
+	err = json.Unmarshal(data, annot)
+	if err != nil {
+		return nil, err
+	}
+	return annot, nil
+}
+
+// ExportJSON writes the annotations of the given pages as a JSONDocument, every page when pageNums is nil.
+// Popups and widgets are skipped.
+func ExportJSON(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNums []int, w io.Writer) error {
+	pageAnnots, err := loadPageAnnots(instance, pdfDoc, pageNums)
+	if err != nil {
+		return err
+	}
+	for i := range pageAnnots {
+		annots := pageAnnots[i].Annots[:0]
+		for _, annot := range pageAnnots[i].Annots {
+			if isExportable(annot) {
+				annots = append(annots, annot)
+			}
+		}
+		pageAnnots[i].Annots = annots
+	}
+
+	data, err := MarshalAnnotationsJSON(pageAnnots)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ImportJSON adds the annotations of a JSONDocument to the pdf in one call, pages are matched by index.
+// Annotations without an appearance get a generated one, annotations whose NM is already on the page are skipped.
+func ImportJSON(ctx context.Context, instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	pageAnnots, err := UnmarshalAnnotationsJSON(data)
+	if err != nil {
+		return 0, err
+	}
+	return addImportedAnnotations(ctx, instance, pdfDoc, pageAnnots)
+}
+
+// UnmarshalJSON decodes a page of a JSONDocument, the annotations are decoded into the types of their subtypes.
+func (p *AddOnePageAnnot) UnmarshalJSON(data []byte) error {
+	var page struct {
+		PageNumber int               `json:"page"`
+		Annots     []json.RawMessage `json:"annots"`
+	}
+	err := json.Unmarshal(data, &page)
+	if err != nil {
+		return err
+	}
+
+	p.PageNumber = page.PageNumber
+	p.Annots = make([]Annotation, 0, len(page.Annots))
+	for i, raw := range page.Annots {
+		annot, err := UnmarshalAnnotationJSON(raw)
+		if err != nil {
+			return fmt.Errorf("page %d annot %d: %w", page.PageNumber, i, err)
+		}
+		p.Annots = append(p.Annots, annot)
+	}
+	return nil
+}
+
+// marshalAnnotationJSON encodes the annotation with the schema of jsonAnnotation.
+func marshalAnnotationJSON(annot Annotation) ([]byte, error) {
+	b := annot.(annotationBase).base()
+	j := jsonAnnotation{
+		Subtype:    annot.GetSubtypeName(),
+		NM:         b.nm,
+		Rect:       [4]float32{b.rect.Left, b.rect.Bottom, b.rect.Right, b.rect.Top},
+		Title:      b.title,
+		Contents:   b.contents,
+		Color:      formatHexColor(b.strikeColor),
+		FillColor:  formatHexColor(b.fillColor),
+		Width:      b.width,
+		Appearance: b.ap,
+	}
+	// the appearance of free texts needs its font resources, it is generated again on import
+	if b.subtype == enums.FPDF_ANNOT_SUBTYPE_FREETEXT {
+		j.Appearance = ""
+	}
+	if !b.creationDate.IsZero() {
+		j.CreationDate = &b.creationDate
+	}
+	if !b.modDate.IsZero() {
+		j.ModDate = &b.modDate
+	}
+	if b.opacity != DefaultOpacity {
+		opacity := float32(b.opacity) / 255
+		j.Opacity = &opacity
+	}
+
+	if s, ok := annot.(interface{ lineStyle() *LineStyle }); ok {
+		l := s.lineStyle()
+		j.LineCap, j.LineJoin = &l.StrikeLineCap, &l.StrikeLineJoin
+	}
+	if s, ok := annot.(interface{ border() *BorderStyle }); ok {
+		bs := s.border()
+		border := &jsonBorder{Style: bs.Style, Dashes: bs.DashArray}
+		if bs.isCloudy() {
+			border.Cloudy = &bs.EffectInt
+		}
+		if border.Style != "" || border.Dashes != nil || border.Cloudy != nil {
+			j.Border = border
+		}
+	}
+
+	if quadPoints, ok := markupQuadPoints(annot); ok {
+		j.QuadPoints = formatJSONQuadPoints(quadPoints)
+	}
+
+	switch a := annot.(type) {
+	case *InkAnnotation:
+		j.InkList = make([][][2]float32, 0, len(a.Points))
+		for _, stroke := range a.Points {
+			j.InkList = append(j.InkList, formatJSONPoints(stroke))
+		}
+	case *PolygonAnnotation:
+		j.Vertices = formatJSONPoints(a.Vertices)
+	case *PolylineAnnotation:
+		j.Vertices = formatJSONPoints(a.Vertices)
+		j.LineEndings = &a.LineEndings
+	case *LineAnnotation:
+		line := [2][2]float32{{a.lineTo[0].X, a.lineTo[0].Y}, {a.lineTo[1].X, a.lineTo[1].Y}}
+		j.Line = &line
+		j.LineEndings = &a.LineEndings
+		j.LeaderLine = a.LeaderLine
+		j.LeaderLineExtension = a.LeaderLineExtension
+		j.Caption = a.Caption
+		j.CaptionFontSize = a.CaptionFontSize
+	case *FreeTextAnnotation:
+		if b.contents == "" {
+			j.Contents = a.Contents
+		}
+		j.FontSize = float32(a.FontSize)
+		j.FontColor = formatHexColor(&a.FontColor)
+		j.Alignment = jsonAlignments[a.Alignment]
+		j.Padding = a.Padding
+	case *TextAnnotation:
+		j.Icon = a.Icon
+		j.Open = a.Open
+		if a.PopupRect != nil {
+			j.PopupRect = &[4]float32{a.PopupRect.Left, a.PopupRect.Bottom, a.PopupRect.Right, a.PopupRect.Top}
+		}
+	case *RedactAnnotation:
+		j.QuadPoints = formatJSONQuadPoints(a.QuadPoints)
+		j.OverlayText = a.OverlayText
+		j.FontSize = a.OverlayFontSize
+		j.FontColor = formatHexColor(&a.FontColor)
+	case *StampAnnotation:
+		img, matrix, ok, err := a.appearanceImage()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			j.Appearance = ""
+			j.Image, err = encodeImgDataURL(img)
+			if err != nil {
+				return nil, err
+			}
+			if matrix != nil {
+				j.ImageMatrix = &[6]float32{matrix.A, matrix.B, matrix.C, matrix.D, matrix.E, matrix.F}
+			}
+		}
+	case *UnsupportedAnnotation:
+		j.Keys = a.Keys
+	}
+	return json.Marshal(j)
+}
+
+// unmarshalAnnotationJSON decodes data into the annotation, the subtype of data must be the one of the annotation.
+func unmarshalAnnotationJSON(annot Annotation, data []byte) error {
+	var j jsonAnnotation
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+	b := annot.(annotationBase).base()
+
+	subtype, ok := subtypeByName(j.Subtype)
+	if !ok {
+		return fmt.Errorf("unknown subtype %q", j.Subtype)
+	}
+	if _, unsupported := annot.(*UnsupportedAnnotation); unsupported {
+		b.subtype = subtype
+	} else if subtype != b.subtype {
+		return fmt.Errorf("can not decode %s into %s annot", j.Subtype, annot.GetSubtypeName())
+	}
+
+	b.rect = Rect{Left: j.Rect[0], Bottom: j.Rect[1], Right: j.Rect[2], Top: j.Rect[3]}
+	if j.NM != "" {
+		b.nm = j.NM
+	}
+	b.title = j.Title
+	b.contents = j.Contents
+	if j.CreationDate != nil {
+		b.creationDate = *j.CreationDate
+	}
+	if j.ModDate != nil {
+		b.modDate = *j.ModDate
+	}
+	b.strikeColor, err = parseHexColor(j.Color)
+	if err != nil {
+		return err
+	}
+	b.fillColor, err = parseHexColor(j.FillColor)
+	if err != nil {
+		return err
+	}
+	if b.subtype == enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT && b.fillColor == nil {
+		b.fillColor = b.strikeColor
+	}
+	b.opacity = DefaultOpacity
+	if j.Opacity != nil {
+		b.opacity = uint8(min(max(*j.Opacity, 0), 1)*255 + 0.5)
+	}
+	b.width = j.Width
+	if b.subtype != enums.FPDF_ANNOT_SUBTYPE_FREETEXT {
+		b.ap = j.Appearance
+	}
+
+	if s, ok := annot.(interface{ lineStyle() *LineStyle }); ok {
+		l := s.lineStyle()
+		if j.LineCap != nil {
+			l.StrikeLineCap = *j.LineCap
+		}
+		if j.LineJoin != nil {
+			l.StrikeLineJoin = *j.LineJoin
+		}
+	}
+	if s, ok := annot.(interface{ border() *BorderStyle }); ok && j.Border != nil {
+		bs := s.border()
+		bs.SetBorderStyle(j.Border.Style, j.Border.Dashes...)
+		if j.Border.Cloudy != nil {
+			bs.SetCloudy(*j.Border.Cloudy)
+		}
+	}
+
+	quadPoints := parseJSONQuadPoints(j.QuadPoints)
+	switch a := annot.(type) {
+	case *HighlightAnnotation:
+		a.QuadPoints = quadPoints
+	case *UnderlineAnnotation:
+		a.QuadPoints = quadPoints
+	case *StrikeoutAnnotation:
+		a.QuadPoints = quadPoints
+	case *SquigglyAnnotation:
+		a.QuadPoints = quadPoints
+	case *InkAnnotation:
+		a.Points = make([][]Point, 0, len(j.InkList))
+		for _, stroke := range j.InkList {
+			a.Points = append(a.Points, parseJSONPoints(stroke))
+		}
+	case *PolygonAnnotation:
+		a.Vertices = parseJSONPoints(j.Vertices)
+	case *PolylineAnnotation:
+		a.Vertices = parseJSONPoints(j.Vertices)
+		if j.LineEndings != nil {
+			a.LineEndings = *j.LineEndings
+		}
+	case *LineAnnotation:
+		if j.Line == nil {
+			return errors.New("line must be set on line annot")
+		}
+		a.SetLineTo(j.Line[0][0], j.Line[0][1], j.Line[1][0], j.Line[1][1])
+		if j.LineEndings != nil {
+			a.LineEndings = *j.LineEndings
+		}
+		a.LeaderLine = j.LeaderLine
+		a.LeaderLineExtension = j.LeaderLineExtension
+		a.Caption = j.Caption
+		a.CaptionFontSize = j.CaptionFontSize
+	case *FreeTextAnnotation:
+		a.Contents = b.contents
+		b.contents = ""
+		if j.FontSize > 0 {
+			a.FontSize = int(j.FontSize + 0.5)
+		}
+		if fontColor, err := parseHexColor(j.FontColor); err != nil {
+			return err
+		} else if fontColor != nil {
+			a.FontColor = *fontColor
+		}
+		for alignment, name := range jsonAlignments {
+			if name == j.Alignment {
+				a.Alignment = alignment
+			}
+		}
+		a.Padding = j.Padding
+	case *TextAnnotation:
+		if j.Icon != "" {
+			a.Icon = j.Icon
+		}
+		a.Open = j.Open
+		if j.PopupRect != nil {
+			a.PopupRect = &Rect{Left: j.PopupRect[0], Bottom: j.PopupRect[1], Right: j.PopupRect[2], Top: j.PopupRect[3]}
+		}
+	case *RedactAnnotation:
+		a.QuadPoints = quadPoints
+		a.OverlayText = j.OverlayText
+		a.OverlayFontSize = j.FontSize
+		if fontColor, err := parseHexColor(j.FontColor); err != nil {
+			return err
+		} else if fontColor != nil {
+			a.FontColor = *fontColor
+		}
+	case *StampAnnotation:
+		if j.Image != "" {
+			img, err := decodeImgDataURL(j.Image)
+			if err != nil {
+				return fmt.Errorf("image: %w", err)
+			}
+			var matrix *structs.FPDF_FS_MATRIX
+			if m := j.ImageMatrix; m != nil {
+				matrix = &structs.FPDF_FS_MATRIX{A: m[0], B: m[1], C: m[2], D: m[3], E: m[4], F: m[5]}
+			}
+			a.setAppearanceImage(img, matrix)
+		}
+	case *UnsupportedAnnotation:
+		a.Keys = j.Keys
+	}
+	return nil
+}
+
+func formatJSONPoints(points []Point) [][2]float32 {
+	res := make([][2]float32, 0, len(points))
+	for _, p := range points {
+		res = append(res, [2]float32{p.X, p.Y})
+	}
+	return res
+}
+
+func parseJSONPoints(points [][2]float32) []Point {
+	res := make([]Point, 0, len(points))
+	for _, p := range points {
+		res = append(res, Point{X: p[0], Y: p[1]})
+	}
+	return res
+}
+
+func formatJSONQuadPoints(quadPoints []QuadPoint) [][8]float32 {
+	res := make([][8]float32, 0, len(quadPoints))
+	for _, q := range convertQuadPointToPdfiumFormat(quadPoints) {
+		res = append(res, [8]float32{q.X1, q.Y1, q.X2, q.Y2, q.X3, q.Y3, q.X4, q.Y4})
+	}
+	return res
+}
+
+func parseJSONQuadPoints(quadPoints [][8]float32) []QuadPoint {
+	if quadPoints == nil {
+		return nil
+	}
+	res := make([]QuadPoint, 0, len(quadPoints))
+	for _, n := range quadPoints {
+		res = append(res, convertQuadPointFromPdfiumFormat(structs.FPDF_FS_QUADPOINTSF{
+			X1: n[0], Y1: n[1], X2: n[2], Y2: n[3], X3: n[4], Y3: n[5], X4: n[6], Y4: n[7],
+		}))
+	}
+	return res
+}
+
+// MarshalJSON and UnmarshalJSON of every annotation type, see jsonAnnotation for the schema.
+
+func (l *LineAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(l)
+}
+
+func (l *LineAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(l, data)
+}
+
+func (s *SquareAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(s)
+}
+
+func (s *SquareAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(s, data)
+}
+
+func (c *CircleAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(c)
+}
+
+func (c *CircleAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(c, data)
+}
+
+func (i *InkAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(i)
+}
+
+func (i *InkAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(i, data)
+}
+
+func (f *FreeTextAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(f)
+}
+
+func (f *FreeTextAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(f, data)
+}
+
+func (h *HighlightAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(h)
+}
+
+func (h *HighlightAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(h, data)
+}
+
+func (u *UnderlineAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(u)
+}
+
+func (u *UnderlineAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(u, data)
+}
+
+func (s *StrikeoutAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(s)
+}
+
+func (s *StrikeoutAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(s, data)
+}
+
+func (s *SquigglyAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(s)
+}
+
+func (s *SquigglyAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(s, data)
+}
+
+func (p *PolygonAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(p)
+}
+
+func (p *PolygonAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(p, data)
+}
+
+func (p *PolylineAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(p)
+}
+
+func (p *PolylineAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(p, data)
+}
+
+func (t *TextAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(t)
+}
+
+func (t *TextAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(t, data)
+}
+
+func (s *StampAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(s)
+}
+
+func (s *StampAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(s, data)
+}
+
+func (r *RedactAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(r)
+}
+
+func (r *RedactAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(r, data)
+}
+
+func (u *UnsupportedAnnotation) MarshalJSON() ([]byte, error) {
+	return marshalAnnotationJSON(u)
+}
+
+func (u *UnsupportedAnnotation) UnmarshalJSON(data []byte) error {
+	return unmarshalAnnotationJSON(u, data)
+}
@@ -1,7 +1,11 @@
 package annotation
 
 import (
+	"fmt"
 	"math"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -17,3 +21,65 @@ var (
 func IsZeroEpsilon(f float32) bool {
 	return float32(math.Abs(float64(f))) < epsilon
 }
+
+func abs32(f float32) float32 {
+	return float32(math.Abs(float64(f)))
+}
+
+// FormatPDFDate formats t as a pdf date string, e.g. D:20240131153000+08'00'.
+func FormatPDFDate(t time.Time) string {
+	date := t.Format("D:20060102150405")
+	_, offset := t.Zone()
+	if offset == 0 {
+		return date + "Z"
+	}
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%c%02d'%02d'", date, sign, offset/3600, offset%3600/60)
+}
+
+// ParsePDFDate parses a pdf date string, every part after the year is optional.
+// A date without a time zone is taken as UTC.
+func ParsePDFDate(s string) (time.Time, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	if len(s) < 4 {
+		return time.Time{}, fmt.Errorf("invalid pdf date %q", s)
+	}
+
+	// year, month, day, hour, minute, second
+	parts := []int{0, 1, 1, 0, 0, 0}
+	sizes := []int{4, 2, 2, 2, 2, 2}
+	for i, size := range sizes {
+		if len(s) < size || s[0] < '0' || s[0] > '9' {
+			break
+		}
+		v, err := strconv.Atoi(s[:size])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid pdf date %q", s)
+		}
+		parts[i] = v
+		s = s[size:]
+	}
+
+	loc := time.UTC
+	if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		tz := strings.ReplaceAll(s[1:], "'", "")
+		var hours, minutes int
+		if len(tz) >= 2 {
+			hours, _ = strconv.Atoi(tz[:2])
+		}
+		if len(tz) >= 4 {
+			minutes, _ = strconv.Atoi(tz[2:4])
+		}
+		offset := hours*3600 + minutes*60
+		if s[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+
+	return time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, loc), nil
+}
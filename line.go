@@ -3,18 +3,32 @@ package annotation
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/klippa-app/go-pdfium"
 	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
 	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
 )
 
+// LineAnnotation is a straight line with optional line endings, leader lines and a caption showing its contents.
+// Closed line endings are filled with the fill color. When no rect is set, it is computed from the line.
+// ps: pdfium can not create lines, they are added as stamps and SavePDF writes their /Subtype, /L, /LE, /LL, /LLE and /Cap
 type LineAnnotation struct {
 	BaseAnnotation
 	LineStyle
-	lineTo [2]Point
+	BorderStyle
+	lineTo              [2]Point
+	LineEndings         [2]LineEnding // start, end
+	LeaderLine          float32       // length of the leader lines, positive goes counterclockwise from the line direction
+	LeaderLineExtension float32       // length of the leader lines beyond the line
+	Caption             bool          // show the contents as a caption above the line
+	CaptionFont         *Font         // nil means Helvetica, loaded when the annotation is added, must be loaded into the annotated document
+	CaptionFontSize     float32
 }
 
 func NewLineAnnotation() *LineAnnotation {
@@ -28,6 +42,8 @@ func NewLineAnnotation() *LineAnnotation {
 			StrikeLineCap:  enums.FPDF_LINECAP_BUTT,
 			StrikeLineJoin: enums.FPDF_LINEJOIN_MITER,
 		},
+		LineEndings:     [2]LineEnding{LineEndingNone, LineEndingNone},
+		CaptionFontSize: float32(DefaultFontSize),
 	}
 }
 
@@ -38,22 +54,114 @@ func (l *LineAnnotation) SetLineTo(beginX, beginY, endX, endY float32) {
 	}
 }
 
-// GenerateAppearance generates the appearance stream for the line annotation.
+// GetLineTo returns the start and end points of the line.
+func (l *LineAnnotation) GetLineTo() [2]Point {
+	return l.lineTo
+}
+
+// SetFillColor sets the color filling the closed line endings.
+func (l *LineAnnotation) SetFillColor(c Color) {
+	l.fillColor = &c
+}
+
+// SetLineEndings sets the shapes drawn at the start and the end of the line, their size follows the width.
+func (l *LineAnnotation) SetLineEndings(start, end LineEnding) {
+	l.LineEndings = [2]LineEnding{start, end}
+}
+
+// SetLeaderLine sets the length of the leader lines, drawn from the endpoints perpendicular to the line,
+// and their extension beyond it. A positive length moves the line counterclockwise from its direction.
+func (l *LineAnnotation) SetLeaderLine(length, extension float32) {
+	l.LeaderLine = length
+	l.LeaderLineExtension = extension
+}
+
+// SetCaption shows the contents as a caption above the line, a nil font means Helvetica.
+// The caption is a text object appended by AddAnnotationToPage, it is not part of GenerateAppearance.
+func (l *LineAnnotation) SetCaption(font *Font, fontSize float32) {
+	l.Caption = true
+	l.CaptionFont = font
+	if fontSize > 0 {
+		l.CaptionFontSize = fontSize
+	}
+}
+
+// GenerateAppearance generates the appearance stream for the line annotation, without the caption.
 func (l *LineAnnotation) GenerateAppearance() error {
+	if IsZeroEpsilon(l.rect.Left) && IsZeroEpsilon(l.rect.Bottom) &&
+		IsZeroEpsilon(l.rect.Right) && IsZeroEpsilon(l.rect.Top) {
+		l.rect = l.computeRect()
+	}
+
 	l.ap = strings.Join([]string{
 		l.GetColorAP(),
 		l.GetWidthAP(),
 		l.GetPDFOpacityAP(),
 		l.GetLineStyleAP(),
+		l.GetDashAP(),
 		l.pointsCallback(),
 	}, "\n")
 
 	return nil
 }
 
+// direction returns the unit vector from the start to the end of the line, and its length.
+func (l *LineAnnotation) direction() (ux, uy, length float32) {
+	dx, dy := l.lineTo[1].X-l.lineTo[0].X, l.lineTo[1].Y-l.lineTo[0].Y
+	length = float32(math.Hypot(float64(dx), float64(dy)))
+	if IsZeroEpsilon(length) {
+		return 0, 0, 0
+	}
+	return dx / length, dy / length, length
+}
+
+// drawnLine returns the endpoints of the line moved by the leader line length.
+func (l *LineAnnotation) drawnLine() (start, end Point) {
+	ux, uy, _ := l.direction()
+	nx, ny := -uy, ux
+	start = Point{X: l.lineTo[0].X + nx*l.LeaderLine, Y: l.lineTo[0].Y + ny*l.LeaderLine}
+	end = Point{X: l.lineTo[1].X + nx*l.LeaderLine, Y: l.lineTo[1].Y + ny*l.LeaderLine}
+	return start, end
+}
+
+// leaderLineEnd returns where the leader line from p ends, beyond the drawn line by the extension.
+func (l *LineAnnotation) leaderLineEnd(p Point) Point {
+	ux, uy, _ := l.direction()
+	nx, ny := -uy, ux
+	length := l.LeaderLine + l.LeaderLineExtension
+	if l.LeaderLine < 0 {
+		length = l.LeaderLine - l.LeaderLineExtension
+	}
+	return Point{X: p.X + nx*length, Y: p.Y + ny*length}
+}
+
+// computeRect returns the bounding box of everything drawn for the line.
+func (l *LineAnnotation) computeRect() Rect {
+	start, end := l.drawnLine()
+	points := []Point{l.lineTo[0], l.lineTo[1], start, end}
+	if !IsZeroEpsilon(l.LeaderLine) {
+		points = append(points, l.leaderLineEnd(l.lineTo[0]), l.leaderLineEnd(l.lineTo[1]))
+	}
+	pad := l.width + lineEndingSize(l.width)
+	if l.Caption {
+		pad += l.CaptionFontSize * 1.5
+	}
+	return verticesRect(points, pad)
+}
+
 func (l *LineAnnotation) pointsCallback() string {
+	start, end := l.drawnLine()
+
 	var path string
-	for i, point := range l.lineTo {
+	// leader lines
+	if !IsZeroEpsilon(l.LeaderLine) {
+		for _, point := range l.lineTo {
+			leaderEnd := l.leaderLineEnd(point)
+			path += fmt.Sprintf("%.3f %.3f m %.3f %.3f l S ", point.X, point.Y, leaderEnd.X, leaderEnd.Y)
+		}
+	}
+
+	for i, point := range []Point{start, end} {
 		op := "l"
 		if i == 0 {
 			op = "m"
@@ -62,23 +170,137 @@ func (l *LineAnnotation) pointsCallback() string {
 
 	}
 	path += "S "
+	path += l.resetDashAP()
+
+	// line endings
+	filled := l.fillColor != nil
+	path += lineEndingAP(l.LineEndings[0], start, end, l.width, filled)
+	path += lineEndingAP(l.LineEndings[1], end, start, l.width, filled)
 
 	return path
 }
 
+// appendAppearanceObjects appends the caption: the contents centered above the middle of the line,
+// along its direction and never upside down.
+func (l *LineAnnotation) appendAppearanceObjects(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, annotRef references.FPDF_ANNOTATION) error {
+	if !l.Caption || l.contents == "" {
+		return nil
+	}
+	ux, uy, length := l.direction()
+	if IsZeroEpsilon(length) {
+		return errors.New("caption needs a line")
+	}
+	if ux < 0 {
+		ux, uy = -ux, -uy
+	}
+
+	// load font, helvetica when no font is given
+	font := l.CaptionFont
+	if font == nil {
+		var err error
+		font, err = LoadStandardFont(instance, document, DefaultFontName)
+		if err != nil {
+			return err
+		}
+		defer font.Close()
+	}
+
+	fontSize := l.CaptionFontSize
+	width, err := font.TextWidth(l.contents, fontSize)
+	if err != nil {
+		return err
+	}
+	ascent, err := font.Ascent(fontSize)
+	if err != nil {
+		return err
+	}
+	descent, err := font.Descent(fontSize)
+	if err != nil {
+		return err
+	}
+
+	// the text object is centered on the origin, move its center above the middle of the line
+	start, end := l.drawnLine()
+	midX, midY := (start.X+end.X)/2, (start.Y+end.Y)/2
+	above := l.width/2 + 2 + (ascent-descent)/2
+	layout := &textLayout{
+		fontSize: fontSize,
+		width:    width,
+		ascent:   ascent,
+		descent:  descent,
+		matrix: structs.FPDF_FS_MATRIX{
+			A: ux,
+			B: uy,
+			C: -uy,
+			D: ux,
+			E: midX - uy*above,
+			F: midY + ux*above,
+		},
+	}
+	color := Color{}
+	if l.strikeColor != nil {
+		color = *l.strikeColor
+	}
+	textRef, err := createTextObject(instance, font, layout, &TextObjectParam{
+		Text:  l.contents,
+		Color: color,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = instance.FPDFAnnot_AppendObject(&requests.FPDFAnnot_AppendObject{
+		Annotation: annotRef,
+		PageObject: textRef,
+	})
+	if err != nil {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: textRef,
+		})
+		return err
+	}
+	return nil
+}
+
 func (l *LineAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
+	var document references.FPDF_DOCUMENT
+	if l.Caption {
+		switch {
+		case l.CaptionFont != nil:
+			document = l.CaptionFont.document
+		case page.ByIndex != nil:
+			document = page.ByIndex.Document
+		default:
+			return l.newError(StepPreCheck, errors.New("caption font must be set when the page is not given by index"))
+		}
+		// the rect leaves room for the caption
+		if l.ap == "" {
+			err := l.GenerateAppearance()
+			if err != nil {
+				return l.newError(StepPreCheck, err)
+			}
+		}
+	}
+
 	// create annotation
+	l.stash = extraKeyEntries(l)
 	err := l.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
 	if err != nil {
 		return err
 	}
 
+	// append caption
+	err = l.appendAppearanceObjects(instance, document, l.annot)
+	if err != nil {
+		return l.abort(instance, page, StepAppendObject, err)
+	}
+
 	// close annotation
 	_, err = instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
 		Annotation: l.annot,
 	})
 	if err != nil {
-		return err
+		return l.newError(StepClose, err)
 	}
-	return nil
+	return l.savePDFWarning()
 }
@@ -0,0 +1,97 @@
+// 线端点
+package annotation
+
+import (
+	"fmt"
+	"math"
+)
+
+// LineEnding is the shape drawn at an end of a line or polyline, the values are the names used in /LE.
+type LineEnding string
+
+const (
+	LineEndingNone         LineEnding = "None"
+	LineEndingSquare       LineEnding = "Square"
+	LineEndingCircle       LineEnding = "Circle"
+	LineEndingDiamond      LineEnding = "Diamond"
+	LineEndingOpenArrow    LineEnding = "OpenArrow"
+	LineEndingClosedArrow  LineEnding = "ClosedArrow"
+	LineEndingButt         LineEnding = "Butt"
+	LineEndingROpenArrow   LineEnding = "ROpenArrow"
+	LineEndingRClosedArrow LineEnding = "RClosedArrow"
+	LineEndingSlash        LineEnding = "Slash"
+)
+
+// lineEndingSize returns the size of the line ending shapes for the line width.
+func lineEndingSize(width float32) float32 {
+	return max(4*width, 6)
+}
+
+// lineEndingAP returns the appearance of the ending at tip of the line coming from from.
+// Closed shapes are filled with the current fill color when filled is true.
+func lineEndingAP(ending LineEnding, tip, from Point, width float32, filled bool) string {
+	dx, dy := tip.X-from.X, tip.Y-from.Y
+	length := float32(math.Hypot(float64(dx), float64(dy)))
+	if IsZeroEpsilon(length) {
+		return ""
+	}
+
+	// u points along the line to the tip, n is perpendicular to it
+	ux, uy := dx/length, dy/length
+	nx, ny := -uy, ux
+	size := lineEndingSize(width)
+	half := size / 2
+
+	// at returns tip moved by a along the line and b across it
+	at := func(a, b float32) Point {
+		return Point{X: tip.X + ux*a + nx*b, Y: tip.Y + uy*a + ny*b}
+	}
+	polyline := func(points ...Point) string {
+		var ap string
+		for i, p := range points {
+			op := "l"
+			if i == 0 {
+				op = "m"
+			}
+			ap += fmt.Sprintf("%.3f %.3f %s ", p.X, p.Y, op)
+		}
+		return ap
+	}
+	closeOp := "s\n"
+	if filled {
+		closeOp = "b\n"
+	}
+
+	switch ending {
+	case LineEndingOpenArrow:
+		return polyline(at(-size, half), tip, at(-size, -half)) + "S\n"
+	case LineEndingClosedArrow:
+		return polyline(at(-size, half), tip, at(-size, -half)) + closeOp
+	case LineEndingROpenArrow:
+		return polyline(at(size, half), tip, at(size, -half)) + "S\n"
+	case LineEndingRClosedArrow:
+		return polyline(at(size, half), tip, at(size, -half)) + closeOp
+	case LineEndingButt:
+		return polyline(at(0, half), at(0, -half)) + "S\n"
+	case LineEndingSlash:
+		// 30 degrees from the perpendicular
+		return polyline(at(half/2, half*0.866), at(-half/2, -half*0.866)) + "S\n"
+	case LineEndingSquare:
+		return polyline(at(-half, -half), at(half, -half), at(half, half), at(-half, half)) + closeOp
+	case LineEndingDiamond:
+		return polyline(at(-half, 0), at(0, -half), at(half, 0), at(0, half)) + closeOp
+	case LineEndingCircle:
+		return circleAP(tip.X, tip.Y, half) + closeOp
+	}
+	return ""
+}
+
+// circleAP returns the path of a circle made of four bezier curves, without painting it.
+func circleAP(cx, cy, r float32) string {
+	k := r * bezierCircleKappa
+	return fmt.Sprintf("%.3f %.3f m ", cx+r, cy) +
+		fmt.Sprintf("%.3f %.3f %.3f %.3f %.3f %.3f c ", cx+r, cy+k, cx+k, cy+r, cx, cy+r) +
+		fmt.Sprintf("%.3f %.3f %.3f %.3f %.3f %.3f c ", cx-k, cy+r, cx-r, cy+k, cx-r, cy) +
+		fmt.Sprintf("%.3f %.3f %.3f %.3f %.3f %.3f c ", cx-r, cy-k, cx-k, cy-r, cx, cy-r) +
+		fmt.Sprintf("%.3f %.3f %.3f %.3f %.3f %.3f c ", cx+k, cy-r, cx+r, cy-k, cx+r, cy)
+}
@@ -0,0 +1,753 @@
+package annotation
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+// annotationBase gives access to the BaseAnnotation embedded in every annotation type.
+type annotationBase interface {
+	base() *BaseAnnotation
+}
+
+// newAnnotationBySubtype returns an empty annotation of the type matching subtype,
+// or an UnsupportedAnnotation when this package has no type for it.
+func newAnnotationBySubtype(subtype enums.FPDF_ANNOTATION_SUBTYPE) Annotation {
+	switch subtype {
+	case enums.FPDF_ANNOT_SUBTYPE_LINE:
+		return NewLineAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_SQUARE:
+		return NewSquareAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_CIRCLE:
+		return NewCircleAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_INK:
+		return NewInkAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_FREETEXT:
+		return NewFreeTextAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT:
+		return NewHighlightAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_UNDERLINE:
+		return NewUnderlineAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_STRIKEOUT:
+		return NewStrikeoutAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_SQUIGGLY:
+		return NewSquigglyAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_POLYGON:
+		return NewPolygonAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_POLYLINE:
+		return NewPolylineAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_TEXT:
+		return NewTextAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_STAMP:
+		return NewStampAnnotation()
+	case enums.FPDF_ANNOT_SUBTYPE_REDACT:
+		return NewRedactAnnotation()
+	default:
+		return NewUnsupportedAnnotation(subtype)
+	}
+}
+
+// LoadAnnotationsInPDF reads the annotations of the given pages into the typed structs of this package.
+// res: map[pageNum][]Annotation, in the same order as the annotations of the page
+func LoadAnnotationsInPDF(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNums []int) (map[int][]Annotation, error) {
+	res := make(map[int][]Annotation, len(pageNums))
+	for _, pageNum := range pageNums {
+		annots, err := LoadAnnotationsInPage(instance, pdfDoc, pageNum)
+		if err != nil {
+			return nil, err
+		}
+		res[pageNum] = annots
+	}
+	return res, nil
+}
+
+// loadPageAnnots reads the annotations of the given pages in their order, every page when pageNums is nil.
+func loadPageAnnots(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNums []int) ([]AddOnePageAnnot, error) {
+	if pageNums == nil {
+		pageCountRes, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+			Document: pdfDoc,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for pageNum := 0; pageNum < pageCountRes.PageCount; pageNum++ {
+			pageNums = append(pageNums, pageNum)
+		}
+	}
+
+	pageAnnots := make([]AddOnePageAnnot, 0, len(pageNums))
+	for _, pageNum := range pageNums {
+		annots, err := LoadAnnotationsInPage(instance, pdfDoc, pageNum)
+		if err != nil {
+			return nil, err
+		}
+		pageAnnots = append(pageAnnots, AddOnePageAnnot{PageNumber: pageNum, Annots: annots})
+	}
+	return pageAnnots, nil
+}
+
+// LoadAnnotationsInPage reads every annotation of a page into the typed structs of this package.
+// Subtypes without a type in this package are returned as *UnsupportedAnnotation.
+//
+// pdfium refuses to report /C and /IC while an annotation has an appearance stream,
+// so the page is copied into a scratch document where the streams can be dropped.
+// The given document is never modified.
+func LoadAnnotationsInPage(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int) ([]Annotation, error) {
+	scratch, err := instance.FPDF_CreateNewDocument(&requests.FPDF_CreateNewDocument{})
+	if err != nil {
+		return nil, err
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: scratch.Document,
+	})
+
+	_, err = instance.FPDF_ImportPagesByIndex(&requests.FPDF_ImportPagesByIndex{
+		Source:      pdfDoc,
+		Destination: scratch.Document,
+		PageIndices: []int{pageNum},
+		Index:       0,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: scratch.Document,
+			Index:    0,
+		},
+	}
+	annotCount, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+		Page: page,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	annots := make([]Annotation, 0, annotCount.Count)
+	for i := 0; i < annotCount.Count; i++ {
+		annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+			Page:  page,
+			Index: i,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		annot, err := loadAnnotation(instance, page, annotRes.Annotation)
+		instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			return nil, err
+		}
+		annots = append(annots, annot)
+	}
+
+	// popups linked in the stash, until SavePDF writes /Popup
+	byNM := make(map[string]Annotation, len(annots))
+	for _, annot := range annots {
+		byNM[annot.GetNM()] = annot
+	}
+	for _, annot := range annots {
+		if t, ok := annot.(*TextAnnotation); ok && t.PopupRect == nil && t.popupNM != "" && byNM[t.popupNM] != nil {
+			rect := byNM[t.popupNM].GetRect()
+			t.PopupRect = &rect
+		}
+	}
+
+	return annots, nil
+}
+
+// loadAnnotation reads an opened annotation into its typed struct.
+// ps: the appearance stream of the annotation is removed to read its colors,
+// only call it on annotations of a scratch document
+func loadAnnotation(instance pdfium.Pdfium, page requests.Page, annotRef references.FPDF_ANNOTATION) (Annotation, error) {
+	subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+		Annotation: annotRef,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// annotations pdfium can not create are stamps with their subtype in the stash
+	stash, err := loadStash(instance, annotRef)
+	if err != nil {
+		return nil, err
+	}
+	subtype := subtypeRes.Subtype
+	placeholder := false
+	if name, ok := stash["Subtype"].(pdfName); ok {
+		if stashed, ok := pdfSubtypeByName(string(name)); ok {
+			subtype, placeholder = stashed, true
+		}
+	}
+
+	annot := newAnnotationBySubtype(subtype)
+	if s, ok := annot.(*StampAnnotation); ok {
+		// before loadBaseAnnotation removes the appearance
+		err = loadStampImage(instance, page, annotRef, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = loadBaseAnnotation(instance, annotRef, annot.(annotationBase).base())
+	if err != nil {
+		return nil, err
+	}
+
+	switch a := annot.(type) {
+	case *LineAnnotation:
+		if !placeholder {
+			err = loadLine(instance, annotRef, a)
+		}
+	case *InkAnnotation:
+		a.Points, err = loadInkList(instance, annotRef)
+	case *HighlightAnnotation:
+		a.QuadPoints, err = loadQuadPoints(instance, annotRef)
+	case *UnderlineAnnotation:
+		a.QuadPoints, err = loadQuadPoints(instance, annotRef)
+	case *StrikeoutAnnotation:
+		a.QuadPoints, err = loadQuadPoints(instance, annotRef)
+	case *SquigglyAnnotation:
+		a.QuadPoints, err = loadQuadPoints(instance, annotRef)
+	case *PolygonAnnotation:
+		if !placeholder {
+			a.Vertices, err = loadVertices(instance, annotRef)
+		}
+	case *PolylineAnnotation:
+		if !placeholder {
+			a.Vertices, err = loadVertices(instance, annotRef)
+		}
+	case *FreeTextAnnotation:
+		a.Contents = a.contents
+		a.contents = ""
+		err = loadDefaultAppearance(instance, annotRef, a)
+	case *TextAnnotation:
+		err = loadTextAnnotation(instance, annotRef, a)
+	case *RedactAnnotation:
+		err = loadRedactAnnotation(instance, annotRef, a)
+	case *UnsupportedAnnotation:
+		a.Keys, err = loadRawKeys(instance, annotRef)
+	}
+	if err != nil {
+		return nil, err
+	}
+	readExtraKeys(nil, stash, annot)
+
+	return annot, nil
+}
+
+// loadStampImage reads the image of a stamp whose appearance is one image object, so the stamp can be added
+// again without the resources of its appearance, e.g. by an import. The image is rendered with its soft mask,
+// one pixel per pixel of the image. An image outside of the rect, in the space of another bounding box, is
+// stretched to the rect.
+func loadStampImage(instance pdfium.Pdfium, page requests.Page, annotRef references.FPDF_ANNOTATION, s *StampAnnotation) error {
+	countRes, err := instance.FPDFAnnot_GetObjectCount(&requests.FPDFAnnot_GetObjectCount{
+		Annotation: annotRef,
+	})
+	if err != nil || countRes.Count != 1 {
+		return err
+	}
+	objRes, err := instance.FPDFAnnot_GetObject(&requests.FPDFAnnot_GetObject{
+		Annotation: annotRef,
+		Index:      0,
+	})
+	if err != nil {
+		return err
+	}
+	typeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
+		PageObject: objRes.PageObject,
+	})
+	if err != nil || typeRes.Type != enums.FPDF_PAGEOBJ_IMAGE {
+		return err
+	}
+
+	rectRes, err := instance.FPDFAnnot_GetRect(&requests.FPDFAnnot_GetRect{
+		Annotation: annotRef,
+	})
+	if err != nil {
+		return err
+	}
+	matrixRes, err := instance.FPDFPageObj_GetMatrix(&requests.FPDFPageObj_GetMatrix{
+		PageObject: objRes.PageObject,
+	})
+	if err != nil {
+		return err
+	}
+	sizeRes, err := instance.FPDFImageObj_GetImagePixelSize(&requests.FPDFImageObj_GetImagePixelSize{
+		ImageObject: objRes.PageObject,
+	})
+	if err != nil {
+		return err
+	}
+	if sizeRes.Width == 0 || sizeRes.Height == 0 {
+		return nil
+	}
+
+	// the appearance is removed after this, the matrix is not restored
+	_, err = instance.FPDFPageObj_SetMatrix(&requests.FPDFPageObj_SetMatrix{
+		PageObject: objRes.PageObject,
+		Transform:  structs.FPDF_FS_MATRIX{A: float32(sizeRes.Width), D: float32(sizeRes.Height)},
+	})
+	if err != nil {
+		return err
+	}
+	bitmapRes, err := instance.FPDFImageObj_GetRenderedBitmap(&requests.FPDFImageObj_GetRenderedBitmap{
+		Document:    page.ByIndex.Document,
+		Page:        page,
+		ImageObject: objRes.PageObject,
+	})
+	if err != nil {
+		return err
+	}
+	defer instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+		Bitmap: bitmapRes.Bitmap,
+	})
+	img, err := bitmapImage(instance, bitmapRes.Bitmap)
+	if err != nil {
+		return err
+	}
+
+	rect := Rect{
+		Left:   min(rectRes.Rect.Left, rectRes.Rect.Right),
+		Bottom: min(rectRes.Rect.Bottom, rectRes.Rect.Top),
+		Right:  max(rectRes.Rect.Left, rectRes.Rect.Right),
+		Top:    max(rectRes.Rect.Bottom, rectRes.Rect.Top),
+	}
+	matrix := matrixRes.Matrix
+	var corners []Point
+	for _, p := range []Point{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 0, Y: 1}, {X: 1, Y: 1}} {
+		corners = append(corners, transformPoint(matrix, p))
+	}
+	bounds := verticesRect(corners, 0)
+	if bounds.Left < rect.Left-1 || bounds.Bottom < rect.Bottom-1 || bounds.Right > rect.Right+1 || bounds.Top > rect.Top+1 {
+		if IsZeroEpsilon(bounds.Right-bounds.Left) || IsZeroEpsilon(bounds.Top-bounds.Bottom) {
+			return nil
+		}
+		sx := (rect.Right - rect.Left) / (bounds.Right - bounds.Left)
+		sy := (rect.Top - rect.Bottom) / (bounds.Top - bounds.Bottom)
+		matrix = structs.FPDF_FS_MATRIX{
+			A: matrix.A * sx,
+			B: matrix.B * sy,
+			C: matrix.C * sx,
+			D: matrix.D * sy,
+			E: (matrix.E-bounds.Left)*sx + rect.Left,
+			F: (matrix.F-bounds.Bottom)*sy + rect.Bottom,
+		}
+	}
+
+	s.objectType = StampObjectImg
+	s.imgObject = &ImageObjectParam{
+		Image:  img,
+		matrix: &matrix,
+	}
+	return nil
+}
+
+// loadBaseAnnotation reads the keys shared by every annotation type.
+func loadBaseAnnotation(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, b *BaseAnnotation) error {
+	var err error
+
+	// nm, title, contents
+	b.nm, err = getAnnotString(instance, annotRef, "NM")
+	if err != nil {
+		return err
+	}
+	b.title, err = getAnnotString(instance, annotRef, "T")
+	if err != nil {
+		return err
+	}
+	b.contents, err = getAnnotString(instance, annotRef, "Contents")
+	if err != nil {
+		return err
+	}
+
+	// dates, dates in another format are left zero
+	creationDate, err := getAnnotString(instance, annotRef, "CreationDate")
+	if err != nil {
+		return err
+	}
+	b.creationDate, _ = ParsePDFDate(creationDate)
+	modDate, err := getAnnotString(instance, annotRef, "M")
+	if err != nil {
+		return err
+	}
+	b.modDate, _ = ParsePDFDate(modDate)
+
+	// rect
+	rectRes, err := instance.FPDFAnnot_GetRect(&requests.FPDFAnnot_GetRect{
+		Annotation: annotRef,
+	})
+	if err != nil {
+		return err
+	}
+	b.rect = Rect{
+		Left:   rectRes.Rect.Left,
+		Bottom: rectRes.Rect.Bottom,
+		Right:  rectRes.Rect.Right,
+		Top:    rectRes.Rect.Top,
+	}
+
+	// border width
+	hasBorder, err := hasAnnotKey(instance, annotRef, "Border")
+	if err != nil {
+		return err
+	}
+	if hasBorder {
+		borderRes, err := instance.FPDFAnnot_GetBorder(&requests.FPDFAnnot_GetBorder{
+			Annotation: annotRef,
+		})
+		if err != nil {
+			return err
+		}
+		b.width = borderRes.BorderWidth
+	}
+
+	// opacity
+	hasOpacity, err := hasAnnotKey(instance, annotRef, "CA")
+	if err != nil {
+		return err
+	}
+	if hasOpacity {
+		opacityRes, err := instance.FPDFAnnot_GetNumberValue(&requests.FPDFAnnot_GetNumberValue{
+			Annotation: annotRef,
+			Key:        "CA",
+		})
+		if err != nil {
+			return err
+		}
+		b.opacity = uint8(opacityRes.Value*255 + 0.5)
+	}
+
+	// normal appearance, then drop it so pdfium reports the colors
+	apRes, err := instance.FPDFAnnot_GetAP(&requests.FPDFAnnot_GetAP{
+		Annotation:     annotRef,
+		AppearanceMode: enums.FPDF_ANNOT_APPEARANCEMODE_NORMAL,
+	})
+	if err != nil {
+		return err
+	}
+	b.ap = apRes.Value
+	if b.ap != "" {
+		_, err = instance.FPDFAnnot_SetAP(&requests.FPDFAnnot_SetAP{
+			Annotation:     annotRef,
+			AppearanceMode: enums.FPDF_ANNOT_APPEARANCEMODE_NORMAL,
+			Value:          nil,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// colors
+	b.strikeColor, err = getAnnotColor(instance, annotRef, "C", enums.FPDFANNOT_COLORTYPE_Color)
+	if err != nil {
+		return err
+	}
+	b.fillColor, err = getAnnotColor(instance, annotRef, "IC", enums.FPDFANNOT_COLORTYPE_InteriorColor)
+	if err != nil {
+		return err
+	}
+
+	// highlight draws with the fill color, see HighlightAnnotation.SetStrikeColor
+	if b.subtype == enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT && b.fillColor == nil {
+		b.fillColor = b.strikeColor
+	}
+
+	return nil
+}
+
+func hasAnnotKey(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, key string) (bool, error) {
+	res, err := instance.FPDFAnnot_HasKey(&requests.FPDFAnnot_HasKey{
+		Annotation: annotRef,
+		Key:        key,
+	})
+	if err != nil {
+		return false, err
+	}
+	return res.HasKey, nil
+}
+
+// getAnnotString returns the string value of key, "" if the key does not exist.
+func getAnnotString(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, key string) (string, error) {
+	hasKey, err := hasAnnotKey(instance, annotRef, key)
+	if err != nil || !hasKey {
+		return "", err
+	}
+	res, err := instance.FPDFAnnot_GetStringValue(&requests.FPDFAnnot_GetStringValue{
+		Annotation: annotRef,
+		Key:        key,
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.Value, nil
+}
+
+// getAnnotColor returns the color stored under key, nil if the key does not exist.
+func getAnnotColor(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, key string, colorType enums.FPDFANNOT_COLORTYPE) (*Color, error) {
+	hasKey, err := hasAnnotKey(instance, annotRef, key)
+	if err != nil || !hasKey {
+		return nil, err
+	}
+	res, err := instance.FPDFAnnot_GetColor(&requests.FPDFAnnot_GetColor{
+		Annotation: annotRef,
+		ColorType:  colorType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Color{R: uint8(res.R), G: uint8(res.G), B: uint8(res.B)}, nil
+}
+
+func loadQuadPoints(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION) ([]QuadPoint, error) {
+	countRes, err := instance.FPDFAnnot_CountAttachmentPoints(&requests.FPDFAnnot_CountAttachmentPoints{
+		Annotation: annotRef,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	quadPoints := make([]QuadPoint, 0, countRes.Count)
+	for i := uint64(0); i < countRes.Count; i++ {
+		pointsRes, err := instance.FPDFAnnot_GetAttachmentPoints(&requests.FPDFAnnot_GetAttachmentPoints{
+			Annotation: annotRef,
+			Index:      i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		quadPoints = append(quadPoints, convertQuadPointFromPdfiumFormat(pointsRes.QuadPoints))
+	}
+	return quadPoints, nil
+}
+
+// loadRawKeys reads the text, name and number values of RawKeyNames in pdf syntax.
+func loadRawKeys(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION) (map[string]string, error) {
+	keys := map[string]string{}
+	for _, key := range RawKeyNames {
+		typeRes, err := instance.FPDFAnnot_GetValueType(&requests.FPDFAnnot_GetValueType{
+			Annotation: annotRef,
+			Key:        key,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		switch typeRes.ValueType {
+		case enums.FPDF_OBJECT_TYPE_STRING, enums.FPDF_OBJECT_TYPE_NAME:
+			value, err := getAnnotString(instance, annotRef, key)
+			if err != nil {
+				return nil, err
+			}
+			if typeRes.ValueType == enums.FPDF_OBJECT_TYPE_NAME {
+				keys[key] = formatPDFName(value)
+			} else {
+				keys[key] = formatPDFString(value)
+			}
+		case enums.FPDF_OBJECT_TYPE_NUMBER:
+			numberRes, err := instance.FPDFAnnot_GetNumberValue(&requests.FPDFAnnot_GetNumberValue{
+				Annotation: annotRef,
+				Key:        key,
+			})
+			if err != nil {
+				return nil, err
+			}
+			keys[key] = formatNumbers(numberRes.Value)
+		}
+	}
+	return keys, nil
+}
+
+// loadTextAnnotation reads the icon of a sticky note and the rect of its popup.
+func loadTextAnnotation(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, t *TextAnnotation) error {
+	icon, err := getAnnotString(instance, annotRef, "Name")
+	if err != nil {
+		return err
+	}
+	if icon != "" {
+		t.Icon = TextIcon(icon)
+	}
+
+	hasPopup, err := hasAnnotKey(instance, annotRef, "Popup")
+	if err != nil || !hasPopup {
+		return err
+	}
+	popupRes, err := instance.FPDFAnnot_GetLinkedAnnot(&requests.FPDFAnnot_GetLinkedAnnot{
+		Annotation: annotRef,
+		Key:        "Popup",
+	})
+	if err != nil {
+		return err
+	}
+	defer instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: popupRes.LinkedAnnotation,
+	})
+	rectRes, err := instance.FPDFAnnot_GetRect(&requests.FPDFAnnot_GetRect{
+		Annotation: popupRes.LinkedAnnotation,
+	})
+	if err != nil {
+		return err
+	}
+	t.PopupRect = &Rect{
+		Left:   rectRes.Rect.Left,
+		Top:    rectRes.Rect.Top,
+		Right:  rectRes.Rect.Right,
+		Bottom: rectRes.Rect.Bottom,
+	}
+	return nil
+}
+
+// loadLine reads /L, /LL and /LLE of a line annotation.
+// ps: /LE is an array of names, pdfium has no getter for it
+func loadLine(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, l *LineAnnotation) error {
+	lineRes, err := instance.FPDFAnnot_GetLine(&requests.FPDFAnnot_GetLine{
+		Annotation: annotRef,
+	})
+	if err != nil {
+		return err
+	}
+	l.SetLineTo(lineRes.Start.X, lineRes.Start.Y, lineRes.End.X, lineRes.End.Y)
+
+	for _, key := range []string{"LL", "LLE"} {
+		hasKey, err := hasAnnotKey(instance, annotRef, key)
+		if err != nil {
+			return err
+		}
+		if !hasKey {
+			continue
+		}
+		numberRes, err := instance.FPDFAnnot_GetNumberValue(&requests.FPDFAnnot_GetNumberValue{
+			Annotation: annotRef,
+			Key:        key,
+		})
+		if err != nil {
+			return err
+		}
+		if key == "LL" {
+			l.LeaderLine = numberRes.Value
+		} else {
+			l.LeaderLineExtension = numberRes.Value
+		}
+	}
+	return nil
+}
+
+func loadVertices(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION) ([]Point, error) {
+	verticesRes, err := instance.FPDFAnnot_GetVertices(&requests.FPDFAnnot_GetVertices{
+		Annotation: annotRef,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return convertPointFromPdfiumFormat(verticesRes.Vertices), nil
+}
+
+func loadInkList(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION) ([][]Point, error) {
+	countRes, err := instance.FPDFAnnot_GetInkListCount(&requests.FPDFAnnot_GetInkListCount{
+		Annotation: annotRef,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	inkList := make([][]Point, 0, countRes.Count)
+	for i := uint64(0); i < countRes.Count; i++ {
+		pathRes, err := instance.FPDFAnnot_GetInkListPath(&requests.FPDFAnnot_GetInkListPath{
+			Annotation: annotRef,
+			Index:      i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		inkList = append(inkList, convertPointFromPdfiumFormat(pathRes.Path))
+	}
+	return inkList, nil
+}
+
+var (
+	daFontSizeRegexp = regexp.MustCompile(`([0-9.]+)\s+Tf`)
+	daRGBRegexp      = regexp.MustCompile(`([0-9.]+)\s+([0-9.]+)\s+([0-9.]+)\s+rg`)
+	daGrayRegexp     = regexp.MustCompile(`([0-9.]+)\s+g(\s|$)`)
+)
+
+// loadDefaultAppearance reads the alignment from /Q and the font size and font color from the /DA string of a free text annotation.
+func loadDefaultAppearance(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, f *FreeTextAnnotation) error {
+	hasAlignment, err := hasAnnotKey(instance, annotRef, "Q")
+	if err != nil {
+		return err
+	}
+	if hasAlignment {
+		alignmentRes, err := instance.FPDFAnnot_GetNumberValue(&requests.FPDFAnnot_GetNumberValue{
+			Annotation: annotRef,
+			Key:        "Q",
+		})
+		if err != nil {
+			return err
+		}
+		f.Alignment = TextAlignment(alignmentRes.Value)
+	}
+
+	da, err := getAnnotString(instance, annotRef, "DA")
+	if err != nil || da == "" {
+		return err
+	}
+
+	fontSize, fontColor := parseDefaultAppearance(da)
+	if fontSize > 0 {
+		f.FontSize = int(fontSize + 0.5)
+	}
+	if fontColor != nil {
+		f.FontColor = *fontColor
+	}
+	return nil
+}
+
+// parseDefaultAppearance returns the font size and the fill color of a /DA string, 0 and nil when they are not set.
+func parseDefaultAppearance(da string) (float32, *Color) {
+	var fontSize float32
+	if match := daFontSizeRegexp.FindStringSubmatch(da); match != nil {
+		size, _ := strconv.ParseFloat(match[1], 32)
+		fontSize = float32(size)
+	}
+
+	parse := func(s string) uint8 {
+		v, _ := strconv.ParseFloat(s, 32)
+		return uint8(v*255 + 0.5)
+	}
+	if match := daRGBRegexp.FindStringSubmatch(da); match != nil {
+		return fontSize, &Color{R: parse(match[1]), G: parse(match[2]), B: parse(match[3])}
+	}
+	if match := daGrayRegexp.FindStringSubmatch(da); match != nil {
+		gray := parse(match[1])
+		return fontSize, &Color{R: gray, G: gray, B: gray}
+	}
+	return fontSize, nil
+}
+
+// loadRedactAnnotation reads the overlay text and its /DA of a redact annotation.
+func loadRedactAnnotation(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, r *RedactAnnotation) error {
+	var err error
+	r.OverlayText, err = getAnnotString(instance, annotRef, "OverlayText")
+	if err != nil {
+		return err
+	}
+
+	da, err := getAnnotString(instance, annotRef, "DA")
+	if err != nil || da == "" {
+		return err
+	}
+	fontSize, fontColor := parseDefaultAppearance(da)
+	r.OverlayFontSize = fontSize
+	if fontColor != nil {
+		r.FontColor = *fontColor
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+// 标记文本
+package annotation
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// DefaultMarkedTextOverlap is the part of a character box that must be under a quad for the character to be marked.
+const DefaultMarkedTextOverlap = 0.5
+
+// MarkedText is the text under a text markup annotation.
+type MarkedText struct {
+	Annotation Annotation
+	Text       string
+}
+
+// pageChar is a character of a text page with its box, its index is its index in the slice.
+type pageChar struct {
+	unicode   rune
+	box       Rect
+	generated bool
+}
+
+// GetMarkedText returns the text under the quads of a highlight, underline, strikeout or squiggly annotation
+// of the page, the lines joined by a space. A character is marked when minOverlap of its box, from 0 to 1,
+// is under a quad, the characters between marked ones that are not marked become a space.
+// A minOverlap of 0 takes pdfium's bounded text of each quad, every character touching it.
+func GetMarkedText(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int, annot Annotation, minOverlap float32) (string, error) {
+	quadPoints, ok := markupQuadPoints(annot)
+	if !ok {
+		return "", errors.New(annot.GetSubtypeName() + " annot is not a text markup")
+	}
+
+	var text string
+	err := withTextPage(instance, pdfDoc, pageNum, func(textPage references.FPDF_TEXTPAGE) error {
+		chars, err := loadPageChars(instance, textPage, minOverlap)
+		if err != nil {
+			return err
+		}
+		text, err = markedText(instance, textPage, chars, quadPoints, minOverlap)
+		return err
+	})
+	return text, err
+}
+
+// GetMarkedTextsInPage returns the text under every text markup annotation of the page, in the annotation order.
+// Annotations from other tools are read too, see GetMarkedText for minOverlap.
+func GetMarkedTextsInPage(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int, minOverlap float32) ([]MarkedText, error) {
+	annots, err := LoadAnnotationsInPage(instance, pdfDoc, pageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	var marked []MarkedText
+	err = withTextPage(instance, pdfDoc, pageNum, func(textPage references.FPDF_TEXTPAGE) error {
+		// the characters are only read once for the page
+		chars, err := loadPageChars(instance, textPage, minOverlap)
+		if err != nil {
+			return err
+		}
+		for _, annot := range annots {
+			quadPoints, ok := markupQuadPoints(annot)
+			if !ok {
+				continue
+			}
+			text, err := markedText(instance, textPage, chars, quadPoints, minOverlap)
+			if err != nil {
+				return err
+			}
+			marked = append(marked, MarkedText{Annotation: annot, Text: text})
+		}
+		return nil
+	})
+	return marked, err
+}
+
+// markupQuadPoints returns the quads of a text markup annotation.
+func markupQuadPoints(annot Annotation) ([]QuadPoint, bool) {
+	switch a := annot.(type) {
+	case *HighlightAnnotation:
+		return a.QuadPoints, true
+	case *UnderlineAnnotation:
+		return a.QuadPoints, true
+	case *StrikeoutAnnotation:
+		return a.QuadPoints, true
+	case *SquigglyAnnotation:
+		return a.QuadPoints, true
+	}
+	return nil, false
+}
+
+// setMarkupQuadPoints sets the quads of a text markup annotation, other subtypes are left as they are.
+func setMarkupQuadPoints(annot Annotation, quadPoints []QuadPoint) {
+	switch a := annot.(type) {
+	case *HighlightAnnotation:
+		a.QuadPoints = quadPoints
+	case *UnderlineAnnotation:
+		a.QuadPoints = quadPoints
+	case *StrikeoutAnnotation:
+		a.QuadPoints = quadPoints
+	case *SquigglyAnnotation:
+		a.QuadPoints = quadPoints
+	}
+}
+
+// loadPageChars returns the characters of the text page, they are not needed for bounded text.
+func loadPageChars(instance pdfium.Pdfium, textPage references.FPDF_TEXTPAGE, minOverlap float32) ([]pageChar, error) {
+	if minOverlap <= 0 {
+		return nil, nil
+	}
+
+	countRes, err := instance.FPDFText_CountChars(&requests.FPDFText_CountChars{
+		TextPage: textPage,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chars := make([]pageChar, 0, countRes.Count)
+	for i := 0; i < countRes.Count; i++ {
+		generatedRes, err := instance.FPDFText_IsGenerated(&requests.FPDFText_IsGenerated{
+			TextPage: textPage,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		unicodeRes, err := instance.FPDFText_GetUnicode(&requests.FPDFText_GetUnicode{
+			TextPage: textPage,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		boxRes, err := instance.FPDFText_GetCharBox(&requests.FPDFText_GetCharBox{
+			TextPage: textPage,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		chars = append(chars, pageChar{
+			unicode: rune(unicodeRes.Unicode),
+			box: Rect{
+				Left:   float32(boxRes.Left),
+				Bottom: float32(boxRes.Bottom),
+				Right:  float32(boxRes.Right),
+				Top:    float32(boxRes.Top),
+			},
+			generated: generatedRes.IsGenerated,
+		})
+	}
+	return chars, nil
+}
+
+// markedText returns the text under the quads, one line per quad.
+func markedText(instance pdfium.Pdfium, textPage references.FPDF_TEXTPAGE, chars []pageChar, quadPoints []QuadPoint, minOverlap float32) (string, error) {
+	var lines []string
+	for _, quad := range quadPoints {
+		var text string
+		if minOverlap <= 0 {
+			bounds := quadPointsRect([]QuadPoint{quad})
+			textRes, err := instance.FPDFText_GetBoundedText(&requests.FPDFText_GetBoundedText{
+				TextPage: textPage,
+				Left:     float64(bounds.Left),
+				Top:      float64(bounds.Top),
+				Right:    float64(bounds.Right),
+				Bottom:   float64(bounds.Bottom),
+			})
+			if err != nil {
+				return "", err
+			}
+			text = textRes.Text
+		} else {
+			// the marked characters, the generated and the unmarked characters between them become a space
+			polygon := convexPolygon(quad.points())
+			var builder strings.Builder
+			gap := false
+			for _, c := range chars {
+				if c.generated || c.unicode == 0 || charOverlap(c.box, polygon) < minOverlap {
+					gap = builder.Len() > 0
+					continue
+				}
+				if gap {
+					builder.WriteRune(' ')
+					gap = false
+				}
+				builder.WriteRune(c.unicode)
+			}
+			text = builder.String()
+		}
+
+		if text = strings.Join(strings.Fields(text), " "); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, " "), nil
+}
+
+// charOverlap returns the part of the box inside the convex polygon, from 0 to 1.
+// Boxes without area, like spaces, are inside when their center is.
+func charOverlap(box Rect, polygon []Point) float32 {
+	area := (box.Right - box.Left) * (box.Top - box.Bottom)
+	if area <= 0 {
+		if convexContainsPoint(polygon, Point{X: (box.Left + box.Right) / 2, Y: (box.Bottom + box.Top) / 2}) {
+			return 1
+		}
+		return 0
+	}
+	return min(polygonArea(clipConvexPolygon(rectPolygon(box), polygon))/area, 1)
+}
+
+// convexPolygon orders the points counterclockwise around their center,
+// quads written by other tools do not all list their corners in the same order.
+func convexPolygon(points []Point) []Point {
+	var cx, cy float32
+	for _, p := range points {
+		cx += p.X / float32(len(points))
+		cy += p.Y / float32(len(points))
+	}
+	sorted := append([]Point(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return math.Atan2(float64(sorted[i].Y-cy), float64(sorted[i].X-cx)) < math.Atan2(float64(sorted[j].Y-cy), float64(sorted[j].X-cx))
+	})
+	return sorted
+}
+
+// clipConvexPolygon returns the part of the subject polygon inside the counterclockwise convex clip polygon.
+func clipConvexPolygon(subject, clip []Point) []Point {
+	output := subject
+	for i, a := range clip {
+		b := clip[(i+1)%len(clip)]
+		inside := func(p Point) bool {
+			return (b.X-a.X)*(p.Y-a.Y)-(b.Y-a.Y)*(p.X-a.X) >= 0
+		}
+		// where the segment p->q crosses the line a->b
+		intersect := func(p, q Point) Point {
+			dx, dy := b.X-a.X, b.Y-a.Y
+			t := (dx*(a.Y-p.Y) - dy*(a.X-p.X)) / (dx*(q.Y-p.Y) - dy*(q.X-p.X))
+			return Point{X: p.X + (q.X-p.X)*t, Y: p.Y + (q.Y-p.Y)*t}
+		}
+
+		input := output
+		output = nil
+		for j, q := range input {
+			p := input[(j+len(input)-1)%len(input)]
+			switch {
+			case inside(q) && !inside(p):
+				output = append(output, intersect(p, q), q)
+			case inside(q):
+				output = append(output, q)
+			case inside(p):
+				output = append(output, intersect(p, q))
+			}
+		}
+		if len(output) == 0 {
+			return nil
+		}
+	}
+	return output
+}
+
+// polygonArea returns the area of the polygon.
+func polygonArea(polygon []Point) float32 {
+	var area float32
+	for i, p := range polygon {
+		q := polygon[(i+1)%len(polygon)]
+		area += p.X*q.Y - q.X*p.Y
+	}
+	return abs32(area) / 2
+}
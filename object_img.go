@@ -1,90 +1,308 @@
 package annotation
 
 import (
+	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"image"
-	"log"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"math"
 	"os"
+	"reflect"
+	"strings"
 
 	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
 	"github.com/klippa-app/go-pdfium/references"
 	"github.com/klippa-app/go-pdfium/requests"
 	"github.com/klippa-app/go-pdfium/structs"
 )
 
-// GetImageDimensions 从指定路径获取图片的宽度和高度
+const pngDataURLPrefix = "data:image/png;base64,"
+
+// GetImageDimensions 从指定路径获取图片的宽度和高度, 只读取图片头, 不解码像素
 func GetImageDimensions(imagePath string) (width int, height int, err error) {
-	// 1. 打开文件
-	file, err := os.Open(imagePath)
+	return getImgDimensions(&ImageObjectParam{FilePath: imagePath})
+}
+
+// CreateImgObject creates an image object.
+func CreateImgObject(instance pdfium.Pdfium, rect Rect, imgParam *ImageObjectParam) (references.FPDF_PAGEOBJECT, error) {
+	var imgRef references.FPDF_PAGEOBJECT
+	var err error
+
+	imgType := imgParam.ImgType
+	if imgParam.Image == nil && imgType == "" {
+		imgType, err = detectImgType(imgParam)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch {
+	case imgParam.Image != nil:
+		imgRef, err = createBitmapImgObject(instance, imgParam.Document, imgParam.Image)
+	case imgType == "jpg" || imgType == "jpeg":
+		imgRef, err = createJPEGImgObject(instance, imgParam.Document, imgParam.FilePath, imgParam.Data)
+	case imgType == "png" || imgType == "gif":
+		var img image.Image
+		img, err = decodeImg(imgParam)
+		if err != nil {
+			return "", err
+		}
+		imgRef, err = createBitmapImgObject(instance, imgParam.Document, img)
+	default:
+		return "", errors.New("unsupported image type")
+	}
+
 	if err != nil {
-		return 0, 0, fmt.Errorf("无法打开文件: %w", err)
+		return "", err
 	}
-	defer file.Close() // 确保文件关闭
 
-	// 2. 解码图片
-	// image.Decode 函数会自动识别格式并返回 image.Image 接口
-	img, _, err := image.Decode(file)
+	destroy := func(err error) (references.FPDF_PAGEOBJECT, error) {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: imgRef,
+		})
+		return "", err
+	}
+
+	// place the image in the rect
+	var matrix structs.FPDF_FS_MATRIX
+	if imgParam.matrix != nil {
+		matrix = *imgParam.matrix
+	} else {
+		matrix, err = placeImg(rect, imgParam)
+		if err != nil {
+			return destroy(err)
+		}
+	}
+	_, err = instance.FPDFImageObj_SetMatrix(&requests.FPDFImageObj_SetMatrix{
+		ImageObject: imgRef,
+		Transform:   matrix,
+	})
 	if err != nil {
-		return 0, 0, fmt.Errorf("无法解码图片: %w", err)
+		return destroy(err)
 	}
 
-	// 3. 获取图片的边界信息
-	// Bounds() 方法返回一个 image.Rectangle 结构体
-	bounds := img.Bounds()
+	return imgRef, nil
+}
+
+// placeImg returns the matrix drawing the unit square of the image at its placement in the rect.
+// ps: the appearance stream of the annotation is clipped to the rect, so the parts of
+// covered, rotated or original size images outside of it are not shown.
+func placeImg(rect Rect, imgParam *ImageObjectParam) (structs.FPDF_FS_MATRIX, error) {
+	rectWidth := rect.Right - rect.Left
+	rectHeight := rect.Top - rect.Bottom
 
-	// 宽度 = X 轴的最大值 - 最小值 (Max.X - Min.X)
-	width = bounds.Dx()
-	// 或者直接使用 width = bounds.Max.X - bounds.Min.X
+	rad := float64(imgParam.Rotation) * math.Pi / 180
+	sin, cos := float32(math.Sin(rad)), float32(math.Cos(rad))
 
-	// 高度 = Y 轴的最大值 - 最小值 (Max.Y - Min.Y)
-	height = bounds.Dy()
-	// 或者直接使用 height = bounds.Max.Y - bounds.Min.Y
+	// size of the image
+	width, height := rectWidth, rectHeight
+	if imgParam.Placement != ImgPlacementFill {
+		pixelWidth, pixelHeight, err := getImgDimensions(imgParam)
+		if err != nil {
+			return structs.FPDF_FS_MATRIX{}, err
+		}
+		width, height = float32(pixelWidth), float32(pixelHeight)
+
+		// scale the box around the rotated image to the rect
+		rotatedWidth := width*abs32(cos) + height*abs32(sin)
+		rotatedHeight := width*abs32(sin) + height*abs32(cos)
+		switch imgParam.Placement {
+		case ImgPlacementFit:
+			scale := min(rectWidth/rotatedWidth, rectHeight/rotatedHeight)
+			width, height = width*scale, height*scale
+		case ImgPlacementCover:
+			scale := max(rectWidth/rotatedWidth, rectHeight/rotatedHeight)
+			width, height = width*scale, height*scale
+		}
+	}
 
-	log.Printf("image dimensions: %d x %d", width, height)
+	// center of the image, the rotation is around it
+	centerX := rect.Left + rectWidth/2
+	centerY := rect.Bottom + rectHeight/2
+	if imgParam.Placement == ImgPlacementOriginal {
+		rotatedWidth := width*abs32(cos) + height*abs32(sin)
+		rotatedHeight := width*abs32(sin) + height*abs32(cos)
+		switch imgParam.Anchor {
+		case ImgAnchorBottomLeft:
+			centerX, centerY = rect.Left+rotatedWidth/2, rect.Bottom+rotatedHeight/2
+		case ImgAnchorBottomRight:
+			centerX, centerY = rect.Right-rotatedWidth/2, rect.Bottom+rotatedHeight/2
+		case ImgAnchorTopLeft:
+			centerX, centerY = rect.Left+rotatedWidth/2, rect.Top-rotatedHeight/2
+		case ImgAnchorTopRight:
+			centerX, centerY = rect.Right-rotatedWidth/2, rect.Top-rotatedHeight/2
+		}
+	}
 
-	return width, height, nil
+	// scale the unit square, move its center to the origin, rotate, then move it to the center
+	return structs.FPDF_FS_MATRIX{
+		A: width * cos,
+		B: width * sin,
+		C: -height * sin,
+		D: height * cos,
+		E: centerX - width/2*cos + height/2*sin,
+		F: centerY - width/2*sin - height/2*cos,
+	}, nil
 }
 
-// CreateImgObject creates an image object.
-func CreateImgObject(instance pdfium.Pdfium, rect Rect, imgParam *ImageObjectParam) (references.FPDF_PAGEOBJECT, error) {
-	var imgRef references.FPDF_PAGEOBJECT
-	var err error
+// getImgDimensions returns the size of the image in pixels, without decoding encoded images.
+func getImgDimensions(imgParam *ImageObjectParam) (width int, height int, err error) {
+	if imgParam.Image != nil {
+		bounds := imgParam.Image.Bounds()
+		return bounds.Dx(), bounds.Dy(), nil
+	}
 
-	switch imgParam.ImgType {
-	case "jpg", "jpeg":
-		imgRef, err = createJPEGImgObject(instance, imgParam.Document, imgParam.FilePath)
-	case "png":
-		// TODO: create png image object
-	default:
-		return "", errors.New("unsupported image type")
+	reader, closeFn, err := openImg(imgParam)
+	if err != nil {
+		return 0, 0, err
 	}
+	defer closeFn()
 
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return 0, 0, fmt.Errorf("decode image config failed: %w", err)
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return 0, 0, errors.New("image is empty")
+	}
+	return config.Width, config.Height, nil
+}
+
+// openImg returns the encoded image of the param, from Data or FilePath.
+func openImg(imgParam *ImageObjectParam) (io.Reader, func(), error) {
+	if imgParam.Data != nil {
+		return bytes.NewReader(imgParam.Data), func() {}, nil
+	}
+	file, err := os.Open(imgParam.FilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
+
+// detectImgType returns the image type from the header of the encoded image.
+func detectImgType(imgParam *ImageObjectParam) (string, error) {
+	reader, closeFn, err := openImg(imgParam)
 	if err != nil {
 		return "", err
 	}
+	defer closeFn()
 
-	// set matrix
-	_, err = instance.FPDFImageObj_SetMatrix(&requests.FPDFImageObj_SetMatrix{
-		ImageObject: imgRef,
-		Transform: structs.FPDF_FS_MATRIX{
-			A: rect.Right - rect.Left,
-			B: 0,
-			C: 0,
-			D: rect.Top - rect.Bottom,
-			E: rect.Left,
-			F: rect.Bottom,
-		},
+	_, format, err := image.DecodeConfig(reader)
+	if err != nil {
+		return "", fmt.Errorf("detect image type failed: %w", err)
+	}
+	return format, nil
+}
+
+// decodeImg decodes the image of the param, gif images decode to their first frame.
+func decodeImg(imgParam *ImageObjectParam) (image.Image, error) {
+	reader, closeFn, err := openImg(imgParam)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	img, _, err := image.Decode(reader)
+	if err != nil {
+		return nil, fmt.Errorf("decode image failed: %w", err)
+	}
+	return img, nil
+}
+
+// encodeImgDataURL returns the image as the data url of a png.
+func encodeImgDataURL(img image.Image) (string, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return "", err
+	}
+	return pngDataURLPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeImgDataURL decodes the data url of a png, jpeg or gif image, or its base64 data without the prefix.
+func decodeImgDataURL(dataURL string) (image.Image, error) {
+	dataURL = strings.TrimSpace(dataURL)
+	if strings.HasPrefix(dataURL, "data:") {
+		_, data, ok := strings.Cut(dataURL, ",")
+		if !ok {
+			return nil, errors.New("invalid data url")
+		}
+		dataURL = data
+	}
+	data, err := base64.StdEncoding.DecodeString(dataURL)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImg(&ImageObjectParam{Data: data})
+}
+
+// createJPEGImgObject creates a jpeg image object from the file, or from data when it is set.
+func createJPEGImgObject(instance pdfium.Pdfium, doc references.FPDF_DOCUMENT, filePath string, data []byte) (references.FPDF_PAGEOBJECT, error) {
+	// create image object
+	imgRef, err := instance.FPDFPageObj_NewImageObj(&requests.FPDFPageObj_NewImageObj{
+		Document: doc,
 	})
 	if err != nil {
 		return "", err
 	}
 
-	return imgRef, nil
+	// load jpeg
+	if data != nil {
+		_, err = instance.FPDFImageObj_LoadJpegFileInline(&requests.FPDFImageObj_LoadJpegFileInline{
+			ImageObject: imgRef.PageObject,
+			FileData:    data,
+		})
+	} else {
+		_, err = instance.FPDFImageObj_LoadJpegFile(&requests.FPDFImageObj_LoadJpegFile{
+			ImageObject: imgRef.PageObject,
+			FilePath:    filePath,
+		})
+	}
+	if err != nil {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: imgRef.PageObject,
+		})
+		return "", err
+	}
+
+	return imgRef.PageObject, nil
 }
 
-// createJPEGImgObject creates a jpeg image object.
-func createJPEGImgObject(instance pdfium.Pdfium, doc references.FPDF_DOCUMENT, filePath string) (references.FPDF_PAGEOBJECT, error) {
+// createBitmapImgObject creates an image object from the pixels of img, pdfium stores
+// the alpha channel as the soft mask of the image.
+func createBitmapImgObject(instance pdfium.Pdfium, doc references.FPDF_DOCUMENT, img image.Image) (references.FPDF_PAGEOBJECT, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return "", errors.New("image is empty")
+	}
+
+	// create bitmap
+	bitmapRes, err := instance.FPDFBitmap_Create(&requests.FPDFBitmap_Create{
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+		Alpha:  1,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+		Bitmap: bitmapRes.Bitmap,
+	})
+
+	// fill pixels
+	err = fillBitmap(instance, bitmapRes.Bitmap, img)
+	if err != nil {
+		return "", err
+	}
+
 	// create image object
 	imgRef, err := instance.FPDFPageObj_NewImageObj(&requests.FPDFPageObj_NewImageObj{
 		Document: doc,
@@ -93,14 +311,190 @@ func createJPEGImgObject(instance pdfium.Pdfium, doc references.FPDF_DOCUMENT, f
 		return "", err
 	}
 
-	// load jpeg file
-	_, err = instance.FPDFImageObj_LoadJpegFile(&requests.FPDFImageObj_LoadJpegFile{
+	// set bitmap
+	_, err = instance.FPDFImageObj_SetBitmap(&requests.FPDFImageObj_SetBitmap{
 		ImageObject: imgRef.PageObject,
-		FilePath:    filePath,
+		Bitmap:      bitmapRes.Bitmap,
 	})
 	if err != nil {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: imgRef.PageObject,
+		})
 		return "", err
 	}
 
 	return imgRef.PageObject, nil
 }
+
+// bitmapImage copies the pixels of a bitmap into an image.
+func bitmapImage(instance pdfium.Pdfium, bitmap references.FPDF_BITMAP) (*image.NRGBA, error) {
+	widthRes, err := instance.FPDFBitmap_GetWidth(&requests.FPDFBitmap_GetWidth{
+		Bitmap: bitmap,
+	})
+	if err != nil {
+		return nil, err
+	}
+	heightRes, err := instance.FPDFBitmap_GetHeight(&requests.FPDFBitmap_GetHeight{
+		Bitmap: bitmap,
+	})
+	if err != nil {
+		return nil, err
+	}
+	strideRes, err := instance.FPDFBitmap_GetStride(&requests.FPDFBitmap_GetStride{
+		Bitmap: bitmap,
+	})
+	if err != nil {
+		return nil, err
+	}
+	formatRes, err := instance.FPDFBitmap_GetFormat(&requests.FPDFBitmap_GetFormat{
+		Bitmap: bitmap,
+	})
+	if err != nil {
+		return nil, err
+	}
+	bufferRes, err := instance.FPDFBitmap_GetBuffer(&requests.FPDFBitmap_GetBuffer{
+		Bitmap: bitmap,
+	})
+	if err != nil {
+		return nil, err
+	}
+	buffer := bufferRes.Buffer
+	if len(buffer) < strideRes.Stride*heightRes.Height {
+		return nil, errors.New("bitmap buffer is too small")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, widthRes.Width, heightRes.Height))
+	for y := 0; y < heightRes.Height; y++ {
+		row := buffer[y*strideRes.Stride:]
+		for x := 0; x < widthRes.Width; x++ {
+			var c color.NRGBA
+			switch formatRes.Format {
+			case enums.FPDF_BITMAP_FORMAT_GRAY:
+				c = color.NRGBA{R: row[x], G: row[x], B: row[x], A: 255}
+			case enums.FPDF_BITMAP_FORMAT_BGR:
+				c = color.NRGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 255}
+			case enums.FPDF_BITMAP_FORMAT_BGRX:
+				c = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: 255}
+			case enums.FPDF_BITMAP_FORMAT_BGRA:
+				c = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+			default:
+				return nil, fmt.Errorf("unsupported bitmap format %d", formatRes.Format)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img, nil
+}
+
+// liveBitmapBufferRuntimes are the packages of the runtimes whose FPDFBitmap_GetBuffer returns the memory of
+// the bitmap, the multi-threaded runtime returns a copy.
+var liveBitmapBufferRuntimes = map[string]bool{
+	"github.com/klippa-app/go-pdfium/single_threaded": true,
+	"github.com/klippa-app/go-pdfium/webassembly":     true,
+}
+
+// hasLiveBitmapBuffer reports whether the buffer of FPDFBitmap_GetBuffer is the memory of the bitmap,
+// by the package of the runtime of the instance.
+func hasLiveBitmapBuffer(instance pdfium.Pdfium) bool {
+	t := reflect.TypeOf(instance)
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return liveBitmapBufferRuntimes[t.PkgPath()]
+}
+
+// fillBitmap copies the pixels of img into the bitmap, a BGRA bitmap of the same size.
+// ps: the pixels are written into the buffer of FPDFBitmap_GetBuffer with the single-threaded and webassembly
+// runtimes, other instances, e.g. the multi-threaded runtime or a wrapper, write them with fillBitmapRuns.
+func fillBitmap(instance pdfium.Pdfium, bitmap references.FPDF_BITMAP, img image.Image) error {
+	if !hasLiveBitmapBuffer(instance) {
+		return fillBitmapRuns(instance, bitmap, img)
+	}
+
+	bounds := img.Bounds()
+	strideRes, err := instance.FPDFBitmap_GetStride(&requests.FPDFBitmap_GetStride{
+		Bitmap: bitmap,
+	})
+	if err != nil {
+		return err
+	}
+	bufferRes, err := instance.FPDFBitmap_GetBuffer(&requests.FPDFBitmap_GetBuffer{
+		Bitmap: bitmap,
+	})
+	if err != nil {
+		return err
+	}
+	buffer := bufferRes.Buffer
+	if len(buffer) < strideRes.Stride*bounds.Dy() {
+		return errors.New("bitmap buffer is too small")
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := buffer[(y-bounds.Min.Y)*strideRes.Stride:]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			i := (x - bounds.Min.X) * 4
+			row[i], row[i+1], row[i+2], row[i+3] = c.B, c.G, c.R, c.A
+		}
+	}
+	return nil
+}
+
+// fillBitmapRuns copies the pixels of img into the bitmap with FPDFBitmap_FillRect, one call per run of
+// equal pixels in a row.
+func fillBitmapRuns(instance pdfium.Pdfium, bitmap references.FPDF_BITMAP, img image.Image) error {
+	bounds := img.Bounds()
+
+	// the bitmap starts fully transparent, transparent pixels are skipped below
+	_, err := instance.FPDFBitmap_FillRect(&requests.FPDFBitmap_FillRect{
+		Bitmap: bitmap,
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+		Color:  0,
+	})
+	if err != nil {
+		return err
+	}
+
+	argb := func(x, y int) uint64 {
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		if c.A == 0 {
+			return 0
+		}
+		return uint64(c.A)<<24 | uint64(c.R)<<16 | uint64(c.G)<<8 | uint64(c.B)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		start := bounds.Min.X
+		startColor := argb(start, y)
+		for x := bounds.Min.X + 1; x <= bounds.Max.X; x++ {
+			var c uint64
+			if x < bounds.Max.X {
+				c = argb(x, y)
+				if c == startColor {
+					continue
+				}
+			}
+
+			// write the finished run
+			if startColor != 0 {
+				_, err = instance.FPDFBitmap_FillRect(&requests.FPDFBitmap_FillRect{
+					Bitmap: bitmap,
+					Left:   start - bounds.Min.X,
+					Top:    y - bounds.Min.Y,
+					Width:  x - start,
+					Height: 1,
+					Color:  startColor,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			start, startColor = x, c
+		}
+	}
+	return nil
+}
@@ -1,24 +1,337 @@
 package annotation
 
 import (
+	"errors"
+	"math"
+
 	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
 	"github.com/klippa-app/go-pdfium/references"
 	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
 )
 
+// bezierCircleKappa is the distance of the control points of a bezier quarter circle, relative to the radius.
+const bezierCircleKappa = 0.5523
+
+// textLayout is the text object placed in a rect: the text is laid out around the origin
+// and the matrix rotates and moves it to the center of the rect.
+type textLayout struct {
+	fontSize float32
+	width    float32 // width of the text
+	ascent   float32
+	descent  float32
+	padding  float32 // space between the text and the border
+	opacity  uint8   // alpha of the text, 0 draws it opaque
+	matrix   structs.FPDF_FS_MATRIX
+}
+
+// CreateTextObjects creates the text object, and the border frame object when TextObjectParam.BorderWidth is set,
+// centered and rotated in the rect. Without a font size the text is sized to fill the rect.
+func CreateTextObjects(instance pdfium.Pdfium, rect Rect, textParam *TextObjectParam) ([]references.FPDF_PAGEOBJECT, error) {
+	if textParam.Text == "" {
+		return nil, errors.New("text must be set")
+	}
+
+	font, closeFont, err := textFont(instance, textParam)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFont()
+
+	layout, err := layoutText(font, rect, textParam)
+	if err != nil {
+		return nil, err
+	}
+
+	var objRefs []references.FPDF_PAGEOBJECT
+	if textParam.BorderWidth > 0 {
+		frameRef, err := createTextFrameObject(instance, layout, textParam)
+		if err != nil {
+			return nil, err
+		}
+		objRefs = append(objRefs, frameRef)
+	}
+
+	textRef, err := createTextObject(instance, font, layout, textParam)
+	if err != nil {
+		for _, objRef := range objRefs {
+			instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+				PageObject: objRef,
+			})
+		}
+		return nil, err
+	}
+	return append(objRefs, textRef), nil
+}
+
+// CreateTextObject creates the text object of textParam with its baseline starting at the origin,
+// move it with FPDFPageObj_Transform. The font size must be set, the border is not drawn, see CreateTextObjects.
 func CreateTextObject(instance pdfium.Pdfium, textParam *TextObjectParam) (references.FPDF_PAGEOBJECT, error) {
+	if textParam.Text == "" {
+		return "", errors.New("text must be set")
+	}
+	if textParam.FontSize <= 0 {
+		return "", errors.New("font size must be set")
+	}
+
+	font, closeFont, err := textFont(instance, textParam)
+	if err != nil {
+		return "", err
+	}
+	defer closeFont()
 
+	// a layout without size keeps the baseline at the origin
+	return createTextObject(instance, font, &textLayout{
+		fontSize: textParam.FontSize,
+		matrix:   structs.FPDF_FS_MATRIX{A: 1, D: 1},
+	}, textParam)
+}
+
+// textFont returns the font of textParam, helvetica when no font is given, and the func closing the loaded helvetica.
+func textFont(instance pdfium.Pdfium, textParam *TextObjectParam) (*Font, func(), error) {
+	if textParam.Font != nil {
+		return textParam.Font, func() {}, nil
+	}
+	font, err := LoadStandardFont(instance, textParam.Document, DefaultFontName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return font, func() { font.Close() }, nil
+}
+
+// layoutText sizes the text and computes the matrix placing it in the center of the rect.
+func layoutText(font *Font, rect Rect, textParam *TextObjectParam) (*textLayout, error) {
+	// measure at size 1, everything but the border width scales with the font size
+	width, err := font.TextWidth(textParam.Text, 1)
+	if err != nil {
+		return nil, err
+	}
+	ascent, err := font.Ascent(1)
+	if err != nil {
+		return nil, err
+	}
+	descent, err := font.Descent(1)
+	if err != nil {
+		return nil, err
+	}
+	padding := float32(0)
+	if textParam.BorderWidth > 0 {
+		padding = 0.3
+	}
+
+	rad := float64(textParam.Rotation) * math.Pi / 180
+	sin, cos := float32(math.Sin(rad)), float32(math.Cos(rad))
+
+	fontSize := textParam.FontSize
+	if fontSize <= 0 {
+		// fit the rotated box of the text into the rect
+		boxWidth := width + 2*padding
+		boxHeight := ascent - descent + 2*padding
+		rotatedWidth := boxWidth*abs32(cos) + boxHeight*abs32(sin)
+		rotatedHeight := boxWidth*abs32(sin) + boxHeight*abs32(cos)
+		fontSize = min((rect.Right-rect.Left-2*textParam.BorderWidth)/rotatedWidth, (rect.Top-rect.Bottom-2*textParam.BorderWidth)/rotatedHeight)
+		if fontSize <= 0 {
+			return nil, errors.New("rect is too small for the text")
+		}
+	}
+
+	layout := &textLayout{
+		fontSize: fontSize,
+		width:    width * fontSize,
+		ascent:   ascent * fontSize,
+		descent:  descent * fontSize,
+		padding:  padding * fontSize,
+	}
+
+	// rotate around the origin, then move the origin to the center of the rect
+	layout.matrix = structs.FPDF_FS_MATRIX{
+		A: cos,
+		B: sin,
+		C: -sin,
+		D: cos,
+		E: (rect.Left + rect.Right) / 2,
+		F: (rect.Bottom + rect.Top) / 2,
+	}
+	return layout, nil
+}
+
+// createTextObject creates the text object with its baseline placed so the text is centered on the origin.
+func createTextObject(instance pdfium.Pdfium, font *Font, layout *textLayout, textParam *TextObjectParam) (references.FPDF_PAGEOBJECT, error) {
 	// create text object
 	textRef, err := instance.FPDFPageObj_CreateTextObj(&requests.FPDFPageObj_CreateTextObj{
-		// Document: nil,
-		// Font:     nil,
-		FontSize: textParam.FontSize,
+		Document: font.document,
+		Font:     font.font,
+		FontSize: layout.fontSize,
 	})
 	if err != nil {
 		return "", err
 	}
 
+	destroy := func(err error) (references.FPDF_PAGEOBJECT, error) {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: textRef.PageObject,
+		})
+		return "", err
+	}
+
 	// set text
+	_, err = instance.FPDFText_SetText(&requests.FPDFText_SetText{
+		PageObject: textRef.PageObject,
+		Text:       textParam.Text,
+	})
+	if err != nil {
+		return destroy(err)
+	}
+
+	// set text color
+	alpha := uint(255)
+	if layout.opacity != 0 {
+		alpha = uint(layout.opacity)
+	}
+	_, err = instance.FPDFPageObj_SetFillColor(&requests.FPDFPageObj_SetFillColor{
+		PageObject: textRef.PageObject,
+		FillColor: structs.FPDF_COLOR{
+			R: uint(textParam.Color.R),
+			G: uint(textParam.Color.G),
+			B: uint(textParam.Color.B),
+			A: alpha,
+		},
+	})
+	if err != nil {
+		return destroy(err)
+	}
+
+	// center the text on the origin, then rotate and move it into the rect
+	_, err = instance.FPDFPageObj_Transform(&requests.FPDFPageObj_Transform{
+		PageObject: textRef.PageObject,
+		Transform: structs.FPDF_FS_MATRIX{
+			A: 1,
+			D: 1,
+			E: -layout.width / 2,
+			F: -(layout.ascent + layout.descent) / 2,
+		},
+	})
+	if err != nil {
+		return destroy(err)
+	}
+	_, err = instance.FPDFPageObj_Transform(&requests.FPDFPageObj_Transform{
+		PageObject: textRef.PageObject,
+		Transform:  layout.matrix,
+	})
+	if err != nil {
+		return destroy(err)
+	}
 
 	return textRef.PageObject, nil
 }
+
+// createTextFrameObject creates the border around the text, with rounded corners when TextObjectParam.BorderRadius is set.
+func createTextFrameObject(instance pdfium.Pdfium, layout *textLayout, textParam *TextObjectParam) (references.FPDF_PAGEOBJECT, error) {
+	// the frame centered on the origin, the stroke is inside of it
+	halfWidth := layout.width/2 + layout.padding + textParam.BorderWidth/2
+	halfHeight := (layout.ascent-layout.descent)/2 + layout.padding + textParam.BorderWidth/2
+	radius := min(textParam.BorderRadius, min(halfWidth, halfHeight))
+	k := radius * (1 - bezierCircleKappa)
+
+	objRef, err := instance.FPDFPageObj_CreateNewPath(&requests.FPDFPageObj_CreateNewPath{
+		X: -halfWidth + radius,
+		Y: -halfHeight,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	destroy := func(err error) (references.FPDF_PAGEOBJECT, error) {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: objRef.PageObject,
+		})
+		return "", err
+	}
+
+	// counterclockwise from the bottom left corner: each side, then its corner
+	corners := [4][2]float32{{halfWidth, -halfHeight}, {halfWidth, halfHeight}, {-halfWidth, halfHeight}, {-halfWidth, -halfHeight}}
+	for i, c := range corners {
+		// direction of the side ending at this corner, and of the next side
+		var inX, inY, outX, outY float32
+		switch i {
+		case 0:
+			inX, outY = 1, 1
+		case 1:
+			inY, outX = 1, -1
+		case 2:
+			inX, outY = -1, -1
+		case 3:
+			inY, outX = -1, 1
+		}
+
+		_, err = instance.FPDFPath_LineTo(&requests.FPDFPath_LineTo{
+			PageObject: objRef.PageObject,
+			X:          c[0] - inX*radius,
+			Y:          c[1] - inY*radius,
+		})
+		if err != nil {
+			return destroy(err)
+		}
+		if radius > 0 {
+			_, err = instance.FPDFPath_BezierTo(&requests.FPDFPath_BezierTo{
+				PageObject: objRef.PageObject,
+				X1:         c[0] - inX*k,
+				Y1:         c[1] - inY*k,
+				X2:         c[0] + outX*k,
+				Y2:         c[1] + outY*k,
+				X3:         c[0] + outX*radius,
+				Y3:         c[1] + outY*radius,
+			})
+			if err != nil {
+				return destroy(err)
+			}
+		}
+	}
+	_, err = instance.FPDFPath_Close(&requests.FPDFPath_Close{
+		PageObject: objRef.PageObject,
+	})
+	if err != nil {
+		return destroy(err)
+	}
+
+	// stroke with the text color
+	_, err = instance.FPDFPageObj_SetStrokeColor(&requests.FPDFPageObj_SetStrokeColor{
+		PageObject: objRef.PageObject,
+		StrokeColor: structs.FPDF_COLOR{
+			R: uint(textParam.Color.R),
+			G: uint(textParam.Color.G),
+			B: uint(textParam.Color.B),
+			A: 255,
+		},
+	})
+	if err != nil {
+		return destroy(err)
+	}
+	_, err = instance.FPDFPageObj_SetStrokeWidth(&requests.FPDFPageObj_SetStrokeWidth{
+		PageObject:  objRef.PageObject,
+		StrokeWidth: textParam.BorderWidth,
+	})
+	if err != nil {
+		return destroy(err)
+	}
+	_, err = instance.FPDFPath_SetDrawMode(&requests.FPDFPath_SetDrawMode{
+		PageObject: objRef.PageObject,
+		FillMode:   enums.FPDF_FILLMODE_NONE,
+		Stroke:     true,
+	})
+	if err != nil {
+		return destroy(err)
+	}
+
+	// rotate and move the frame into the rect
+	_, err = instance.FPDFPageObj_Transform(&requests.FPDFPageObj_Transform{
+		PageObject: objRef.PageObject,
+		Transform:  layout.matrix,
+	})
+	if err != nil {
+		return destroy(err)
+	}
+
+	return objRef.PageObject, nil
+}
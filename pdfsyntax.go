@@ -0,0 +1,590 @@
+// pdf 语法
+package annotation
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdf objects read by pdfParser: bool, float64, string, pdfName, []any, pdfDict, *pdfStream, pdfRef and nil.
+// Strings are pdfRawString instead of string when the parser keeps them raw.
+type (
+	pdfName      string
+	pdfRawString string // the bytes of a string, not decoded as text
+	pdfDict      map[string]any
+	pdfRef       struct {
+		num, gen int
+	}
+	pdfStream struct {
+		dict pdfDict
+		data []byte
+	}
+)
+
+var errPDFSyntax = errors.New("pdf syntax error")
+
+// pdfParser reads pdf objects from the body of a pdf or fdf file, cross-reference tables are not needed.
+type pdfParser struct {
+	data []byte
+	pos  int
+
+	raw      bool                                    // keep strings as pdfRawString, so binary strings are written back unchanged
+	onObject func(num, gen, start, end int, obj any) // called with the span of each indirect object read by parseBody
+	onDict   func(start, end int, dict pdfDict)      // called with the span of each dictionary
+
+	compressed map[int]bool // objects parseBody read from object streams, they have no span in the file
+}
+
+// parseBody reads the indirect objects and the trailer of a file. The objects of object streams are read too,
+// and the dictionary of a cross-reference stream is the trailer unless a trailer follows it.
+// ps: the callbacks are not called for the objects of object streams.
+func (p *pdfParser) parseBody() (map[int]any, pdfDict, error) {
+	objects := map[int]any{}
+	var trailer pdfDict
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			break
+		}
+		switch {
+		case p.hasKeyword("trailer"):
+			p.pos += len("trailer")
+			obj, err := p.parseObject()
+			if err != nil {
+				return nil, nil, err
+			}
+			if dict, ok := obj.(pdfDict); ok {
+				trailer = dict
+			}
+		case p.hasKeyword("xref"):
+			// the objects are found by scanning, skip the table up to the trailer
+			next := bytes.Index(p.data[p.pos+1:], []byte("trailer"))
+			if next == -1 {
+				p.pos = len(p.data)
+			} else {
+				p.pos += next + 1
+			}
+		case p.hasKeyword("startxref"):
+			// an incremental update may follow the offset
+			p.pos += len("startxref")
+			p.skipSpace()
+			_, err := p.parseInt()
+			if err != nil {
+				return nil, nil, err
+			}
+		case p.data[p.pos] >= '0' && p.data[p.pos] <= '9':
+			num, err := p.parseInt()
+			if err != nil {
+				return nil, nil, err
+			}
+			p.skipSpace()
+			gen, err := p.parseInt()
+			if err != nil {
+				return nil, nil, err
+			}
+			p.skipSpace()
+			if !p.hasKeyword("obj") {
+				return nil, nil, fmt.Errorf("%w: expect obj at %d", errPDFSyntax, p.pos)
+			}
+			p.pos += len("obj")
+			start := p.pos
+			obj, err := p.parseObject()
+			if err != nil {
+				return nil, nil, err
+			}
+			if p.onObject != nil {
+				p.onObject(num, gen, start, p.pos, obj)
+			}
+			p.skipSpace()
+			if p.hasKeyword("endobj") {
+				p.pos += len("endobj")
+			}
+			objects[num] = obj
+			delete(p.compressed, num)
+			if stream, ok := obj.(*pdfStream); ok {
+				switch stream.dict["Type"] {
+				case pdfName("ObjStm"):
+					err = p.parseObjectStream(objects, stream)
+					if err != nil {
+						return nil, nil, fmt.Errorf("object stream %d: %w", num, err)
+					}
+				case pdfName("XRef"):
+					trailer = stream.dict
+				}
+			}
+		default:
+			return nil, nil, fmt.Errorf("%w: unexpected %q at %d", errPDFSyntax, p.data[p.pos], p.pos)
+		}
+	}
+	return objects, trailer, nil
+}
+
+// parseObjectStream reads the objects compressed in an object stream.
+func (p *pdfParser) parseObjectStream(objects map[int]any, stream *pdfStream) error {
+	count, _ := resolvePDFObject(objects, stream.dict["N"]).(float64)
+	first, _ := resolvePDFObject(objects, stream.dict["First"]).(float64)
+	data, err := decodePDFStream(stream)
+	if err != nil {
+		return err
+	}
+	if first < 0 || int(first) > len(data) {
+		return fmt.Errorf("%w: /First %v is out of the stream", errPDFSyntax, first)
+	}
+	header := &pdfParser{data: data[:int(first)]}
+	for i := 0; i < int(count); i++ {
+		header.skipSpace()
+		num, err := header.parseInt()
+		if err != nil {
+			return fmt.Errorf("%w: invalid object number at %d", errPDFSyntax, header.pos)
+		}
+		header.skipSpace()
+		offset, err := header.parseInt()
+		if err != nil || int(first)+offset > len(data) {
+			return fmt.Errorf("%w: invalid offset of object %d", errPDFSyntax, num)
+		}
+		obj, err := (&pdfParser{data: data[int(first)+offset:], raw: p.raw}).parseObject()
+		if err != nil {
+			return err
+		}
+		objects[num] = obj
+		if p.compressed == nil {
+			p.compressed = map[int]bool{}
+		}
+		p.compressed[num] = true
+	}
+	return nil
+}
+
+// parseObject reads the next direct object, a dictionary followed by stream data is read as a stream.
+func (p *pdfParser) parseObject() (any, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, fmt.Errorf("%w: unexpected end", errPDFSyntax)
+	}
+
+	switch c := p.data[p.pos]; {
+	case bytes.HasPrefix(p.data[p.pos:], []byte("<<")):
+		dict, err := p.parseDict()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.hasKeyword("stream") {
+			return p.parseStream(dict)
+		}
+		return dict, nil
+	case c == '<':
+		raw, err := p.parseHexBytes()
+		if err != nil || p.raw {
+			return pdfRawString(raw), err
+		}
+		return decodePDFText(raw), nil
+	case c == '(':
+		raw, err := p.parseLiteralBytes()
+		if err != nil || p.raw {
+			return pdfRawString(raw), err
+		}
+		return decodePDFText(raw), nil
+	case c == '/':
+		return p.parseName(), nil
+	case c == '[':
+		p.pos++
+		var array []any
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return nil, fmt.Errorf("%w: unclosed array", errPDFSyntax)
+			}
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return array, nil
+			}
+			obj, err := p.parseObject()
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, obj)
+		}
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.parseNumberOrRef()
+	case p.hasKeyword("true"):
+		p.pos += len("true")
+		return true, nil
+	case p.hasKeyword("false"):
+		p.pos += len("false")
+		return false, nil
+	case p.hasKeyword("null"):
+		p.pos += len("null")
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected %q at %d", errPDFSyntax, p.data[p.pos], p.pos)
+}
+
+func (p *pdfParser) parseDict() (pdfDict, error) {
+	start := p.pos
+	p.pos += 2
+	dict := pdfDict{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("%w: unclosed dictionary", errPDFSyntax)
+		}
+		if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+			p.pos += 2
+			if p.onDict != nil {
+				p.onDict(start, p.pos, dict)
+			}
+			return dict, nil
+		}
+		if p.data[p.pos] != '/' {
+			return nil, fmt.Errorf("%w: expect a name key at %d", errPDFSyntax, p.pos)
+		}
+		key := p.parseName()
+		value, err := p.parseObject()
+		if err != nil {
+			return nil, err
+		}
+		dict[string(key)] = value
+	}
+}
+
+// parseStream reads the data after the stream keyword, /Length is trusted when it is a direct number that ends at endstream.
+func (p *pdfParser) parseStream(dict pdfDict) (*pdfStream, error) {
+	p.pos += len("stream")
+	if bytes.HasPrefix(p.data[p.pos:], []byte("\r\n")) {
+		p.pos += 2
+	} else if p.pos < len(p.data) && (p.data[p.pos] == '\n' || p.data[p.pos] == '\r') {
+		p.pos++
+	}
+
+	start := p.pos
+	end := -1
+	if length, ok := dict["Length"].(float64); ok && start+int(length) <= len(p.data) {
+		rest := bytes.TrimLeft(p.data[start+int(length):], " \r\n")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			end = start + int(length)
+		}
+	}
+	if end == -1 {
+		i := bytes.Index(p.data[start:], []byte("endstream"))
+		if i == -1 {
+			return nil, fmt.Errorf("%w: unclosed stream", errPDFSyntax)
+		}
+		end = start + len(bytes.TrimRight(p.data[start:start+i], "\r\n"))
+	}
+
+	stream := &pdfStream{dict: dict, data: p.data[start:end]}
+	p.pos = end
+	p.skipSpace()
+	p.pos += len("endstream")
+	return stream, nil
+}
+
+func (p *pdfParser) parseName() pdfName {
+	p.pos++
+	var name []byte
+	for p.pos < len(p.data) && !isPDFDelimiter(p.data[p.pos]) && !isPDFSpace(p.data[p.pos]) {
+		c := p.data[p.pos]
+		if c == '#' && p.pos+2 < len(p.data) {
+			if v, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				name = append(name, byte(v))
+				p.pos += 3
+				continue
+			}
+		}
+		name = append(name, c)
+		p.pos++
+	}
+	return pdfName(name)
+}
+
+func (p *pdfParser) parseHexBytes() ([]byte, error) {
+	end := bytes.IndexByte(p.data[p.pos:], '>')
+	if end == -1 {
+		return nil, fmt.Errorf("%w: unclosed hex string", errPDFSyntax)
+	}
+	hex := strings.Join(strings.Fields(string(p.data[p.pos+1:p.pos+end])), "")
+	p.pos += end + 1
+	if len(hex)%2 == 1 {
+		hex += "0"
+	}
+	raw := make([]byte, 0, len(hex)/2)
+	for i := 0; i < len(hex); i += 2 {
+		v, err := strconv.ParseUint(hex[i:i+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid hex string", errPDFSyntax)
+		}
+		raw = append(raw, byte(v))
+	}
+	return raw, nil
+}
+
+func (p *pdfParser) parseLiteralBytes() ([]byte, error) {
+	p.pos++
+	var raw []byte
+	depth := 0
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return raw, nil
+			}
+			depth--
+		case '\\':
+			if p.pos >= len(p.data) {
+				continue
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// line continuation
+				if e == '\r' && p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		raw = append(raw, c)
+	}
+	return nil, fmt.Errorf("%w: unclosed string", errPDFSyntax)
+}
+
+func (p *pdfParser) parseNumberOrRef() (any, error) {
+	start := p.pos
+	for p.pos < len(p.data) && strings.IndexByte("+-.0123456789", p.data[p.pos]) != -1 {
+		p.pos++
+	}
+	number, err := strconv.ParseFloat(string(p.data[start:p.pos]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid number at %d", errPDFSyntax, start)
+	}
+
+	// an integer followed by a generation and R is a reference
+	if number == float64(int(number)) && !bytes.ContainsAny(p.data[start:p.pos], "+-.") {
+		end := p.pos
+		p.skipSpace()
+		if gen, err := p.parseInt(); err == nil {
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == 'R' {
+				p.pos++
+				return pdfRef{num: int(number), gen: gen}, nil
+			}
+		}
+		p.pos = end
+	}
+	return number, nil
+}
+
+func (p *pdfParser) parseInt() (int, error) {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+		p.pos++
+	}
+	return strconv.Atoi(string(p.data[start:p.pos]))
+}
+
+// skipSpace skips white space and comments.
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '%' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		if !isPDFSpace(c) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// hasKeyword reports whether the keyword starts at the current position and is not the start of a longer word.
+func (p *pdfParser) hasKeyword(keyword string) bool {
+	if !bytes.HasPrefix(p.data[p.pos:], []byte(keyword)) {
+		return false
+	}
+	end := p.pos + len(keyword)
+	return end == len(p.data) || isPDFSpace(p.data[end]) || isPDFDelimiter(p.data[end])
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) != -1
+}
+
+// decodePDFText decodes a text string, UTF-16BE with a byte order mark or PDFDocEncoding read as Latin-1.
+func decodePDFText(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	if len(raw) >= 3 && raw[0] == 0xEF && raw[1] == 0xBB && raw[2] == 0xBF {
+		return string(raw[3:])
+	}
+	runes := make([]rune, 0, len(raw))
+	for _, c := range raw {
+		runes = append(runes, rune(c))
+	}
+	return string(runes)
+}
+
+// resolvePDFObject follows references, a missing object is null.
+func resolvePDFObject(objects map[int]any, obj any) any {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = objects[ref.num]
+	}
+	return nil
+}
+
+// decodePDFStream returns the data of a stream without filter or with /FlateDecode.
+func decodePDFStream(stream *pdfStream) ([]byte, error) {
+	switch filter := stream.dict["Filter"].(type) {
+	case nil:
+		return stream.data, nil
+	case pdfName:
+		if filter == "FlateDecode" {
+			r, err := zlib.NewReader(bytes.NewReader(stream.data))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		}
+	case []any:
+		if len(filter) == 0 {
+			return stream.data, nil
+		}
+		if len(filter) == 1 {
+			return decodePDFStream(&pdfStream{dict: pdfDict{"Filter": filter[0]}, data: stream.data})
+		}
+	}
+	return nil, fmt.Errorf("stream filter %v not supported", stream.dict["Filter"])
+}
+
+// formatPDFObject writes a direct object in pdf syntax and leaves streams out.
+// References are resolved with objects, or written as references when objects is nil.
+func formatPDFObject(objects map[int]any, obj any) string {
+	if ref, ok := obj.(pdfRef); ok && objects == nil {
+		return fmt.Sprintf("%d %d R", ref.num, ref.gen)
+	}
+	switch v := resolvePDFObject(objects, obj).(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return formatPDFString(v)
+	case pdfRawString:
+		return fmt.Sprintf("<%X>", string(v))
+	case pdfName:
+		return formatPDFName(string(v))
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, formatPDFObject(objects, item))
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case pdfDict:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var sb strings.Builder
+		sb.WriteString("<<")
+		for _, key := range keys {
+			sb.WriteString(formatPDFName(key) + " " + formatPDFObject(objects, v[key]))
+		}
+		sb.WriteString(">>")
+		return sb.String()
+	}
+	return "null"
+}
+
+// formatPDFString writes a text string, as a literal string when it is ASCII and as UTF-16BE otherwise.
+func formatPDFString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r > 0x7E || (r < 0x20 && r != '\n' && r != '\r' && r != '\t') {
+			ascii = false
+			break
+		}
+	}
+	if !ascii {
+		var sb strings.Builder
+		sb.WriteString("<FEFF")
+		for _, unit := range utf16.Encode([]rune(s)) {
+			fmt.Fprintf(&sb, "%04X", unit)
+		}
+		sb.WriteString(">")
+		return sb.String()
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return "(" + replacer.Replace(s) + ")"
+}
+
+// formatPDFName writes a name, escaping delimiters, white space and non-ASCII bytes.
+func formatPDFName(name string) string {
+	var sb strings.Builder
+	sb.WriteString("/")
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 0x21 || c > 0x7E || c == '#' || isPDFDelimiter(c) {
+			fmt.Fprintf(&sb, "#%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// parsePDFValue reads one direct object written in pdf syntax.
+func parsePDFValue(s string) (any, error) {
+	p := &pdfParser{data: []byte(s)}
+	return p.parseObject()
+}
@@ -0,0 +1,157 @@
+// 多边形
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// PolygonAnnotation is a closed shape through its vertices, filled with the fill color and stroked with the strike color.
+// When no rect is set, it is computed from the vertices when the appearance is generated.
+// ps: pdfium can not create polygons, they are added as stamps and SavePDF writes their /Subtype and /Vertices.
+type PolygonAnnotation struct {
+	BaseAnnotation
+	LineStyle
+	BorderStyle
+	Vertices []Point
+}
+
+func NewPolygonAnnotation() *PolygonAnnotation {
+	return &PolygonAnnotation{
+		BaseAnnotation: BaseAnnotation{
+			subtype: enums.FPDF_ANNOT_SUBTYPE_POLYGON,
+			nm:      GenerateUUID(),
+			opacity: DefaultOpacity,
+		},
+		LineStyle: LineStyle{
+			StrikeLineCap:  enums.FPDF_LINECAP_BUTT,
+			StrikeLineJoin: enums.FPDF_LINEJOIN_MITER,
+		},
+	}
+}
+
+func (p *PolygonAnnotation) SetFillColor(c Color) {
+	p.fillColor = &c
+}
+
+func (p *PolygonAnnotation) SetVertices(vertices []Point) {
+	p.Vertices = vertices
+}
+
+// AddVertex appends a vertex to the polygon.
+func (p *PolygonAnnotation) AddVertex(vertex Point) {
+	p.Vertices = append(p.Vertices, vertex)
+}
+
+// MoveVertex moves the vertex at index, out of range indexes are ignored.
+func (p *PolygonAnnotation) MoveVertex(index int, vertex Point) {
+	if index >= 0 && index < len(p.Vertices) {
+		p.Vertices[index] = vertex
+	}
+}
+
+// RemoveVertex removes the vertex at index, out of range indexes are ignored.
+func (p *PolygonAnnotation) RemoveVertex(index int) {
+	if index >= 0 && index < len(p.Vertices) {
+		p.Vertices = append(p.Vertices[:index], p.Vertices[index+1:]...)
+	}
+}
+
+func (p *PolygonAnnotation) GenerateAppearance() error {
+	if len(p.Vertices) > 0 && IsZeroEpsilon(p.rect.Left) && IsZeroEpsilon(p.rect.Bottom) &&
+		IsZeroEpsilon(p.rect.Right) && IsZeroEpsilon(p.rect.Top) {
+		pad := p.width
+		if p.isCloudy() {
+			pad += p.cloudyExtent(p.width)
+		}
+		p.rect = verticesRect(p.Vertices, pad)
+	}
+
+	// generate polygon appearance
+	p.ap = strings.Join([]string{
+		p.GetWidthAP(),
+		p.GetColorAP(),
+		p.GetPDFOpacityAP(),
+		p.GetLineStyleAP(),
+		p.GetDashAP(),
+		p.pointsCallback(),
+	}, "\n")
+
+	return nil
+}
+
+func (p *PolygonAnnotation) pointsCallback() string {
+	if len(p.Vertices) < 2 {
+		return ""
+	}
+	ap := verticesPathAP(p.Vertices) + "h "
+	if p.isCloudy() {
+		ap = cloudyPathAP(p.Vertices, p.cloudyRadius(p.width))
+	}
+	switch {
+	case p.fillColor != nil && p.strikeColor != nil:
+		ap += "B\n"
+	case p.fillColor != nil:
+		ap += "f\n"
+	default:
+		ap += "S\n"
+	}
+	return ap
+}
+
+// verticesPathAP returns the path through the vertices, without painting it.
+func verticesPathAP(vertices []Point) string {
+	var ap string
+	for i, vertex := range vertices {
+		op := "l"
+		if i == 0 {
+			op = "m"
+		}
+		ap += fmt.Sprintf("%.3f %.3f %s ", vertex.X, vertex.Y, op)
+	}
+	return ap
+}
+
+// verticesRect returns the bounding box of the vertices, padded by pad on each side.
+func verticesRect(vertices []Point, pad float32) Rect {
+	rect := Rect{
+		Left:   vertices[0].X,
+		Bottom: vertices[0].Y,
+		Right:  vertices[0].X,
+		Top:    vertices[0].Y,
+	}
+	for _, vertex := range vertices[1:] {
+		rect.Left = min(rect.Left, vertex.X)
+		rect.Bottom = min(rect.Bottom, vertex.Y)
+		rect.Right = max(rect.Right, vertex.X)
+		rect.Top = max(rect.Top, vertex.Y)
+	}
+	rect.Left -= pad
+	rect.Bottom -= pad
+	rect.Right += pad
+	rect.Top += pad
+	return rect
+}
+
+func (p *PolygonAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
+	// create annotation
+	p.stash = extraKeyEntries(p)
+	err := p.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
+	if err != nil {
+		return err
+	}
+
+	// close annotation
+	_, err = instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: p.annot,
+	})
+	if err != nil {
+		return p.newError(StepClose, err)
+	}
+	return p.savePDFWarning()
+}
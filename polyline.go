@@ -0,0 +1,133 @@
+// 折线
+package annotation
+
+import (
+	"context"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// PolylineAnnotation is an open line through its vertices, with optional shapes at both ends.
+// Closed line endings are filled with the fill color. When no rect is set, it is computed from
+// the vertices when the appearance is generated.
+// ps: pdfium can not create polylines, they are added as stamps and SavePDF writes their /Subtype, /Vertices and /LE.
+type PolylineAnnotation struct {
+	BaseAnnotation
+	LineStyle
+	BorderStyle
+	Vertices    []Point
+	LineEndings [2]LineEnding // start, end
+}
+
+func NewPolylineAnnotation() *PolylineAnnotation {
+	return &PolylineAnnotation{
+		BaseAnnotation: BaseAnnotation{
+			subtype: enums.FPDF_ANNOT_SUBTYPE_POLYLINE,
+			nm:      GenerateUUID(),
+			opacity: DefaultOpacity,
+		},
+		LineStyle: LineStyle{
+			StrikeLineCap:  enums.FPDF_LINECAP_BUTT,
+			StrikeLineJoin: enums.FPDF_LINEJOIN_MITER,
+		},
+		LineEndings: [2]LineEnding{LineEndingNone, LineEndingNone},
+	}
+}
+
+// SetFillColor sets the color filling the closed line endings.
+func (p *PolylineAnnotation) SetFillColor(c Color) {
+	p.fillColor = &c
+}
+
+func (p *PolylineAnnotation) SetVertices(vertices []Point) {
+	p.Vertices = vertices
+}
+
+// AddVertex appends a vertex to the polyline.
+func (p *PolylineAnnotation) AddVertex(vertex Point) {
+	p.Vertices = append(p.Vertices, vertex)
+}
+
+// MoveVertex moves the vertex at index, out of range indexes are ignored.
+func (p *PolylineAnnotation) MoveVertex(index int, vertex Point) {
+	if index >= 0 && index < len(p.Vertices) {
+		p.Vertices[index] = vertex
+	}
+}
+
+// RemoveVertex removes the vertex at index, out of range indexes are ignored.
+func (p *PolylineAnnotation) RemoveVertex(index int) {
+	if index >= 0 && index < len(p.Vertices) {
+		p.Vertices = append(p.Vertices[:index], p.Vertices[index+1:]...)
+	}
+}
+
+// SetLineEndings sets the shapes drawn at the first and the last vertex.
+func (p *PolylineAnnotation) SetLineEndings(start, end LineEnding) {
+	p.LineEndings = [2]LineEnding{start, end}
+}
+
+func (p *PolylineAnnotation) GenerateAppearance() error {
+	if len(p.Vertices) > 0 && IsZeroEpsilon(p.rect.Left) && IsZeroEpsilon(p.rect.Bottom) &&
+		IsZeroEpsilon(p.rect.Right) && IsZeroEpsilon(p.rect.Top) {
+		pad := p.width
+		if p.hasLineEndings() {
+			pad += lineEndingSize(p.width)
+		}
+		p.rect = verticesRect(p.Vertices, pad)
+	}
+
+	// generate polyline appearance
+	p.ap = strings.Join([]string{
+		p.GetWidthAP(),
+		p.GetColorAP(),
+		p.GetPDFOpacityAP(),
+		p.GetLineStyleAP(),
+		p.GetDashAP(),
+		p.pointsCallback(),
+	}, "\n")
+
+	return nil
+}
+
+func (p *PolylineAnnotation) hasLineEndings() bool {
+	for _, ending := range p.LineEndings {
+		if ending != "" && ending != LineEndingNone {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PolylineAnnotation) pointsCallback() string {
+	n := len(p.Vertices)
+	if n < 2 {
+		return ""
+	}
+	ap := verticesPathAP(p.Vertices) + "S\n"
+	ap += p.resetDashAP()
+	ap += lineEndingAP(p.LineEndings[0], p.Vertices[0], p.Vertices[1], p.width, p.fillColor != nil)
+	ap += lineEndingAP(p.LineEndings[1], p.Vertices[n-1], p.Vertices[n-2], p.width, p.fillColor != nil)
+	return ap
+}
+
+func (p *PolylineAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
+	// create annotation
+	p.stash = extraKeyEntries(p)
+	err := p.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
+	if err != nil {
+		return err
+	}
+
+	// close annotation
+	_, err = instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: p.annot,
+	})
+	if err != nil {
+		return p.newError(StepClose, err)
+	}
+	return p.savePDFWarning()
+}
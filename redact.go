@@ -0,0 +1,166 @@
+// 涂黑
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+var (
+	DefaultRedactColor     = Color{R: 255, G: 0, B: 0} // outline shown until the redaction is applied
+	DefaultRedactFillColor = Color{R: 0, G: 0, B: 0}   // box painted over the removed content
+)
+
+// RedactAnnotation marks a region for redaction, its rect or the text quads in it.
+// The mark is outlined with the strike color until ApplyRedactions removes the content
+// under it and paints the fill color and the overlay text in its place.
+// ps: pdfium can not create redactions, they are added as stamps and SavePDF writes their /Subtype and /QuadPoints.
+// pdfium has no getter for the quads of a redaction, one from another tool covers its whole rect.
+type RedactAnnotation struct {
+	BaseAnnotation
+	QuadPoints      []QuadPoint
+	OverlayText     string
+	OverlayFontSize float32 // 0 sizes the overlay text to fill the rect
+	FontColor       Color   // color of the overlay text
+}
+
+func NewRedactAnnotation() *RedactAnnotation {
+	return &RedactAnnotation{
+		BaseAnnotation: BaseAnnotation{
+			subtype: enums.FPDF_ANNOT_SUBTYPE_REDACT,
+			nm:      GenerateUUID(),
+			opacity: DefaultOpacity,
+		},
+		FontColor: Color{R: 255, G: 255, B: 255},
+	}
+}
+
+// SetFillColor sets the color of the box painted over the region when the redaction is applied.
+func (r *RedactAnnotation) SetFillColor(c Color) {
+	r.fillColor = &c
+}
+
+// SetOverlayText sets the text drawn on the box when the redaction is applied, e.g. "REDACTED".
+func (r *RedactAnnotation) SetOverlayText(text string, fontSize float32, color Color) {
+	r.OverlayText = text
+	r.OverlayFontSize = fontSize
+	r.FontColor = color
+}
+
+// GetDefaultAppearance returns the /DA string of the overlay text.
+func (r *RedactAnnotation) GetDefaultAppearance() string {
+	return fmt.Sprintf("/%s %d Tf %.3f %.3f %.3f rg", standardFontResourceNames[DefaultFontName], int(r.OverlayFontSize+0.5),
+		float32(r.FontColor.R)/255, float32(r.FontColor.G)/255, float32(r.FontColor.B)/255)
+}
+
+// regions returns the quads to redact, the rect when there are no quads.
+func (r *RedactAnnotation) regions() []QuadPoint {
+	if len(r.QuadPoints) > 0 {
+		return r.QuadPoints
+	}
+	return []QuadPoint{rectQuadPoint(r.rect)}
+}
+
+// GenerateAppearance outlines the regions to redact, the rect is computed from the quads when it is not set.
+func (r *RedactAnnotation) GenerateAppearance() error {
+	if len(r.QuadPoints) > 0 && IsZeroEpsilon(r.rect.Left) && IsZeroEpsilon(r.rect.Bottom) &&
+		IsZeroEpsilon(r.rect.Right) && IsZeroEpsilon(r.rect.Top) {
+		r.rect = quadPointsRect(r.QuadPoints)
+	}
+
+	// the outline is drawn with the strike color only, the fill color is kept for applying
+	strikeColor := r.strikeColor
+	if strikeColor == nil {
+		strikeColor = &DefaultRedactColor
+	}
+	r.ap = strings.Join([]string{
+		r.GetPDFOpacityAP(),
+		r.getColorAP(strikeColor, false),
+		"1.000 w",
+		r.pointsCallback(),
+	}, "\n")
+
+	return nil
+}
+
+func (r *RedactAnnotation) pointsCallback() string {
+	var pointsAP string
+	for _, quad := range r.regions() {
+		pointsAP += fmt.Sprintf("%.3f %.3f m ", quad.LeftTopX, quad.LeftTopY)
+		pointsAP += fmt.Sprintf("%.3f %.3f l ", quad.RightTopX, quad.RightTopY)
+		pointsAP += fmt.Sprintf("%.3f %.3f l ", quad.RightBottomX, quad.RightBottomY)
+		pointsAP += fmt.Sprintf("%.3f %.3f l ", quad.LeftBottomX, quad.LeftBottomY)
+		pointsAP += "h S\n"
+	}
+	return pointsAP
+}
+
+func (r *RedactAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
+	if r.strikeColor == nil {
+		r.strikeColor = &DefaultRedactColor
+	}
+	// create annotation
+	r.stash = extraKeyEntries(r)
+	err := r.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
+	if err != nil {
+		return err
+	}
+
+	// set overlay text
+	if r.OverlayText != "" {
+		_, err = instance.FPDFAnnot_SetStringValue(&requests.FPDFAnnot_SetStringValue{
+			Annotation: r.annot,
+			Key:        "OverlayText",
+			Value:      r.OverlayText,
+		})
+		if err != nil {
+			return r.abort(instance, page, StepSetOverlayText, err)
+		}
+		_, err = instance.FPDFAnnot_SetStringValue(&requests.FPDFAnnot_SetStringValue{
+			Annotation: r.annot,
+			Key:        "DA",
+			Value:      r.GetDefaultAppearance(),
+		})
+		if err != nil {
+			return r.abort(instance, page, StepSetDA, err)
+		}
+	}
+
+	// close annotation
+	_, err = instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: r.annot,
+	})
+	if err != nil {
+		return r.newError(StepClose, err)
+	}
+
+	return r.savePDFWarning()
+}
+
+// rectQuadPoint returns the quad of the corners of the rect.
+func rectQuadPoint(rect Rect) QuadPoint {
+	return QuadPoint{
+		LeftTopX:     rect.Left,
+		LeftTopY:     rect.Top,
+		RightTopX:    rect.Right,
+		RightTopY:    rect.Top,
+		LeftBottomX:  rect.Left,
+		LeftBottomY:  rect.Bottom,
+		RightBottomX: rect.Right,
+		RightBottomY: rect.Bottom,
+	}
+}
+
+// quadPointsRect returns the bounding box of the quads.
+func quadPointsRect(quadPoints []QuadPoint) Rect {
+	var points []Point
+	for _, quad := range quadPoints {
+		points = append(points, quad.points()...)
+	}
+	return verticesRect(points, 0)
+}
@@ -0,0 +1,1107 @@
+// 应用涂黑
+package annotation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unicode"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+// redactChar is a character of the text page.
+type redactChar struct {
+	unicode  rune
+	origin   Point
+	redacted bool
+}
+
+// redactObject is a page object touching a region.
+type redactObject struct {
+	index   int
+	obj     references.FPDF_PAGEOBJECT
+	objType enums.FPDF_PAGEOBJ
+	bounds  Rect
+	chars   []redactChar // the characters of a text object
+}
+
+// pathSegment is a segment of a path object, in the space of the path.
+type pathSegment struct {
+	segmentType enums.FPDF_SEGMENT
+	point       Point
+	close       bool
+}
+
+// ApplyRedactionsInPDF applies the redact annotations of the given pages, see ApplyRedactions.
+// res: the number of applied redactions
+func ApplyRedactionsInPDF(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNums []int) (int, error) {
+	var applied int
+	for _, pageNum := range pageNums {
+		n, err := ApplyRedactions(instance, pdfDoc, pageNum)
+		applied += n
+		if err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
+
+// ApplyRedactions removes the content under every redact annotation of the page, paints the fill color
+// (black by default) and the overlay text over each region, then deletes the redact annotations.
+// The content is removed from the page, not only covered:
+//   - characters whose center is in a region are removed, the other characters of their text object
+//     are written back one text object per character at their original position
+//   - pixels of images under a region are painted black, images inside a region are removed
+//   - subpaths of paths touching a region are removed, unless they enclose it, e.g. a page background
+//   - form XObjects touching a region are replaced by the objects in them, which are redacted as above
+//   - shadings inside a region are removed, one only partly in a region fails with ErrRedactObject
+//
+// ps: characters written back are encoded from their unicode by their font, subset fonts without a
+// unicode map may not show them. Redacted images lose their soft mask, replaced forms the clip of their box.
+// Other annotations are kept.
+// res: the number of applied redactions
+func ApplyRedactions(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int) (int, error) {
+	// step1. find the redact annotations of the page
+	annots, err := LoadAnnotationsInPage(instance, pdfDoc, pageNum)
+	if err != nil {
+		return 0, err
+	}
+	var redacts []*RedactAnnotation
+	var indices []int
+	var regions []QuadPoint
+	for i, annot := range annots {
+		if r, ok := annot.(*RedactAnnotation); ok {
+			redacts = append(redacts, r)
+			indices = append(indices, i)
+			regions = append(regions, r.regions()...)
+		}
+	}
+	if len(redacts) == 0 {
+		return 0, nil
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: pdfDoc,
+			Index:    pageNum,
+		},
+	}
+
+	// step2. remove the content under the regions
+	err = redactPageObjects(instance, pdfDoc, page, regions)
+	if err != nil {
+		return 0, err
+	}
+
+	// step3. paint the boxes and the overlay texts
+	for _, r := range redacts {
+		err = paintRedaction(instance, pdfDoc, page, r)
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
+		Page: page,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// step4. delete the redact annotations, from the last one so the indices stay valid
+	for i := len(indices) - 1; i >= 0; i-- {
+		_, err = instance.FPDFPage_RemoveAnnot(&requests.FPDFPage_RemoveAnnot{
+			Page:  page,
+			Index: indices[i],
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(redacts), nil
+}
+
+// redactPageObjects removes or rewrites the page objects under the regions.
+func redactPageObjects(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, page requests.Page, regions []QuadPoint) error {
+	err := flattenForms(instance, page, regions)
+	if err != nil {
+		return err
+	}
+
+	// the objects and their characters are read before any object changes
+	objs, err := loadRedactObjects(instance, page, regions)
+	if err != nil {
+		return err
+	}
+	err = loadRedactChars(instance, page, regions, objs)
+	if err != nil {
+		return err
+	}
+
+	// from the last object, so replacing an object keeps the indices of the ones before it
+	for i := len(objs) - 1; i >= 0; i-- {
+		o := objs[i]
+
+		var replacements []references.FPDF_PAGEOBJECT
+		remove := true
+		switch o.objType {
+		case enums.FPDF_PAGEOBJ_TEXT:
+			switch {
+			case len(o.chars) == 0:
+				// no character of the text page belongs to it, e.g. a copy of a fake bold text, it is removed
+			case !hasRedactedChar(o.chars):
+				remove = false
+			default:
+				replacements, err = rewriteTextObject(instance, pdfDoc, o.obj, o.chars)
+			}
+		case enums.FPDF_PAGEOBJ_IMAGE:
+			if !regionsContain(regions, o.bounds) {
+				err = redactImageObject(instance, o.obj, regions)
+				remove = err != nil
+				if err != nil {
+					// the pixels can not be edited, remove the whole image
+					err = nil
+				}
+			}
+		case enums.FPDF_PAGEOBJ_PATH:
+			replacements, remove, err = redactPathObject(instance, o.obj, regions)
+		}
+		if err != nil {
+			return err
+		}
+		if !remove {
+			continue
+		}
+
+		err = replacePageObject(instance, page, o.index, o.obj, replacements)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRedactObjects returns the page objects touching a region.
+// Shadings can not be edited, one only partly in a region fails with ErrRedactObject.
+func loadRedactObjects(instance pdfium.Pdfium, page requests.Page, regions []QuadPoint) ([]*redactObject, error) {
+	countRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+		Page: page,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []*redactObject
+	for i := 0; i < countRes.Count; i++ {
+		objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+			Page:  page,
+			Index: i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		bounds, err := getPageObjectBounds(instance, objRes.PageObject)
+		if err != nil {
+			return nil, err
+		}
+		if !regionsIntersect(regions, bounds) {
+			continue
+		}
+		typeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
+			PageObject: objRes.PageObject,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		switch typeRes.Type {
+		case enums.FPDF_PAGEOBJ_TEXT, enums.FPDF_PAGEOBJ_IMAGE, enums.FPDF_PAGEOBJ_PATH:
+		default:
+			if !regionsContain(regions, bounds) {
+				return nil, fmt.Errorf("%w: object %d of type %d", ErrRedactObject, i, typeRes.Type)
+			}
+		}
+		objs = append(objs, &redactObject{
+			index:   i,
+			obj:     objRes.PageObject,
+			objType: typeRes.Type,
+			bounds:  bounds,
+		})
+	}
+	return objs, nil
+}
+
+// flattenForms replaces the form objects touching a region by the objects in them, so they are redacted
+// like the other objects of the page, forms in forms as well.
+// ps: pdfium can not edit inside a form XObject in a way other viewers read, the clip of the form box is lost.
+func flattenForms(instance pdfium.Pdfium, page requests.Page, regions []QuadPoint) error {
+	countRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+		Page: page,
+	})
+	if err != nil {
+		return err
+	}
+
+	count := countRes.Count
+	for i := 0; i < count; i++ {
+		objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+			Page:  page,
+			Index: i,
+		})
+		if err != nil {
+			return err
+		}
+		typeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
+			PageObject: objRes.PageObject,
+		})
+		if err != nil {
+			return err
+		}
+		if typeRes.Type != enums.FPDF_PAGEOBJ_FORM {
+			continue
+		}
+		bounds, err := getPageObjectBounds(instance, objRes.PageObject)
+		if err != nil {
+			return err
+		}
+		if !regionsIntersect(regions, bounds) {
+			continue
+		}
+
+		moved, err := flattenForm(instance, page, i, objRes.PageObject)
+		if err != nil {
+			return err
+		}
+		// the objects of the form are from i on now, they may be forms too
+		count += moved - 1
+		i--
+	}
+	return nil
+}
+
+// flattenForm moves the objects of the form object at index onto the page in its place, with the matrix
+// of the form applied, and destroys the form object.
+// res: the number of moved objects
+func flattenForm(instance pdfium.Pdfium, page requests.Page, index int, form references.FPDF_PAGEOBJECT) (int, error) {
+	matrixRes, err := instance.FPDFPageObj_GetMatrix(&requests.FPDFPageObj_GetMatrix{
+		PageObject: form,
+	})
+	if err != nil {
+		return 0, err
+	}
+	countRes, err := instance.FPDFFormObj_CountObjects(&requests.FPDFFormObj_CountObjects{
+		PageObject: form,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// from the last object, each one is inserted right after the form
+	for j := countRes.Count - 1; j >= 0; j-- {
+		objRes, err := instance.FPDFFormObj_GetObject(&requests.FPDFFormObj_GetObject{
+			PageObject: form,
+			Index:      uint64(j),
+		})
+		if err != nil {
+			return 0, err
+		}
+		err = removeFormObject(instance, form, objRes.PageObject)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = instance.FPDFPageObj_TransformF(&requests.FPDFPageObj_TransformF{
+			PageObject: objRes.PageObject,
+			Transform:  matrixRes.Matrix,
+		})
+		if err == nil {
+			_, err = instance.FPDFPage_InsertObjectAtIndex(&requests.FPDFPage_InsertObjectAtIndex{
+				Page:       page,
+				PageObject: objRes.PageObject,
+				Index:      index + 1,
+			})
+		}
+		if err != nil {
+			instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+				PageObject: objRes.PageObject,
+			})
+			return 0, err
+		}
+	}
+
+	return countRes.Count, replacePageObject(instance, page, index, form, nil)
+}
+
+// removeFormObject takes obj out of the form object, the caller owns it then.
+// ps: go-pdfium passes the arguments of FPDFFormObj_RemoveObject to pdfium swapped, the swapped request
+// is tried when the right one fails.
+func removeFormObject(instance pdfium.Pdfium, form, obj references.FPDF_PAGEOBJECT) error {
+	_, err := instance.FPDFFormObj_RemoveObject(&requests.FPDFFormObj_RemoveObject{
+		FormObject: form,
+		PageObject: obj,
+	})
+	if err == nil {
+		return nil
+	}
+	_, swappedErr := instance.FPDFFormObj_RemoveObject(&requests.FPDFFormObj_RemoveObject{
+		FormObject: obj,
+		PageObject: form,
+	})
+	if swappedErr != nil {
+		return err
+	}
+	return nil
+}
+
+// replacePageObject removes the object at index from the page and inserts the replacements in its place.
+func replacePageObject(instance pdfium.Pdfium, page requests.Page, index int, obj references.FPDF_PAGEOBJECT, replacements []references.FPDF_PAGEOBJECT) error {
+	for j, replacement := range replacements {
+		_, err := instance.FPDFPage_InsertObjectAtIndex(&requests.FPDFPage_InsertObjectAtIndex{
+			Page:       page,
+			PageObject: replacement,
+			Index:      index + 1 + j,
+		})
+		if err != nil {
+			for _, notInserted := range replacements[j:] {
+				instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+					PageObject: notInserted,
+				})
+			}
+			return err
+		}
+	}
+
+	_, err := instance.FPDFPage_RemoveObject(&requests.FPDFPage_RemoveObject{
+		Page:       page,
+		PageObject: obj,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+		PageObject: obj,
+	})
+	return err
+}
+
+// loadRedactChars sets the characters of the text objects from the text page.
+// pdfium gives a new reference for every lookup of an object: the bounds of the object of a character
+// narrow down the objects it may belong to, and turning the object off tells apart the ones with the
+// same bounds, e.g. the copies of a fake bold text.
+func loadRedactChars(instance pdfium.Pdfium, page requests.Page, regions []QuadPoint, objs []*redactObject) error {
+	textPageRes, err := instance.FPDFText_LoadPage(&requests.FPDFText_LoadPage{
+		Page: page,
+	})
+	if err != nil {
+		return err
+	}
+	defer instance.FPDFText_ClosePage(&requests.FPDFText_ClosePage{
+		TextPage: textPageRes.TextPage,
+	})
+
+	countRes, err := instance.FPDFText_CountChars(&requests.FPDFText_CountChars{
+		TextPage: textPageRes.TextPage,
+	})
+	if err != nil {
+		return err
+	}
+
+	type textChar struct {
+		obj  references.FPDF_PAGEOBJECT
+		char redactChar
+	}
+	chars := make(map[Rect][]textChar)
+	for i := 0; i < countRes.Count; i++ {
+		objRes, err := instance.FPDFText_GetTextObject(&requests.FPDFText_GetTextObject{
+			TextPage: textPageRes.TextPage,
+			Index:    i,
+		})
+		if err != nil {
+			// characters generated by pdfium, like spaces between words, have no object
+			continue
+		}
+		bounds, err := getPageObjectBounds(instance, objRes.TextObject)
+		if err != nil {
+			return err
+		}
+		if !regionsIntersect(regions, bounds) {
+			continue
+		}
+
+		unicodeRes, err := instance.FPDFText_GetUnicode(&requests.FPDFText_GetUnicode{
+			TextPage: textPageRes.TextPage,
+			Index:    i,
+		})
+		if err != nil {
+			return err
+		}
+		boxRes, err := instance.FPDFText_GetCharBox(&requests.FPDFText_GetCharBox{
+			TextPage: textPageRes.TextPage,
+			Index:    i,
+		})
+		if err != nil {
+			return err
+		}
+		originRes, err := instance.FPDFText_GetCharOrigin(&requests.FPDFText_GetCharOrigin{
+			TextPage: textPageRes.TextPage,
+			Index:    i,
+		})
+		if err != nil {
+			return err
+		}
+
+		center := Point{X: float32(boxRes.Left+boxRes.Right) / 2, Y: float32(boxRes.Bottom+boxRes.Top) / 2}
+		chars[bounds] = append(chars[bounds], textChar{
+			obj: objRes.TextObject,
+			char: redactChar{
+				unicode:  rune(unicodeRes.Unicode),
+				origin:   Point{X: float32(originRes.X), Y: float32(originRes.Y)},
+				redacted: regionsContainPoint(regions, center),
+			},
+		})
+	}
+
+	for _, o := range objs {
+		candidates := chars[o.bounds]
+		if o.objType != enums.FPDF_PAGEOBJ_TEXT || len(candidates) == 0 {
+			continue
+		}
+
+		_, err = instance.FPDFPageObj_SetIsActive(&requests.FPDFPageObj_SetIsActive{
+			PageObject: o.obj,
+			Active:     false,
+		})
+		if err != nil {
+			return err
+		}
+		for _, c := range candidates {
+			var activeRes *responses.FPDFPageObj_GetIsActive
+			activeRes, err = instance.FPDFPageObj_GetIsActive(&requests.FPDFPageObj_GetIsActive{
+				PageObject: c.obj,
+			})
+			if err != nil {
+				break
+			}
+			if !activeRes.Active {
+				o.chars = append(o.chars, c.char)
+			}
+		}
+		_, activeErr := instance.FPDFPageObj_SetIsActive(&requests.FPDFPageObj_SetIsActive{
+			PageObject: o.obj,
+			Active:     true,
+		})
+		if err != nil {
+			return err
+		}
+		if activeErr != nil {
+			return activeErr
+		}
+	}
+	return nil
+}
+
+func hasRedactedChar(chars []redactChar) bool {
+	for _, c := range chars {
+		if c.redacted {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteTextObject creates a text object for every character of the text object left by the redaction,
+// with the font, size, colors and matrix of the text object.
+func rewriteTextObject(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, obj references.FPDF_PAGEOBJECT, chars []redactChar) ([]references.FPDF_PAGEOBJECT, error) {
+	fontRes, err := instance.FPDFTextObj_GetFont(&requests.FPDFTextObj_GetFont{
+		PageObject: obj,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sizeRes, err := instance.FPDFTextObj_GetFontSize(&requests.FPDFTextObj_GetFontSize{
+		PageObject: obj,
+	})
+	if err != nil {
+		return nil, err
+	}
+	matrixRes, err := instance.FPDFPageObj_GetMatrix(&requests.FPDFPageObj_GetMatrix{
+		PageObject: obj,
+	})
+	if err != nil {
+		return nil, err
+	}
+	renderModeRes, err := instance.FPDFTextObj_GetTextRenderMode(&requests.FPDFTextObj_GetTextRenderMode{
+		PageObject: obj,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fillRes, err := instance.FPDFPageObj_GetFillColor(&requests.FPDFPageObj_GetFillColor{
+		PageObject: obj,
+	})
+	if err != nil {
+		return nil, err
+	}
+	strokeRes, err := instance.FPDFPageObj_GetStrokeColor(&requests.FPDFPageObj_GetStrokeColor{
+		PageObject: obj,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var objRefs []references.FPDF_PAGEOBJECT
+	destroy := func(err error) ([]references.FPDF_PAGEOBJECT, error) {
+		for _, objRef := range objRefs {
+			instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+				PageObject: objRef,
+			})
+		}
+		return nil, err
+	}
+
+	for _, c := range chars {
+		if c.redacted || c.unicode == 0 || unicode.IsSpace(c.unicode) {
+			continue
+		}
+
+		textRes, err := instance.FPDFPageObj_CreateTextObj(&requests.FPDFPageObj_CreateTextObj{
+			Document: pdfDoc,
+			Font:     fontRes.Font,
+			FontSize: sizeRes.FontSize,
+		})
+		if err != nil {
+			return destroy(err)
+		}
+		objRefs = append(objRefs, textRes.PageObject)
+
+		_, err = instance.FPDFText_SetText(&requests.FPDFText_SetText{
+			PageObject: textRes.PageObject,
+			Text:       string(c.unicode),
+		})
+		if err != nil {
+			return destroy(err)
+		}
+
+		matrix := matrixRes.Matrix
+		matrix.E, matrix.F = c.origin.X, c.origin.Y
+		_, err = instance.FPDFPageObj_SetMatrix(&requests.FPDFPageObj_SetMatrix{
+			PageObject: textRes.PageObject,
+			Transform:  matrix,
+		})
+		if err != nil {
+			return destroy(err)
+		}
+
+		_, err = instance.FPDFTextObj_SetTextRenderMode(&requests.FPDFTextObj_SetTextRenderMode{
+			PageObject:     textRes.PageObject,
+			TextRenderMode: renderModeRes.TextRenderMode,
+		})
+		if err != nil {
+			return destroy(err)
+		}
+		_, err = instance.FPDFPageObj_SetFillColor(&requests.FPDFPageObj_SetFillColor{
+			PageObject: textRes.PageObject,
+			FillColor:  fillRes.FillColor,
+		})
+		if err != nil {
+			return destroy(err)
+		}
+		_, err = instance.FPDFPageObj_SetStrokeColor(&requests.FPDFPageObj_SetStrokeColor{
+			PageObject:  textRes.PageObject,
+			StrokeColor: strokeRes.StrokeColor,
+		})
+		if err != nil {
+			return destroy(err)
+		}
+	}
+	return objRefs, nil
+}
+
+// redactImageObject paints the pixels of the image under the regions black.
+func redactImageObject(instance pdfium.Pdfium, obj references.FPDF_PAGEOBJECT, regions []QuadPoint) error {
+	matrixRes, err := instance.FPDFPageObj_GetMatrix(&requests.FPDFPageObj_GetMatrix{
+		PageObject: obj,
+	})
+	if err != nil {
+		return err
+	}
+	m := matrixRes.Matrix
+	det := m.A*m.D - m.B*m.C
+	if IsZeroEpsilon(det) {
+		return errors.New("image matrix can not be inverted")
+	}
+
+	bitmapRes, err := instance.FPDFImageObj_GetBitmap(&requests.FPDFImageObj_GetBitmap{
+		ImageObject: obj,
+	})
+	if err != nil {
+		return err
+	}
+	defer instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+		Bitmap: bitmapRes.Bitmap,
+	})
+	widthRes, err := instance.FPDFBitmap_GetWidth(&requests.FPDFBitmap_GetWidth{
+		Bitmap: bitmapRes.Bitmap,
+	})
+	if err != nil {
+		return err
+	}
+	heightRes, err := instance.FPDFBitmap_GetHeight(&requests.FPDFBitmap_GetHeight{
+		Bitmap: bitmapRes.Bitmap,
+	})
+	if err != nil {
+		return err
+	}
+	width, height := float32(widthRes.Width), float32(heightRes.Height)
+
+	for _, region := range regions {
+		// the region in pixels: the matrix maps the unit square to the page, pixel rows go down
+		var pixels []Point
+		for _, p := range region.points() {
+			u := (m.D*(p.X-m.E) - m.C*(p.Y-m.F)) / det
+			v := (-m.B*(p.X-m.E) + m.A*(p.Y-m.F)) / det
+			pixels = append(pixels, Point{X: u * width, Y: (1 - v) * height})
+		}
+		box := verticesRect(pixels, 0)
+		left := max(int(math.Floor(float64(box.Left))), 0)
+		top := max(int(math.Floor(float64(box.Bottom))), 0)
+		right := min(int(math.Ceil(float64(box.Right))), widthRes.Width)
+		bottom := min(int(math.Ceil(float64(box.Top))), heightRes.Height)
+		if right <= left || bottom <= top {
+			continue
+		}
+
+		_, err = instance.FPDFBitmap_FillRect(&requests.FPDFBitmap_FillRect{
+			Bitmap: bitmapRes.Bitmap,
+			Left:   left,
+			Top:    top,
+			Width:  right - left,
+			Height: bottom - top,
+			Color:  0xFF000000,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = instance.FPDFImageObj_SetBitmap(&requests.FPDFImageObj_SetBitmap{
+		ImageObject: obj,
+		Bitmap:      bitmapRes.Bitmap,
+	})
+	return err
+}
+
+// redactPathObject removes the subpaths of the path touching a region, unless they enclose it.
+// res: the path rebuilt from the remaining subpaths, and whether the path must be removed
+func redactPathObject(instance pdfium.Pdfium, obj references.FPDF_PAGEOBJECT, regions []QuadPoint) ([]references.FPDF_PAGEOBJECT, bool, error) {
+	matrixRes, err := instance.FPDFPageObj_GetMatrix(&requests.FPDFPageObj_GetMatrix{
+		PageObject: obj,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	subpaths, err := loadSubpaths(instance, obj)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var kept [][]pathSegment
+	for _, subpath := range subpaths {
+		points := make([]Point, 0, len(subpath))
+		for _, segment := range subpath {
+			points = append(points, transformPoint(matrixRes.Matrix, segment.point))
+		}
+		bounds := verticesRect(points, 0)
+		if !regionsIntersect(regions, bounds) || enclosesTouchedRegions(bounds, regions) {
+			kept = append(kept, subpath)
+		}
+	}
+
+	switch len(kept) {
+	case len(subpaths):
+		return nil, false, nil
+	case 0:
+		return nil, true, nil
+	}
+
+	newPath, err := copyPathObject(instance, obj, matrixRes.Matrix, kept)
+	if err != nil {
+		return nil, false, err
+	}
+	return []references.FPDF_PAGEOBJECT{newPath}, true, nil
+}
+
+// loadSubpaths returns the segments of the path, split at every move.
+func loadSubpaths(instance pdfium.Pdfium, obj references.FPDF_PAGEOBJECT) ([][]pathSegment, error) {
+	countRes, err := instance.FPDFPath_CountSegments(&requests.FPDFPath_CountSegments{
+		PageObject: obj,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var subpaths [][]pathSegment
+	for i := 0; i < countRes.Count; i++ {
+		segmentRes, err := instance.FPDFPath_GetPathSegment(&requests.FPDFPath_GetPathSegment{
+			PageObject: obj,
+			Index:      i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		typeRes, err := instance.FPDFPathSegment_GetType(&requests.FPDFPathSegment_GetType{
+			PathSegment: segmentRes.PathSegment,
+		})
+		if err != nil {
+			return nil, err
+		}
+		pointRes, err := instance.FPDFPathSegment_GetPoint(&requests.FPDFPathSegment_GetPoint{
+			PathSegment: segmentRes.PathSegment,
+		})
+		if err != nil {
+			return nil, err
+		}
+		closeRes, err := instance.FPDFPathSegment_GetClose(&requests.FPDFPathSegment_GetClose{
+			PathSegment: segmentRes.PathSegment,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		segment := pathSegment{
+			segmentType: typeRes.Type,
+			point:       Point{X: pointRes.X, Y: pointRes.Y},
+			close:       closeRes.IsClose,
+		}
+		if segment.segmentType == enums.FPDF_SEGMENT_MOVETO || len(subpaths) == 0 {
+			subpaths = append(subpaths, nil)
+		}
+		subpaths[len(subpaths)-1] = append(subpaths[len(subpaths)-1], segment)
+	}
+	return subpaths, nil
+}
+
+// copyPathObject creates a path object of the subpaths, drawn like obj.
+func copyPathObject(instance pdfium.Pdfium, obj references.FPDF_PAGEOBJECT, matrix structs.FPDF_FS_MATRIX, subpaths [][]pathSegment) (references.FPDF_PAGEOBJECT, error) {
+	first := subpaths[0][0].point
+	pathRes, err := instance.FPDFPageObj_CreateNewPath(&requests.FPDFPageObj_CreateNewPath{
+		X: first.X,
+		Y: first.Y,
+	})
+	if err != nil {
+		return "", err
+	}
+	newPath := pathRes.PageObject
+	destroy := func(err error) (references.FPDF_PAGEOBJECT, error) {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: newPath,
+		})
+		return "", err
+	}
+
+	// segments
+	var bezier []Point
+	for i, subpath := range subpaths {
+		for j, segment := range subpath {
+			p := segment.point
+			switch segment.segmentType {
+			case enums.FPDF_SEGMENT_MOVETO:
+				if i == 0 && j == 0 {
+					break
+				}
+				_, err = instance.FPDFPath_MoveTo(&requests.FPDFPath_MoveTo{PageObject: newPath, X: p.X, Y: p.Y})
+			case enums.FPDF_SEGMENT_LINETO:
+				_, err = instance.FPDFPath_LineTo(&requests.FPDFPath_LineTo{PageObject: newPath, X: p.X, Y: p.Y})
+			case enums.FPDF_SEGMENT_BEZIERTO:
+				// bezier curves come as three segments: two control points and the end point
+				bezier = append(bezier, p)
+				if len(bezier) == 3 {
+					_, err = instance.FPDFPath_BezierTo(&requests.FPDFPath_BezierTo{
+						PageObject: newPath,
+						X1:         bezier[0].X,
+						Y1:         bezier[0].Y,
+						X2:         bezier[1].X,
+						Y2:         bezier[1].Y,
+						X3:         bezier[2].X,
+						Y3:         bezier[2].Y,
+					})
+					bezier = nil
+				}
+			}
+			if err != nil {
+				return destroy(err)
+			}
+			if segment.close {
+				_, err = instance.FPDFPath_Close(&requests.FPDFPath_Close{PageObject: newPath})
+				if err != nil {
+					return destroy(err)
+				}
+			}
+		}
+	}
+
+	// drawing state
+	drawModeRes, err := instance.FPDFPath_GetDrawMode(&requests.FPDFPath_GetDrawMode{PageObject: obj})
+	if err != nil {
+		return destroy(err)
+	}
+	_, err = instance.FPDFPath_SetDrawMode(&requests.FPDFPath_SetDrawMode{
+		PageObject: newPath,
+		FillMode:   drawModeRes.FillMode,
+		Stroke:     drawModeRes.Stroke,
+	})
+	if err != nil {
+		return destroy(err)
+	}
+
+	fillRes, err := instance.FPDFPageObj_GetFillColor(&requests.FPDFPageObj_GetFillColor{PageObject: obj})
+	if err != nil {
+		return destroy(err)
+	}
+	_, err = instance.FPDFPageObj_SetFillColor(&requests.FPDFPageObj_SetFillColor{PageObject: newPath, FillColor: fillRes.FillColor})
+	if err != nil {
+		return destroy(err)
+	}
+
+	strokeRes, err := instance.FPDFPageObj_GetStrokeColor(&requests.FPDFPageObj_GetStrokeColor{PageObject: obj})
+	if err != nil {
+		return destroy(err)
+	}
+	_, err = instance.FPDFPageObj_SetStrokeColor(&requests.FPDFPageObj_SetStrokeColor{PageObject: newPath, StrokeColor: strokeRes.StrokeColor})
+	if err != nil {
+		return destroy(err)
+	}
+
+	widthRes, err := instance.FPDFPageObj_GetStrokeWidth(&requests.FPDFPageObj_GetStrokeWidth{PageObject: obj})
+	if err != nil {
+		return destroy(err)
+	}
+	_, err = instance.FPDFPageObj_SetStrokeWidth(&requests.FPDFPageObj_SetStrokeWidth{PageObject: newPath, StrokeWidth: widthRes.StrokeWidth})
+	if err != nil {
+		return destroy(err)
+	}
+
+	joinRes, err := instance.FPDFPageObj_GetLineJoin(&requests.FPDFPageObj_GetLineJoin{PageObject: obj})
+	if err != nil {
+		return destroy(err)
+	}
+	_, err = instance.FPDFPageObj_SetLineJoin(&requests.FPDFPageObj_SetLineJoin{PageObject: newPath, LineJoin: joinRes.LineJoin})
+	if err != nil {
+		return destroy(err)
+	}
+
+	capRes, err := instance.FPDFPageObj_GetLineCap(&requests.FPDFPageObj_GetLineCap{PageObject: obj})
+	if err != nil {
+		return destroy(err)
+	}
+	_, err = instance.FPDFPageObj_SetLineCap(&requests.FPDFPageObj_SetLineCap{PageObject: newPath, LineCap: capRes.LineCap})
+	if err != nil {
+		return destroy(err)
+	}
+
+	dashRes, err := instance.FPDFPageObj_GetDashArray(&requests.FPDFPageObj_GetDashArray{PageObject: obj})
+	if err != nil {
+		return destroy(err)
+	}
+	if len(dashRes.DashArray) > 0 {
+		phaseRes, err := instance.FPDFPageObj_GetDashPhase(&requests.FPDFPageObj_GetDashPhase{PageObject: obj})
+		if err != nil {
+			return destroy(err)
+		}
+		_, err = instance.FPDFPageObj_SetDashArray(&requests.FPDFPageObj_SetDashArray{
+			PageObject: newPath,
+			DashArray:  dashRes.DashArray,
+			DashPhase:  phaseRes.DashPhase,
+		})
+		if err != nil {
+			return destroy(err)
+		}
+	}
+
+	_, err = instance.FPDFPageObj_SetMatrix(&requests.FPDFPageObj_SetMatrix{
+		PageObject: newPath,
+		Transform:  matrix,
+	})
+	if err != nil {
+		return destroy(err)
+	}
+	return newPath, nil
+}
+
+// paintRedaction draws the fill color over the regions of the redaction and the overlay text in its rect.
+func paintRedaction(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, page requests.Page, r *RedactAnnotation) error {
+	fillColor := r.fillColor
+	if fillColor == nil {
+		fillColor = &DefaultRedactFillColor
+	}
+
+	var objRefs []references.FPDF_PAGEOBJECT
+	for _, region := range r.regions() {
+		points := region.points()
+		pathRes, err := instance.FPDFPageObj_CreateNewPath(&requests.FPDFPageObj_CreateNewPath{
+			X: points[0].X,
+			Y: points[0].Y,
+		})
+		if err != nil {
+			return err
+		}
+		objRefs = append(objRefs, pathRes.PageObject)
+		for _, p := range points[1:] {
+			_, err = instance.FPDFPath_LineTo(&requests.FPDFPath_LineTo{PageObject: pathRes.PageObject, X: p.X, Y: p.Y})
+			if err != nil {
+				return err
+			}
+		}
+		_, err = instance.FPDFPath_Close(&requests.FPDFPath_Close{PageObject: pathRes.PageObject})
+		if err != nil {
+			return err
+		}
+		_, err = instance.FPDFPageObj_SetFillColor(&requests.FPDFPageObj_SetFillColor{
+			PageObject: pathRes.PageObject,
+			FillColor: structs.FPDF_COLOR{
+				R: uint(fillColor.R),
+				G: uint(fillColor.G),
+				B: uint(fillColor.B),
+				A: 255,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = instance.FPDFPath_SetDrawMode(&requests.FPDFPath_SetDrawMode{
+			PageObject: pathRes.PageObject,
+			FillMode:   enums.FPDF_FILLMODE_WINDING,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.OverlayText != "" {
+		textRefs, err := CreateTextObjects(instance, r.rect, &TextObjectParam{
+			Document: pdfDoc,
+			FontSize: r.OverlayFontSize,
+			Text:     r.OverlayText,
+			Color:    r.FontColor,
+		})
+		if err != nil {
+			return err
+		}
+		objRefs = append(objRefs, textRefs...)
+	}
+
+	for i, objRef := range objRefs {
+		_, err := instance.FPDFPage_InsertObject(&requests.FPDFPage_InsertObject{
+			Page:       page,
+			PageObject: objRef,
+		})
+		if err != nil {
+			for _, notInserted := range objRefs[i:] {
+				instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+					PageObject: notInserted,
+				})
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// getPageObjectBounds returns the bounding box of the page object on the page.
+func getPageObjectBounds(instance pdfium.Pdfium, obj references.FPDF_PAGEOBJECT) (Rect, error) {
+	boundsRes, err := instance.FPDFPageObj_GetBounds(&requests.FPDFPageObj_GetBounds{
+		PageObject: obj,
+	})
+	if err != nil {
+		return Rect{}, err
+	}
+	return Rect{
+		Left:   boundsRes.Left,
+		Bottom: boundsRes.Bottom,
+		Right:  boundsRes.Right,
+		Top:    boundsRes.Top,
+	}, nil
+}
+
+// transformPoint maps p by the matrix.
+func transformPoint(m structs.FPDF_FS_MATRIX, p Point) Point {
+	return Point{
+		X: m.A*p.X + m.C*p.Y + m.E,
+		Y: m.B*p.X + m.D*p.Y + m.F,
+	}
+}
+
+// quadContainsPoint reports whether p is inside the convex quad, in either winding.
+func quadContainsPoint(quad QuadPoint, p Point) bool {
+	return convexContainsPoint(quad.points(), p)
+}
+
+// convexContainsPoint reports whether p is inside the convex polygon, in either winding.
+func convexContainsPoint(points []Point, p Point) bool {
+	var positive, negative bool
+	for i, a := range points {
+		b := points[(i+1)%len(points)]
+		cross := (b.X-a.X)*(p.Y-a.Y) - (b.Y-a.Y)*(p.X-a.X)
+		positive = positive || cross > 0
+		negative = negative || cross < 0
+	}
+	return !(positive && negative)
+}
+
+func regionsContainPoint(regions []QuadPoint, p Point) bool {
+	for _, region := range regions {
+		if quadContainsPoint(region, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// regionsContain reports whether the rect is inside one of the regions.
+func regionsContain(regions []QuadPoint, rect Rect) bool {
+	for _, region := range regions {
+		inside := true
+		for _, corner := range rectQuadPoint(rect).points() {
+			inside = inside && quadContainsPoint(region, corner)
+		}
+		if inside {
+			return true
+		}
+	}
+	return false
+}
+
+// regionsIntersect reports whether the rect overlaps the bounding box of one of the regions.
+func regionsIntersect(regions []QuadPoint, rect Rect) bool {
+	for _, region := range regions {
+		if rectsIntersect(quadPointsRect([]QuadPoint{region}), rect) {
+			return true
+		}
+	}
+	return false
+}
+
+// enclosesTouchedRegions reports whether the rect encloses every region it overlaps.
+func enclosesTouchedRegions(rect Rect, regions []QuadPoint) bool {
+	for _, region := range regions {
+		box := quadPointsRect([]QuadPoint{region})
+		if !rectsIntersect(box, rect) {
+			continue
+		}
+		if box.Left < rect.Left || box.Bottom < rect.Bottom || box.Right > rect.Right || box.Top > rect.Top {
+			return false
+		}
+	}
+	return true
+}
+
+func rectsIntersect(a, b Rect) bool {
+	return a.Left < b.Right && b.Left < a.Right && a.Bottom < b.Top && b.Bottom < a.Top
+}
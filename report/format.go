@@ -0,0 +1,248 @@
+// 报告格式
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+
+	annotation "pdf-annotation-knife"
+)
+
+// DateFormat is the format of the dates in the reports.
+const DateFormat = "2006-01-02 15:04"
+
+// csvHeader is the first row of the CSV report.
+var csvHeader = []string{"page", "type", "author", "date", "color", "contents", "quote", "nm"}
+
+// WriteMarkdown writes the report as Markdown, a section per page with a list of entries.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	var sb strings.Builder
+	title := r.Title
+	if title == "" {
+		title = "Comments Summary"
+	}
+	fmt.Fprintf(&sb, "# %s\n", escapeMarkdown(title))
+
+	for _, page := range r.Pages {
+		fmt.Fprintf(&sb, "\n## Page %d\n\n", page.PageNumber+1)
+		for i, entry := range page.Entries {
+			fmt.Fprintf(&sb, "%d. **%s**", i+1, entry.Subtype)
+			if entry.Author != "" {
+				fmt.Fprintf(&sb, " by %s", escapeMarkdown(entry.Author))
+			}
+			if !entry.Date.IsZero() {
+				fmt.Fprintf(&sb, ", %s", entry.Date.Format(DateFormat))
+			}
+			if entry.Color != nil {
+				fmt.Fprintf(&sb, " `%s`", formatColor(entry.Color))
+			}
+			sb.WriteString("\n")
+			if entry.Quote != "" {
+				fmt.Fprintf(&sb, "   > %s\n", escapeMarkdown(entry.Quote))
+			}
+			for _, line := range strings.Split(strings.TrimSpace(entry.Contents), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					fmt.Fprintf(&sb, "   %s\n", escapeMarkdown(line))
+				}
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteCSV writes the report as CSV, a row per entry after a header row, pages from 1.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, entry := range r.Entries() {
+		date := ""
+		if !entry.Date.IsZero() {
+			date = entry.Date.Format(time.RFC3339)
+		}
+		err = cw.Write([]string{
+			strconv.Itoa(entry.PageNumber + 1),
+			entry.Subtype,
+			entry.Author,
+			date,
+			formatColor(entry.Color),
+			entry.Contents,
+			entry.Quote,
+			entry.NM,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteHTML writes the report as a standalone HTML page, with the thumbnails and the boxes of the entries over them when rendered.
+func (r *Report) WriteHTML(w io.Writer) error {
+	title := r.Title
+	if title == "" {
+		title = "Comments Summary"
+	}
+
+	type htmlBox struct {
+		Number                   int
+		Left, Top, Width, Height float32
+		Color                    string
+	}
+	type htmlPage struct {
+		Page
+		Thumbnail template.URL
+		Boxes     []htmlBox
+	}
+	pages := make([]htmlPage, 0, len(r.Pages))
+	for _, page := range r.Pages {
+		p := htmlPage{Page: page}
+		if len(page.Thumbnail) > 0 {
+			p.Thumbnail = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(page.Thumbnail))
+			for i, entry := range page.Entries {
+				color := formatColor(entry.Color)
+				if color == "" {
+					color = "#FF0000"
+				}
+				// svg coordinates go down from the top of the page
+				p.Boxes = append(p.Boxes, htmlBox{
+					Number: i + 1,
+					Left:   entry.Rect.Left,
+					Top:    page.Height - entry.Rect.Top,
+					Width:  entry.Rect.Right - entry.Rect.Left,
+					Height: entry.Rect.Top - entry.Rect.Bottom,
+					Color:  color,
+				})
+			}
+		}
+		pages = append(pages, p)
+	}
+
+	return htmlTemplate.Execute(w, map[string]any{
+		"Title": title,
+		"Pages": pages,
+	})
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"inc":   func(i int) int { return i + 1 },
+	"color": formatColor,
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(DateFormat)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+section { display: flex; gap: 2em; align-items: flex-start; margin-bottom: 2em; }
+.thumbnail { position: relative; flex: none; border: 1px solid #ccc; }
+.thumbnail img, .thumbnail svg { display: block; }
+.thumbnail svg { position: absolute; top: 0; left: 0; width: 100%; height: 100%; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.4em; text-align: left; vertical-align: top; }
+.swatch { display: inline-block; width: 1em; height: 1em; border: 1px solid #999; vertical-align: middle; }
+blockquote { margin: 0 0 0.3em 0; padding-left: 0.6em; border-left: 3px solid #ccc; color: #555; }
+.contents { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Pages}}
+<h2>Page {{inc .PageNumber}}</h2>
+<section>
+{{- if .Thumbnail}}
+<div class="thumbnail">
+<img src="{{.Thumbnail}}" alt="page {{inc .PageNumber}}">
+<svg viewBox="0 0 {{.Width}} {{.Height}}" preserveAspectRatio="none">
+{{- range .Boxes}}
+<rect x="{{.Left}}" y="{{.Top}}" width="{{.Width}}" height="{{.Height}}" fill="none" stroke="{{.Color}}" stroke-width="2"/>
+<text x="{{.Left}}" y="{{.Top}}" font-size="14" fill="{{.Color}}">{{.Number}}</text>
+{{- end}}
+</svg>
+</div>
+{{- end}}
+<table>
+<tr><th>#</th><th>Type</th><th>Author</th><th>Date</th><th>Color</th><th>Comment</th></tr>
+{{- range $i, $e := .Entries}}
+<tr>
+<td>{{inc $i}}</td>
+<td>{{$e.Subtype}}</td>
+<td>{{$e.Author}}</td>
+<td>{{date $e.Date}}</td>
+<td>{{with color $e.Color}}<span class="swatch" style="background: {{.}}"></span> {{.}}{{end}}</td>
+<td>{{with $e.Quote}}<blockquote>{{.}}</blockquote>{{end}}<div class="contents">{{$e.Contents}}</div></td>
+</tr>
+{{- end}}
+</table>
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+// renderThumbnail renders the page with its annotations as a png of the width.
+func renderThumbnail(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum, width int) ([]byte, error) {
+	if width <= 0 {
+		width = DefaultThumbnailWidth
+	}
+	renderRes, err := instance.RenderPageInPixels(&requests.RenderPageInPixels{
+		Page: requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: pdfDoc,
+				Index:    pageNum,
+			},
+		},
+		Width:       width,
+		Height:      width * 10, // the width limits the size, whatever the aspect ratio
+		RenderFlags: enums.FPDF_RENDER_FLAG_ANNOT,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer renderRes.Cleanup()
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, renderRes.Result.Image)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// formatColor returns the color as #RRGGBB, "" for nil.
+func formatColor(c *annotation.Color) string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// escapeMarkdown escapes the characters starting Markdown markup inside a line.
+func escapeMarkdown(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`, "#", `\#`)
+	return replacer.Replace(strings.Join(strings.Fields(s), " "))
+}
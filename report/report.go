@@ -0,0 +1,221 @@
+// 标注汇总报告
+
+// Package report writes a comments summary of the annotations of a pdf, grouped by page, as Markdown, HTML or CSV.
+package report
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+
+	annotation "pdf-annotation-knife"
+)
+
+// SortBy is the order of the entries of a page.
+type SortBy int
+
+const (
+	SortByPage   SortBy = iota // top to bottom, then left to right
+	SortByAuthor               // author, then date
+	SortByDate                 // oldest first, entries without a date last
+)
+
+// Entry is an annotation of the report.
+type Entry struct {
+	PageNumber int // page num, start from 0
+	NM         string
+	Subtype    string
+	Author     string
+	Date       time.Time // modification date, creation date when not modified
+	Color      *annotation.Color
+	Contents   string
+	Quote      string // text under text markups
+	Rect       annotation.Rect
+}
+
+// Page is a page of the report with its entries.
+type Page struct {
+	PageNumber int
+	Width      float32 // size of the page in points
+	Height     float32
+	Entries    []Entry
+	Thumbnail  []byte // png, only rendered when Options.Thumbnails is set
+}
+
+// Report is the comments summary of a document.
+type Report struct {
+	Title string
+	Pages []Page // pages with at least one entry, in page order
+}
+
+// Options of New.
+type Options struct {
+	Title string
+	// SortBy is the order of the entries of each page.
+	SortBy SortBy
+	// Thumbnails renders a png of every page with entries, shown by the HTML report.
+	Thumbnails bool
+	// ThumbnailWidth is the width of the thumbnails in pixels, DefaultThumbnailWidth when 0.
+	ThumbnailWidth int
+}
+
+const DefaultThumbnailWidth = 300
+
+// New reads the annotations of every page of the document into a report, popups and widgets are left out.
+// The quote of text markups is the text under their quads, see annotation.GetMarkedText.
+func New(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, opts Options) (*Report, error) {
+	pageCountRes, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+		Document: pdfDoc,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{Title: opts.Title}
+	for pageNum := 0; pageNum < pageCountRes.PageCount; pageNum++ {
+		entries, err := loadEntries(instance, pdfDoc, pageNum)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		sizeRes, err := instance.FPDF_GetPageSizeByIndex(&requests.FPDF_GetPageSizeByIndex{
+			Document: pdfDoc,
+			Index:    pageNum,
+		})
+		if err != nil {
+			return nil, err
+		}
+		page := Page{
+			PageNumber: pageNum,
+			Width:      float32(sizeRes.Width),
+			Height:     float32(sizeRes.Height),
+			Entries:    entries,
+		}
+		if opts.Thumbnails {
+			page.Thumbnail, err = renderThumbnail(instance, pdfDoc, pageNum, opts.ThumbnailWidth)
+			if err != nil {
+				return nil, err
+			}
+		}
+		r.Pages = append(r.Pages, page)
+	}
+
+	r.Sort(opts.SortBy)
+	return r, nil
+}
+
+// Sort orders the entries of every page.
+func (r *Report) Sort(by SortBy) {
+	for _, page := range r.Pages {
+		sortEntries(page.Entries, by)
+	}
+}
+
+// Entries returns the entries of every page, in page order.
+func (r *Report) Entries() []Entry {
+	var entries []Entry
+	for _, page := range r.Pages {
+		entries = append(entries, page.Entries...)
+	}
+	return entries
+}
+
+func sortEntries(entries []Entry, by SortBy) {
+	byPosition := func(a, b Entry) bool {
+		if a.Rect.Top != b.Rect.Top {
+			return a.Rect.Top > b.Rect.Top
+		}
+		return a.Rect.Left < b.Rect.Left
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch by {
+		case SortByAuthor:
+			if ka, kb := strings.ToLower(a.Author), strings.ToLower(b.Author); ka != kb {
+				return ka < kb
+			}
+			return a.Date.Before(b.Date)
+		case SortByDate:
+			if a.Date.IsZero() != b.Date.IsZero() {
+				return b.Date.IsZero()
+			}
+			if !a.Date.Equal(b.Date) {
+				return a.Date.Before(b.Date)
+			}
+		}
+		return byPosition(a, b)
+	})
+}
+
+// loadEntries reads the annotations of the page into entries.
+func loadEntries(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int) ([]Entry, error) {
+	annots, err := annotation.LoadAnnotationsInPage(instance, pdfDoc, pageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := map[string]string{}
+	marked, err := annotation.GetMarkedTextsInPage(instance, pdfDoc, pageNum, annotation.DefaultMarkedTextOverlap)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range marked {
+		quotes[m.Annotation.GetNM()] = m.Text
+	}
+
+	entries := make([]Entry, 0, len(annots))
+	for _, annot := range annots {
+		switch annot.GetSubtype() {
+		case enums.FPDF_ANNOT_SUBTYPE_POPUP, enums.FPDF_ANNOT_SUBTYPE_WIDGET, enums.FPDF_ANNOT_SUBTYPE_XFAWIDGET:
+			continue
+		}
+		entries = append(entries, newEntry(pageNum, annot, quotes[annot.GetNM()]))
+	}
+	return entries, nil
+}
+
+// annotationFields are the getters of the common keys, implemented by every annotation type.
+type annotationFields interface {
+	GetTitle() string
+	GetContents() string
+	GetRect() annotation.Rect
+	GetStrikeColor() *annotation.Color
+	GetFillColor() *annotation.Color
+	GetCreationDate() time.Time
+	GetModDate() time.Time
+}
+
+func newEntry(pageNum int, annot annotation.Annotation, quote string) Entry {
+	entry := Entry{
+		PageNumber: pageNum,
+		NM:         annot.GetNM(),
+		Subtype:    annot.GetSubtypeName(),
+		Quote:      quote,
+	}
+	if a, ok := annot.(annotationFields); ok {
+		entry.Author = a.GetTitle()
+		entry.Contents = a.GetContents()
+		entry.Rect = a.GetRect()
+		entry.Date = a.GetModDate()
+		if entry.Date.IsZero() {
+			entry.Date = a.GetCreationDate()
+		}
+		// highlights are drawn with their fill color
+		entry.Color = a.GetStrikeColor()
+		if entry.Color == nil || annot.GetSubtype() == enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT && a.GetFillColor() != nil {
+			entry.Color = a.GetFillColor()
+		}
+	}
+	if f, ok := annot.(*annotation.FreeTextAnnotation); ok && entry.Contents == "" {
+		entry.Contents = f.Contents
+	}
+	return entry
+}
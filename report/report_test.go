@@ -0,0 +1,109 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	annotation "pdf-annotation-knife"
+)
+
+func newTestReport() *Report {
+	yellow := annotation.Color{R: 255, G: 255, B: 0}
+	return &Report{
+		Title: "Review",
+		Pages: []Page{
+			{
+				PageNumber: 0,
+				Width:      595,
+				Height:     842,
+				Entries: []Entry{
+					{PageNumber: 0, NM: "a", Subtype: "Square", Author: "bob", Contents: "too wide",
+						Date: time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC), Rect: annotation.Rect{Left: 100, Bottom: 100, Right: 200, Top: 200}},
+					{PageNumber: 0, NM: "b", Subtype: "Highlight", Author: "Alice", Quote: "the *quoted* text", Color: &yellow,
+						Date: time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC), Rect: annotation.Rect{Left: 100, Bottom: 700, Right: 200, Top: 720}},
+					{PageNumber: 0, NM: "c", Subtype: "Text", Author: "carol", Contents: "<b>undated</b>\nsecond line",
+						Rect: annotation.Rect{Left: 50, Bottom: 400, Right: 70, Top: 420}},
+				},
+			},
+		},
+	}
+}
+
+func TestSort(t *testing.T) {
+	for _, tc := range []struct {
+		by   SortBy
+		want string
+	}{
+		{SortByPage, "bca"},
+		{SortByAuthor, "bac"},
+		{SortByDate, "abc"},
+	} {
+		r := newTestReport()
+		r.Sort(tc.by)
+		var got string
+		for _, entry := range r.Entries() {
+			got += entry.NM
+		}
+		if got != tc.want {
+			t.Fatalf("sort by %d: expect %s, got %s", tc.by, tc.want, got)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := newTestReport()
+	r.Sort(SortByPage)
+
+	t.Run("markdown", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := r.WriteMarkdown(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		md := buf.String()
+		for _, want := range []string{"# Review\n", "## Page 1\n", "1. **Highlight** by Alice, 2024-05-08 00:00 `#FFFF00`\n",
+			"   > the \\*quoted\\* text\n", "   \\<b>undated\\</b>\n   second line\n"} {
+			if !strings.Contains(md, want) {
+				t.Fatalf("expect %q in markdown:\n%s", want, md)
+			}
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := r.WriteCSV(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 4 || strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+			t.Fatalf("unexpected rows: %v", rows)
+		}
+		if rows[3][0] != "1" || rows[3][2] != "bob" || rows[3][3] != "2024-05-07T00:00:00Z" || rows[1][4] != "#FFFF00" {
+			t.Fatalf("unexpected rows: %v", rows)
+		}
+	})
+
+	t.Run("html", func(t *testing.T) {
+		r.Pages[0].Thumbnail = []byte{0x89, 'P', 'N', 'G'}
+		var buf bytes.Buffer
+		err := r.WriteHTML(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		html := buf.String()
+		for _, want := range []string{"<title>Review</title>", "&lt;b&gt;undated&lt;/b&gt;", `src="data:image/png;base64,`,
+			// the highlight is drawn 842-720 points from the top
+			`<rect x="100" y="122" width="100" height="20"`} {
+			if !strings.Contains(html, want) {
+				t.Fatalf("expect %q in html:\n%s", want, html)
+			}
+		}
+	})
+}
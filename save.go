@@ -0,0 +1,590 @@
+// 保存
+package annotation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// pdfium has setters for strings, colors, the rect, the border and a few arrays only, and it can not
+// create every subtype. The other keys are kept in a stash: a dictionary in pdf syntax written as the
+// string value of stashKey. Subtypes pdfium can not create are added as stamps with their /Subtype in the
+// stash. SavePDF writes the stash into the annotation dictionaries and keeps it, LoadAnnotationsInPage reads
+// the keys pdfium has no getter for from it.
+const (
+	stashKey       = "AKStash"
+	stashRefsKey   = "AKRefs"      // keys referencing another annotation of the page, the values are the NM of the annotation
+	stashDRFontKey = "AKDRFont"    // name the font of the appearance is registered under in /DR
+	stashBlendKey  = "AKBlendMode" // blend mode of the ExtGState /GS of the appearance
+)
+
+// creatableSubtypes are the subtypes FPDFPage_CreateAnnot accepts.
+var creatableSubtypes = map[enums.FPDF_ANNOTATION_SUBTYPE]bool{
+	enums.FPDF_ANNOT_SUBTYPE_TEXT:           true,
+	enums.FPDF_ANNOT_SUBTYPE_LINK:           true,
+	enums.FPDF_ANNOT_SUBTYPE_FREETEXT:       true,
+	enums.FPDF_ANNOT_SUBTYPE_SQUARE:         true,
+	enums.FPDF_ANNOT_SUBTYPE_CIRCLE:         true,
+	enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT:      true,
+	enums.FPDF_ANNOT_SUBTYPE_UNDERLINE:      true,
+	enums.FPDF_ANNOT_SUBTYPE_SQUIGGLY:       true,
+	enums.FPDF_ANNOT_SUBTYPE_STRIKEOUT:      true,
+	enums.FPDF_ANNOT_SUBTYPE_STAMP:          true,
+	enums.FPDF_ANNOT_SUBTYPE_INK:            true,
+	enums.FPDF_ANNOT_SUBTYPE_POPUP:          true,
+	enums.FPDF_ANNOT_SUBTYPE_FILEATTACHMENT: true,
+}
+
+// extraKeyEntries returns the keys of the annotation pdfium has no setter for, in pdf syntax.
+func extraKeyEntries(annot Annotation) []string {
+	var entries []string
+	if s, ok := annot.(interface{ border() *BorderStyle }); ok && s.border().styled() {
+		entries = append(entries, borderStyleEntries(annot.(annotationBase).base().width, s.border())...)
+	}
+	switch a := annot.(type) {
+	case *LineAnnotation:
+		entries = append(entries,
+			"/L ["+formatFDFPoints(a.lineTo[:])+"]",
+			"/LE "+formatFDFLineEndings(a.LineEndings),
+		)
+		if !IsZeroEpsilon(a.LeaderLine) {
+			entries = append(entries, "/LL "+formatNumbers(a.LeaderLine))
+		}
+		if !IsZeroEpsilon(a.LeaderLineExtension) {
+			entries = append(entries, "/LLE "+formatNumbers(a.LeaderLineExtension))
+		}
+		if a.Caption {
+			entries = append(entries, "/Cap true")
+		}
+	case *PolygonAnnotation:
+		entries = append(entries, "/Vertices ["+formatFDFPoints(a.Vertices)+"]")
+	case *PolylineAnnotation:
+		entries = append(entries, "/Vertices ["+formatFDFPoints(a.Vertices)+"]", "/LE "+formatFDFLineEndings(a.LineEndings))
+	case *TextAnnotation:
+		if a.Icon != "" {
+			entries = append(entries, "/Name "+formatPDFName(string(a.Icon)))
+		}
+		entries = append(entries, fmt.Sprintf("/Open %t", a.Open))
+	case *FreeTextAnnotation:
+		entries = append(entries, fmt.Sprintf("/Q %d", a.Alignment))
+	case *RedactAnnotation:
+		if len(a.QuadPoints) > 0 {
+			entries = append(entries, "/QuadPoints ["+formatFDFQuadPoints(a.QuadPoints)+"]")
+		}
+	}
+	return entries
+}
+
+// readExtraKeys reads the keys written by extraKeyEntries from an annotation dictionary, missing keys are left as they are.
+func readExtraKeys(objects map[int]any, dict pdfDict, annot Annotation) {
+	get := func(key string) any {
+		return resolvePDFObject(objects, dict[key])
+	}
+
+	if s, ok := annot.(interface{ border() *BorderStyle }); ok {
+		style := s.border()
+		bs, _ := get("BS").(pdfDict)
+		if name, ok := resolvePDFObject(objects, bs["S"]).(pdfName); ok {
+			style.Style = string(name)
+		}
+		if dashes, ok := resolvePDFObject(objects, bs["D"]).([]any); ok {
+			style.DashArray = nil
+			for _, dash := range pdfNumbers(dashes) {
+				style.DashArray = append(style.DashArray, int(dash+0.5))
+			}
+		}
+		be, _ := get("BE").(pdfDict)
+		if name, _ := resolvePDFObject(objects, be["S"]).(pdfName); name == BorderEffectCloudy {
+			intensity, _ := resolvePDFObject(objects, be["I"]).(float64)
+			style.SetCloudy(int(intensity + 0.5))
+		}
+	}
+
+	// the quads of redactions are stashed, like fewer quads than pdfium holds after UpdateAnnotByNM
+	if quadPoints, err := pdfQuadPoints(get("QuadPoints")); err == nil && len(quadPoints) > 0 {
+		setMarkupQuadPoints(annot, quadPoints)
+		if a, ok := annot.(*RedactAnnotation); ok {
+			a.QuadPoints = quadPoints
+		}
+	}
+
+	switch a := annot.(type) {
+	case *LineAnnotation:
+		if line := pdfNumbers(get("L")); len(line) == 4 {
+			a.SetLineTo(line[0], line[1], line[2], line[3])
+		}
+		if endings, ok := get("LE").([]any); ok {
+			a.LineEndings = pdfLineEndings(endings)
+		}
+		if leaderLine, ok := get("LL").(float64); ok {
+			a.LeaderLine = float32(leaderLine)
+		}
+		if extension, ok := get("LLE").(float64); ok {
+			a.LeaderLineExtension = float32(extension)
+		}
+		if caption, ok := get("Cap").(bool); ok {
+			a.Caption = caption
+		}
+	case *PolygonAnnotation:
+		if vertices, ok := get("Vertices").([]any); ok {
+			a.Vertices = pdfPoints(vertices)
+		}
+	case *PolylineAnnotation:
+		if vertices, ok := get("Vertices").([]any); ok {
+			a.Vertices = pdfPoints(vertices)
+		}
+		if endings, ok := get("LE").([]any); ok {
+			a.LineEndings = pdfLineEndings(endings)
+		}
+	case *TextAnnotation:
+		if icon, ok := get("Name").(pdfName); ok {
+			a.Icon = TextIcon(icon)
+		}
+		if open, ok := get("Open").(bool); ok {
+			a.Open = open
+		}
+		if refs, ok := get(stashRefsKey).(pdfDict); ok {
+			a.popupNM, _ = refs["Popup"].(string)
+		}
+	case *FreeTextAnnotation:
+		if alignment, ok := get("Q").(float64); ok {
+			a.Alignment = TextAlignment(alignment)
+		}
+	case *HighlightAnnotation:
+		if mode, ok := get(stashBlendKey).(pdfName); ok {
+			a.blendMode = enums.PDF_BLEND_MODE(mode)
+		}
+	}
+}
+
+// writeStash writes the entries to the stash of the annotation, replacing the previous stash.
+func writeStash(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, entries []string) error {
+	var buf bytes.Buffer
+	buf.WriteString("<<")
+	for _, entry := range entries {
+		buf.WriteString(entry)
+		buf.WriteString(" ")
+	}
+	buf.WriteString(">>")
+	_, err := instance.FPDFAnnot_SetStringValue(&requests.FPDFAnnot_SetStringValue{
+		Annotation: annotRef,
+		Key:        stashKey,
+		Value:      buf.String(),
+	})
+	return err
+}
+
+// loadStash returns the stash of the annotation, nil when it has none.
+func loadStash(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION) (pdfDict, error) {
+	value, err := getAnnotString(instance, annotRef, stashKey)
+	if err != nil || value == "" {
+		return nil, err
+	}
+	stash, err := parsePDFValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stash %q: %w", value, err)
+	}
+	dict, _ := stash.(pdfDict)
+	return dict, nil
+}
+
+// SavePDF saves the document like FPDF_SaveAsCopy and writes the stashed keys of the annotations added
+// by this package into their dictionaries, in an incremental update at the end of the file. Without it
+// lines, polygons, polylines, redactions and free texts are saved as stamps with their keys in a private string,
+// which LoadAnnotationsInPage still reads but other viewers ignore.
+// ps: an encrypted document can not be updated, saving one with stashed keys fails.
+func SavePDF(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, w io.Writer) error {
+	saveRes, err := instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+		Document: pdfDoc,
+	})
+	if err != nil {
+		return err
+	}
+	if saveRes.FileBytes == nil {
+		return errors.New("document was not saved")
+	}
+	data := *saveRes.FileBytes
+
+	if bytes.Contains(data, []byte("/"+stashKey)) {
+		revisionRes, err := instance.FPDF_GetSecurityHandlerRevision(&requests.FPDF_GetSecurityHandlerRevision{
+			Document: pdfDoc,
+		})
+		if err != nil {
+			return err
+		}
+		if revisionRes.SecurityHandlerRevision != -1 {
+			return errors.New("can not write the stashed keys of an encrypted document")
+		}
+		data, err = writeStashedKeys(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// SavePDFFile saves the document to filePath with SavePDF.
+func SavePDFFile(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, filePath string) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	err = SavePDF(instance, pdfDoc, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// savedAnnot is an annotation dictionary of a saved file.
+type savedAnnot struct {
+	obj        int // object the dictionary is in, or the object of the dictionary
+	start, end int // span of the dictionary in the file
+	dict       pdfDict
+	stash      pdfDict
+	num        int // number of the object of the dictionary, 0 while it is a direct object
+}
+
+// pdfObjectSpan is the span of the value of an indirect object in a file.
+type pdfObjectSpan struct {
+	gen, start, end int
+}
+
+var fontOperatorRegexp = regexp.MustCompile(`/([^\s/\[\]()<>{}%]+)\s+[0-9.+-]+\s+Tf`)
+
+// writeStashedKeys appends an incremental update to a file saved by pdfium: the annotations with a stash,
+// and the ones they reference, become indirect objects with the stashed keys merged in, and their pages
+// reference them. The appearance streams get the resources pdfium put in the page resources.
+func writeStashedKeys(data []byte) ([]byte, error) {
+	spans := map[int]pdfObjectSpan{}
+	var annots, pending []*savedAnnot
+	lastDictStart := -1
+	p := &pdfParser{data: data, raw: true}
+	p.onDict = func(start, end int, dict pdfDict) {
+		lastDictStart = start
+		_, hasStash := dict[stashKey]
+		_, hasNM := dict["NM"]
+		_, hasRect := dict["Rect"]
+		if hasStash || hasNM && hasRect {
+			pending = append(pending, &savedAnnot{start: start, end: end, dict: dict})
+		}
+	}
+	p.onObject = func(num, gen, start, end int, obj any) {
+		spans[num] = pdfObjectSpan{gen: gen, start: start, end: end}
+		_, isDict := obj.(pdfDict)
+		for _, a := range pending {
+			a.obj = num
+			if isDict && a.start == lastDictStart {
+				a.num = num
+			}
+		}
+		annots = append(annots, pending...)
+		pending = nil
+	}
+	objects, trailer, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	// pdfium writes no object streams, the spans of the annotations are needed to replace them
+	for num := range p.compressed {
+		if strings.Contains(formatPDFObject(nil, objects[num]), "/"+stashKey) {
+			return nil, fmt.Errorf("object %d with stashed keys is in an object stream", num)
+		}
+	}
+	size, ok := trailer["Size"].(float64)
+	if !ok {
+		return nil, errors.New("the saved file has no trailer")
+	}
+	prev := bytes.LastIndex(data, []byte("startxref"))
+	if prev == -1 {
+		return nil, errors.New("the saved file has no startxref")
+	}
+	prevXref, err := strconv.Atoi(string(bytes.TrimSpace(bytes.SplitN(data[prev+len("startxref"):], []byte("%%EOF"), 2)[0])))
+	if err != nil {
+		return nil, fmt.Errorf("invalid startxref: %w", err)
+	}
+
+	// the annotations with a stash and the ones they reference
+	byNM := map[string]*savedAnnot{}
+	for _, a := range annots {
+		if nm, ok := a.dict["NM"].(pdfRawString); ok {
+			byNM[decodePDFText([]byte(nm))] = a
+		}
+	}
+	written := map[*savedAnnot]bool{}
+	for _, a := range annots {
+		raw, ok := a.dict[stashKey].(pdfRawString)
+		if !ok {
+			continue
+		}
+		stash, err := (&pdfParser{data: []byte(raw), raw: true}).parseObject()
+		if err != nil {
+			return nil, fmt.Errorf("invalid stash %q: %w", raw, err)
+		}
+		a.stash, _ = stash.(pdfDict)
+		if stashMerged(objects, a) {
+			continue
+		}
+		written[a] = true
+		refs, _ := a.stash[stashRefsKey].(pdfDict)
+		for _, nm := range refs {
+			if nm, ok := nm.(pdfRawString); ok && byNM[decodePDFText([]byte(nm))] != nil {
+				written[byNM[decodePDFText([]byte(nm))]] = true
+			}
+		}
+	}
+	if len(written) == 0 {
+		return data, nil
+	}
+
+	nextNum := int(size)
+	for _, a := range annots {
+		if written[a] && a.num == 0 {
+			a.num = nextNum
+			nextNum++
+		}
+	}
+
+	bodies := map[int]string{}
+	for _, a := range annots {
+		// referenced indirect annotations stay as they are
+		if !written[a] || a.stash == nil && a.num == a.obj {
+			continue
+		}
+		pageNum, page := savedAnnotPage(objects, a.obj)
+		if a.num != a.obj && pageNum != 0 {
+			a.dict["P"] = pdfRef{num: pageNum, gen: spans[pageNum].gen}
+		}
+		if a.stash != nil {
+			err = mergeStash(objects, spans, bodies, byNM, a, page)
+			if err != nil {
+				return nil, err
+			}
+		}
+		bodies[a.num] = formatPDFObject(nil, a.dict)
+	}
+
+	// the objects holding direct annotation dictionaries reference them instead
+	replaced := map[int][]*savedAnnot{}
+	for _, a := range annots {
+		if written[a] && a.num != a.obj {
+			replaced[a.obj] = append(replaced[a.obj], a)
+		}
+	}
+	for obj, items := range replaced {
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].start < items[j].start
+		})
+		span := spans[obj]
+		var body bytes.Buffer
+		pos := span.start
+		for _, a := range items {
+			body.Write(data[pos:a.start])
+			fmt.Fprintf(&body, " %d 0 R ", a.num)
+			pos = a.end
+		}
+		body.Write(data[pos:span.end])
+		bodies[obj] = string(bytes.TrimSpace(body.Bytes()))
+	}
+
+	// write the update
+	var out bytes.Buffer
+	out.Write(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		out.WriteString("\n")
+	}
+	nums := make([]int, 0, len(bodies))
+	for num := range bodies {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	offsets := make(map[int]int, len(nums))
+	for _, num := range nums {
+		offsets[num] = out.Len()
+		fmt.Fprintf(&out, "%d %d obj\n%s\nendobj\n", num, spans[num].gen, bodies[num])
+	}
+
+	xref := out.Len()
+	out.WriteString("xref\n")
+	for i := 0; i < len(nums); {
+		j := i + 1
+		for j < len(nums) && nums[j] == nums[j-1]+1 {
+			j++
+		}
+		fmt.Fprintf(&out, "%d %d\n", nums[i], j-i)
+		for _, num := range nums[i:j] {
+			fmt.Fprintf(&out, "%010d %05d n\r\n", offsets[num], spans[num].gen)
+		}
+		i = j
+	}
+
+	delete(trailer, "XRefStm")
+	trailer["Size"] = float64(nextNum)
+	trailer["Prev"] = float64(prevXref)
+	fmt.Fprintf(&out, "trailer\n%s\nstartxref\n%d\n%%%%EOF\n", formatPDFObject(nil, trailer), xref)
+	return out.Bytes(), nil
+}
+
+// mergeStash moves the stashed keys of the annotation into its dictionary. The appearance stream,
+// whose resources pdfium puts in the page resources, gets the resources of the page.
+func mergeStash(objects map[int]any, spans map[int]pdfObjectSpan, bodies map[int]string, byNM map[string]*savedAnnot, a *savedAnnot, page pdfDict) error {
+	for key, value := range a.stash {
+		switch key {
+		case stashRefsKey:
+			refs, _ := value.(pdfDict)
+			for refKey, nm := range refs {
+				nm, _ := nm.(pdfRawString)
+				if target := byNM[decodePDFText([]byte(nm))]; target != nil {
+					a.dict[refKey] = pdfRef{num: target.num, gen: spans[target.num].gen}
+				}
+			}
+		case stashDRFontKey, stashBlendKey:
+		default:
+			a.dict[key] = value
+		}
+	}
+
+	ap, _ := resolvePDFObject(objects, a.dict["AP"]).(pdfDict)
+	apRef, ok := ap["N"].(pdfRef)
+	if !ok {
+		return nil
+	}
+	stream, ok := objects[apRef.num].(*pdfStream)
+	if !ok {
+		return nil
+	}
+	writeStream := func() {
+		bodies[apRef.num] = formatPDFObject(nil, stream.dict) + "\nstream\r\n" + string(stream.data) + "\r\nendstream"
+	}
+	if _, ok := stream.dict["Resources"]; !ok && page != nil {
+		resources := savedPageResources(objects, page)
+		if resources == nil {
+			return nil
+		}
+		stream.dict["Resources"] = resources
+		writeStream()
+	}
+
+	// set the blend mode in /GS, pdfium writes /BM /Normal. The dictionaries are copied, the page resources may be shared
+	if mode, ok := a.stash[stashBlendKey].(pdfName); ok {
+		resources := copyPDFDict(resolvePDFObject(objects, stream.dict["Resources"]))
+		extGState := copyPDFDict(resolvePDFObject(objects, resources["ExtGState"]))
+		gs := copyPDFDict(resolvePDFObject(objects, extGState["GS"]))
+		gs["Type"] = pdfName("ExtGState")
+		gs["BM"] = mode
+		extGState["GS"] = gs
+		resources["ExtGState"] = extGState
+		stream.dict["Resources"] = resources
+		writeStream()
+	}
+
+	// register the font of the text in the appearance under the name of /DA
+	if name, ok := a.stash[stashDRFontKey].(pdfName); ok {
+		content, err := decodePDFStream(stream)
+		if err != nil {
+			return err
+		}
+		match := fontOperatorRegexp.FindSubmatch(content)
+		resources, _ := resolvePDFObject(objects, stream.dict["Resources"]).(pdfDict)
+		fonts, _ := resolvePDFObject(objects, resources["Font"]).(pdfDict)
+		if match != nil && fonts[string(match[1])] != nil {
+			a.dict["DR"] = pdfDict{"Font": pdfDict{string(name): fonts[string(match[1])]}}
+		}
+	}
+	return nil
+}
+
+// stashMerged reports whether the stashed keys are already in the dictionary, e.g. in a file saved by SavePDF before.
+func stashMerged(objects map[int]any, a *savedAnnot) bool {
+	for key, value := range a.stash {
+		switch key {
+		case stashRefsKey:
+			refs, _ := value.(pdfDict)
+			for refKey := range refs {
+				if _, ok := a.dict[refKey]; !ok {
+					return false
+				}
+			}
+		case stashDRFontKey:
+			if _, ok := a.dict["DR"]; !ok {
+				return false
+			}
+		case stashBlendKey:
+			ap, _ := resolvePDFObject(objects, a.dict["AP"]).(pdfDict)
+			stream, _ := resolvePDFObject(objects, ap["N"]).(*pdfStream)
+			if stream == nil {
+				return false
+			}
+			resources, _ := resolvePDFObject(objects, stream.dict["Resources"]).(pdfDict)
+			extGState, _ := resolvePDFObject(objects, resources["ExtGState"]).(pdfDict)
+			gs, _ := resolvePDFObject(objects, extGState["GS"]).(pdfDict)
+			if resolvePDFObject(objects, gs["BM"]) != value {
+				return false
+			}
+		default:
+			if a.dict[key] == nil || formatPDFObject(nil, a.dict[key]) != formatPDFObject(nil, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// copyPDFDict returns a copy of the dictionary, an empty one when obj is no dictionary.
+func copyPDFDict(obj any) pdfDict {
+	dict, _ := obj.(pdfDict)
+	c := make(pdfDict, len(dict)+1)
+	for key, value := range dict {
+		c[key] = value
+	}
+	return c
+}
+
+// savedAnnotPage returns the page holding an annotation of object obj: the page itself, or the page whose /Annots is obj.
+func savedAnnotPage(objects map[int]any, obj int) (int, pdfDict) {
+	if dict, ok := objects[obj].(pdfDict); ok && dict["Type"] == pdfName("Page") {
+		return obj, dict
+	}
+	for num, object := range objects {
+		dict, ok := object.(pdfDict)
+		if !ok || dict["Type"] != pdfName("Page") {
+			continue
+		}
+		if ref, ok := dict["Annots"].(pdfRef); ok && ref.num == obj {
+			return num, dict
+		}
+		// an indirect annotation listed in /Annots
+		annotRefs, _ := resolvePDFObject(objects, dict["Annots"]).([]any)
+		for _, annotRef := range annotRefs {
+			if ref, ok := annotRef.(pdfRef); ok && ref.num == obj {
+				return num, dict
+			}
+		}
+	}
+	return 0, nil
+}
+
+// savedPageResources returns the resources of a page, inherited from the page tree when the page has none.
+func savedPageResources(objects map[int]any, page pdfDict) any {
+	for i := 0; page != nil && i < 32; i++ {
+		if resources, ok := page["Resources"]; ok {
+			return resources
+		}
+		page, _ = resolvePDFObject(objects, page["Parent"]).(pdfDict)
+	}
+	return nil
+}
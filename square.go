@@ -13,6 +13,7 @@ import (
 
 type SquareAnnotation struct {
 	BaseAnnotation
+	BorderStyle
 }
 
 func NewSquareAnnotation() *SquareAnnotation {
@@ -35,6 +36,7 @@ func (s *SquareAnnotation) GenerateAppearance() error {
 		s.GetWidthAP(),
 		s.GetColorAP(),
 		s.GetPDFOpacityAP(),
+		s.GetDashAP(),
 		s.pointsCallback(),
 	}, "\n")
 
@@ -42,21 +44,26 @@ func (s *SquareAnnotation) GenerateAppearance() error {
 }
 
 func (s *SquareAnnotation) pointsCallback() string {
-	x := s.rect.Left + float32(s.width)/2
-	y := s.rect.Bottom + float32(s.width)/2
-	width := s.rect.Right - s.rect.Left - float32(s.width)
-	height := s.rect.Top - s.rect.Bottom - float32(s.width)
-	var ap = fmt.Sprintf("%.3f %.3f %.3f %.3f re\n", x, y, width, height)
+	var ap string
+	// an underline border only strokes the bottom, fill the whole rect first
+	if s.fillColor != nil && s.Style == BorderStyleUnderline && !s.isCloudy() {
+		ap += fmt.Sprintf("%.3f %.3f %.3f %.3f re\nf\n", s.rect.Left, s.rect.Bottom, s.rect.Right-s.rect.Left, s.rect.Top-s.rect.Bottom)
+	}
+	ap += s.rectBorderPathAP(s.rect, s.width)
 	if s.fillColor != nil {
 		ap += "B\n"
 	}
 	if s.strikeColor != nil {
 		ap += "S\n"
 	}
+	if !s.isCloudy() {
+		ap += s.bevelAP(s.Style, insetRect(s.rect, s.width))
+	}
 	return ap
 }
 
 func (s *SquareAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
+	s.stash = extraKeyEntries(s)
 	err := s.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
 	if err != nil {
 		return err
@@ -66,7 +73,7 @@ func (s *SquareAnnotation) AddAnnotationToPage(ctx context.Context, instance pdf
 		Annotation: s.annot,
 	})
 	if err != nil {
-		return err
+		return s.newError(StepClose, err)
 	}
 
 	return nil
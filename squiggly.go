@@ -0,0 +1,108 @@
+// 波浪线
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+type SquigglyAnnotation struct {
+	BaseAnnotation
+	QuadPoints []QuadPoint
+}
+
+func NewSquigglyAnnotation() *SquigglyAnnotation {
+	return &SquigglyAnnotation{
+		BaseAnnotation: BaseAnnotation{
+			subtype: enums.FPDF_ANNOT_SUBTYPE_SQUIGGLY,
+			nm:      GenerateUUID(),
+			opacity: DefaultOpacity,
+		},
+	}
+}
+
+func (s *SquigglyAnnotation) GenerateAppearance() error {
+	// generate squiggly appearance
+	s.ap = strings.Join([]string{
+		"1 J 1 j",
+		s.GetColorAP(),
+		s.GetPDFOpacityAP(),
+		s.pointsCallback(),
+	}, "\n")
+	return nil
+}
+
+// pointsCallback draws a zigzag along the bottom of each quad, its size follows the quad height
+// and its direction follows the quad, so rotated text gets a rotated wave.
+func (s *SquigglyAnnotation) pointsCallback() string {
+	var ap string
+	for _, q := range s.QuadPoints {
+		// unit vectors along the text and up from the baseline
+		alongX, alongY := q.RightBottomX-q.LeftBottomX, q.RightBottomY-q.LeftBottomY
+		length := float32(math.Hypot(float64(alongX), float64(alongY)))
+		upX, upY := q.LeftTopX-q.LeftBottomX, q.LeftTopY-q.LeftBottomY
+		height := float32(math.Hypot(float64(upX), float64(upY)))
+		if IsZeroEpsilon(length) || IsZeroEpsilon(height) {
+			continue
+		}
+		alongX, alongY = alongX/length, alongY/length
+		upX, upY = upX/height, upY/height
+
+		amplitude := height / 14
+		halfPeriod := height / 7
+		ap += fmt.Sprintf("%.3f w\n", max(height/24, 0.5))
+
+		steps := int(math.Ceil(float64(length / halfPeriod)))
+		for i := 0; i <= steps; i++ {
+			d := min(float32(i)*halfPeriod, length)
+			offset := float32(0)
+			if i%2 == 1 {
+				offset = 2 * amplitude
+			}
+			x := q.LeftBottomX + alongX*d + upX*offset
+			y := q.LeftBottomY + alongY*d + upY*offset
+			if i == 0 {
+				ap += fmt.Sprintf("%.3f %.3f m ", x, y)
+			} else {
+				ap += fmt.Sprintf("%.3f %.3f l ", x, y)
+			}
+		}
+		ap += "S\n"
+	}
+	return ap
+}
+
+func (s *SquigglyAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
+	// create annotation
+	err := s.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
+	if err != nil {
+		return err
+	}
+
+	// insert quad points
+	quadPoints := convertQuadPointToPdfiumFormat(s.QuadPoints)
+	for _, points := range quadPoints {
+		_, err = instance.FPDFAnnot_AppendAttachmentPoints(&requests.FPDFAnnot_AppendAttachmentPoints{
+			Annotation:       s.annot,
+			AttachmentPoints: points,
+		})
+		if err != nil {
+			return s.abort(instance, page, StepSetQuadPoints, err)
+		}
+	}
+
+	// close annotation
+	_, err = instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: s.annot,
+	})
+	if err != nil {
+		return s.newError(StepClose, err)
+	}
+	return nil
+}
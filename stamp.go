@@ -3,11 +3,13 @@ package annotation
 import (
 	"context"
 	"errors"
+	"image"
 
 	"github.com/klippa-app/go-pdfium"
 	"github.com/klippa-app/go-pdfium/enums"
 	"github.com/klippa-app/go-pdfium/references"
 	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
 )
 
 type StampObjectType int
@@ -40,11 +42,40 @@ func (s *StampAnnotation) SetPathObject(points [][]Point, width float32, strikeC
 	}
 }
 
+// ImgPlacement is how an image is placed in the rect of the stamp.
+type ImgPlacement int
+
+const (
+	ImgPlacementFill     ImgPlacement = 0 // stretch the image to the rect
+	ImgPlacementFit      ImgPlacement = 1 // the whole image in the center of the rect, keeping its aspect ratio
+	ImgPlacementCover    ImgPlacement = 2 // fill the rect keeping the aspect ratio, the overflow is clipped
+	ImgPlacementOriginal ImgPlacement = 3 // one point per pixel, anchored at a corner of the rect
+)
+
+// ImgAnchor is the corner of the rect an original size image is anchored at.
+type ImgAnchor int
+
+const (
+	ImgAnchorBottomLeft  ImgAnchor = 0
+	ImgAnchorBottomRight ImgAnchor = 1
+	ImgAnchorTopLeft     ImgAnchor = 2
+	ImgAnchorTopRight    ImgAnchor = 3
+)
+
 // ImageObjectParam is the parameter for creating an image object.
+// The image is read from Image, Data or FilePath, the first one set.
 type ImageObjectParam struct {
-	ImgType  string // png/jpg/jpeg
+	ImgType  string // png/jpg/jpeg/gif, detected from the image when empty
 	Document references.FPDF_DOCUMENT
 	FilePath string
+	Data     []byte      // encoded image, e.g. a png uploaded by the browser
+	Image    image.Image // decoded image, e.g. a signature drawn on a canvas
+
+	Placement ImgPlacement
+	Anchor    ImgAnchor // ImgPlacementOriginal only
+	Rotation  float32   // degrees counterclockwise, around the center of the image
+
+	matrix *structs.FPDF_FS_MATRIX // the matrix of an image read from an appearance, used instead of the placement
 }
 
 func (s *StampAnnotation) SetImgObject(imgType string, document references.FPDF_DOCUMENT, filePath string) {
@@ -56,11 +87,68 @@ func (s *StampAnnotation) SetImgObject(imgType string, document references.FPDF_
 	}
 }
 
+// SetImgObjectData sets an encoded jpeg, png or gif image, gif images show their first frame.
+func (s *StampAnnotation) SetImgObjectData(document references.FPDF_DOCUMENT, data []byte) {
+	s.objectType = StampObjectImg
+	s.imgObject = &ImageObjectParam{
+		Document: document,
+		Data:     data,
+	}
+}
+
+// SetImgObjectImage sets a decoded image, its alpha channel becomes the soft mask of the image.
+func (s *StampAnnotation) SetImgObjectImage(document references.FPDF_DOCUMENT, img image.Image) {
+	s.objectType = StampObjectImg
+	s.imgObject = &ImageObjectParam{
+		Document: document,
+		Image:    img,
+	}
+}
+
 // TextObjectParam is the parameter for creating a text object.
 type TextObjectParam struct {
-	Document references.FPDF_DOCUMENT
-	FontSize float32
-	Text     string
+	Document     references.FPDF_DOCUMENT // the document of the page, used to load Helvetica when Font is nil
+	Font         *Font                    // nil means Helvetica, a font from LoadFontFile/LoadFontData shows CJK text
+	FontSize     float32                  // 0 sizes the text to fill the rect
+	Text         string
+	Color        Color
+	Rotation     float32 // degrees counterclockwise, around the center of the rect
+	BorderWidth  float32 // width of the frame around the text, 0 draws no frame
+	BorderRadius float32 // corner radius of the frame
+}
+
+// SetImgPlacement sets how the image is placed in the rect and its rotation,
+// the anchor is only used by ImgPlacementOriginal. Call it after setting the image.
+func (s *StampAnnotation) SetImgPlacement(placement ImgPlacement, anchor ImgAnchor, rotation float32) {
+	if s.imgObject == nil {
+		return
+	}
+	s.imgObject.Placement = placement
+	s.imgObject.Anchor = anchor
+	s.imgObject.Rotation = rotation
+}
+
+// SetTextObject sets the text of the stamp, centered in the rect and rotated around its center.
+// A size of 0 sizes the text to fill the rect, a nil font means Helvetica.
+func (s *StampAnnotation) SetTextObject(text string, font *Font, size float32, color Color, rotation float32) {
+	s.objectType = StampObjectText
+	s.textObject = &TextObjectParam{
+		Font:     font,
+		FontSize: size,
+		Text:     text,
+		Color:    color,
+		Rotation: rotation,
+	}
+}
+
+// SetTextBorder draws a frame in the text color around the text set by SetTextObject,
+// like the classic "APPROVED" stamps. A radius above 0 rounds its corners.
+func (s *StampAnnotation) SetTextBorder(width, radius float32) {
+	if s.textObject == nil {
+		return
+	}
+	s.textObject.BorderWidth = width
+	s.textObject.BorderRadius = radius
 }
 
 type StampAnnotation struct {
@@ -68,6 +156,8 @@ type StampAnnotation struct {
 	objectType StampObjectType
 	pathObject *PathObjectParam
 	imgObject  *ImageObjectParam
+	textObject *TextObjectParam
+	blendMode  enums.PDF_BLEND_MODE
 }
 
 func NewStampAnnotation() *StampAnnotation {
@@ -80,6 +170,63 @@ func NewStampAnnotation() *StampAnnotation {
 	}
 }
 
+// SetBlendMode sets the blend mode of the stamp object, e.g. enums.PDF_BLEND_MODE_MULTIPLY
+// lets a signature or logo darken the page below instead of covering it.
+// ps: pdfium writes the ExtGState of SetAP generated appearances with /BM /Normal,
+// so only stamps, whose appearance is generated from page objects, and highlights saved by SavePDF support it
+func (s *StampAnnotation) SetBlendMode(mode enums.PDF_BLEND_MODE) {
+	s.blendMode = mode
+}
+
+// appearanceImage returns the image of an image stamp and the matrix placing it, for the exports.
+// The matrix is nil when the image fills the rect. ok is false for the other stamps.
+func (s *StampAnnotation) appearanceImage() (img image.Image, matrix *structs.FPDF_FS_MATRIX, ok bool, err error) {
+	if s.objectType != StampObjectImg || s.imgObject == nil {
+		return nil, nil, false, nil
+	}
+	img = s.imgObject.Image
+	if img == nil {
+		img, err = decodeImg(s.imgObject)
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+	placement := s.imgObject.matrix
+	if placement == nil {
+		m, err := placeImg(s.rect, s.imgObject)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		placement = &m
+	}
+	fill := structs.FPDF_FS_MATRIX{
+		A: s.rect.Right - s.rect.Left,
+		D: s.rect.Top - s.rect.Bottom,
+		E: s.rect.Left,
+		F: s.rect.Bottom,
+	}
+	// a matrix read from an appearance is off by float rounding
+	const tolerance = 0.01
+	if abs32(placement.A-fill.A) < tolerance && abs32(placement.B) < tolerance && abs32(placement.C) < tolerance &&
+		abs32(placement.D-fill.D) < tolerance && abs32(placement.E-fill.E) < tolerance && abs32(placement.F-fill.F) < tolerance {
+		return img, nil, true, nil
+	}
+	return img, placement, true, nil
+}
+
+// setAppearanceImage sets the image of an imported stamp, it replaces the appearance. A nil matrix fills the rect.
+func (s *StampAnnotation) setAppearanceImage(img image.Image, matrix *structs.FPDF_FS_MATRIX) {
+	s.SetImgObjectImage("", img)
+	s.imgObject.matrix = matrix
+	s.ap = ""
+}
+
+// GenerateAppearance does nothing for stamps: pdfium builds the appearance
+// stream from the page object appended in AddAnnotationToPage.
+func (s *StampAnnotation) GenerateAppearance() error {
+	return nil
+}
+
 func (s *StampAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
 	// create annotation
 	err := s.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
@@ -87,30 +234,66 @@ func (s *StampAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfi
 		return err
 	}
 
-	// create page object
-	var objRef references.FPDF_PAGEOBJECT
+	// create page objects
+	var objRefs []references.FPDF_PAGEOBJECT
 	switch s.objectType {
 	case StampObjectPath:
+		var objRef references.FPDF_PAGEOBJECT
 		objRef, err = CreatePathObject(instance, page, s.pathObject)
+		objRefs = append(objRefs, objRef)
 	case StampObjectText:
-		// TODO objRef, err = CreateTextObject(instance, page, s.textObject)
+		if s.textObject.Document == "" && page.ByIndex != nil {
+			s.textObject.Document = page.ByIndex.Document
+		}
+		objRefs, err = CreateTextObjects(instance, s.rect, s.textObject)
 	case StampObjectImg:
+		if s.imgObject.Document == "" && page.ByIndex != nil {
+			s.imgObject.Document = page.ByIndex.Document
+		}
+		var objRef references.FPDF_PAGEOBJECT
 		objRef, err = CreateImgObject(instance, s.rect, s.imgObject)
+		objRefs = append(objRefs, objRef)
 	default:
-		return errors.New("object type not supported")
+		// a stamp without objects keeps its appearance, e.g. one loaded from a pdf or imported
+		if s.ap == "" {
+			err = errors.New("object type not supported")
+		}
 	}
 
 	if err != nil {
-		return err
+		return s.abort(instance, page, StepCreateObject, err)
 	}
 
-	// insert object
-	_, err = instance.FPDFAnnot_AppendObject(&requests.FPDFAnnot_AppendObject{
-		Annotation: s.annot,
-		PageObject: objRef,
-	})
-	if err != nil {
-		return err
+	// the objects not appended yet are not owned by the annotation
+	destroy := func(objRefs []references.FPDF_PAGEOBJECT) {
+		for _, objRef := range objRefs {
+			instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+				PageObject: objRef,
+			})
+		}
+	}
+	for i, objRef := range objRefs {
+		// set blend mode
+		if s.blendMode != "" {
+			_, err = instance.FPDFPageObj_SetBlendMode(&requests.FPDFPageObj_SetBlendMode{
+				PageObject: objRef,
+				BlendMode:  s.blendMode,
+			})
+			if err != nil {
+				destroy(objRefs[i:])
+				return s.abort(instance, page, StepCreateObject, err)
+			}
+		}
+
+		// insert object
+		_, err = instance.FPDFAnnot_AppendObject(&requests.FPDFAnnot_AppendObject{
+			Annotation: s.annot,
+			PageObject: objRef,
+		})
+		if err != nil {
+			destroy(objRefs[i:])
+			return s.abort(instance, page, StepAppendObject, err)
+		}
 	}
 
 	// close annotation
@@ -118,7 +301,7 @@ func (s *StampAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfi
 		Annotation: s.annot,
 	})
 	if err != nil {
-		return err
+		return s.newError(StepClose, err)
 	}
 
 	return nil
@@ -62,7 +62,7 @@ func (s *StrikeoutAnnotation) AddAnnotationToPage(ctx context.Context, instance
 			AttachmentPoints: points,
 		})
 		if err != nil {
-			return err
+			return s.abort(instance, page, StepSetQuadPoints, err)
 		}
 	}
 
@@ -71,7 +71,7 @@ func (s *StrikeoutAnnotation) AddAnnotationToPage(ctx context.Context, instance
 		Annotation: s.annot,
 	})
 	if err != nil {
-		return err
+		return s.newError(StepClose, err)
 	}
 
 	return nil
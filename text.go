@@ -0,0 +1,196 @@
+// 便签
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// TextIcon is the icon of a sticky note, the values are the names used in /Name.
+type TextIcon string
+
+const (
+	TextIconComment      TextIcon = "Comment"
+	TextIconNote         TextIcon = "Note"
+	TextIconHelp         TextIcon = "Help"
+	TextIconKey          TextIcon = "Key"
+	TextIconInsert       TextIcon = "Insert"
+	TextIconParagraph    TextIcon = "Paragraph"
+	TextIconNewParagraph TextIcon = "NewParagraph"
+)
+
+var (
+	DefaultTextIconSize  = float32(20)
+	DefaultTextIconColor = Color{R: 255, G: 209, B: 0}
+	DefaultPopupWidth    = float32(180)
+	DefaultPopupHeight   = float32(120)
+)
+
+// TextAnnotation is a sticky note: an icon on the page whose contents show in a popup window.
+// The strike color is the color of the icon. AddAnnotationToPage adds the popup annotation too.
+// ps: pdfium has no setter for names, booleans or references, SavePDF writes /Name, /Open and the
+// /Popup and /Parent links between the note and its popup
+type TextAnnotation struct {
+	BaseAnnotation
+	Icon      TextIcon
+	Open      bool  // whether the popup is shown when the document is opened
+	PopupRect *Rect // rect of the popup window, nil places it right of the icon in the default popup size
+
+	popupNM string // nm of the popup linked in the stash, until SavePDF writes /Popup
+}
+
+func NewTextAnnotation() *TextAnnotation {
+	color := DefaultTextIconColor
+	return &TextAnnotation{
+		BaseAnnotation: BaseAnnotation{
+			subtype:     enums.FPDF_ANNOT_SUBTYPE_TEXT,
+			nm:          GenerateUUID(),
+			opacity:     DefaultOpacity,
+			strikeColor: &color,
+		},
+		Icon: TextIconNote,
+	}
+}
+
+// SetPosition places the icon with its top left corner at x, y, in the default icon size.
+func (t *TextAnnotation) SetPosition(x, y float32) {
+	t.rect = Rect{
+		Left:   x,
+		Top:    y,
+		Right:  x + DefaultTextIconSize,
+		Bottom: y - DefaultTextIconSize,
+	}
+}
+
+func (t *TextAnnotation) SetIcon(icon TextIcon) {
+	t.Icon = icon
+}
+
+func (t *TextAnnotation) SetOpen(open bool) {
+	t.Open = open
+}
+
+func (t *TextAnnotation) SetPopupRect(rect Rect) {
+	t.PopupRect = &rect
+}
+
+func (t *TextAnnotation) GenerateAppearance() error {
+	// draw the 20x20 icon scaled into the rect
+	scaleX := (t.rect.Right - t.rect.Left) / 20
+	scaleY := (t.rect.Top - t.rect.Bottom) / 20
+
+	// generate text appearance
+	t.ap = strings.Join([]string{
+		"q",
+		fmt.Sprintf("%.3f 0 0 %.3f %.3f %.3f cm", scaleX, scaleY, t.rect.Left, t.rect.Bottom),
+		t.GetPDFOpacityAP(),
+		t.getColorAP(t.strikeColor, true),
+		"0.2 G 1 w 1 j 1 J",
+		t.iconCallback(),
+		"Q",
+	}, "\n")
+	return nil
+}
+
+// iconCallback draws the icon in a 20x20 box, filled with the fill color and outlined in dark gray.
+func (t *TextAnnotation) iconCallback() string {
+	switch t.Icon {
+	case TextIconComment:
+		// speech bubble with a tail at the bottom left
+		return "2 17 m 2 18.1 2.9 19 4 19 c 16 19 l 17.1 19 18 18.1 18 17 c 18 8 l 18 6.9 17.1 6 16 6 c " +
+			"9 6 l 4 2 l 5 6 l 4 6 l 2.9 6 2 6.9 2 8 c h B\n" +
+			"5 15 m 15 15 l S 5 12 m 15 12 l S 5 9 m 12 9 l S\n"
+	case TextIconHelp:
+		// question mark in a circle
+		return circleAP(10, 10, 8.5) + "B\n" +
+			"2 w 7 12.5 m 7 14.5 8.5 15.5 10 15.5 c 11.5 15.5 13 14.5 13 12.8 c 13 10.5 10 10.5 10 8 c S\n" +
+			circleAP(10, 4.8, 1.1) + "0.2 g f\n"
+	case TextIconKey:
+		// key with a round head and two teeth
+		return circleAP(6, 14, 4.5) + "B\n" +
+			circleAP(6, 14, 1.5) + "S\n" +
+			"9.2 10.8 m 18 2 l 18 2 m 15.5 2 l 15.5 4.5 l S 14 6 m 12 4 l S\n"
+	case TextIconInsert:
+		// caret
+		return "2 3 m 10 17 l 18 3 l 14 3 l 10 10 l 6 3 l h B\n"
+	case TextIconParagraph:
+		// pilcrow
+		return "9 18 m 5.7 18 3 15.8 3 13 c 3 10.2 5.7 8 9 8 c h B\n" +
+			"2 w 9 18 m 9 2 l S 13 18 m 13 2 l S 8 18 m 16 18 l S\n"
+	case TextIconNewParagraph:
+		// caret over the letters NP
+		return "4 12 m 10 19 l 16 12 l h B\n" +
+			"1.5 w 3 2 m 3 9 l 7 2 l 7 9 l S 10 2 m 10 9 l 12.5 9 l 14.5 9 14.5 5.5 12.5 5.5 c 10 5.5 l S\n"
+	default:
+		// page with text lines, the note icon
+		return "3 1 m 3 19 l 13 19 l 17 15 l 17 1 l h B\n" +
+			"13 19 m 13 15 l 17 15 l S\n" +
+			"6 12 m 14 12 l S 6 9 m 14 9 l S 6 6 m 14 6 l S\n"
+	}
+}
+
+func (t *TextAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
+	if t.PopupRect == nil {
+		t.PopupRect = &Rect{
+			Left:   t.rect.Right,
+			Top:    t.rect.Top,
+			Right:  t.rect.Right + DefaultPopupWidth,
+			Bottom: t.rect.Top - DefaultPopupHeight,
+		}
+	}
+
+	// create annotation, linked to its popup by nm
+	popup := &BaseAnnotation{
+		subtype: enums.FPDF_ANNOT_SUBTYPE_POPUP,
+		nm:      GenerateUUID(),
+		rect:    *t.PopupRect,
+		opacity: DefaultOpacity,
+		stash: []string{
+			fmt.Sprintf("/Open %t", t.Open),
+			"/" + stashRefsKey + " <</Parent " + formatPDFString(t.nm) + ">>",
+		},
+	}
+	t.popupNM = popup.nm
+	t.stash = append(extraKeyEntries(t), "/"+stashRefsKey+" <</Popup "+formatPDFString(popup.nm)+">>")
+	err := t.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
+	if err != nil {
+		return err
+	}
+
+	// create popup
+	err = popup.AddAnnotationToPage(ctx, instance, page)
+	if err != nil {
+		t.removeFromPage(instance, page)
+		return err
+	}
+	_, err = instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: popup.annot,
+	})
+	if err != nil {
+		t.removeFromPage(instance, page)
+		return popup.newError(StepClose, err)
+	}
+
+	// the icon keeps its size and orientation when the page is zoomed or rotated
+	_, err = instance.FPDFAnnot_SetFlags(&requests.FPDFAnnot_SetFlags{
+		Annotation: t.annot,
+		Flags:      enums.FPDF_ANNOT_FLAG_PRINT | enums.FPDF_ANNOT_FLAG_NOZOOM | enums.FPDF_ANNOT_FLAG_NOROTATE,
+	})
+	if err != nil {
+		return t.abort(instance, page, StepSetFlags, err)
+	}
+
+	// close annotation
+	_, err = instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: t.annot,
+	})
+	if err != nil {
+		return t.newError(StepClose, err)
+	}
+	return nil
+}
@@ -0,0 +1,562 @@
+// 文本搜索
+package annotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+const (
+	// sameAngleEpsilon is the largest difference of angles, in radians, between characters of one line.
+	sameAngleEpsilon = 0.01
+)
+
+// TextMatch is a piece of text found on a page.
+type TextMatch struct {
+	PageNumber int // page num, start from 0
+	CharIndex  int // index of the first character in the text page
+	CharCount  int
+	Text       string
+	QuadPoints []QuadPoint // one quad per line, following the direction of the text
+}
+
+// SearchOptions are the options of a plain text search, both are off by default.
+type SearchOptions struct {
+	MatchCase      bool
+	MatchWholeWord bool
+}
+
+// textChar is the geometry of a character of a text page.
+type textChar struct {
+	box    Rect
+	origin Point
+	angle  float32 // direction of the baseline, counterclockwise in radians
+}
+
+// textLine is a line of characters while building quads, in the frame of its direction:
+// u goes along the baseline and v up from it.
+type textLine struct {
+	angle      float32
+	cos, sin   float32
+	baseline   float32
+	uMin, uMax float32
+	vMin, vMax float32
+}
+
+// SearchTextInPage finds every occurrence of text on the page with pdfium's text search.
+func SearchTextInPage(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int, text string, opts *SearchOptions) ([]TextMatch, error) {
+	if text == "" {
+		return nil, errors.New("search text is empty")
+	}
+
+	var matches []TextMatch
+	err := withTextPage(instance, pdfDoc, pageNum, func(textPage references.FPDF_TEXTPAGE) error {
+		var flags requests.FPDFText_FindStartFlag
+		if opts != nil && opts.MatchCase {
+			flags |= requests.FPDFText_FindStartFlag_MATCHCASE
+		}
+		if opts != nil && opts.MatchWholeWord {
+			flags |= requests.FPDFText_FindStartFlag_MATCHWHOLEWORD
+		}
+
+		searchRes, err := instance.FPDFText_FindStart(&requests.FPDFText_FindStart{
+			TextPage:   textPage,
+			Find:       text,
+			Flags:      flags,
+			StartIndex: 0,
+		})
+		if err != nil {
+			return err
+		}
+		defer instance.FPDFText_FindClose(&requests.FPDFText_FindClose{
+			Search: searchRes.Search,
+		})
+
+		for {
+			nextRes, err := instance.FPDFText_FindNext(&requests.FPDFText_FindNext{
+				Search: searchRes.Search,
+			})
+			if err != nil {
+				return err
+			}
+			if !nextRes.GotMatch {
+				return nil
+			}
+
+			indexRes, err := instance.FPDFText_GetSchResultIndex(&requests.FPDFText_GetSchResultIndex{
+				Search: searchRes.Search,
+			})
+			if err != nil {
+				return err
+			}
+			countRes, err := instance.FPDFText_GetSchCount(&requests.FPDFText_GetSchCount{
+				Search: searchRes.Search,
+			})
+			if err != nil {
+				return err
+			}
+
+			match, err := newTextMatch(instance, textPage, pageNum, indexRes.Index, countRes.Count)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, match)
+		}
+	})
+	return matches, err
+}
+
+// SearchRegexpInPage finds every match of re in the text of the page.
+// The text of the page is the text pdfium extracts, lines are separated by "\r\n".
+func SearchRegexpInPage(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int, re *regexp.Regexp) ([]TextMatch, error) {
+	var matches []TextMatch
+	err := withTextPage(instance, pdfDoc, pageNum, func(textPage references.FPDF_TEXTPAGE) error {
+		countRes, err := instance.FPDFText_CountChars(&requests.FPDFText_CountChars{
+			TextPage: textPage,
+		})
+		if err != nil {
+			return err
+		}
+
+		// one rune per character, so rune offsets are character indices
+		runes := make([]rune, 0, countRes.Count)
+		for i := 0; i < countRes.Count; i++ {
+			unicodeRes, err := instance.FPDFText_GetUnicode(&requests.FPDFText_GetUnicode{
+				TextPage: textPage,
+				Index:    i,
+			})
+			if err != nil {
+				return err
+			}
+			runes = append(runes, rune(unicodeRes.Unicode))
+		}
+		text := string(runes)
+
+		// byte offsets of the match to character indices
+		charIndices := make(map[int]int, len(runes)+1)
+		var charIndex int
+		for offset := range text {
+			charIndices[offset] = charIndex
+			charIndex++
+		}
+		charIndices[len(text)] = charIndex
+
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			start, end := charIndices[loc[0]], charIndices[loc[1]]
+			if start == end {
+				continue
+			}
+			match, err := newTextMatch(instance, textPage, pageNum, start, end-start)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, match)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// SearchTextInPDF finds every occurrence of text on every page, see SearchTextInPage.
+func SearchTextInPDF(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, text string, opts *SearchOptions) ([]TextMatch, error) {
+	return searchInPDF(instance, pdfDoc, func(pageNum int) ([]TextMatch, error) {
+		return SearchTextInPage(instance, pdfDoc, pageNum, text, opts)
+	})
+}
+
+// SearchRegexpInPDF finds every match of re on every page, see SearchRegexpInPage.
+func SearchRegexpInPDF(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, re *regexp.Regexp) ([]TextMatch, error) {
+	return searchInPDF(instance, pdfDoc, func(pageNum int) ([]TextMatch, error) {
+		return SearchRegexpInPage(instance, pdfDoc, pageNum, re)
+	})
+}
+
+func searchInPDF(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, searchPage func(pageNum int) ([]TextMatch, error)) ([]TextMatch, error) {
+	pageCountRes, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+		Document: pdfDoc,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []TextMatch
+	for pageNum := 0; pageNum < pageCountRes.PageCount; pageNum++ {
+		pageMatches, err := searchPage(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, pageMatches...)
+	}
+	return matches, nil
+}
+
+// NewTextMarkupAnnotation creates a text markup annotation of the subtype over the match.
+// Highlight, underline, strikeout and squiggly are supported, a nil color keeps the default color of the subtype.
+// ps: appearance is generated, set other properties and generate it again before adding it
+func NewTextMarkupAnnotation(subtype enums.FPDF_ANNOTATION_SUBTYPE, match TextMatch, color *Color) (Annotation, error) {
+	var annot Annotation
+	switch subtype {
+	case enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT:
+		h := NewHighlightAnnotation()
+		h.QuadPoints = match.QuadPoints
+		if color == nil {
+			color = &DefaultHighlightColor
+		}
+		annot = h
+	case enums.FPDF_ANNOT_SUBTYPE_UNDERLINE:
+		u := NewUnderlineAnnotation()
+		u.QuadPoints = match.QuadPoints
+		annot = u
+	case enums.FPDF_ANNOT_SUBTYPE_STRIKEOUT:
+		s := NewStrikeoutAnnotation()
+		s.QuadPoints = match.QuadPoints
+		annot = s
+	case enums.FPDF_ANNOT_SUBTYPE_SQUIGGLY:
+		s := NewSquigglyAnnotation()
+		s.QuadPoints = match.QuadPoints
+		annot = s
+	default:
+		return nil, errors.New("subtype is not a text markup")
+	}
+
+	b := annot.(annotationBase).base()
+	b.rect = quadPointsRect(match.QuadPoints)
+	if color != nil {
+		annot.(interface{ SetStrikeColor(Color) }).SetStrikeColor(*color)
+	}
+	err := annot.GenerateAppearance()
+	if err != nil {
+		return nil, err
+	}
+	return annot, nil
+}
+
+// AddTextMarkupsToPDF adds a text markup annotation of the subtype over every match, on the page of the match.
+// A failed annotation does not stop the others, every failure is reported in a *BatchAddError.
+// res: the annotations created for the matches, in the order of the matches
+func AddTextMarkupsToPDF(ctx context.Context, instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT,
+	subtype enums.FPDF_ANNOTATION_SUBTYPE, matches []TextMatch, color *Color) ([]Annotation, error) {
+	var annots []Annotation
+	var pageAnnots []AddOnePageAnnot
+	for _, match := range matches {
+		annot, err := NewTextMarkupAnnotation(subtype, match, color)
+		if err != nil {
+			return nil, err
+		}
+		annots = append(annots, annot)
+
+		if len(pageAnnots) == 0 || pageAnnots[len(pageAnnots)-1].PageNumber != match.PageNumber {
+			pageAnnots = append(pageAnnots, AddOnePageAnnot{PageNumber: match.PageNumber})
+		}
+		pageAnnots[len(pageAnnots)-1].Annots = append(pageAnnots[len(pageAnnots)-1].Annots, annot)
+	}
+
+	_, err := AddAnnotationsToPDF(ctx, instance, pdfDoc, pageAnnots)
+	return annots, err
+}
+
+// MarkupTextInPDF searches text on every page and adds a text markup annotation of the subtype over each occurrence.
+// e.g. MarkupTextInPDF(ctx, instance, doc, enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT, "confidential", nil, nil)
+func MarkupTextInPDF(ctx context.Context, instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT,
+	subtype enums.FPDF_ANNOTATION_SUBTYPE, text string, opts *SearchOptions, color *Color) ([]Annotation, error) {
+	matches, err := SearchTextInPDF(instance, pdfDoc, text, opts)
+	if err != nil {
+		return nil, err
+	}
+	return AddTextMarkupsToPDF(ctx, instance, pdfDoc, subtype, matches, color)
+}
+
+// MarkupRegexpInPDF searches re on every page and adds a text markup annotation of the subtype over each match.
+func MarkupRegexpInPDF(ctx context.Context, instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT,
+	subtype enums.FPDF_ANNOTATION_SUBTYPE, re *regexp.Regexp, color *Color) ([]Annotation, error) {
+	matches, err := SearchRegexpInPDF(instance, pdfDoc, re)
+	if err != nil {
+		return nil, err
+	}
+	return AddTextMarkupsToPDF(ctx, instance, pdfDoc, subtype, matches, color)
+}
+
+// withTextPage loads the text page of the page for fn.
+func withTextPage(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int, fn func(textPage references.FPDF_TEXTPAGE) error) error {
+	textPageRes, err := instance.FPDFText_LoadPage(&requests.FPDFText_LoadPage{
+		Page: requests.Page{
+			ByIndex: &requests.PageByIndex{
+				Document: pdfDoc,
+				Index:    pageNum,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer instance.FPDFText_ClosePage(&requests.FPDFText_ClosePage{
+		TextPage: textPageRes.TextPage,
+	})
+	return fn(textPageRes.TextPage)
+}
+
+// newTextMatch returns the match of count characters from start, with its text and quads.
+func newTextMatch(instance pdfium.Pdfium, textPage references.FPDF_TEXTPAGE, pageNum, start, count int) (TextMatch, error) {
+	textRes, err := instance.FPDFText_GetText(&requests.FPDFText_GetText{
+		TextPage:   textPage,
+		StartIndex: start,
+		Count:      count,
+	})
+	if err != nil {
+		return TextMatch{}, err
+	}
+	quadPoints, err := textQuadPoints(instance, textPage, start, count)
+	if err != nil {
+		return TextMatch{}, err
+	}
+	return TextMatch{
+		PageNumber: pageNum,
+		CharIndex:  start,
+		CharCount:  count,
+		Text:       textRes.Text,
+		QuadPoints: quadPoints,
+	}, nil
+}
+
+// textQuadPoints returns the quads of count characters from start, one per line.
+// Upright text uses the text rects of pdfium, so the quads match the selection of viewers.
+func textQuadPoints(instance pdfium.Pdfium, textPage references.FPDF_TEXTPAGE, start, count int) ([]QuadPoint, error) {
+	var chars []textChar
+	for i := start; i < start+count; i++ {
+		// characters generated by pdfium, like spaces and line breaks, have no glyph
+		generatedRes, err := instance.FPDFText_IsGenerated(&requests.FPDFText_IsGenerated{
+			TextPage: textPage,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if generatedRes.IsGenerated {
+			continue
+		}
+
+		boxRes, err := instance.FPDFText_GetCharBox(&requests.FPDFText_GetCharBox{
+			TextPage: textPage,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if boxRes.Right-boxRes.Left <= 0 || boxRes.Top-boxRes.Bottom <= 0 {
+			continue
+		}
+		originRes, err := instance.FPDFText_GetCharOrigin(&requests.FPDFText_GetCharOrigin{
+			TextPage: textPage,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		angleRes, err := instance.FPDFText_GetCharAngle(&requests.FPDFText_GetCharAngle{
+			TextPage: textPage,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		chars = append(chars, textChar{
+			box: Rect{
+				Left:   float32(boxRes.Left),
+				Bottom: float32(boxRes.Bottom),
+				Right:  float32(boxRes.Right),
+				Top:    float32(boxRes.Top),
+			},
+			origin: Point{X: float32(originRes.X), Y: float32(originRes.Y)},
+			angle:  angleRes.CharAngle,
+		})
+	}
+	if len(chars) == 0 {
+		return nil, nil
+	}
+
+	// rotated text gets the quads of its lines, pdfium only gives upright text rects
+	for _, c := range chars {
+		if !sameAngle(c.angle, 0) {
+			return charLinesQuadPoints(chars), nil
+		}
+	}
+	return textRectsQuadPoints(instance, textPage, start, count)
+}
+
+// textRectsQuadPoints returns the quads of the text rects pdfium gives for count characters from start,
+// the rects of one line merged.
+func textRectsQuadPoints(instance pdfium.Pdfium, textPage references.FPDF_TEXTPAGE, start, count int) ([]QuadPoint, error) {
+	countRes, err := instance.FPDFText_CountRects(&requests.FPDFText_CountRects{
+		TextPage:   textPage,
+		StartIndex: start,
+		Count:      count,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rects := make([]Rect, 0, countRes.Count)
+	for i := 0; i < countRes.Count; i++ {
+		rectRes, err := instance.FPDFText_GetRect(&requests.FPDFText_GetRect{
+			TextPage: textPage,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, err
+		}
+		rects = append(rects, Rect{
+			Left:   float32(rectRes.Left),
+			Bottom: float32(rectRes.Bottom),
+			Right:  float32(rectRes.Right),
+			Top:    float32(rectRes.Top),
+		})
+	}
+
+	var quads []QuadPoint
+	for _, rect := range mergeLineRects(rects) {
+		quads = append(quads, rectQuadPoint(rect))
+	}
+	return quads, nil
+}
+
+// mergeLineRects merges the rects following each other on one line, e.g. words of different fonts.
+// Two rects are on one line when they overlap by more than half the height of the lower one.
+func mergeLineRects(rects []Rect) []Rect {
+	var lines []Rect
+	for _, rect := range rects {
+		if len(lines) > 0 {
+			last := &lines[len(lines)-1]
+			overlap := min(last.Top, rect.Top) - max(last.Bottom, rect.Bottom)
+			if overlap > min(last.Top-last.Bottom, rect.Top-rect.Bottom)/2 {
+				last.Left, last.Right = min(last.Left, rect.Left), max(last.Right, rect.Right)
+				last.Bottom, last.Top = min(last.Bottom, rect.Bottom), max(last.Top, rect.Top)
+				continue
+			}
+		}
+		lines = append(lines, rect)
+	}
+	return lines
+}
+
+// SelectCharRange returns count characters from startCharIndex on the page with their quads, one per line,
+// like a selection in the text layer of a viewer. A count of -1 selects to the end of the page.
+// The match can be given to NewTextMarkupAnnotation or AddTextMarkupsToPDF.
+func SelectCharRange(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum, startCharIndex, count int) (TextMatch, error) {
+	var match TextMatch
+	err := withTextPage(instance, pdfDoc, pageNum, func(textPage references.FPDF_TEXTPAGE) error {
+		countRes, err := instance.FPDFText_CountChars(&requests.FPDFText_CountChars{
+			TextPage: textPage,
+		})
+		if err != nil {
+			return err
+		}
+		if startCharIndex < 0 || startCharIndex >= countRes.Count {
+			return fmt.Errorf("char index %d out of range [0, %d)", startCharIndex, countRes.Count)
+		}
+		if count == 0 || count < -1 {
+			return errors.New("char count must be positive or -1")
+		}
+		if count == -1 || startCharIndex+count > countRes.Count {
+			count = countRes.Count - startCharIndex
+		}
+
+		match, err = newTextMatch(instance, textPage, pageNum, startCharIndex, count)
+		return err
+	})
+	return match, err
+}
+
+// CharRangeQuadPoints returns the quads of count characters from startCharIndex on the page, see SelectCharRange.
+func CharRangeQuadPoints(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum, startCharIndex, count int) ([]QuadPoint, error) {
+	match, err := SelectCharRange(instance, pdfDoc, pageNum, startCharIndex, count)
+	if err != nil {
+		return nil, err
+	}
+	return match.QuadPoints, nil
+}
+
+// charLinesQuadPoints groups the characters into lines and returns a quad around each line,
+// turned to the direction of its text. A character starts a new line when its direction changes
+// or its baseline moves by more than half the height of the line.
+// ps: pdfium only gives the upright box of a character, so quads of text at angles other than
+// multiples of 90° are a bit larger than the glyphs.
+func charLinesQuadPoints(chars []textChar) []QuadPoint {
+	var quads []QuadPoint
+	var line *textLine
+	for _, c := range chars {
+		cos, sin := float32(math.Cos(float64(c.angle))), float32(math.Sin(float64(c.angle)))
+		if line == nil || !sameAngle(line.angle, c.angle) {
+			if line != nil {
+				quads = append(quads, line.quadPoint())
+			}
+			line = newTextLine(c, cos, sin)
+			continue
+		}
+
+		uMin, uMax, vMin, vMax := line.project(c.box)
+		baseline := -c.origin.X*line.sin + c.origin.Y*line.cos
+		if abs32(baseline-line.baseline) > max(line.vMax-line.vMin, vMax-vMin)/2 {
+			quads = append(quads, line.quadPoint())
+			line = newTextLine(c, cos, sin)
+			continue
+		}
+		line.uMin, line.uMax = min(line.uMin, uMin), max(line.uMax, uMax)
+		line.vMin, line.vMax = min(line.vMin, vMin), max(line.vMax, vMax)
+	}
+	if line != nil {
+		quads = append(quads, line.quadPoint())
+	}
+	return quads
+}
+
+func newTextLine(c textChar, cos, sin float32) *textLine {
+	line := &textLine{
+		angle:    c.angle,
+		cos:      cos,
+		sin:      sin,
+		baseline: -c.origin.X*sin + c.origin.Y*cos,
+	}
+	line.uMin, line.uMax, line.vMin, line.vMax = line.project(c.box)
+	return line
+}
+
+// project returns the extent of the box along and across the line.
+func (l *textLine) project(box Rect) (uMin, uMax, vMin, vMax float32) {
+	uMin, vMin = float32(math.MaxFloat32), float32(math.MaxFloat32)
+	uMax, vMax = -float32(math.MaxFloat32), -float32(math.MaxFloat32)
+	for _, p := range rectPolygon(box) {
+		u := p.X*l.cos + p.Y*l.sin
+		v := -p.X*l.sin + p.Y*l.cos
+		uMin, uMax = min(uMin, u), max(uMax, u)
+		vMin, vMax = min(vMin, v), max(vMax, v)
+	}
+	return uMin, uMax, vMin, vMax
+}
+
+// quadPoint returns the quad of the line on the page, its bottom is on the side of the baseline.
+func (l *textLine) quadPoint() QuadPoint {
+	toPage := func(u, v float32) (float32, float32) {
+		return u*l.cos - v*l.sin, u*l.sin + v*l.cos
+	}
+	var q QuadPoint
+	q.LeftBottomX, q.LeftBottomY = toPage(l.uMin, l.vMin)
+	q.RightBottomX, q.RightBottomY = toPage(l.uMax, l.vMin)
+	q.LeftTopX, q.LeftTopY = toPage(l.uMin, l.vMax)
+	q.RightTopX, q.RightTopY = toPage(l.uMax, l.vMax)
+	return q
+}
+
+// sameAngle reports whether two directions are the same, 0 and 2π included.
+func sameAngle(a, b float32) bool {
+	d := math.Mod(math.Abs(float64(a-b)), 2*math.Pi)
+	return min(d, 2*math.Pi-d) < sameAngleEpsilon
+}
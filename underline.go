@@ -4,6 +4,7 @@ package annotation
 import (
 	"context"
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/klippa-app/go-pdfium"
@@ -40,9 +41,14 @@ func (u *UnderlineAnnotation) GenerateAppearance() error {
 
 func (u *UnderlineAnnotation) pointsCallback() string {
 	var ap string
-	for i := 0; i < len(u.QuadPoints); i++ {
-		ap += fmt.Sprintf("%.3f %.3f m ", u.QuadPoints[i].LeftBottomX, u.QuadPoints[i].LeftBottomY+1.3)
-		ap += fmt.Sprintf("%.3f %.3f l ", u.QuadPoints[i].RightBottomX, u.QuadPoints[i].RightBottomY+1.3)
+	for _, q := range u.QuadPoints {
+		// the line sits just above the bottom, up is toward the top of the quad so rotated text is followed
+		upX, upY := float32(0), float32(1)
+		if height := float32(math.Hypot(float64(q.LeftTopX-q.LeftBottomX), float64(q.LeftTopY-q.LeftBottomY))); !IsZeroEpsilon(height) {
+			upX, upY = (q.LeftTopX-q.LeftBottomX)/height, (q.LeftTopY-q.LeftBottomY)/height
+		}
+		ap += fmt.Sprintf("%.3f %.3f m ", q.LeftBottomX+upX*1.3, q.LeftBottomY+upY*1.3)
+		ap += fmt.Sprintf("%.3f %.3f l ", q.RightBottomX+upX*1.3, q.RightBottomY+upY*1.3)
 		ap += "S\n"
 	}
 	return ap
@@ -63,7 +69,7 @@ func (u *UnderlineAnnotation) AddAnnotationToPage(ctx context.Context, instance
 			AttachmentPoints: points,
 		})
 		if err != nil {
-			return err
+			return u.abort(instance, page, StepSetQuadPoints, err)
 		}
 	}
 
@@ -72,7 +78,7 @@ func (u *UnderlineAnnotation) AddAnnotationToPage(ctx context.Context, instance
 		Annotation: u.annot,
 	})
 	if err != nil {
-		return err
+		return u.newError(StepClose, err)
 	}
 	return nil
 }
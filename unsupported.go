@@ -0,0 +1,71 @@
+package annotation
+
+import (
+	"context"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// UnsupportedAnnotation is an opaque annotation loaded from a pdf whose subtype has no type in this package.
+// Only the common keys (rect, colors, opacity, border width, contents, title, nm, dates) and the normal appearance are kept,
+// plus the keys of RawKeyNames pdfium can read.
+type UnsupportedAnnotation struct {
+	BaseAnnotation
+	// Keys are the other keys of the dictionary, the values are written in pdf syntax, e.g. /Comment, (text) or 12.
+	Keys map[string]string
+}
+
+// RawKeyNames are the keys kept in UnsupportedAnnotation.Keys when loading from a pdf,
+// pdfium can not list the keys of a dictionary so only these are looked up.
+var RawKeyNames = []string{"Subj", "IT", "RT", "RC", "DS", "DA", "Q", "Name", "State", "StateModel", "Rotate"}
+
+func NewUnsupportedAnnotation(subtype enums.FPDF_ANNOTATION_SUBTYPE) *UnsupportedAnnotation {
+	return &UnsupportedAnnotation{
+		BaseAnnotation: BaseAnnotation{
+			subtype: subtype,
+			nm:      GenerateUUID(),
+			opacity: DefaultOpacity,
+		},
+	}
+}
+
+// GenerateAppearance does nothing, the appearance loaded from the pdf is kept as is.
+func (u *UnsupportedAnnotation) GenerateAppearance() error {
+	return nil
+}
+
+func (u *UnsupportedAnnotation) AddAnnotationToPage(ctx context.Context, instance pdfium.Pdfium, page requests.Page) error {
+	// create annotation
+	err := u.BaseAnnotation.AddAnnotationToPage(ctx, instance, page)
+	if err != nil {
+		return err
+	}
+
+	// set raw keys
+	// ps: pdfium can only write text values, names and numbers are left out
+	for key, value := range u.Keys {
+		text, err := parsePDFValue(value)
+		if _, ok := text.(string); err != nil || !ok {
+			continue
+		}
+		_, err = instance.FPDFAnnot_SetStringValue(&requests.FPDFAnnot_SetStringValue{
+			Annotation: u.annot,
+			Key:        key,
+			Value:      text.(string),
+		})
+		if err != nil {
+			return u.abort(instance, page, StepSetRawKey, err)
+		}
+	}
+
+	// close annotation
+	_, err = instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: u.annot,
+	})
+	if err != nil {
+		return u.newError(StepClose, err)
+	}
+	return u.savePDFWarning()
+}
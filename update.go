@@ -0,0 +1,445 @@
+package annotation
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+var (
+	ErrAnnotNotFound = errors.New("annot not found")
+)
+
+// UpdateAnnot is a partial change set of an annotation, nil fields are left unchanged.
+type UpdateAnnot struct {
+	Rect        *Rect
+	StrikeColor *Color
+	FillColor   *Color
+	Opacity     *uint8
+	Width       *float32
+	Contents    *string
+	// highlight/underline/strikeout/squiggly/redact only.
+	// pdfium can not remove quads, fewer quads than before are written by SavePDF, like the quads of redactions.
+	QuadPoints []QuadPoint
+	InkPoints  [][]Point // ink only
+	// polygon/polyline only, the rect follows the vertices.
+	// pdfium has no setter for /Vertices, they are written by SavePDF.
+	Vertices []Point
+	// line only. pdfium has no setter for /L, it is written by SavePDF.
+	LineTo *[2]Point
+}
+
+func (u *UpdateAnnot) changesAppearance() bool {
+	return u.StrikeColor != nil || u.FillColor != nil || u.Opacity != nil || u.Width != nil ||
+		u.QuadPoints != nil || u.InkPoints != nil || u.LineTo != nil || u.Vertices != nil
+}
+
+// objectAppearance is implemented by annotations appending page objects to their generated appearance, e.g. line captions.
+type objectAppearance interface {
+	appendAppearanceObjects(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, annotRef references.FPDF_ANNOTATION) error
+}
+
+// outlineAppearance is implemented by annotations drawing the glyph outlines of their text when pdfium can not
+// append text objects to them, they load their default font into the document.
+type outlineAppearance interface {
+	generateOutlineAppearance(instance pdfium.Pdfium, document references.FPDF_DOCUMENT) error
+}
+
+// UpdateAnnotByNM applies a partial change to the annotation with the given nm in place,
+// and regenerates its appearance stream. The annotation keeps its position in the
+// annotation order and every dictionary key not touched by the change, /M is set to now.
+// The previous appearance is restored when writing the change fails.
+// Stamps and unsupported subtypes keep their appearance, so only Rect and Contents can be changed on them.
+func UpdateAnnotByNM(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int, nm string, update UpdateAnnot) (Annotation, error) {
+	// step1. load the typed annotation and find its index
+	annots, err := LoadAnnotationsInPage(instance, pdfDoc, pageNum)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for i, annot := range annots {
+		if annot.GetNM() == nm {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, ErrAnnotNotFound
+	}
+	annot := annots[index]
+
+	// step2. apply the change to the typed annotation and regenerate the appearance
+	regenerate := true
+	switch annot.(type) {
+	case *StampAnnotation, *UnsupportedAnnotation:
+		regenerate = false
+	}
+	if !regenerate && update.changesAppearance() {
+		return nil, errors.New("only rect and contents can be updated on " + annot.GetSubtypeName() + " annot")
+	}
+
+	page := requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: pdfDoc,
+			Index:    pageNum,
+		},
+	}
+	annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+		Page:  page,
+		Index: index,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+		Annotation: annotRes.Annotation,
+	})
+
+	// pdfium only appends page objects to stamps and inks, saved free texts draw the outlines of their text
+	appendObjects := false
+	if _, ok := annot.(objectAppearance); ok && regenerate {
+		subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			return nil, err
+		}
+		supportedRes, err := instance.FPDFAnnot_IsObjectSupportedSubtype(&requests.FPDFAnnot_IsObjectSupportedSubtype{
+			Subtype: subtypeRes.Subtype,
+		})
+		if err != nil {
+			return nil, err
+		}
+		appendObjects = supportedRes.IsObjectSupportedSubtype
+	}
+
+	err = applyUpdate(annot, update)
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := annot.(*LineAnnotation); ok && regenerate && !appendObjects && l.Caption && l.contents != "" {
+		return nil, errors.New("pdfium can not append the caption to a saved line annot")
+	}
+	if o, ok := annot.(outlineAppearance); ok && regenerate && !appendObjects {
+		err = o.generateOutlineAppearance(instance, pdfDoc)
+		if err != nil {
+			return nil, err
+		}
+	} else if regenerate {
+		err = annot.GenerateAppearance()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// step3. write the change into the annotation dictionary
+	err = writeUpdate(instance, pdfDoc, annotRes.Annotation, annot, update, regenerate, appendObjects)
+	if err != nil {
+		return nil, err
+	}
+
+	return annot, nil
+}
+
+// applyUpdate applies the change set to the typed annotation.
+func applyUpdate(annot Annotation, update UpdateAnnot) error {
+	b := annot.(annotationBase).base()
+	b.modDate = time.Now()
+
+	if update.Rect != nil {
+		b.rect = *update.Rect
+	}
+	if update.Width != nil {
+		b.width = *update.Width
+	}
+	if update.Opacity != nil {
+		b.opacity = *update.Opacity
+	}
+	if update.StrikeColor != nil {
+		if s, ok := annot.(interface{ SetStrikeColor(Color) }); ok {
+			s.SetStrikeColor(*update.StrikeColor)
+		}
+	}
+	if update.FillColor != nil {
+		b.fillColor = update.FillColor
+	}
+	if update.Contents != nil {
+		if s, ok := annot.(interface{ SetContents(string) }); ok {
+			s.SetContents(*update.Contents)
+		}
+	}
+
+	if _, ok := markupQuadPoints(annot); ok {
+		if update.QuadPoints != nil {
+			setMarkupQuadPoints(annot, update.QuadPoints)
+		}
+		return nil
+	}
+
+	switch a := annot.(type) {
+	case *InkAnnotation:
+		if update.InkPoints != nil {
+			a.Points = update.InkPoints
+		}
+	case *LineAnnotation:
+		if update.LineTo != nil {
+			a.lineTo = *update.LineTo
+		}
+	case *PolygonAnnotation:
+		if update.Vertices != nil {
+			a.Vertices = update.Vertices
+		}
+	case *PolylineAnnotation:
+		if update.Vertices != nil {
+			a.Vertices = update.Vertices
+		}
+	case *RedactAnnotation:
+		if update.QuadPoints != nil {
+			a.QuadPoints = update.QuadPoints
+		}
+	default:
+		if update.QuadPoints != nil || update.InkPoints != nil || update.LineTo != nil || update.Vertices != nil {
+			return errors.New("geometry update not supported on " + annot.GetSubtypeName() + " annot")
+		}
+	}
+	return nil
+}
+
+// writeUpdate writes the changed keys of the typed annotation into the opened annotation.
+// The appearance removed to set the colors is set again when a later step fails, so the annotation stays visible.
+func writeUpdate(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, annotRef references.FPDF_ANNOTATION, annot Annotation, update UpdateAnnot, regenerate, appendObjects bool) (err error) {
+	b := annot.(annotationBase).base()
+
+	// polygons and polylines compute their rect from the vertices
+	if update.Rect != nil || update.Vertices != nil {
+		_, err := instance.FPDFAnnot_SetRect(&requests.FPDFAnnot_SetRect{
+			Annotation: annotRef,
+			Rect: structs.FPDF_FS_RECTF{
+				Left:   b.rect.Left,
+				Bottom: b.rect.Bottom,
+				Right:  b.rect.Right,
+				Top:    b.rect.Top,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if update.Width != nil {
+		_, err := instance.FPDFAnnot_SetBorder(&requests.FPDFAnnot_SetBorder{
+			Annotation:       annotRef,
+			HorizontalRadius: 0,
+			VerticalRadius:   0,
+			BorderWidth:      b.width,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = instance.FPDFAnnot_SetStringValue(&requests.FPDFAnnot_SetStringValue{
+		Annotation: annotRef,
+		Key:        "M",
+		Value:      FormatPDFDate(b.modDate),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the removed appearance is set again when a step below fails
+	var removedAP string
+	defer func() {
+		if err != nil && removedAP != "" {
+			instance.FPDFAnnot_SetAP(&requests.FPDFAnnot_SetAP{
+				Annotation:     annotRef,
+				AppearanceMode: enums.FPDF_ANNOT_APPEARANCEMODE_NORMAL,
+				Value:          &removedAP,
+			})
+		}
+	}()
+
+	// colors carry the opacity, so they are written again when it changes.
+	// pdfium refuses to set colors while an appearance stream exists, it is set again below
+	if update.StrikeColor != nil || update.FillColor != nil || update.Opacity != nil {
+		apRes, err := instance.FPDFAnnot_GetAP(&requests.FPDFAnnot_GetAP{
+			Annotation:     annotRef,
+			AppearanceMode: enums.FPDF_ANNOT_APPEARANCEMODE_NORMAL,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = instance.FPDFAnnot_SetAP(&requests.FPDFAnnot_SetAP{
+			Annotation:     annotRef,
+			AppearanceMode: enums.FPDF_ANNOT_APPEARANCEMODE_NORMAL,
+			Value:          nil,
+		})
+		if err != nil {
+			return err
+		}
+		removedAP = apRes.Value
+		if strikeColor := b.strikeColorWithOpacity(); strikeColor != nil {
+			_, err := instance.FPDFAnnot_SetColor(&requests.FPDFAnnot_SetColor{
+				Annotation: annotRef,
+				ColorType:  enums.FPDFANNOT_COLORTYPE_Color,
+				R:          uint(strikeColor.R),
+				G:          uint(strikeColor.G),
+				B:          uint(strikeColor.B),
+				A:          uint(b.opacity),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if b.fillColor != nil {
+			_, err := instance.FPDFAnnot_SetColor(&requests.FPDFAnnot_SetColor{
+				Annotation: annotRef,
+				ColorType:  enums.FPDFANNOT_COLORTYPE_InteriorColor,
+				R:          uint(b.fillColor.R),
+				G:          uint(b.fillColor.G),
+				B:          uint(b.fillColor.B),
+				A:          uint(b.opacity),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if update.Contents != nil {
+		_, err := instance.FPDFAnnot_SetStringValue(&requests.FPDFAnnot_SetStringValue{
+			Annotation: annotRef,
+			Key:        "Contents",
+			Value:      *update.Contents,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// pdfium can not remove quads, fewer quads than before are stashed
+	shrunk := false
+	if _, ok := markupQuadPoints(annot); ok && update.QuadPoints != nil {
+		countRes, err := instance.FPDFAnnot_CountAttachmentPoints(&requests.FPDFAnnot_CountAttachmentPoints{
+			Annotation: annotRef,
+		})
+		if err != nil {
+			return err
+		}
+		shrunk = uint64(len(update.QuadPoints)) < countRes.Count
+		for i, points := range convertQuadPointToPdfiumFormat(update.QuadPoints) {
+			if uint64(i) < countRes.Count {
+				_, err = instance.FPDFAnnot_SetAttachmentPoints(&requests.FPDFAnnot_SetAttachmentPoints{
+					Annotation:       annotRef,
+					Index:            uint64(i),
+					AttachmentPoints: points,
+				})
+			} else {
+				_, err = instance.FPDFAnnot_AppendAttachmentPoints(&requests.FPDFAnnot_AppendAttachmentPoints{
+					Annotation:       annotRef,
+					AttachmentPoints: points,
+				})
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if update.InkPoints != nil {
+		_, err := instance.FPDFAnnot_RemoveInkList(&requests.FPDFAnnot_RemoveInkList{
+			Annotation: annotRef,
+		})
+		if err != nil {
+			return err
+		}
+		for _, points := range update.InkPoints {
+			_, err = instance.FPDFAnnot_AddInkStroke(&requests.FPDFAnnot_AddInkStroke{
+				Annotation: annotRef,
+				Points:     convertPointToPdfiumFormat(points),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = updateStash(instance, annotRef, annot, update, shrunk)
+	if err != nil {
+		return err
+	}
+
+	// the appearance is set last, after the rect it is drawn in
+	if regenerate && b.ap != "" {
+		_, err := instance.FPDFAnnot_SetAP(&requests.FPDFAnnot_SetAP{
+			Annotation:     annotRef,
+			AppearanceMode: enums.FPDF_ANNOT_APPEARANCEMODE_NORMAL,
+			Value:          &b.ap,
+		})
+		if err != nil {
+			return err
+		}
+		if o, ok := annot.(objectAppearance); ok && appendObjects {
+			err = o.appendAppearanceObjects(instance, pdfDoc, annotRef)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// updateStash writes the keys of the change pdfium has no setter for into the stash, SavePDF writes them into the
+// annotation dictionary. Stashed keys are written again from the typed annotation, the other keys only when the
+// change sets them: pdfium has no getter for keys like /LE, an annotation of another tool only gets the changed keys.
+func updateStash(instance pdfium.Pdfium, annotRef references.FPDF_ANNOTATION, annot Annotation, update UpdateAnnot, shrunk bool) error {
+	stash, err := loadStash(instance, annotRef)
+	if err != nil {
+		return err
+	}
+	if stash == nil {
+		stash = pdfDict{}
+	}
+	extra, err := parsePDFValue("<<" + strings.Join(extraKeyEntries(annot), " ") + ">>")
+	if err != nil {
+		return err
+	}
+	changed := map[string]bool{
+		"L":          update.LineTo != nil,
+		"Vertices":   update.Vertices != nil,
+		"QuadPoints": update.QuadPoints != nil,
+	}
+	for key, value := range extra.(pdfDict) {
+		if _, ok := stash[key]; ok || changed[key] {
+			stash[key] = value
+		}
+	}
+	if _, ok := markupQuadPoints(annot); ok && update.QuadPoints != nil {
+		delete(stash, "QuadPoints")
+		if shrunk {
+			quadPoints, err := parsePDFValue("[" + formatFDFQuadPoints(update.QuadPoints) + "]")
+			if err != nil {
+				return err
+			}
+			stash["QuadPoints"] = quadPoints
+		}
+	}
+	if len(stash) == 0 {
+		return nil
+	}
+
+	entries := make([]string, 0, len(stash))
+	for key, value := range stash {
+		entries = append(entries, formatPDFName(key)+" "+formatPDFObject(nil, value))
+	}
+	sort.Strings(entries)
+	return writeStash(instance, annotRef, entries)
+}
@@ -0,0 +1,368 @@
+// W3C 网页标注
+package annotation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// WebAnnotationContext is the JSON-LD context of the W3C Web Annotation Data Model.
+const WebAnnotationContext = "http://www.w3.org/ns/anno.jsonld"
+
+// pdfFragmentSpec is the specification of the pdf fragment identifiers, RFC 3778.
+const pdfFragmentSpec = "http://tools.ietf.org/rfc/rfc3778"
+
+// WebAnnotation is an annotation of the W3C Web Annotation Data Model.
+type WebAnnotation struct {
+	Context    string           `json:"@context"`
+	ID         string           `json:"id"`
+	Type       string           `json:"type"`
+	Motivation string           `json:"motivation,omitempty"`
+	Creator    *WebAgent        `json:"creator,omitempty"`
+	Created    string           `json:"created,omitempty"`
+	Modified   string           `json:"modified,omitempty"`
+	Body       WebList[WebBody] `json:"body,omitempty"`
+	Target     WebTarget        `json:"target"`
+}
+
+// WebAgent is the creator of a web annotation, a plain IRI is read as its id.
+type WebAgent struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// WebBody is a textual body of a web annotation.
+type WebBody struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Format  string `json:"format,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// WebTarget is the part of the pdf a web annotation is about.
+type WebTarget struct {
+	Source   string               `json:"source"`
+	Selector WebList[WebSelector] `json:"selector,omitempty"`
+}
+
+// WebSelector is a FragmentSelector or a TextQuoteSelector.
+type WebSelector struct {
+	Type       string `json:"type"`
+	ConformsTo string `json:"conformsTo,omitempty"`
+	Value      string `json:"value,omitempty"`
+	Exact      string `json:"exact,omitempty"`
+	Prefix     string `json:"prefix,omitempty"`
+	Suffix     string `json:"suffix,omitempty"`
+}
+
+// WebList is a list of values, read from a single value or an array as the data model allows both.
+type WebList[T any] []T
+
+func (l *WebList[T]) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, (*[]T)(l))
+	}
+	var v T
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	*l = WebList[T]{v}
+	return nil
+}
+
+func (a *WebAgent) UnmarshalJSON(data []byte) error {
+	var id string
+	if json.Unmarshal(data, &id) == nil {
+		*a = WebAgent{ID: id}
+		return nil
+	}
+	type agent WebAgent
+	return json.Unmarshal(data, (*agent)(a))
+}
+
+// ExportWebAnnotations converts the annotations of the given pages into web annotations, every page when pageNums is nil.
+// source is the IRI of the pdf, the target of every annotation. Popups and widgets are skipped.
+// The rect is written as a FragmentSelector #page=N&viewrect=left,top,width,height, in points from the top left of the page.
+// Text markups get a TextQuoteSelector with the text under their quads, their subtype is a tagging body.
+func ExportWebAnnotations(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, source string, pageNums []int) ([]WebAnnotation, error) {
+	pageAnnots, err := loadPageAnnots(instance, pdfDoc, pageNums)
+	if err != nil {
+		return nil, err
+	}
+
+	var webAnnots []WebAnnotation
+	for _, item := range pageAnnots {
+		sizeRes, err := instance.FPDF_GetPageSizeByIndex(&requests.FPDF_GetPageSizeByIndex{
+			Document: pdfDoc,
+			Index:    item.PageNumber,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var marked []MarkedText
+		for _, annot := range item.Annots {
+			if _, ok := markupQuadPoints(annot); ok {
+				marked, err = GetMarkedTextsInPage(instance, pdfDoc, item.PageNumber, DefaultMarkedTextOverlap)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+
+		for _, annot := range item.Annots {
+			if !isExportable(annot) {
+				continue
+			}
+			quote := ""
+			for _, m := range marked {
+				if m.Annotation.GetNM() == annot.GetNM() {
+					quote = m.Text
+				}
+			}
+			webAnnots = append(webAnnots, newWebAnnotation(source, item.PageNumber, float32(sizeRes.Height), annot, quote))
+		}
+	}
+	return webAnnots, nil
+}
+
+// ImportWebAnnotations adds web annotations with a pdf FragmentSelector to the pdf, their source is not checked.
+// Text markups, found by their tagging body or the highlighting motivation, are placed over the quoted text
+// inside the fragment rect, squares and circles take the rect with a 1pt red border,
+// everything else becomes a sticky note at its top left.
+// Annotations whose NM, taken from the id, is already on the page are skipped.
+func ImportWebAnnotations(ctx context.Context, instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, webAnnots []WebAnnotation) (int, error) {
+	var pageAnnots []AddOnePageAnnot
+	for i, webAnnot := range webAnnots {
+		pageNum, rect, ok, err := webAnnotFragment(instance, pdfDoc, webAnnot)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("web annot %d (%s): no pdf fragment selector", i, webAnnot.ID)
+		}
+		annot, err := webAnnotation(instance, pdfDoc, pageNum, rect, webAnnot)
+		if err != nil {
+			return 0, fmt.Errorf("web annot %d (%s): %w", i, webAnnot.ID, err)
+		}
+		pageAnnots = appendPageAnnot(pageAnnots, pageNum, annot)
+	}
+	return addImportedAnnotations(ctx, instance, pdfDoc, pageAnnots)
+}
+
+// newWebAnnotation returns the web annotation of an annotation of the page.
+func newWebAnnotation(source string, pageNum int, pageHeight float32, annot Annotation, quote string) WebAnnotation {
+	b := annot.(annotationBase).base()
+	webAnnot := WebAnnotation{
+		Context:    WebAnnotationContext,
+		ID:         webAnnotationID(source, b.nm),
+		Type:       "Annotation",
+		Motivation: "commenting",
+		Target: WebTarget{
+			Source: source,
+			Selector: WebList[WebSelector]{{
+				Type:       "FragmentSelector",
+				ConformsTo: pdfFragmentSpec,
+				Value: fmt.Sprintf("page=%d&viewrect=%s", pageNum+1,
+					formatNumbers(b.rect.Left, pageHeight-b.rect.Top, b.rect.Right-b.rect.Left, b.rect.Top-b.rect.Bottom)),
+			}},
+		},
+	}
+	if b.title != "" {
+		webAnnot.Creator = &WebAgent{Type: "Person", Name: b.title}
+	}
+	if !b.creationDate.IsZero() {
+		webAnnot.Created = b.creationDate.Format(time.RFC3339)
+	}
+	if !b.modDate.IsZero() {
+		webAnnot.Modified = b.modDate.Format(time.RFC3339)
+	}
+
+	contents := b.contents
+	if f, ok := annot.(*FreeTextAnnotation); ok && contents == "" {
+		contents = f.Contents
+	}
+	if contents != "" {
+		webAnnot.Body = append(webAnnot.Body, WebBody{Type: "TextualBody", Value: contents, Format: "text/plain", Purpose: "commenting"})
+	}
+	if _, ok := markupQuadPoints(annot); ok {
+		webAnnot.Motivation = "highlighting"
+		webAnnot.Body = append(webAnnot.Body, WebBody{Type: "TextualBody", Value: annot.GetSubtypeName(), Purpose: "tagging"})
+		if quote != "" {
+			webAnnot.Target.Selector = append(webAnnot.Target.Selector, WebSelector{Type: "TextQuoteSelector", Exact: quote})
+		}
+	}
+	return webAnnot
+}
+
+// webAnnotationID returns the id of an annotation, urn:uuid: for uuid nms and a fragment of the source otherwise.
+func webAnnotationID(source, nm string) string {
+	if _, err := uuid.Parse(nm); err == nil {
+		return "urn:uuid:" + nm
+	}
+	return source + "#annotation=" + url.QueryEscape(nm)
+}
+
+// webAnnotationNM returns the nm of a web annotation id, "" when the id does not carry one.
+func webAnnotationNM(id string) string {
+	if nm, ok := strings.CutPrefix(id, "urn:uuid:"); ok {
+		return nm
+	}
+	if i := strings.LastIndex(id, "#annotation="); i != -1 {
+		nm, err := url.QueryUnescape(id[i+len("#annotation="):])
+		if err == nil {
+			return nm
+		}
+	}
+	return ""
+}
+
+// webAnnotFragment returns the page and the rect of the pdf FragmentSelector of a web annotation.
+func webAnnotFragment(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, webAnnot WebAnnotation) (int, Rect, bool, error) {
+	for _, selector := range webAnnot.Target.Selector {
+		if selector.Type != "FragmentSelector" {
+			continue
+		}
+		pageNum, viewRect, ok := parsePDFFragment(selector.Value)
+		if !ok {
+			continue
+		}
+		sizeRes, err := instance.FPDF_GetPageSizeByIndex(&requests.FPDF_GetPageSizeByIndex{
+			Document: pdfDoc,
+			Index:    pageNum,
+		})
+		if err != nil {
+			return 0, Rect{}, false, err
+		}
+		height := float32(sizeRes.Height)
+		if viewRect == nil {
+			// the whole page
+			return pageNum, Rect{Right: float32(sizeRes.Width), Top: height}, true, nil
+		}
+		return pageNum, Rect{
+			Left:   viewRect[0],
+			Top:    height - viewRect[1],
+			Right:  viewRect[0] + viewRect[2],
+			Bottom: height - viewRect[1] - viewRect[3],
+		}, true, nil
+	}
+	return 0, Rect{}, false, nil
+}
+
+// parsePDFFragment parses page=N&viewrect=left,top,width,height, the page is returned from 0 and viewrect may be missing.
+func parsePDFFragment(fragment string) (int, []float32, bool) {
+	values, err := url.ParseQuery(strings.TrimPrefix(fragment, "#"))
+	if err != nil {
+		return 0, nil, false
+	}
+	page, err := strconv.Atoi(values.Get("page"))
+	if err != nil || page < 1 {
+		return 0, nil, false
+	}
+	if values.Get("viewrect") == "" {
+		return page - 1, nil, true
+	}
+	viewRect, err := parseNumbers(values.Get("viewrect"), 4)
+	if err != nil {
+		return 0, nil, false
+	}
+	return page - 1, viewRect, true
+}
+
+// webAnnotation returns the annotation of a web annotation, placed in the rect of the page.
+func webAnnotation(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int, rect Rect, webAnnot WebAnnotation) (Annotation, error) {
+	var contents, tag, quote string
+	for _, body := range webAnnot.Body {
+		switch body.Purpose {
+		case "tagging":
+			tag = body.Value
+		case "commenting", "":
+			if body.Type == "TextualBody" && contents == "" {
+				contents = body.Value
+			}
+		}
+	}
+	for _, selector := range webAnnot.Target.Selector {
+		if selector.Type == "TextQuoteSelector" {
+			quote = selector.Exact
+		}
+	}
+
+	subtype, ok := subtypeByName(tag)
+	if !ok && webAnnot.Motivation == "highlighting" {
+		subtype = enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT
+	}
+
+	var annot Annotation
+	var err error
+	switch subtype {
+	case enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT, enums.FPDF_ANNOT_SUBTYPE_UNDERLINE,
+		enums.FPDF_ANNOT_SUBTYPE_STRIKEOUT, enums.FPDF_ANNOT_SUBTYPE_SQUIGGLY:
+		match, err := webQuoteMatch(instance, pdfDoc, pageNum, rect, quote)
+		if err != nil {
+			return nil, err
+		}
+		annot, err = NewTextMarkupAnnotation(subtype, match, nil)
+		if err != nil {
+			return nil, err
+		}
+	case enums.FPDF_ANNOT_SUBTYPE_SQUARE, enums.FPDF_ANNOT_SUBTYPE_CIRCLE:
+		annot = newAnnotationBySubtype(subtype)
+		b := annot.(annotationBase).base()
+		b.rect = rect
+		b.width = 1
+		b.strikeColor = &Color{R: 255, G: 0, B: 0}
+		err = annot.GenerateAppearance()
+	default:
+		t := NewTextAnnotation()
+		t.SetPosition(rect.Left, rect.Top)
+		annot = t
+		err = annot.GenerateAppearance()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := annot.(annotationBase).base()
+	if nm := webAnnotationNM(webAnnot.ID); nm != "" {
+		b.nm = nm
+	}
+	b.contents = contents
+	if webAnnot.Creator != nil {
+		b.title = webAnnot.Creator.Name
+	}
+	b.creationDate, _ = time.Parse(time.RFC3339, webAnnot.Created)
+	b.modDate, _ = time.Parse(time.RFC3339, webAnnot.Modified)
+	return annot, nil
+}
+
+// webQuoteMatch finds the quote inside the rect of the page, the rect itself when it is not found.
+func webQuoteMatch(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNum int, rect Rect, quote string) (TextMatch, error) {
+	if quote != "" {
+		matches, err := SearchTextInPage(instance, pdfDoc, pageNum, quote, &SearchOptions{MatchCase: true})
+		if err != nil {
+			return TextMatch{}, err
+		}
+		for _, match := range matches {
+			if rectsIntersect(quadPointsRect(match.QuadPoints), rect) {
+				return match, nil
+			}
+		}
+	}
+	return TextMatch{PageNumber: pageNum, Text: quote, QuadPoints: []QuadPoint{rectQuadPoint(rect)}}, nil
+}
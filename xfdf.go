@@ -0,0 +1,673 @@
+// XFDF 导入导出
+package annotation
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+const xfdfNamespace = "http://ns.adobe.com/xfdf/"
+
+// border styles of the XFDF style attribute
+var xfdfBorderStyles = map[string]string{
+	BorderStyleSolid:     "solid",
+	BorderStyleDashed:    "dash",
+	BorderStyleBeveled:   "bevelled",
+	BorderStyleInset:     "inset",
+	BorderStyleUnderline: "underline",
+}
+
+// alignments of the XFDF justification attribute
+var xfdfJustifications = map[TextAlignment]string{
+	TextAlignLeft:   "left",
+	TextAlignCenter: "centered",
+	TextAlignRight:  "right",
+}
+
+type xfdfDocument struct {
+	XMLName xml.Name   `xml:"http://ns.adobe.com/xfdf/ xfdf"`
+	Annots  xfdfAnnots `xml:"annots"`
+	File    *xfdfFile  `xml:"f"`
+}
+
+type xfdfAnnots struct {
+	Annots []xfdfAnnot `xml:",any"`
+}
+
+type xfdfFile struct {
+	Href string `xml:"href,attr"`
+}
+
+// xfdfAnnot is an annotation element, its name is the subtype in lower case, e.g. highlight.
+type xfdfAnnot struct {
+	XMLName       xml.Name
+	Page          int    `xml:"page,attr"`
+	Rect          string `xml:"rect,attr"`
+	Name          string `xml:"name,attr,omitempty"`
+	Title         string `xml:"title,attr,omitempty"`
+	CreationDate  string `xml:"creationdate,attr,omitempty"`
+	Date          string `xml:"date,attr,omitempty"`
+	Color         string `xml:"color,attr,omitempty"`
+	InteriorColor string `xml:"interior-color,attr,omitempty"`
+	Opacity       string `xml:"opacity,attr,omitempty"`
+	Width         string `xml:"width,attr,omitempty"`
+	Style         string `xml:"style,attr,omitempty"`
+	Dashes        string `xml:"dashes,attr,omitempty"`
+	Intensity     string `xml:"intensity,attr,omitempty"`
+	Coords        string `xml:"coords,attr,omitempty"`
+	Start         string `xml:"start,attr,omitempty"`
+	End           string `xml:"end,attr,omitempty"`
+	Head          string `xml:"head,attr,omitempty"`
+	Tail          string `xml:"tail,attr,omitempty"`
+	LeaderLength  string `xml:"leaderLength,attr,omitempty"`
+	LeaderExtend  string `xml:"leaderExtend,attr,omitempty"`
+	Caption       string `xml:"caption,attr,omitempty"`
+	Icon          string `xml:"icon,attr,omitempty"`
+	Justification string `xml:"justification,attr,omitempty"`
+	OverlayText   string `xml:"overlay-text,attr,omitempty"`
+	ImageMatrix   string `xml:"image-matrix,attr,omitempty"`
+
+	Contents          string        `xml:"contents,omitempty"`
+	DefaultAppearance string        `xml:"defaultappearance,omitempty"`
+	Vertices          string        `xml:"vertices,omitempty"`
+	InkList           *xfdfInkList  `xml:"inklist"`
+	Popup             *xfdfPopup    `xml:"popup"`
+	ImageData         string        `xml:"imagedata,omitempty"`
+	Appearance        string        `xml:"appearance,omitempty"`
+	Extra             []xfdfElement `xml:",any"`
+}
+
+type xfdfInkList struct {
+	Gestures []string `xml:"gesture"`
+}
+
+type xfdfPopup struct {
+	Rect string `xml:"rect,attr"`
+	Open string `xml:"open,attr,omitempty"`
+}
+
+// xfdfElement keeps the child elements of other tools, e.g. contents-richtext, so they do not fail the import.
+type xfdfElement struct {
+	XMLName xml.Name
+}
+
+// MarshalXFDF encodes the annotations of each page as an XFDF document, file is the href of the pdf, "" leaves it out.
+// The normal appearance is written base64 encoded in <appearance>, as a content stream. Popups and widgets are skipped.
+// Free texts and image stamps have no <appearance>, its resources can not be exported: free texts are generated
+// again on import, image stamps write their image as a png data url in <imagedata>, with image-matrix="a,b,c,d,e,f"
+// when the image does not fill the rect.
+// ps: Acrobat expects an appearance dictionary in <appearance>, it draws its own appearance instead.
+func MarshalXFDF(pageAnnots []AddOnePageAnnot, file string) ([]byte, error) {
+	doc := xfdfDocument{}
+	if file != "" {
+		doc.File = &xfdfFile{Href: file}
+	}
+	for _, item := range pageAnnots {
+		for _, annot := range item.Annots {
+			if !isExportable(annot) {
+				continue
+			}
+			el, err := newXFDFAnnot(item.PageNumber, annot)
+			if err != nil {
+				return nil, err
+			}
+			doc.Annots.Annots = append(doc.Annots.Annots, el)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	err := encoder.Encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// UnmarshalXFDF decodes the annotations of an XFDF document, grouped by page in the order of the document.
+// Elements of subtypes without a type in this package become *UnsupportedAnnotation, unknown elements are skipped.
+// Annotations without <appearance> have none, see ImportXFDF. The <appearance> of free texts is left out,
+// stamps with <imagedata> are image stamps.
+func UnmarshalXFDF(data []byte) ([]AddOnePageAnnot, error) {
+	var doc xfdfDocument
+	err := xml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var pageAnnots []AddOnePageAnnot
+	for _, el := range doc.Annots.Annots {
+		subtype, ok := subtypeByName(el.XMLName.Local)
+		if !ok || subtype == enums.FPDF_ANNOT_SUBTYPE_POPUP || subtype == enums.FPDF_ANNOT_SUBTYPE_WIDGET {
+			continue
+		}
+		annot, err := el.annotation(subtype)
+		if err != nil {
+			return nil, fmt.Errorf("xfdf %s annot %q: %w", el.XMLName.Local, el.Name, err)
+		}
+		pageAnnots = appendPageAnnot(pageAnnots, el.Page, annot)
+	}
+	return pageAnnots, nil
+}
+
+// ExportXFDF writes the annotations of the given pages as an XFDF document, every page when pageNums is nil.
+func ExportXFDF(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageNums []int, w io.Writer) error {
+	pageAnnots, err := loadPageAnnots(instance, pdfDoc, pageNums)
+	if err != nil {
+		return err
+	}
+	data, err := MarshalXFDF(pageAnnots, "")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ImportXFDF adds the annotations of an XFDF document to the pdf, pages are matched by index.
+// Annotations without an appearance get a generated one, annotations whose NM is already on the page are skipped.
+// A failed annotation does not stop the others, every failure is reported in a *BatchAddError.
+// ps: lines, polygons, polylines and redactions are added as stamps, save the document with SavePDF to write them.
+func ImportXFDF(ctx context.Context, instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	pageAnnots, err := UnmarshalXFDF(data)
+	if err != nil {
+		return 0, err
+	}
+	return addImportedAnnotations(ctx, instance, pdfDoc, pageAnnots)
+}
+
+// isExportable reports whether the annotation is written by the exports,
+// popups belong to their parent and widgets to the form.
+func isExportable(annot Annotation) bool {
+	switch annot.GetSubtype() {
+	case enums.FPDF_ANNOT_SUBTYPE_POPUP, enums.FPDF_ANNOT_SUBTYPE_WIDGET, enums.FPDF_ANNOT_SUBTYPE_XFAWIDGET:
+		return false
+	}
+	return true
+}
+
+// appendPageAnnot adds the annotation to the group of its page, pages keep the order they first appear in.
+func appendPageAnnot(pageAnnots []AddOnePageAnnot, pageNum int, annot Annotation) []AddOnePageAnnot {
+	for i := range pageAnnots {
+		if pageAnnots[i].PageNumber == pageNum {
+			pageAnnots[i].Annots = append(pageAnnots[i].Annots, annot)
+			return pageAnnots
+		}
+	}
+	return append(pageAnnots, AddOnePageAnnot{PageNumber: pageNum, Annots: []Annotation{annot}})
+}
+
+// addImportedAnnotations adds the imported annotations to their pages, skipping the nms already on a page,
+// and generates the appearance of the ones without.
+func addImportedAnnotations(ctx context.Context, instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, pageAnnots []AddOnePageAnnot) (int, error) {
+	newAnnots := make([]AddOnePageAnnot, 0, len(pageAnnots))
+	for _, item := range pageAnnots {
+		nms, err := pageAnnotNMs(instance, pdfDoc, item.PageNumber)
+		if err != nil {
+			return 0, err
+		}
+		newItem := AddOnePageAnnot{PageNumber: item.PageNumber}
+		for _, annot := range item.Annots {
+			if nms[annot.GetNM()] {
+				continue
+			}
+			newItem.Annots = append(newItem.Annots, annot)
+		}
+		if len(newItem.Annots) > 0 {
+			newAnnots = append(newAnnots, newItem)
+		}
+	}
+
+	for _, item := range newAnnots {
+		for _, annot := range item.Annots {
+			if annot.(annotationBase).base().ap != "" {
+				continue
+			}
+			err := annot.GenerateAppearance()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return AddAnnotationsToPDF(ctx, instance, pdfDoc, newAnnots)
+}
+
+// newXFDFAnnot returns the XFDF element of the annotation.
+func newXFDFAnnot(pageNum int, annot Annotation) (xfdfAnnot, error) {
+	b := annot.(annotationBase).base()
+	el := xfdfAnnot{
+		XMLName:  xml.Name{Local: strings.ToLower(annot.GetSubtypeName())},
+		Page:     pageNum,
+		Rect:     formatNumbers(b.rect.Left, b.rect.Bottom, b.rect.Right, b.rect.Top),
+		Name:     b.nm,
+		Title:    b.title,
+		Contents: b.contents,
+		Color:    formatHexColor(b.strikeColor),
+	}
+	if !b.creationDate.IsZero() {
+		el.CreationDate = FormatPDFDate(b.creationDate)
+	}
+	if !b.modDate.IsZero() {
+		el.Date = FormatPDFDate(b.modDate)
+	}
+	if b.opacity != DefaultOpacity {
+		el.Opacity = formatNumbers(float32(b.opacity) / 255)
+	}
+	if !IsZeroEpsilon(b.width) {
+		el.Width = formatNumbers(b.width)
+	}
+	if b.ap != "" && b.subtype != enums.FPDF_ANNOT_SUBTYPE_FREETEXT {
+		el.Appearance = base64.StdEncoding.EncodeToString([]byte(b.ap))
+	}
+
+	// highlight draws with the fill color, see HighlightAnnotation.SetStrikeColor
+	if b.subtype == enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT {
+		if b.fillColor != nil {
+			el.Color = formatHexColor(b.fillColor)
+		}
+	} else {
+		el.InteriorColor = formatHexColor(b.fillColor)
+	}
+
+	if s, ok := annot.(interface{ border() *BorderStyle }); ok {
+		bs := s.border()
+		el.Style = xfdfBorderStyles[bs.Style]
+		if bs.Style == BorderStyleDashed && len(bs.DashArray) > 0 {
+			dashes := make([]string, 0, len(bs.DashArray))
+			for _, dash := range bs.DashArray {
+				dashes = append(dashes, strconv.Itoa(dash))
+			}
+			el.Dashes = strings.Join(dashes, ",")
+		}
+		if bs.isCloudy() {
+			el.Style = "cloudy"
+			el.Intensity = strconv.Itoa(bs.EffectInt)
+		}
+	}
+
+	if quadPoints, ok := markupQuadPoints(annot); ok {
+		el.Coords = formatQuadPoints(quadPoints)
+	}
+
+	switch a := annot.(type) {
+	case *InkAnnotation:
+		el.InkList = &xfdfInkList{}
+		for _, stroke := range a.Points {
+			el.InkList.Gestures = append(el.InkList.Gestures, formatPoints(stroke))
+		}
+	case *PolygonAnnotation:
+		el.Vertices = formatPoints(a.Vertices)
+	case *PolylineAnnotation:
+		el.Vertices = formatPoints(a.Vertices)
+		el.Head, el.Tail = formatLineEndings(a.LineEndings)
+	case *LineAnnotation:
+		el.Start = formatNumbers(a.lineTo[0].X, a.lineTo[0].Y)
+		el.End = formatNumbers(a.lineTo[1].X, a.lineTo[1].Y)
+		el.Head, el.Tail = formatLineEndings(a.LineEndings)
+		if !IsZeroEpsilon(a.LeaderLine) {
+			el.LeaderLength = formatNumbers(a.LeaderLine)
+		}
+		if !IsZeroEpsilon(a.LeaderLineExtension) {
+			el.LeaderExtend = formatNumbers(a.LeaderLineExtension)
+		}
+		if a.Caption {
+			el.Caption = "yes"
+		}
+	case *FreeTextAnnotation:
+		el.Contents = a.Contents
+		el.DefaultAppearance = a.GetDefaultAppearance()
+		el.Justification = xfdfJustifications[a.Alignment]
+	case *TextAnnotation:
+		el.Icon = string(a.Icon)
+		if a.PopupRect != nil {
+			el.Popup = &xfdfPopup{
+				Rect: formatNumbers(a.PopupRect.Left, a.PopupRect.Bottom, a.PopupRect.Right, a.PopupRect.Top),
+			}
+			if a.Open {
+				el.Popup.Open = "yes"
+			}
+		}
+	case *RedactAnnotation:
+		el.Coords = formatQuadPoints(a.QuadPoints)
+		el.OverlayText = a.OverlayText
+		if a.OverlayText != "" {
+			el.DefaultAppearance = a.GetDefaultAppearance()
+		}
+	case *StampAnnotation:
+		img, matrix, ok, err := a.appearanceImage()
+		if err != nil {
+			return el, err
+		}
+		if ok {
+			el.Appearance = ""
+			el.ImageData, err = encodeImgDataURL(img)
+			if err != nil {
+				return el, err
+			}
+			if matrix != nil {
+				el.ImageMatrix = formatNumbers(matrix.A, matrix.B, matrix.C, matrix.D, matrix.E, matrix.F)
+			}
+		}
+	}
+	return el, nil
+}
+
+// annotation returns the typed annotation of the element.
+func (el *xfdfAnnot) annotation(subtype enums.FPDF_ANNOTATION_SUBTYPE) (Annotation, error) {
+	annot := newAnnotationBySubtype(subtype)
+	b := annot.(annotationBase).base()
+
+	rect, err := parseNumbers(el.Rect, 4)
+	if err != nil {
+		return nil, fmt.Errorf("rect: %w", err)
+	}
+	b.rect = Rect{Left: rect[0], Bottom: rect[1], Right: rect[2], Top: rect[3]}
+	if el.Name != "" {
+		b.nm = el.Name
+	}
+	b.title = el.Title
+	b.contents = el.Contents
+	b.creationDate, _ = ParsePDFDate(el.CreationDate)
+	b.modDate, _ = ParsePDFDate(el.Date)
+
+	b.strikeColor, err = parseHexColor(el.Color)
+	if err != nil {
+		return nil, err
+	}
+	b.fillColor, err = parseHexColor(el.InteriorColor)
+	if err != nil {
+		return nil, err
+	}
+	if subtype == enums.FPDF_ANNOT_SUBTYPE_HIGHLIGHT && b.fillColor == nil {
+		b.fillColor = b.strikeColor
+	}
+	if el.Opacity != "" {
+		opacity, err := parseNumbers(el.Opacity, 1)
+		if err != nil {
+			return nil, fmt.Errorf("opacity: %w", err)
+		}
+		b.opacity = uint8(min(max(opacity[0], 0), 1)*255 + 0.5)
+	}
+	if el.Width != "" {
+		width, err := parseNumbers(el.Width, 1)
+		if err != nil {
+			return nil, fmt.Errorf("width: %w", err)
+		}
+		b.width = width[0]
+	}
+	if el.Appearance != "" && subtype != enums.FPDF_ANNOT_SUBTYPE_FREETEXT {
+		ap, err := base64.StdEncoding.DecodeString(strings.TrimSpace(el.Appearance))
+		if err != nil {
+			return nil, fmt.Errorf("appearance: %w", err)
+		}
+		b.ap = string(ap)
+	}
+
+	if s, ok := annot.(interface{ border() *BorderStyle }); ok {
+		bs := s.border()
+		for style, name := range xfdfBorderStyles {
+			if name == el.Style {
+				bs.Style = style
+			}
+		}
+		if el.Dashes != "" {
+			dashes, err := parseNumbers(el.Dashes, 0)
+			if err != nil {
+				return nil, fmt.Errorf("dashes: %w", err)
+			}
+			for _, dash := range dashes {
+				bs.DashArray = append(bs.DashArray, int(dash+0.5))
+			}
+		}
+		if el.Style == "cloudy" {
+			intensity, _ := strconv.Atoi(el.Intensity)
+			bs.SetCloudy(intensity)
+		}
+	}
+
+	var quadPoints []QuadPoint
+	if el.Coords != "" {
+		quadPoints, err = parseQuadPoints(el.Coords)
+		if err != nil {
+			return nil, fmt.Errorf("coords: %w", err)
+		}
+	}
+
+	switch a := annot.(type) {
+	case *HighlightAnnotation:
+		a.QuadPoints = quadPoints
+	case *UnderlineAnnotation:
+		a.QuadPoints = quadPoints
+	case *StrikeoutAnnotation:
+		a.QuadPoints = quadPoints
+	case *SquigglyAnnotation:
+		a.QuadPoints = quadPoints
+	case *RedactAnnotation:
+		a.QuadPoints = quadPoints
+		a.OverlayText = el.OverlayText
+		fontSize, fontColor := parseDefaultAppearance(el.DefaultAppearance)
+		a.OverlayFontSize = fontSize
+		if fontColor != nil {
+			a.FontColor = *fontColor
+		}
+	case *InkAnnotation:
+		if el.InkList != nil {
+			for _, gesture := range el.InkList.Gestures {
+				points, err := parsePoints(gesture)
+				if err != nil {
+					return nil, fmt.Errorf("gesture: %w", err)
+				}
+				a.Points = append(a.Points, points)
+			}
+		}
+	case *PolygonAnnotation:
+		a.Vertices, err = parsePoints(el.Vertices)
+	case *PolylineAnnotation:
+		a.Vertices, err = parsePoints(el.Vertices)
+		a.LineEndings = parseLineEndings(el.Head, el.Tail)
+	case *LineAnnotation:
+		var start, end []float32
+		start, err = parseNumbers(el.Start, 2)
+		if err == nil {
+			end, err = parseNumbers(el.End, 2)
+		}
+		if err == nil {
+			a.SetLineTo(start[0], start[1], end[0], end[1])
+		}
+		a.LineEndings = parseLineEndings(el.Head, el.Tail)
+		if el.LeaderLength != "" {
+			leaderLine, _ := strconv.ParseFloat(el.LeaderLength, 32)
+			a.LeaderLine = float32(leaderLine)
+		}
+		if el.LeaderExtend != "" {
+			extension, _ := strconv.ParseFloat(el.LeaderExtend, 32)
+			a.LeaderLineExtension = float32(extension)
+		}
+		a.Caption = el.Caption == "yes"
+	case *FreeTextAnnotation:
+		a.Contents = b.contents
+		b.contents = ""
+		for alignment, name := range xfdfJustifications {
+			if name == el.Justification {
+				a.Alignment = alignment
+			}
+		}
+		fontSize, fontColor := parseDefaultAppearance(el.DefaultAppearance)
+		if fontSize > 0 {
+			a.FontSize = int(fontSize + 0.5)
+		}
+		if fontColor != nil {
+			a.FontColor = *fontColor
+		}
+	case *StampAnnotation:
+		if el.ImageData != "" {
+			img, err := decodeImgDataURL(el.ImageData)
+			if err != nil {
+				return nil, fmt.Errorf("imagedata: %w", err)
+			}
+			var matrix *structs.FPDF_FS_MATRIX
+			if el.ImageMatrix != "" {
+				m, err := parseNumbers(el.ImageMatrix, 6)
+				if err != nil {
+					return nil, fmt.Errorf("image-matrix: %w", err)
+				}
+				matrix = &structs.FPDF_FS_MATRIX{A: m[0], B: m[1], C: m[2], D: m[3], E: m[4], F: m[5]}
+			}
+			a.setAppearanceImage(img, matrix)
+		}
+	case *TextAnnotation:
+		if el.Icon != "" {
+			a.Icon = TextIcon(el.Icon)
+		}
+		if el.Popup != nil {
+			popupRect, err := parseNumbers(el.Popup.Rect, 4)
+			if err != nil {
+				return nil, fmt.Errorf("popup rect: %w", err)
+			}
+			a.PopupRect = &Rect{Left: popupRect[0], Bottom: popupRect[1], Right: popupRect[2], Top: popupRect[3]}
+			a.Open = el.Popup.Open == "yes"
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return annot, nil
+}
+
+// formatNumbers joins the numbers with commas, in their shortest form.
+func formatNumbers(numbers ...float32) string {
+	s := make([]string, 0, len(numbers))
+	for _, n := range numbers {
+		s = append(s, strconv.FormatFloat(float64(n), 'f', -1, 32))
+	}
+	return strings.Join(s, ",")
+}
+
+// parseNumbers parses numbers separated by commas, semicolons or spaces, count 0 takes any count.
+func parseNumbers(s string, count int) ([]float32, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if count > 0 && len(fields) != count {
+		return nil, fmt.Errorf("expect %d numbers, got %q", count, s)
+	}
+	numbers := make([]float32, 0, len(fields))
+	for _, field := range fields {
+		n, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, float32(n))
+	}
+	return numbers, nil
+}
+
+// formatPoints writes the points as x,y pairs separated by semicolons.
+func formatPoints(points []Point) string {
+	s := make([]string, 0, len(points))
+	for _, p := range points {
+		s = append(s, formatNumbers(p.X, p.Y))
+	}
+	return strings.Join(s, ";")
+}
+
+func parsePoints(s string) ([]Point, error) {
+	numbers, err := parseNumbers(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(numbers)%2 != 0 {
+		return nil, fmt.Errorf("odd count of coordinates in %q", s)
+	}
+	points := make([]Point, 0, len(numbers)/2)
+	for i := 0; i < len(numbers); i += 2 {
+		points = append(points, Point{X: numbers[i], Y: numbers[i+1]})
+	}
+	return points, nil
+}
+
+// formatQuadPoints writes the quads in the order of /QuadPoints: left top, right top, left bottom, right bottom.
+func formatQuadPoints(quadPoints []QuadPoint) string {
+	s := make([]string, 0, len(quadPoints))
+	for _, q := range convertQuadPointToPdfiumFormat(quadPoints) {
+		s = append(s, formatNumbers(q.X1, q.Y1, q.X2, q.Y2, q.X3, q.Y3, q.X4, q.Y4))
+	}
+	return strings.Join(s, ",")
+}
+
+func parseQuadPoints(s string) ([]QuadPoint, error) {
+	numbers, err := parseNumbers(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(numbers)%8 != 0 {
+		return nil, fmt.Errorf("quad points need 8 numbers each, got %d", len(numbers))
+	}
+	quadPoints := make([]QuadPoint, 0, len(numbers)/8)
+	for i := 0; i < len(numbers); i += 8 {
+		n := numbers[i : i+8]
+		quadPoints = append(quadPoints, convertQuadPointFromPdfiumFormat(structs.FPDF_FS_QUADPOINTSF{
+			X1: n[0], Y1: n[1], X2: n[2], Y2: n[3], X3: n[4], Y3: n[5], X4: n[6], Y4: n[7],
+		}))
+	}
+	return quadPoints, nil
+}
+
+// formatHexColor returns the color as #RRGGBB, "" for nil.
+func formatHexColor(c *Color) string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// parseHexColor parses a #RRGGBB color, nil for "".
+func parseHexColor(s string) (*Color, error) {
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+	if err != nil || len(strings.TrimPrefix(s, "#")) != 6 {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+	return &Color{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}, nil
+}
+
+func formatLineEndings(endings [2]LineEnding) (head, tail string) {
+	if endings[0] != LineEndingNone {
+		head = string(endings[0])
+	}
+	if endings[1] != LineEndingNone {
+		tail = string(endings[1])
+	}
+	return head, tail
+}
+
+func parseLineEndings(head, tail string) [2]LineEnding {
+	endings := [2]LineEnding{LineEndingNone, LineEndingNone}
+	if head != "" {
+		endings[0] = LineEnding(head)
+	}
+	if tail != "" {
+		endings[1] = LineEnding(tail)
+	}
+	return endings
+}